@@ -3,6 +3,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	_ "embed"
 	"errors"
 	"fmt"
@@ -19,6 +20,7 @@ import (
 	"github.com/sebasttiano/Blackbird.git/internal/agent"
 	"github.com/sebasttiano/Blackbird.git/internal/config"
 	"github.com/sebasttiano/Blackbird.git/internal/logger"
+	"github.com/sebasttiano/Blackbird.git/internal/tlsconfig"
 )
 
 var buildVersion = "N/A"
@@ -83,7 +85,31 @@ func run(cfg *config.Config) error {
 		}
 	}
 
-	a, err := agent.NewAgent("http://"+cfg.ServerIPAddr, 3, 1, cfg.SecretKey, publicKey, cfg.GRPSServerIPAddr)
+	scheme := "http://"
+	tlsOpts := tlsconfig.ClientOptions{CAFile: cfg.TLSCA, CertFile: cfg.TLSCert, KeyFile: cfg.TLSKey, ServerName: cfg.TLSServerName}
+	var tlsConfig *tls.Config
+	if tlsOpts.Enabled() {
+		tlsConfig, err = tlsconfig.Client(tlsOpts)
+		if err != nil {
+			logger.Log.Error("failed to load tls settings", zap.Error(err))
+			return err
+		}
+		scheme = "https://"
+	}
+
+	a, err := agent.NewAgent(agent.Config{
+		ServerAddr:    scheme + cfg.ServerIPAddr,
+		ClientRetries: 3,
+		BackoffFactor: 1,
+		SignKey:       cfg.SecretKey,
+		PublicKey:     publicKey,
+		GRPCServer:    cfg.GRPSServerIPAddr,
+		AgentID:       cfg.AgentID,
+		AuthToken:     cfg.AuthToken,
+		TLS:           tlsConfig,
+		KeyIDs:        agent.KeyIDs{Sign: cfg.SecretKeyID, Encryption: cfg.CryptoKeyID},
+		LegacySign:    cfg.SignLegacy,
+	})
 	if err != nil && errors.Is(agent.ErrInitSender, err) {
 		logger.Log.Error("failed to initialize agent", zap.Error(err))
 		return err
@@ -95,6 +121,12 @@ func run(cfg *config.Config) error {
 	jobsMetrics := make(chan agent.MetricsSet, 10)
 	jobsGMetrics := make(chan agent.GopsutilMetricsSet, 10)
 
+	if cfg.StatsdAddr != "" {
+		if err := a.RunStatsd(ctx, cfg.StatsdAddr, time.Duration(cfg.StatsdFlush)*time.Second); err != nil {
+			logger.Log.Error("failed to start statsd listener", zap.Error(err))
+		}
+	}
+
 	a.WG.Add(2)
 	go a.GetMetrics(ctx, time.Duration(cfg.PollInterval)*time.Second, jobsMetrics)
 	go a.GetGopsutilMetrics(ctx, time.Duration(cfg.PollInterval)*time.Second, jobsGMetrics)
@@ -0,0 +1,306 @@
+// Package main утилита администратора сервера: выпуск, просмотр и отзыв API токенов и секретов агентов,
+// просмотр ключей.
+//
+//	blackbirdctl tokens create -tokens tokens.json -name ci -scopes read,write -ttl 720h
+//	blackbirdctl tokens list -d postgres://...
+//	blackbirdctl tokens revoke -tokens tokens.json -id <id>
+//	blackbirdctl keys list -keyring keyring.json -grace 24h
+//	blackbirdctl agents enroll -agents agents.json -id web-01
+//	blackbirdctl agents list -d postgres://...
+//	blackbirdctl agents revoke -agents agents.json -id web-01
+//
+// Хранилища токенов и агентов выбираются так же, как на сервере: JSON файл через -tokens или -agents
+// или база данных через -d.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jmoiron/sqlx"
+	"github.com/sebasttiano/Blackbird.git/internal/agentkeys"
+	"github.com/sebasttiano/Blackbird.git/internal/auth"
+	"github.com/sebasttiano/Blackbird.git/internal/keyring"
+	"github.com/sebasttiano/Blackbird.git/internal/logger"
+)
+
+// ErrUsage ошибка, если команда вызвана неверно.
+var ErrUsage = errors.New("usage: blackbirdctl tokens create|list|revoke [flags] or blackbirdctl keys list [flags] or blackbirdctl agents enroll|list|revoke [flags]")
+
+func main() {
+	if err := logger.Initialize("error"); err != nil {
+		fmt.Fprintln(os.Stderr, "logger initialization failed")
+		os.Exit(1)
+	}
+	if err := run(context.Background(), os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run выполняет команду. Вынесена из main для тестов.
+func run(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) < 2 {
+		return ErrUsage
+	}
+	switch args[0] {
+	case "tokens":
+		return runTokens(ctx, args[1], args[2:], out)
+	case "keys":
+		return runKeys(args[1], args[2:], out)
+	case "agents":
+		return runAgents(ctx, args[1], args[2:], out)
+	default:
+		return ErrUsage
+	}
+}
+
+// runTokens выполняет команды с API токенами.
+func runTokens(ctx context.Context, command string, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("tokens "+command, flag.ContinueOnError)
+	tokensFile := fs.String("tokens", "", "path to JSON file with API tokens")
+	databaseDSN := fs.String("d", "", "database to keep API tokens in")
+	name := fs.String("name", "", "token name, for example the service using it")
+	scopes := fs.String("scopes", string(auth.ScopeRead), "comma separated token scopes: read, write, admin")
+	ttl := fs.Duration("ttl", 0, "token lifetime, never expires if zero")
+	id := fs.String("id", "", "token id to revoke")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	store, closeStore, err := openStore(*tokensFile, *databaseDSN)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	switch command {
+	case "create":
+		return createToken(ctx, store, out, *name, *scopes, *ttl)
+	case "list":
+		return listTokens(ctx, store, out)
+	case "revoke":
+		if *id == "" {
+			return errors.New("token id is required")
+		}
+		if err := store.Revoke(ctx, *id); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "token %s revoked\n", *id)
+		return nil
+	default:
+		return ErrUsage
+	}
+}
+
+// openStore открывает хранилище токенов: файл или базу данных.
+func openStore(tokensFile, databaseDSN string) (auth.Store, func(), error) {
+	switch {
+	case tokensFile != "" && databaseDSN != "":
+		return nil, nil, errors.New("use either -tokens or -d")
+	case tokensFile != "":
+		store, err := auth.NewFileStore(tokensFile)
+		return store, func() {}, err
+	case databaseDSN != "":
+		conn, err := sqlx.Connect("pgx", databaseDSN)
+		if err != nil {
+			return nil, nil, err
+		}
+		store, err := auth.NewDBStore(conn, true)
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		return store, func() { conn.Close() }, nil
+	default:
+		return nil, nil, errors.New("tokens store is required: -tokens or -d")
+	}
+}
+
+// createToken выпускает токен и печатает его секрет.
+func createToken(ctx context.Context, store auth.Store, out io.Writer, name, scopes string, ttl time.Duration) error {
+	if name == "" {
+		return errors.New("token name is required")
+	}
+	parsed, err := auth.ParseScopes(scopes)
+	if err != nil {
+		return err
+	}
+	token, secret, err := auth.NewToken(name, parsed, ttl)
+	if err != nil {
+		return err
+	}
+	if err := store.Add(ctx, token); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "id:     %s\nscopes: %s\ntoken:  %s\n", token.ID, scopes, secret)
+	fmt.Fprintln(out, "the token is shown only once, store it now")
+	return nil
+}
+
+// listTokens печатает токены таблицей.
+func listTokens(ctx context.Context, store auth.Store, out io.Writer) error {
+	tokens, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSCOPES\tCREATED\tEXPIRES\tSTATUS")
+	now := time.Now()
+	for i := range tokens {
+		t := &tokens[i]
+		scopes := make([]string, 0, len(t.Scopes))
+		for _, s := range t.Scopes {
+			scopes = append(scopes, string(s))
+		}
+		expires := "never"
+		if t.ExpiresAt != nil {
+			expires = t.ExpiresAt.Format(time.RFC3339)
+		}
+		st := "active"
+		if err := t.Active(now); err != nil {
+			st = strings.TrimPrefix(err.Error(), "token is ")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", t.ID, t.Name, strings.Join(scopes, ","), t.CreatedAt.Format(time.RFC3339), expires, st)
+	}
+	return w.Flush()
+}
+
+// runKeys выполняет команды с набором ключей сервера.
+func runKeys(command string, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("keys "+command, flag.ContinueOnError)
+	keyringFile := fs.String("keyring", "", "path to JSON file with server keys")
+	grace := fs.Duration("grace", keyring.DefaultGracePeriod, "grace period configured on server for retired keys")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if command != "list" {
+		return ErrUsage
+	}
+	if *keyringFile == "" {
+		return errors.New("keyring file is required: -keyring")
+	}
+	keys, err := keyring.Load(*keyringFile, *grace)
+	if err != nil {
+		return err
+	}
+	return listKeys(keys, out, time.Now())
+}
+
+// listKeys печатает ключи таблицей без секретов.
+func listKeys(keys *keyring.Keyring, out io.Writer, now time.Time) error {
+	primary := map[keyring.Type]*keyring.Key{
+		keyring.TypeHMAC: keys.Primary(keyring.TypeHMAC),
+		keyring.TypeRSA:  keys.Primary(keyring.TypeRSA),
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTYPE\tSTATUS\tRETIRED\tACCEPTED UNTIL")
+	for _, key := range keys.Keys() {
+		st := key.Status(now, keys.GracePeriod())
+		if primary[key.Type] == key {
+			st += ", primary"
+		}
+		retired, until := "-", "-"
+		if key.RetiredAt != nil {
+			retired = key.RetiredAt.Format(time.RFC3339)
+			until = key.RetiredAt.Add(keys.GracePeriod()).Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", key.ID, key.Type, st, retired, until)
+	}
+	return w.Flush()
+}
+
+// runAgents выполняет команды с реестром секретов агентов.
+func runAgents(ctx context.Context, command string, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("agents "+command, flag.ContinueOnError)
+	agentsFile := fs.String("agents", "", "path to JSON file with agent secrets")
+	databaseDSN := fs.String("d", "", "database to keep agent secrets in")
+	id := fs.String("id", "", "agent id, the same as the agent sends in X-Agent-ID")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	store, closeStore, err := openAgentStore(*agentsFile, *databaseDSN)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	switch command {
+	case "enroll":
+		agent, err := agentkeys.NewAgent(*id)
+		if err != nil {
+			return err
+		}
+		if err := store.Add(ctx, agent); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "id:     %s\nsecret: %s\n", agent.ID, agent.Secret)
+		fmt.Fprintln(out, "set the secret as KEY on the agent, it is shown only once")
+		return nil
+	case "list":
+		return listAgents(ctx, store, out)
+	case "revoke":
+		if *id == "" {
+			return errors.New("agent id is required")
+		}
+		if err := store.Revoke(ctx, *id); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "agent %s revoked\n", *id)
+		return nil
+	default:
+		return ErrUsage
+	}
+}
+
+// openAgentStore открывает реестр агентов: файл или базу данных.
+func openAgentStore(agentsFile, databaseDSN string) (agentkeys.Store, func(), error) {
+	switch {
+	case agentsFile != "" && databaseDSN != "":
+		return nil, nil, errors.New("use either -agents or -d")
+	case agentsFile != "":
+		store, err := agentkeys.NewFileStore(agentsFile)
+		return store, func() {}, err
+	case databaseDSN != "":
+		conn, err := sqlx.Connect("pgx", databaseDSN)
+		if err != nil {
+			return nil, nil, err
+		}
+		store, err := agentkeys.NewDBStore(conn, true)
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		return store, func() { conn.Close() }, nil
+	default:
+		return nil, nil, errors.New("agents store is required: -agents or -d")
+	}
+}
+
+// listAgents печатает агентов таблицей без секретов.
+func listAgents(ctx context.Context, store agentkeys.Store, out io.Writer) error {
+	agents, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCREATED\tSTATUS")
+	for i := range agents {
+		a := &agents[i]
+		st := "active"
+		if a.RevokedAt != nil {
+			st = "revoked " + a.RevokedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", a.ID, a.CreatedAt.Format(time.RFC3339), st)
+	}
+	return w.Flush()
+}
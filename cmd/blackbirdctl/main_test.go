@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/sebasttiano/Blackbird.git/internal/agentkeys"
+	"github.com/sebasttiano/Blackbird.git/internal/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// field достает значение строки вида "name: value" из вывода команды.
+func field(t *testing.T, out, name string) string {
+	t.Helper()
+	m := regexp.MustCompile(`(?m)^` + name + `:\s+(\S+)$`).FindStringSubmatch(out)
+	require.Len(t, m, 2, "no %s in output:\n%s", name, out)
+	return m[1]
+}
+
+func TestRunUsage(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "no command", args: nil},
+		{name: "no subcommand", args: []string{"tokens"}},
+		{name: "unknown command", args: []string{"users", "list"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, run(ctx, tt.args, &bytes.Buffer{}), ErrUsage)
+		})
+	}
+
+	tokens := filepath.Join(t.TempDir(), "tokens.json")
+	assert.ErrorIs(t, run(ctx, []string{"tokens", "rotate", "-tokens", tokens}, &bytes.Buffer{}), ErrUsage)
+	assert.ErrorContains(t, run(ctx, []string{"tokens", "list"}, &bytes.Buffer{}), "tokens store is required")
+	assert.ErrorContains(t, run(ctx, []string{"tokens", "list", "-tokens", tokens, "-d", "postgres://"}, &bytes.Buffer{}), "use either")
+}
+
+func TestRunTokens(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "tokens.json")
+
+	var out bytes.Buffer
+	require.NoError(t, run(ctx, []string{"tokens", "create", "-tokens", path, "-name", "ci", "-scopes", "read,write", "-ttl", "1h"}, &out))
+	id, secret := field(t, out.String(), "id"), field(t, out.String(), "token")
+	assert.Contains(t, out.String(), "scopes: read,write")
+
+	// в файле лежит хеш, а не сам секрет
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), secret)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	store, err := auth.NewFileStore(path)
+	require.NoError(t, err)
+	token, err := store.Lookup(ctx, auth.HashSecret(secret))
+	require.NoError(t, err)
+	assert.Equal(t, id, token.ID)
+	assert.Equal(t, "ci", token.Name)
+	assert.Equal(t, []auth.Scope{auth.ScopeRead, auth.ScopeWrite}, token.Scopes)
+	require.NotNil(t, token.ExpiresAt)
+
+	out.Reset()
+	require.NoError(t, run(ctx, []string{"tokens", "list", "-tokens", path}, &out))
+	assert.Regexp(t, id+`\s+ci\s+read,write\s+\S+\s+\S+\s+active`, out.String())
+
+	out.Reset()
+	require.NoError(t, run(ctx, []string{"tokens", "revoke", "-tokens", path, "-id", id}, &out))
+	assert.Equal(t, "token "+id+" revoked\n", out.String())
+	token, err = store.Lookup(ctx, auth.HashSecret(secret))
+	require.NoError(t, err)
+	assert.ErrorIs(t, token.Active(token.CreatedAt), auth.ErrTokenRevoked)
+
+	out.Reset()
+	require.NoError(t, run(ctx, []string{"tokens", "list", "-tokens", path}, &out))
+	assert.Regexp(t, id+`.*\srevoked`, out.String())
+
+	assert.ErrorContains(t, run(ctx, []string{"tokens", "create", "-tokens", path}, &out), "token name is required")
+	assert.ErrorIs(t, run(ctx, []string{"tokens", "create", "-tokens", path, "-name", "x", "-scopes", "root"}, &out), auth.ErrUnknownScope)
+	assert.ErrorContains(t, run(ctx, []string{"tokens", "revoke", "-tokens", path}, &out), "token id is required")
+	assert.ErrorIs(t, run(ctx, []string{"tokens", "revoke", "-tokens", path, "-id", "unknown"}, &out), auth.ErrTokenNotFound)
+
+	tokens, err := store.List(ctx)
+	require.NoError(t, err)
+	assert.Len(t, tokens, 1, "failed commands must not change the file")
+}
+
+func TestRunAgents(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "agents.json")
+
+	var out bytes.Buffer
+	require.NoError(t, run(ctx, []string{"agents", "enroll", "-agents", path, "-id", "web-01"}, &out))
+	assert.Equal(t, "web-01", field(t, out.String(), "id"))
+	secret := field(t, out.String(), "secret")
+
+	store, err := agentkeys.NewFileStore(path)
+	require.NoError(t, err)
+	agent, err := store.Lookup(ctx, "web-01")
+	require.NoError(t, err)
+	assert.Equal(t, secret, agent.Secret)
+	assert.Nil(t, agent.RevokedAt)
+
+	assert.ErrorIs(t, run(ctx, []string{"agents", "enroll", "-agents", path, "-id", "web-01"}, &out), agentkeys.ErrAgentExists)
+
+	out.Reset()
+	require.NoError(t, run(ctx, []string{"agents", "list", "-agents", path}, &out))
+	assert.Regexp(t, `web-01\s+\S+\s+active`, out.String())
+	assert.NotContains(t, out.String(), secret, "list must not print secrets")
+
+	out.Reset()
+	require.NoError(t, run(ctx, []string{"agents", "revoke", "-agents", path, "-id", "web-01"}, &out))
+	assert.Equal(t, "agent web-01 revoked\n", out.String())
+	agent, err = store.Lookup(ctx, "web-01")
+	require.NoError(t, err)
+	assert.NotNil(t, agent.RevokedAt)
+
+	// отозванного агента можно зарегистрировать заново с новым секретом
+	out.Reset()
+	require.NoError(t, run(ctx, []string{"agents", "enroll", "-agents", path, "-id", "web-01"}, &out))
+	assert.NotEqual(t, secret, field(t, out.String(), "secret"))
+	agents, err := store.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, agents, 1)
+	assert.Nil(t, agents[0].RevokedAt)
+
+	assert.ErrorContains(t, run(ctx, []string{"agents", "revoke", "-agents", path}, &out), "agent id is required")
+	assert.ErrorIs(t, run(ctx, []string{"agents", "revoke", "-agents", path, "-id", "web-02"}, &out), agentkeys.ErrAgentNotFound)
+	assert.ErrorContains(t, run(ctx, []string{"agents", "list"}, &out), "agents store is required")
+}
+
+func TestRunKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"keys": [
+		{"id": "old", "type": "hmac", "secret": "old-secret", "retired_at": "2020-01-01T00:00:00Z"},
+		{"id": "new", "type": "hmac", "secret": "new-secret"}
+	]}`), 0600))
+
+	var out bytes.Buffer
+	require.NoError(t, run(context.Background(), []string{"keys", "list", "-keyring", path, "-grace", "1h"}, &out))
+	assert.Regexp(t, `old\s+hmac\s+expired\s+2020-01-01T00:00:00Z\s+2020-01-01T01:00:00Z`, out.String())
+	assert.Regexp(t, `new\s+hmac\s+active, primary\s+-\s+-`, out.String())
+	assert.NotContains(t, out.String(), "secret", "list must not print secrets")
+
+	assert.ErrorContains(t, run(context.Background(), []string{"keys", "list"}, &out), "keyring file is required")
+	assert.ErrorIs(t, run(context.Background(), []string{"keys", "rotate", "-keyring", path}, &out), ErrUsage)
+}
@@ -1,11 +1,22 @@
 package main
 
 import (
+	"errors"
+	"path/filepath"
+
+	"github.com/sebasttiano/Blackbird.git/internal/agentkeys"
+	"github.com/sebasttiano/Blackbird.git/internal/auth"
 	"github.com/sebasttiano/Blackbird.git/internal/common"
 	"github.com/sebasttiano/Blackbird.git/internal/handlers"
+	"github.com/sebasttiano/Blackbird.git/internal/health"
+	"github.com/sebasttiano/Blackbird.git/internal/keyring"
 	"github.com/sebasttiano/Blackbird.git/internal/logger"
+	"github.com/sebasttiano/Blackbird.git/internal/openapi"
 	"github.com/sebasttiano/Blackbird.git/internal/repository"
 	"github.com/sebasttiano/Blackbird.git/internal/service"
+	"github.com/sebasttiano/Blackbird.git/internal/service/dedup"
+	"github.com/sebasttiano/Blackbird.git/internal/signing"
+	"go.uber.org/zap"
 )
 
 var currentApp = newApp()
@@ -21,8 +32,19 @@ func newApp() *app {
 	return &app{}
 }
 
-// Initialize принимает на вход внешние зависимости приложения и инициализирует его
-func (a *app) Initialize(s *service.Settings, key string, privateKey []byte) error {
+// secrets откуда брать токены и ключи подписи. AuthTokens и AgentSecrets это путь к JSON файлу или "db"
+// для таблицы в базе данных: пустой AuthTokens отключает проверку токенов, пустой AgentSecrets
+// оставляет только общие ключи. Key и PrivateKey проверяют запросы агентов без идентификатора ключа.
+type secrets struct {
+	AuthTokens   string
+	AgentSecrets string
+	Key          string
+	PrivateKey   []byte
+}
+
+// Initialize принимает на вход внешние зависимости приложения и инициализирует его.
+// Настройки серверов в h дополняются хранилищем токенов, ключами и проверкой подписи.
+func (a *app) Initialize(s *service.Settings, h *handlers.Config, sec secrets) error {
 	var err error
 	var repo service.Repository
 
@@ -37,11 +59,84 @@ func (a *app) Initialize(s *service.Settings, key string, privateKey []byte) err
 		repo = repository.NewMemStorage()
 	}
 
+	if s.DedupWindow >= 0 && s.Dedup == nil {
+		if s.DBSave && s.Conn != nil {
+			s.Dedup, err = dedup.NewDBWindow(s.Conn, s.DedupWindow, true)
+			if err != nil {
+				return err
+			}
+		} else {
+			s.Dedup = dedup.NewMemoryWindow(s.DedupWindow)
+		}
+	}
+
+	if sec.AuthTokens != "" && h.Tokens == nil {
+		if sec.AuthTokens == "db" {
+			if s.Conn == nil {
+				return errors.New("auth tokens in the database require a database connection")
+			}
+			h.Tokens, err = auth.NewDBStore(s.Conn, true)
+		} else {
+			h.Tokens, err = auth.NewFileStore(sec.AuthTokens)
+		}
+		if err != nil {
+			return err
+		}
+		logger.Log.Info("api tokens are required", zap.String("store", sec.AuthTokens))
+	}
+
+	var agentKeys agentkeys.Store
+	if sec.AgentSecrets != "" {
+		if sec.AgentSecrets == "db" {
+			if s.Conn == nil {
+				return errors.New("agent secrets in the database require a database connection")
+			}
+			agentKeys, err = agentkeys.NewDBStore(s.Conn, true)
+		} else {
+			agentKeys, err = agentkeys.NewFileStore(sec.AgentSecrets)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if h.Keys == nil {
+		h.Keys = keyring.New(keyring.DefaultGracePeriod)
+	}
+	// ключи из KEY и CRYPTO_KEY проверяют запросы агентов без идентификатора ключа
+	if sec.Key != "" {
+		if err := h.Keys.Add(keyring.Key{ID: keyring.DefaultID, Type: keyring.TypeHMAC, Secret: sec.Key}); err != nil {
+			return err
+		}
+	}
+	if priv := common.UnmarshalRSAPrivate(sec.PrivateKey); priv != nil {
+		if err := h.Keys.Add(keyring.WithPrivateKey(keyring.DefaultID, priv)); err != nil {
+			return err
+		}
+	}
+
+	if h.Signatures == nil {
+		h.Signatures = signing.NewVerifier(h.Keys, signing.DefaultMaxSkew, false)
+	}
+	if agentKeys != nil {
+		h.Signatures.WithAgents(agentKeys)
+		logger.Log.Info("agents sign requests with their own secrets", zap.String("store", sec.AgentSecrets))
+	}
+
 	a.service = service.NewService(s, repo)
-	a.views = handlers.NewServerViews(a.service)
+	a.views = handlers.NewServerViews(a.service, *h)
 	a.views.DB = s.Conn
-	a.views.SignKey = key
-	a.views.PrivateKey = common.UnmarshalRSAPrivate(privateKey)
-	a.views.TrustedSubnet = s.TrustedSubnet
+	if s.Conn != nil {
+		a.views.Health.Register("db", health.Ping(s.Conn))
+	}
+	if s.FileSave && s.SaveFilePath != "" {
+		a.views.Health.Register("snapshot", health.WritableDir(filepath.Dir(s.SaveFilePath)))
+	}
+	if h.ValidateRequests {
+		a.views.APISpec, err = openapi.Load()
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
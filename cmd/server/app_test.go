@@ -3,6 +3,7 @@ package main
 import (
 	"testing"
 
+	"github.com/sebasttiano/Blackbird.git/internal/handlers"
 	"github.com/sebasttiano/Blackbird.git/internal/service"
 )
 
@@ -10,6 +11,6 @@ func BenchmarkApp_Initialize(b *testing.B) {
 	b.ReportAllocs()
 	app := newApp()
 	for i := 0; i < b.N; i++ {
-		app.Initialize(&service.Settings{DBSave: false}, "SECRET_KEY", nil)
+		app.Initialize(&service.Settings{DBSave: false}, &handlers.Config{}, secrets{Key: "SECRET_KEY"})
 	}
 }
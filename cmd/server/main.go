@@ -4,17 +4,30 @@ package main
 import (
 	"context"
 	_ "embed"
+	"errors"
 	"fmt"
-	"net"
+	"io/fs"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"text/template"
 	"time"
 
+	"github.com/sebasttiano/Blackbird.git/internal/audit"
+	"github.com/sebasttiano/Blackbird.git/internal/handlers"
+	"github.com/sebasttiano/Blackbird.git/internal/health"
+	"github.com/sebasttiano/Blackbird.git/internal/ingest/graphite"
+	"github.com/sebasttiano/Blackbird.git/internal/ingest/remotewrite"
+	"github.com/sebasttiano/Blackbird.git/internal/ingest/statsd"
+	"github.com/sebasttiano/Blackbird.git/internal/ipfilter"
+	"github.com/sebasttiano/Blackbird.git/internal/keyring"
+	"github.com/sebasttiano/Blackbird.git/internal/selfmetrics"
 	"github.com/sebasttiano/Blackbird.git/internal/server"
+	"github.com/sebasttiano/Blackbird.git/internal/signing"
+	"github.com/sebasttiano/Blackbird.git/internal/tlsconfig"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/jmoiron/sqlx"
@@ -24,6 +37,9 @@ import (
 	"go.uber.org/zap"
 )
 
+// auditMemoryEntries сколько последних записей аудита хранить в памяти, если файл журнала не задан.
+const auditMemoryEntries = 10000
+
 var buildVersion = "N/A"
 var buildDate = "N/A"
 var buildCommit = "N/A"
@@ -60,7 +76,8 @@ func main() {
 
 // run инициализирует заисимости и запускает http сервер.
 func run(cfg *config.Config) {
-	serviceSettings := &service.Settings{SaveFilePath: cfg.FileStoragePath, Retries: cfg.RetriesDB, BackoffFactor: cfg.BackoffFactor, TrustedSubnet: nil}
+	serviceSettings := &service.Settings{SaveFilePath: cfg.FileStoragePath, Retries: cfg.RetriesDB, BackoffFactor: cfg.BackoffFactor, DedupWindow: cfg.DedupWindow, SelfMetricsPrefix: cfg.SelfMetricsPrefix}
+	serverConfig := &handlers.Config{ValidateRequests: cfg.ValidateRequests, AdminToken: cfg.AdminToken}
 	if cfg.DatabaseDSN != "" {
 		var conn *sqlx.DB
 		conn, err := sqlx.Connect("pgx", cfg.DatabaseDSN)
@@ -80,13 +97,89 @@ func run(cfg *config.Config) {
 	}
 
 	if cfg.TrustedSubnet != "" {
-		_, subnet, err := net.ParseCIDR(cfg.TrustedSubnet)
+		filter, err := newTrustedSubnet(cfg)
 		if err != nil {
-			logger.Log.Info("trusted subnet parse failed", zap.Error(err))
-		} else {
-			logger.Log.Info("trusted subnet parsed", zap.String("subnet", subnet.String()))
-			serviceSettings.TrustedSubnet = subnet
+			logger.Log.Error("trusted subnet parse failed", zap.Error(err))
+			os.Exit(1)
+		}
+		logger.Log.Info("trusted subnet parsed", zap.Any("subnets", filter.Subnets()), zap.Any("proxies", filter.Proxies()))
+		if len(filter.Proxies()) == 0 && !strings.EqualFold(cfg.TrustedHeaders, "none") {
+			logger.Log.Warn("proxy headers are ignored without trusted proxies, client address is taken from the connection")
+		}
+		serverConfig.TrustedSubnet = filter
+	}
+
+	tlsOpts := tlsconfig.ServerOptions{CertFile: cfg.TLSCert, KeyFile: cfg.TLSKey, ClientCAFile: cfg.TLSClientCA, ClientAuth: cfg.TLSClientAuth}
+	if tlsOpts.Enabled() {
+		tlsConfig, err := tlsconfig.Server(tlsOpts)
+		if err != nil {
+			logger.Log.Error("failed to load tls settings", zap.Error(err))
+			os.Exit(1)
+		}
+		serverConfig.TLS = tlsConfig
+		logger.Log.Info("tls is enabled", zap.Bool("mtls", cfg.TLSClientCA != ""))
+	}
+
+	grace := time.Duration(cfg.KeyGracePeriod) * time.Second
+	if cfg.Keyring != "" {
+		keys, err := keyring.Load(cfg.Keyring, grace)
+		if err != nil {
+			logger.Log.Error("failed to load keyring", zap.Error(err))
+			os.Exit(1)
+		}
+		logger.Log.Info("keyring loaded", zap.Int("keys", len(keys.Keys())))
+		serverConfig.Keys = keys
+	} else {
+		serverConfig.Keys = keyring.New(grace)
+	}
+	serverConfig.Signatures = signing.NewVerifier(serverConfig.Keys, time.Duration(cfg.SignMaxSkew)*time.Second, cfg.SignLegacy).
+		AllowUnsigned(cfg.SignAllowUnsigned)
+	if cfg.SignLegacy {
+		logger.Log.Warn("body-only HashSHA256 signatures are accepted, signed requests can be replayed")
+	}
+	if cfg.SignAllowUnsigned {
+		logger.Log.Warn("unsigned agent writes are accepted, captured requests can be replayed without signature headers")
+	}
+
+	var auditSink audit.Sink
+	if cfg.AuditFile != "" {
+		fileSink, err := audit.NewFileSink(cfg.AuditFile, cfg.AuditMaxSize*1024*1024, cfg.AuditMaxBackups)
+		if err != nil {
+			logger.Log.Error("failed to open audit file", zap.Error(err))
+			os.Exit(1)
+		}
+		auditSink = fileSink
+	} else {
+		auditSink = audit.NewMemorySink(auditMemoryEntries)
+	}
+	serviceSettings.Auditor = audit.NewAuditor(auditSink)
+	defer serviceSettings.Auditor.Close()
+
+	if cfg.RemoteWriteRules != "" {
+		rules, err := remotewrite.LoadRules(cfg.RemoteWriteRules)
+		if err != nil {
+			logger.Log.Error("failed to load remote write rules", zap.Error(err))
+			os.Exit(1)
 		}
+		serverConfig.RemoteWriteRules = rules
+	}
+
+	var graphiteTemplates []graphite.Template
+	if cfg.GraphiteTemplate != "" {
+		templates, err := graphite.LoadTemplates(cfg.GraphiteTemplate)
+		if err != nil {
+			logger.Log.Error("failed to load graphite templates", zap.Error(err))
+			os.Exit(1)
+		}
+		graphiteTemplates = templates
+	}
+
+	if cfg.OTLPResourceAttr != "" {
+		serverConfig.OTLPResourceAttributes = strings.Split(cfg.OTLPResourceAttr, ",")
+	}
+
+	if cfg.InfluxNameTags != "" {
+		serverConfig.InfluxNameTags = strings.Split(cfg.InfluxNameTags, ",")
 	}
 
 	var privateKey []byte
@@ -95,11 +188,14 @@ func run(cfg *config.Config) {
 		privateKey, err = os.ReadFile(cfg.CryptoKey)
 		if err != nil {
 			logger.Log.Error("failed to read crypto key", zap.Error(err))
+			os.Exit(1)
 		}
 	}
 
-	if err := currentApp.Initialize(serviceSettings, cfg.SecretKey, privateKey); err != nil {
+	// без хранилища, окна пакетов, токенов или спецификации OpenAPI сервер работал бы не так, как настроен
+	if err := currentApp.Initialize(serviceSettings, serverConfig, secrets{AuthTokens: cfg.AuthTokens, AgentSecrets: cfg.AgentSecrets, Key: cfg.SecretKey, PrivateKey: privateKey}); err != nil {
 		logger.Log.Error("failed to init app", zap.Error(err))
+		os.Exit(1)
 	}
 
 	if cfg.StoreInterval > 0 {
@@ -107,11 +203,18 @@ func run(cfg *config.Config) {
 		go service.TickerSaver(ticker, currentApp.service)
 	}
 
+	// метрики восстанавливаются до старта серверов, чтобы восстановление не затерло новые записи.
+	// Сбой восстановления остается в проверке готовности, отсутствие файла значит, что восстанавливать нечего.
 	if *cfg.RestoreMetrics && serviceSettings.FileSave {
-		if err := currentApp.service.Restore(); err != nil {
-			logger.Log.Error("couldn`t restore data")
+		restored := health.NewFlag("metrics are not restored yet")
+		currentApp.views.Health.Register("restore", restored)
+		if err := currentApp.service.Restore(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logger.Log.Error("couldn`t restore data", zap.Error(err))
+			restored.Fail(fmt.Errorf("failed to restore metrics: %w", err))
+		} else {
+			restored.Done()
+			logger.Log.Debug("metrics were restored")
 		}
-		logger.Log.Debug("metrics were restored")
 	}
 
 	srv := server.NewServer(cfg.ServerIPAddr, &currentApp.views, currentApp.views.InitRouter())
@@ -123,14 +226,55 @@ func run(cfg *config.Config) {
 	wg.Add(1)
 
 	if cfg.GRPSServerIPAddr != "" {
-		grpcSrv := server.NewGRPSServer(currentApp.service)
+		grpcSrv := server.NewGRPSServer(currentApp.service, *serverConfig, currentApp.views.OTLPReceiver, currentApp.views.Health)
+		currentApp.views.Health.Register("grpc", grpcSrv)
 		wg.Add(1)
 		go grpcSrv.Start(cfg.GRPSServerIPAddr)
 		go grpcSrv.HandleShutdown(ctx, wg)
 	}
 
+	if cfg.SelfMetricsPrefix != "" {
+		recorder := selfmetrics.NewRecorder(serviceSettings.SelfMetrics, cfg.SelfMetricsPrefix, currentApp.service)
+		go recorder.Run(ctx, time.Duration(cfg.SelfMetricsInterval)*time.Second)
+	}
+
+	if cfg.StatsdAddr != "" {
+		statsdSrv := statsd.NewServer(cfg.StatsdAddr, time.Duration(cfg.StatsdFlush)*time.Second, currentApp.service)
+		wg.Add(1)
+		go statsdSrv.Start()
+		go statsdSrv.HandleShutdown(ctx, wg)
+	}
+
+	if cfg.GraphiteAddr != "" {
+		graphiteSrv := graphite.NewServer(cfg.GraphiteAddr, cfg.GraphitePickle, graphite.NewMapper(graphiteTemplates), currentApp.service)
+		wg.Add(1)
+		go graphiteSrv.Start()
+		go graphiteSrv.HandleShutdown(ctx, wg)
+	}
+
 	go srv.Start(cfg)
 	go srv.HandleShutdown(ctx, wg, cfg)
 
 	wg.Wait()
 }
+
+// newTrustedSubnet собирает фильтр доверенных подсетей из конфига. Заголовки "none" или пустой список
+// доверенных прокси означают, что адрес клиента берется только из соединения.
+func newTrustedSubnet(cfg *config.Config) (*ipfilter.Filter, error) {
+	prefixes, err := ipfilter.ParseSubnets(cfg.TrustedSubnet)
+	if err != nil {
+		return nil, err
+	}
+	proxies, err := ipfilter.ParseSubnets(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	var headers []string
+	switch {
+	case strings.EqualFold(cfg.TrustedHeaders, "none"):
+		headers = []string{}
+	case cfg.TrustedHeaders != "":
+		headers = strings.Split(cfg.TrustedHeaders, ",")
+	}
+	return ipfilter.New(prefixes, proxies, headers, cfg.TrustedProxyDepth)
+}
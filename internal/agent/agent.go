@@ -3,10 +3,12 @@ package agent
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"math/rand"
 	"regexp"
 	"runtime"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -14,8 +16,12 @@ import (
 	"github.com/shirou/gopsutil/v3/mem"
 
 	"github.com/sebasttiano/Blackbird.git/internal/common"
+	"github.com/sebasttiano/Blackbird.git/internal/ingest/statsd"
 	"github.com/sebasttiano/Blackbird.git/internal/logger"
+	"github.com/sebasttiano/Blackbird.git/internal/models"
+	"github.com/sebasttiano/Blackbird.git/internal/signing"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 )
 
 var ErrInitSender = errors.New("failed to init sender")
@@ -63,6 +69,75 @@ type GopsutilMetricsSet struct {
 
 type Sender interface {
 	SendToRepo(jobsMetrics <-chan MetricsSet, jobsGMetrics <-chan GopsutilMetricsSet) error
+	SendBatch(ctx context.Context, metrics []models.Metrics) error
+}
+
+// senderWriter отправляет агрегированные метрики StatsD на сервер через Sender агента.
+type senderWriter struct {
+	sender Sender
+}
+
+// SetModelValueBatch реализует ingest.Writer. Метрики, отклоненные сервером, считает Sender.
+func (w senderWriter) SetModelValueBatch(ctx context.Context, _ string, metrics []*models.Metrics, _ bool) (*models.BatchResult, error) {
+	batch := make([]models.Metrics, 0, len(metrics))
+	for _, m := range metrics {
+		batch = append(batch, *m)
+	}
+	if err := w.sender.SendBatch(ctx, batch); err != nil {
+		return nil, err
+	}
+	return &models.BatchResult{Accepted: len(batch), Results: []models.MetricResult{}}, nil
+}
+
+// rejectCounter считает метрики, которые сервер отклонил при пакетной отправке.
+type rejectCounter struct {
+	rejected int64
+}
+
+// Rejected возвращает общее количество отклоненных сервером метрик.
+func (r *rejectCounter) Rejected() int64 {
+	return atomic.LoadInt64(&r.rejected)
+}
+
+// countRejected логгирует отклоненные метрики из ответа сервера и увеличивает счетчик.
+func (r *rejectCounter) countRejected(results []models.MetricResult) int {
+	var rejected int
+	for _, result := range results {
+		if result.Status != models.StatusRejected {
+			continue
+		}
+		rejected++
+		logger.Log.Warn("server rejected metric",
+			zap.String("id", result.ID),
+			zap.String("type", result.MType),
+			zap.String("reason", result.Reason),
+			zap.String("message", result.Message))
+	}
+	if rejected > 0 {
+		total := atomic.AddInt64(&r.rejected, int64(rejected))
+		logger.Log.Warn("some metrics were rejected by server", zap.Int("rejected", rejected), zap.Int64("total_rejected", total))
+	}
+	return rejected
+}
+
+// batchSequence выдает идентификаторы пакетов метрик вида <agent id>:<номер>.
+// Нумерация начинается с времени запуска в наносекундах, чтобы номера не повторялись после перезапуска агента.
+type batchSequence struct {
+	agentID string
+	seq     uint64
+}
+
+// newBatchSequence конструктор для batchSequence.
+func newBatchSequence(agentID string) *batchSequence {
+	return &batchSequence{agentID: agentID, seq: uint64(time.Now().UnixNano())}
+}
+
+// nextBatchID возвращает идентификатор очередного пакета, пустой если агент не знает свой идентификатор.
+func (b *batchSequence) nextBatchID() string {
+	if b == nil || b.agentID == "" {
+		return ""
+	}
+	return b.agentID + ":" + strconv.FormatUint(atomic.AddUint64(&b.seq, 1), 10)
 }
 
 // Agent - тип, который реализует сущность агент.
@@ -75,37 +150,88 @@ type Agent struct {
 	Sender     Sender
 }
 
+// KeyIDs идентификаторы ключей агента в наборе ключей сервера. Пустые означают ключи сервера по умолчанию.
+type KeyIDs struct {
+	// Sign идентификатор ключа подписи HMAC.
+	Sign string
+	// Encryption идентификатор приватного ключа RSA, парного публичному ключу агента.
+	Encryption string
+}
+
+// Config настройки агента: адреса сервера, повторы, подпись, шифрование и доступ.
+// Без GRPCServer агент отправляет метрики по REST на ServerAddr.
+type Config struct {
+	// ServerAddr адрес REST сервера со схемой, например http://localhost:8080.
+	ServerAddr string
+	// ClientRetries сколько раз повторять запрос REST после ошибки.
+	ClientRetries int
+	// BackoffFactor множитель паузы между повторами в секундах.
+	BackoffFactor uint
+	// SignKey секрет HMAC для подписи запросов, пустой отключает подпись.
+	SignKey string
+	// PublicKey публичный ключ RSA сервера в PEM для шифрования метрик, пустой отключает шифрование.
+	PublicKey []byte
+	// GRPCServer адрес gRPC сервера, если задан, метрики уходят по gRPC.
+	GRPCServer string
+	// AgentID идентификатор агента для реестра агентов и защиты от повторной доставки пакетов.
+	AgentID string
+	// AuthToken API токен с правом write, пустой если сервер не требует токенов.
+	AuthToken string
+	// TLS включает TLS к серверу, nil означает соединение без шифрования.
+	TLS *tls.Config
+	// KeyIDs идентификаторы ключей агента в наборе ключей сервера.
+	KeyIDs KeyIDs
+	// LegacySign подписывает только тело запроса REST для серверов, которые не проверяют время и nonce.
+	LegacySign bool
+}
+
 // NewAgent - конструктор для типа Agent.
-func NewAgent(serverAddr string, clientRetries int, backoffFactor uint, signKey string, publicKey []byte, grpcServer string) (*Agent, error) {
+func NewAgent(cfg Config) (*Agent, error) {
 	getCounter := new(int64)
 	re, _ := regexp.Compile("^.+://(.+$)")
-	addr := re.FindAllStringSubmatch(serverAddr, 1)
+	addr := re.FindAllStringSubmatch(cfg.ServerAddr, 1)
 	x, err := common.GetLocalIP(addr[0][1])
 	var xRealIP string
 	if err != nil {
-		logger.Log.Warn("failed to get local IP", zap.String("serverAddr", serverAddr))
+		logger.Log.Warn("failed to get local IP", zap.String("serverAddr", cfg.ServerAddr))
 		xRealIP = ""
 	} else {
 		xRealIP = x.String()
 	}
 
-	if grpcServer != "" {
-		gClient, err := NewGRPCClient(grpcServer)
+	if cfg.GRPCServer != "" {
+		var opts []grpc.DialOption
+		if cfg.SignKey != "" {
+			opts = append(opts, grpc.WithUnaryInterceptor(signing.UnaryClientInterceptor(cfg.SignKey, cfg.KeyIDs.Sign)))
+		}
+		gClient, err := NewGRPCClient(cfg.GRPCServer, cfg.AgentID, cfg.AuthToken, cfg.TLS, opts...)
 		if err != nil {
 			return nil, err
 		}
+		gClient.xRealIP = xRealIP
+		gClient.publicKey = common.UnmarshalRSAPublic(cfg.PublicKey)
+		gClient.keyIDs = cfg.KeyIDs
 		return &Agent{
 			getCounter: *getCounter,
 			Sender:     gClient,
 		}, nil
 	}
+	client := common.NewHTTPClient(cfg.ServerAddr, cfg.ClientRetries, cfg.BackoffFactor)
+	if cfg.TLS != nil {
+		client = client.WithTLSConfig(cfg.TLS)
+	}
 	return &Agent{
 		getCounter: *getCounter,
 		Sender: &HTTPSender{
-			client:    common.NewHTTPClient(serverAddr, clientRetries, backoffFactor),
-			signKey:   signKey,
-			publicKey: common.UnmarshalRSAPublic(publicKey),
-			XRealIP:   xRealIP,
+			client:     client,
+			signKey:    cfg.SignKey,
+			publicKey:  common.UnmarshalRSAPublic(cfg.PublicKey),
+			XRealIP:    xRealIP,
+			agentID:    cfg.AgentID,
+			authToken:  cfg.AuthToken,
+			keyIDs:     cfg.KeyIDs,
+			legacySign: cfg.LegacySign,
+			batches:    newBatchSequence(cfg.AgentID),
 		},
 	}, nil
 }
@@ -184,6 +310,30 @@ func (a *Agent) GetGopsutilMetrics(ctx context.Context, getInterval time.Duratio
 	}
 }
 
+// RunStatsd - запускает на агенте прием метрик по протоколу StatsD. Метрики агрегируются за flushInterval
+// и отправляются на сервер тем же способом, что и собственные метрики агента. Прием останавливается вместе с ctx.
+func (a *Agent) RunStatsd(ctx context.Context, addr string, flushInterval time.Duration) error {
+	srv := statsd.NewServer(addr, flushInterval, senderWriter{sender: a.Sender})
+	if err := srv.Listen(); err != nil {
+		return err
+	}
+	logger.Log.Info("Running statsd listener", zap.String("address", srv.Addr()))
+
+	a.WG.Add(1)
+	go func() {
+		defer a.WG.Done()
+		go srv.Serve()
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logger.Log.Error("couldn`t flush statsd metrics on shutdown", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
 // SendMetrics - метод  через переданный интервал времени передает на сервер метрики.
 func (a *Agent) SendMetrics(ctx context.Context, sendInterval time.Duration, jobsMetrics <-chan MetricsSet, jobsGMetrics <-chan GopsutilMetricsSet) {
 	tick := time.NewTicker(sendInterval)
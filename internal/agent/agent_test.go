@@ -2,23 +2,35 @@ package agent
 
 import (
 	"context"
+	"net"
+	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/sebasttiano/Blackbird.git/internal/common"
 	"github.com/sebasttiano/Blackbird.git/internal/handlers"
+	"github.com/sebasttiano/Blackbird.git/internal/keyring"
+	"github.com/sebasttiano/Blackbird.git/internal/models"
+	pb "github.com/sebasttiano/Blackbird.git/internal/proto"
 	"github.com/sebasttiano/Blackbird.git/internal/repository"
 	"github.com/sebasttiano/Blackbird.git/internal/service"
+	"github.com/sebasttiano/Blackbird.git/internal/service/dedup"
+	"github.com/sebasttiano/Blackbird.git/internal/signing"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
 )
 
 func TestGetMetrics(t *testing.T) {
-	views := handlers.NewServerViews(service.NewService(&service.Settings{}, repository.NewMemStorage()))
+	views := handlers.NewServerViews(service.NewService(&service.Settings{}, repository.NewMemStorage()), handlers.Config{})
 	router := views.InitRouter()
 	server := httptest.NewServer(router)
 	defer server.Close()
 	serverURL := server.URL
-	a, _ := NewAgent(serverURL, 3, 1, "", nil, "")
+	a, _ := NewAgent(Config{ServerAddr: serverURL, ClientRetries: 3, BackoffFactor: 1, AgentID: "test-agent"})
 
 	t.Run("Test running intervals", func(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
@@ -32,7 +44,7 @@ func TestGetMetrics(t *testing.T) {
 }
 
 func BenchmarkAgentMetrics(b *testing.B) {
-	a, _ := NewAgent("localhost:8080", 1, 1, "", nil, "")
+	a, _ := NewAgent(Config{ServerAddr: "localhost:8080", ClientRetries: 1, BackoffFactor: 1})
 
 	var jobsMetricCount int
 	var jobsGMetricCount int
@@ -60,3 +72,92 @@ func BenchmarkAgentMetrics(b *testing.B) {
 		b.ReportMetric(float64(jobsGMetricCount)/float64(b.N), "gopsutil_jobs/op")
 	})
 }
+
+func TestRejectCounter_countRejected(t *testing.T) {
+	var r rejectCounter
+
+	results := []models.MetricResult{
+		{ID: "Alloc", MType: "gauge", Status: models.StatusAccepted},
+		{ID: "", MType: "gauge", Status: models.StatusRejected, Reason: models.ReasonEmptyID},
+		{ID: "PollCount", MType: "counter", Status: models.StatusRejected, Reason: models.ReasonMissingValue},
+	}
+
+	assert.Equal(t, 2, r.countRejected(results))
+	assert.Equal(t, 0, r.countRejected(nil))
+	assert.Equal(t, 2, r.countRejected(results))
+	assert.Equal(t, int64(4), r.Rejected())
+}
+
+func TestBatchSequence_nextBatchID(t *testing.T) {
+	b := newBatchSequence("host-1")
+	first := b.nextBatchID()
+	second := b.nextBatchID()
+
+	assert.True(t, strings.HasPrefix(first, "host-1:"))
+	assert.NotEqual(t, first, second)
+	// новый запуск агента не повторяет старые номера
+	assert.NotEqual(t, first, newBatchSequence("host-1").nextBatchID())
+
+	assert.Equal(t, "", newBatchSequence("").nextBatchID())
+	var empty *batchSequence
+	assert.Equal(t, "", empty.nextBatchID())
+}
+
+func TestHTTPSender_SendBatchRetry(t *testing.T) {
+	keys := keyring.New(0)
+	require.NoError(t, keys.Add(keyring.Key{ID: keyring.DefaultID, Type: keyring.TypeHMAC, Secret: "secret"}))
+	repo := repository.NewMemStorage()
+	views := handlers.NewServerViews(service.NewService(&service.Settings{Retries: 1, BackoffFactor: 1, Dedup: dedup.NewMemoryWindow(10)}, repo),
+		handlers.Config{Keys: keys, Signatures: signing.NewVerifier(keys, 0, false)})
+	router := views.InitRouter()
+
+	var mu sync.Mutex
+	var nonces []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		nonces = append(nonces, r.Header.Get(signing.HeaderNonce))
+		first := len(nonces) == 1
+		mu.Unlock()
+		if !first {
+			router.ServeHTTP(w, r)
+			return
+		}
+		// первый пакет применяется, но ответ теряется вместе с соединением
+		router.ServeHTTP(httptest.NewRecorder(), r)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		require.NoError(t, err)
+		conn.Close()
+	}))
+	defer server.Close()
+
+	delta := int64(5)
+	sender := &HTTPSender{
+		client:  common.NewHTTPClient(server.URL, 2, 1),
+		signKey: "secret",
+		agentID: "host-1",
+		batches: newBatchSequence("host-1"),
+	}
+	require.NoError(t, sender.SendBatch(context.Background(), []models.Metrics{{ID: "PollCount", MType: "counter", Delta: &delta}}))
+
+	require.Len(t, nonces, 2)
+	assert.NotEqual(t, nonces[0], nonces[1], "повтор должен быть подписан заново")
+	assert.Equal(t, int64(5), repo.Counter["PollCount"], "повтор пакета не применяется дважды")
+}
+
+func TestGRPCClient_SendBatchRejected(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := grpc.NewServer()
+	pb.RegisterMetricsServer(s, &handlers.MetricsServer{Service: service.NewService(&service.Settings{Retries: 1, BackoffFactor: 1}, repository.NewMemStorage())})
+	go s.Serve(lis)
+	defer s.Stop()
+
+	client, err := NewGRPCClient(lis.Addr().String(), "", "", nil)
+	require.NoError(t, err)
+
+	// пакет отклонен целиком, причины приходят деталью статуса и повторять его не нужно
+	value := 1.5
+	err = client.SendBatch(context.Background(), []models.Metrics{{ID: "", MType: "gauge", Value: &value}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), client.Rejected())
+}
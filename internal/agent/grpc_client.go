@@ -2,29 +2,50 @@ package agent
 
 import (
 	"context"
+	"crypto/rsa"
+	"crypto/tls"
 	"fmt"
 	"reflect"
 	"time"
 
+	"github.com/sebasttiano/Blackbird.git/internal/common"
 	"github.com/sebasttiano/Blackbird.git/internal/logger"
+	"github.com/sebasttiano/Blackbird.git/internal/models"
 	pb "github.com/sebasttiano/Blackbird.git/internal/proto"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 // GRPCClient реализующий интерфейс Sender, отправляет на gRPC сервер
 type GRPCClient struct {
-	client pb.MetricsClient
-	conn   *grpc.ClientConn
+	client    pb.MetricsClient
+	conn      *grpc.ClientConn
+	agentID   string
+	authToken string
+	// xRealIP адрес агента для проверки доверенной подсети на сервере.
+	xRealIP string
+	// publicKey ключ сервера, если задан, метрики отправляются зашифрованным конвертом.
+	publicKey *rsa.PublicKey
+	keyIDs    KeyIDs
+	batches   *batchSequence
+	rejectCounter
 }
 
-// NewGRPCClient - конструктор для GRPCClient
-func NewGRPCClient(serverAddr string) (*GRPCClient, error) {
+// NewGRPCClient - конструктор для GRPCClient. С nil tlsConfig соединение идет без TLS, opts дополнительные
+// настройки соединения, например интерцептор подписи.
+func NewGRPCClient(serverAddr string, agentID string, authToken string, tlsConfig *tls.Config, opts ...grpc.DialOption) (*GRPCClient, error) {
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
 	// устанавливаем соединение с сервером
-	conn, err := grpc.NewClient(serverAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.NewClient(serverAddr, append(opts, grpc.WithTransportCredentials(creds))...)
 	if err != nil {
 		logger.Log.Error("failed to create grpc client", zap.Error(err))
 		return nil, fmt.Errorf("%w: %v", ErrInitSender, err)
@@ -33,8 +54,11 @@ func NewGRPCClient(serverAddr string) (*GRPCClient, error) {
 	c := pb.NewMetricsClient(conn)
 
 	return &GRPCClient{
-		client: c,
-		conn:   conn,
+		client:    c,
+		conn:      conn,
+		agentID:   agentID,
+		authToken: authToken,
+		batches:   newBatchSequence(agentID),
 	}, nil
 }
 
@@ -48,10 +72,6 @@ func (g *GRPCClient) CloseConnection() error {
 
 // SendToRepo собирает из каналов метрики, формирует и шлет protobuf сообщение в репозиторий
 func (g *GRPCClient) SendToRepo(jobsMetrics <-chan MetricsSet, jobsGMetrics <-chan GopsutilMetricsSet) error {
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
 	var metric MetricsSet
 	var metricG GopsutilMetricsSet
 	var metricsBatch []*pb.Metric
@@ -85,10 +105,52 @@ func (g *GRPCClient) SendToRepo(jobsMetrics <-chan MetricsSet, jobsGMetrics <-ch
 		metricsBatch = append(metricsBatch, &metrics)
 	}
 
+	return g.sendProto(context.Background(), metricsBatch)
+}
+
+// SendBatch отправляет пачку метрик через UpdateMetrics.
+func (g *GRPCClient) SendBatch(ctx context.Context, metrics []models.Metrics) error {
+	metricsBatch := make([]*pb.Metric, 0, len(metrics))
+	for _, m := range metrics {
+		metric := &pb.Metric{Id: m.ID}
+		if m.MType == "counter" && m.Delta != nil {
+			metric.Type = pb.MetricType_counter
+			metric.Delta = *m.Delta
+		} else if m.Value != nil {
+			metric.Type = pb.MetricType_gauge
+			metric.Value = *m.Value
+		}
+		metricsBatch = append(metricsBatch, metric)
+	}
+	return g.sendProto(ctx, metricsBatch)
+}
+
+// sendProto шлет пачку protobuf метрик на сервер.
+func (g *GRPCClient) sendProto(ctx context.Context, metricsBatch []*pb.Metric) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if g.agentID != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, common.AgentIDHeader, g.agentID)
+	}
+	if g.authToken != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+g.authToken)
+	}
+	if g.xRealIP != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, common.RealIPHeader, g.xRealIP)
+	}
+
 	if len(metricsBatch) > 0 {
-		_, err := g.client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: metricsBatch})
+		batchID := g.batches.nextBatchID()
+		response, err := g.updateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: metricsBatch, BatchId: batchID})
 		if err != nil {
 			if e, ok := status.FromError(err); ok {
+				// отклоненный сервером пакет приходит с результатом по каждой метрике, как 4xx в REST,
+				// и повторять его бессмысленно
+				if result := batchResultFromStatus(e); result != nil && e.Code() != codes.Unavailable && e.Code() != codes.Internal {
+					rejected := g.countRejected(resultsFromProto(result.GetResults()))
+					logger.Log.Error("server rejected metrics", zap.String("error", e.Message()), zap.Int("rejected", rejected))
+					return nil
+				}
 				switch e.Code() {
 				case codes.DeadlineExceeded:
 					logger.Log.Error("server context deadline exceeded", zap.String("error", e.Message()))
@@ -102,8 +164,57 @@ func (g *GRPCClient) SendToRepo(jobsMetrics <-chan MetricsSet, jobsGMetrics <-ch
 			}
 			return err
 		}
-		logger.Log.Info("send metrics to repository server successfully.")
+		if response.GetDuplicate() {
+			logger.Log.Info("server already accepted this batch", zap.String("batch_id", batchID))
+			return nil
+		}
+		rejected := g.countRejected(resultsFromProto(response.GetResults()))
+		logger.Log.Info("send metrics to repository server successfully.", zap.Int32("accepted", response.GetAccepted()), zap.Int("rejected", rejected))
 	}
 
 	return nil
 }
+
+// batchResultFromStatus достает результат по каждой метрике из деталей статуса ошибки.
+func batchResultFromStatus(st *status.Status) *pb.UpdateMetricsResponse {
+	for _, detail := range st.Details() {
+		if result, ok := detail.(*pb.UpdateMetricsResponse); ok {
+			return result
+		}
+	}
+	return nil
+}
+
+// resultsFromProto конвертирует результаты обработки метрик из protobuf сообщения
+func resultsFromProto(in []*pb.MetricResult) []models.MetricResult {
+	results := make([]models.MetricResult, 0, len(in))
+	for _, r := range in {
+		result := models.MetricResult{ID: r.Id, MType: r.Type.String(), Status: models.StatusAccepted}
+		if !r.Accepted {
+			result.Status = models.StatusRejected
+			result.Reason = r.Reason
+			result.Message = r.Message
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// updateMetrics отправляет пачку метрик, при заданном ключе сервера шифрует ее конвертом.
+func (g *GRPCClient) updateMetrics(ctx context.Context, req *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
+	if g.publicKey == nil {
+		return g.client.UpdateMetrics(ctx, req)
+	}
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	envelope, err := common.EncryptEnvelope(data, g.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if g.keyIDs.Encryption != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, common.EncryptionKeyIDHeader, g.keyIDs.Encryption)
+	}
+	return g.client.UpdateMetricsEncrypted(ctx, &pb.EncryptedMessage{Envelope: envelope})
+}
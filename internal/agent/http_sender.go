@@ -2,18 +2,18 @@ package agent
 
 import (
 	"bytes"
-	"crypto/hmac"
+	"context"
 	"crypto/rsa"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
 	"reflect"
 
 	"github.com/sebasttiano/Blackbird.git/internal/common"
 	"github.com/sebasttiano/Blackbird.git/internal/logger"
 	"github.com/sebasttiano/Blackbird.git/internal/models"
+	"github.com/sebasttiano/Blackbird.git/internal/signing"
 	"go.uber.org/zap"
 )
 
@@ -23,6 +23,13 @@ type HTTPSender struct {
 	signKey   string
 	publicKey *rsa.PublicKey
 	XRealIP   string
+	agentID   string
+	authToken string
+	keyIDs    KeyIDs
+	// legacySign подписывает только тело заголовком HashSHA256 для серверов без защиты от повторов.
+	legacySign bool
+	batches    *batchSequence
+	rejectCounter
 }
 
 // SendToRepo собирает из каналов метрики, формирует и шлет http запрос в репозиторий
@@ -60,55 +67,101 @@ func (h *HTTPSender) SendToRepo(jobsMetrics <-chan MetricsSet, jobsGMetrics <-ch
 		metricsBatch = append(metricsBatch, metrics)
 	}
 
-	if len(metricsBatch) > 0 {
-		// Make an HTTP post request
-		reqBody, err := json.Marshal(metricsBatch)
-		if err != nil {
-			logger.Log.Error("couldn`t serialize to json", zap.Error(err))
-			return fmt.Errorf("%w: %v", ErrSendToRepo, err)
+	return h.SendBatch(context.Background(), metricsBatch)
+}
 
+// SendBatch отправляет пачку метрик на /updates/.
+func (h *HTTPSender) SendBatch(ctx context.Context, metricsBatch []models.Metrics) error {
+	if len(metricsBatch) == 0 {
+		return nil
+	}
+
+	// Make an HTTP post request
+	reqBody, err := json.Marshal(metricsBatch)
+	if err != nil {
+		logger.Log.Error("couldn`t serialize to json", zap.Error(err))
+		return fmt.Errorf("%w: %v", ErrSendToRepo, err)
+
+	}
+
+	compressedData, err := common.Compress(reqBody)
+	if err != nil {
+		logger.Log.Error("failed to compress data to gzip", zap.Error(err))
+		return fmt.Errorf("%w: %v", ErrSendToRepo, err)
+	}
+
+	headers := map[string]string{"Content-Type": "application/json", "Content-Encoding": "gzip", common.RealIPHeader: h.XRealIP}
+	if h.agentID != "" {
+		headers[common.AgentIDHeader] = h.agentID
+	}
+	if h.authToken != "" {
+		headers["Authorization"] = "Bearer " + h.authToken
+	}
+	if batchID := h.batches.nextBatchID(); batchID != "" {
+		headers[common.BatchIDHeader] = batchID
+	}
+	if h.signKey != "" && h.legacySign {
+		headers[signing.HeaderLegacy] = signing.LegacySum(h.signKey, compressedData.Bytes())
+		if h.keyIDs.Sign != "" {
+			headers[common.SignKeyIDHeader] = h.keyIDs.Sign
 		}
+	}
+	// подпись покрывает сжатое тело до шифрования
+	signed := compressedData.Bytes()
 
-		compressedData, err := common.Compress(reqBody)
+	if h.publicKey != nil {
+		encrypted, err := common.EncryptEnvelope(compressedData.Bytes(), h.publicKey)
 		if err != nil {
-			logger.Log.Error("failed to compress data to gzip", zap.Error(err))
+			// без шифрования метрики не отправляются
+			logger.Log.Error("couldn`t encrypt json data", zap.Error(err))
 			return fmt.Errorf("%w: %v", ErrSendToRepo, err)
 		}
-
-		headers := map[string]string{"Content-Type": "application/json", "Content-Encoding": "gzip", "X-Real-IP": h.XRealIP}
-		if h.signKey != "" {
-			data := *compressedData
-			h := hmac.New(sha256.New, []byte(h.signKey))
-			if _, errWr := h.Write(data.Bytes()); errWr != nil {
-				logger.Log.Error("failed to create hmac signature")
-				return fmt.Errorf("%w: %v", ErrSendToRepo, err)
-			}
-			dst := h.Sum(nil)
-			logger.Log.Info("create hmac signature")
-			headers["HashSHA256"] = hex.EncodeToString(dst)
+		headers[common.EncryptionHeader] = common.EnvelopeV1
+		if h.keyIDs.Encryption != "" {
+			headers[common.EncryptionKeyIDHeader] = h.keyIDs.Encryption
 		}
+		compressedData = bytes.NewBuffer(encrypted)
+	}
 
-		if h.publicKey != nil {
-			encrypted, err := common.EncryptRSA(compressedData.String(), h.publicKey)
-			if err != nil {
-				logger.Log.Error("couldn`t encrypt json data", zap.Error(err))
-			}
-			compressedData = bytes.NewBuffer([]byte(encrypted))
+	// каждая попытка подписывается заново: повтор с тем же nonce сервер отклонит как перехваченный
+	res, err := h.client.PostWith("/updates/", compressedData.Bytes(), func(r *http.Request) error {
+		for k, v := range headers {
+			r.Header.Set(k, v)
 		}
-
-		res, err := h.client.Post("/updates/", compressedData, headers)
+		if h.signKey == "" || h.legacySign {
+			return nil
+		}
+		signHeaders, err := signing.Sign(h.signKey, h.keyIDs.Sign, http.MethodPost, "/updates/", headers[common.BatchIDHeader], signed)
 		if err != nil {
-			logger.Log.Error(fmt.Sprintf("couldn`t send metrics batch of length %d", len(metricsBatch)), zap.Error(err))
-			return fmt.Errorf("%w: %v", ErrSendToRepo, err)
+			logger.Log.Error("failed to create hmac signature", zap.Error(err))
+			return err
 		}
-		answer, _ := io.ReadAll(res.Body)
-		res.Body.Close()
-
-		if res.StatusCode != 200 {
-			logger.Log.Error(fmt.Sprintf("error: server return code %d: message: %s", res.StatusCode, answer))
-			return fmt.Errorf("%w: %v", ErrSendToRepo, err)
+		for k, v := range signHeaders {
+			r.Header.Set(k, v)
 		}
-		logger.Log.Info("send metrics to repository server successfully.")
+		return nil
+	})
+	if err != nil {
+		logger.Log.Error(fmt.Sprintf("couldn`t send metrics batch of length %d", len(metricsBatch)), zap.Error(err))
+		return fmt.Errorf("%w: %v", ErrSendToRepo, err)
+	}
+	answer, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+
+	var result models.BatchResult
+	if err := json.Unmarshal(answer, &result); err != nil {
+		logger.Log.Debug("couldn`t decode batch result from server answer", zap.Error(err))
+	}
+	if result.Duplicate {
+		logger.Log.Info("server already accepted this batch", zap.String("batch_id", headers[common.BatchIDHeader]))
+		return nil
+	}
+	rejected := h.countRejected(result.Results)
+
+	if res.StatusCode != http.StatusOK && (res.StatusCode >= http.StatusInternalServerError || len(result.Results) == 0) {
+		logger.Log.Error(fmt.Sprintf("error: server return code %d: message: %s", res.StatusCode, answer))
+		return fmt.Errorf("%w: server return code %d", ErrSendToRepo, res.StatusCode)
 	}
+	logger.Log.Info("send metrics to repository server successfully.", zap.Int("accepted", result.Accepted), zap.Int("rejected", rejected))
 	return nil
 }
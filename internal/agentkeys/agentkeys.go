@@ -0,0 +1,68 @@
+// Package agentkeys хранит персональные секреты подписи агентов. Секрет выбирается по идентификатору
+// агента из заголовка X-Agent-ID, поэтому утечка секрета с одной машины не затрагивает остальные агенты,
+// а отзыв агента отключает только его. Реестр хранится в JSON файле или в Postgres.
+//
+// Для HMAC серверу нужен сам секрет, поэтому в реестре он хранится открытым текстом: права на файл
+// и таблицу должны быть не шире, чем у ключа KEY.
+package agentkeys
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// secretSize сколько случайных байт в секрете агента.
+const secretSize = 32
+
+// ErrAgentNotFound ошибка, если агента нет в реестре.
+var ErrAgentNotFound = errors.New("agent is not enrolled")
+
+// ErrAgentRevoked ошибка, если агент отозван.
+var ErrAgentRevoked = errors.New("agent is revoked")
+
+// ErrAgentExists ошибка, если действующий агент с таким идентификатором уже есть.
+var ErrAgentExists = errors.New("agent is already enrolled, revoke it first")
+
+// Agent агент и его секрет подписи.
+type Agent struct {
+	ID        string     `json:"id" db:"agent_id"`
+	Secret    string     `json:"secret" db:"secret"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+// Active проверяет, что агент не отозван.
+func (a *Agent) Active() error {
+	if a.RevokedAt != nil {
+		return fmt.Errorf("%w: %q", ErrAgentRevoked, a.ID)
+	}
+	return nil
+}
+
+// Store хранилище агентов.
+type Store interface {
+	// Lookup ищет агента по идентификатору, включая отозванных.
+	Lookup(ctx context.Context, id string) (*Agent, error)
+	// List возвращает всех агентов, включая отозванных.
+	List(ctx context.Context) ([]Agent, error)
+	// Add регистрирует агента. Отозванный агент с тем же идентификатором заменяется новым.
+	Add(ctx context.Context, agent *Agent) error
+	// Revoke отзывает агента.
+	Revoke(ctx context.Context, id string) error
+}
+
+// NewAgent создает агента с новым случайным секретом.
+func NewAgent(id string) (*Agent, error) {
+	if id == "" {
+		return nil, errors.New("agent id is required")
+	}
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return &Agent{ID: id, Secret: hex.EncodeToString(b), CreatedAt: time.Now().UTC()}, nil
+}
@@ -0,0 +1,189 @@
+package agentkeys
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sebasttiano/Blackbird.git/internal/jsonfile"
+	"github.com/sebasttiano/Blackbird.git/internal/logger"
+	"go.uber.org/zap"
+)
+
+// FileStore хранит агентов в JSON файле. Файл перечитывается при изменении,
+// поэтому регистрация и отзыв агентов командой blackbirdctl действуют без перезапуска сервера.
+type FileStore struct {
+	mu   sync.Mutex
+	file *jsonfile.List[Agent]
+}
+
+// NewFileStore конструктор для FileStore. Отсутствующий файл означает пустой реестр.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{file: jsonfile.New[Agent](path, "agents")}
+	if err := s.file.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// find возвращает индекс агента или -1.
+func (s *FileStore) find(id string) int {
+	for i := range s.file.Items {
+		if s.file.Items[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Lookup ищет агента по идентификатору.
+func (s *FileStore) Lookup(ctx context.Context, id string) (*Agent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(); err != nil {
+		logger.Log.Error("failed to reload agents file, using the previous version", zap.Error(err))
+	}
+	if i := s.find(id); i >= 0 {
+		a := s.file.Items[i]
+		return &a, nil
+	}
+	return nil, ErrAgentNotFound
+}
+
+// List возвращает всех агентов, включая отозванных.
+func (s *FileStore) List(ctx context.Context) ([]Agent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(); err != nil {
+		return nil, err
+	}
+	return append([]Agent(nil), s.file.Items...), nil
+}
+
+// Add регистрирует агента.
+func (s *FileStore) Add(ctx context.Context, agent *Agent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(); err != nil {
+		return err
+	}
+	switch i := s.find(agent.ID); {
+	case i < 0:
+		s.file.Items = append(s.file.Items, *agent)
+	case s.file.Items[i].RevokedAt == nil:
+		return fmt.Errorf("%w: %q", ErrAgentExists, agent.ID)
+	default:
+		s.file.Items[i] = *agent
+	}
+	return s.file.Save()
+}
+
+// Revoke отзывает агента.
+func (s *FileStore) Revoke(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(); err != nil {
+		return err
+	}
+	i := s.find(id)
+	if i < 0 {
+		return ErrAgentNotFound
+	}
+	if s.file.Items[i].RevokedAt == nil {
+		now := time.Now().UTC()
+		s.file.Items[i].RevokedAt = &now
+	}
+	return s.file.Save()
+}
+
+// DBStore хранит агентов в Postgres.
+type DBStore struct {
+	conn *sqlx.DB
+}
+
+// NewDBStore конструктор для DBStore, при bootstrap создает таблицу агентов.
+func NewDBStore(conn *sqlx.DB, bootstrap bool) (*DBStore, error) {
+	s := &DBStore{conn: conn}
+	if bootstrap {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		if err := s.Bootstrap(ctx); err != nil {
+			logger.Log.Error("agent secrets table bootstrap failed", zap.Error(err))
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Lookup ищет агента по идентификатору.
+func (s *DBStore) Lookup(ctx context.Context, id string) (*Agent, error) {
+	var agent Agent
+	sqlSelect := `SELECT agent_id, secret, created_at, revoked_at FROM agent_secrets WHERE agent_id = $1`
+	if err := s.conn.GetContext(ctx, &agent, sqlSelect, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAgentNotFound
+		}
+		return nil, err
+	}
+	return &agent, nil
+}
+
+// List возвращает всех агентов, включая отозванных.
+func (s *DBStore) List(ctx context.Context) ([]Agent, error) {
+	var agents []Agent
+	sqlSelect := `SELECT agent_id, secret, created_at, revoked_at FROM agent_secrets ORDER BY created_at`
+	if err := s.conn.SelectContext(ctx, &agents, sqlSelect); err != nil {
+		return nil, err
+	}
+	return agents, nil
+}
+
+// Add регистрирует агента. Отозванная запись заменяется, действующая остается.
+func (s *DBStore) Add(ctx context.Context, agent *Agent) error {
+	sqlInsert := `INSERT INTO agent_secrets (agent_id, secret, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (agent_id) DO UPDATE SET secret = EXCLUDED.secret, created_at = EXCLUDED.created_at, revoked_at = NULL
+		WHERE agent_secrets.revoked_at IS NOT NULL`
+	res, err := s.conn.ExecContext(ctx, sqlInsert, agent.ID, agent.Secret, agent.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: %q", ErrAgentExists, agent.ID)
+	}
+	return nil
+}
+
+// Revoke отзывает агента.
+func (s *DBStore) Revoke(ctx context.Context, id string) error {
+	sqlUpdate := `UPDATE agent_secrets SET revoked_at = COALESCE(revoked_at, now()) WHERE agent_id = $1`
+	res, err := s.conn.ExecContext(ctx, sqlUpdate, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrAgentNotFound
+	}
+	return nil
+}
+
+// Bootstrap создает, если надо, таблицу агентов.
+func (s *DBStore) Bootstrap(ctx context.Context) error {
+	_, err := s.conn.ExecContext(ctx, `
+	   CREATE TABLE IF NOT EXISTS agent_secrets (
+	       agent_id varchar(256) PRIMARY KEY,
+	       secret varchar(128) NOT NULL,
+	       created_at timestamptz DEFAULT now(),
+	       revoked_at timestamptz
+	   )
+	`)
+	return err
+}
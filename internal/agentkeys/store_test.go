@@ -0,0 +1,138 @@
+package agentkeys
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sqlxmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "agents.json")
+
+	store, err := NewFileStore(path)
+	require.NoError(t, err)
+	agents, err := store.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, agents)
+
+	agent, err := NewAgent("web-01")
+	require.NoError(t, err)
+	assert.Len(t, agent.Secret, 2*secretSize)
+	require.NoError(t, store.Add(ctx, agent))
+	assert.ErrorIs(t, store.Add(ctx, agent), ErrAgentExists)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	got, err := store.Lookup(ctx, "web-01")
+	require.NoError(t, err)
+	assert.Equal(t, agent.Secret, got.Secret)
+	assert.NoError(t, got.Active())
+
+	_, err = store.Lookup(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrAgentNotFound)
+
+	// другой процесс, например blackbirdctl, отзывает агента в том же файле
+	other, err := NewFileStore(path)
+	require.NoError(t, err)
+	require.NoError(t, other.Revoke(ctx, "web-01"))
+	assert.ErrorIs(t, other.Revoke(ctx, "unknown"), ErrAgentNotFound)
+
+	got, err = store.Lookup(ctx, "web-01")
+	require.NoError(t, err)
+	assert.ErrorIs(t, got.Active(), ErrAgentRevoked)
+
+	// отозванного агента можно зарегистрировать заново с новым секретом
+	renewed, err := NewAgent("web-01")
+	require.NoError(t, err)
+	require.NoError(t, store.Add(ctx, renewed))
+	got, err = other.Lookup(ctx, "web-01")
+	require.NoError(t, err)
+	assert.Equal(t, renewed.Secret, got.Secret)
+	assert.NoError(t, got.Active())
+}
+
+func TestFileStore_Broken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agents.json")
+	require.NoError(t, os.WriteFile(path, []byte("{not json"), 0600))
+
+	_, err := NewFileStore(path)
+	assert.Error(t, err)
+}
+
+func TestNewAgent(t *testing.T) {
+	_, err := NewAgent("")
+	assert.Error(t, err)
+
+	a, err := NewAgent("web-01")
+	require.NoError(t, err)
+	b, err := NewAgent("web-01")
+	require.NoError(t, err)
+	assert.NotEqual(t, a.Secret, b.Secret)
+}
+
+func TestDBStore(t *testing.T) {
+	db, mock, err := sqlxmock.Newx()
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	store, err := NewDBStore(db, false)
+	require.NoError(t, err)
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	columns := []string{"agent_id", "secret", "created_at", "revoked_at"}
+
+	t.Run("lookup", func(t *testing.T) {
+		rows := sqlxmock.NewRows(columns).AddRow("web-01", "secret", created, nil)
+		mock.ExpectQuery("SELECT (.+) FROM agent_secrets WHERE agent_id").WithArgs("web-01").WillReturnRows(rows)
+
+		agent, err := store.Lookup(ctx, "web-01")
+		require.NoError(t, err)
+		assert.Equal(t, &Agent{ID: "web-01", Secret: "secret", CreatedAt: created}, agent)
+	})
+	t.Run("lookup missing", func(t *testing.T) {
+		mock.ExpectQuery("SELECT (.+) FROM agent_secrets WHERE agent_id").WithArgs("missing").WillReturnRows(sqlxmock.NewRows(columns))
+
+		_, err := store.Lookup(ctx, "missing")
+		assert.ErrorIs(t, err, ErrAgentNotFound)
+	})
+	t.Run("list", func(t *testing.T) {
+		rows := sqlxmock.NewRows(columns).
+			AddRow("web-01", "secret", created, nil).
+			AddRow("web-02", "secret2", created, created)
+		mock.ExpectQuery("SELECT (.+) FROM agent_secrets ORDER BY").WillReturnRows(rows)
+
+		agents, err := store.List(ctx)
+		require.NoError(t, err)
+		require.Len(t, agents, 2)
+		assert.ErrorIs(t, agents[1].Active(), ErrAgentRevoked)
+	})
+	t.Run("add", func(t *testing.T) {
+		agent := &Agent{ID: "web-01", Secret: "secret", CreatedAt: created}
+		mock.ExpectExec("INSERT INTO agent_secrets").
+			WithArgs("web-01", "secret", created).
+			WillReturnResult(sqlxmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO agent_secrets").
+			WithArgs("web-01", "secret", created).
+			WillReturnResult(sqlxmock.NewResult(0, 0))
+
+		assert.NoError(t, store.Add(ctx, agent))
+		assert.ErrorIs(t, store.Add(ctx, agent), ErrAgentExists)
+	})
+	t.Run("revoke", func(t *testing.T) {
+		mock.ExpectExec("UPDATE agent_secrets SET revoked_at").WithArgs("web-01").WillReturnResult(sqlxmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE agent_secrets SET revoked_at").WithArgs("missing").WillReturnResult(sqlxmock.NewResult(0, 0))
+
+		assert.NoError(t, store.Revoke(ctx, "web-01"))
+		assert.ErrorIs(t, store.Revoke(ctx, "missing"), ErrAgentNotFound)
+	})
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
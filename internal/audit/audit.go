@@ -0,0 +1,132 @@
+// Package audit записывает журнал изменений метрик: кто, когда и откуда прислал значения.
+package audit
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"github.com/sebasttiano/Blackbird.git/internal/logger"
+	"github.com/sebasttiano/Blackbird.git/internal/models"
+	"go.uber.org/zap"
+)
+
+// Транспорты, через которые поступают метрики.
+const (
+	TransportHTTP     = "http"
+	TransportGRPC     = "grpc"
+	TransportStatsD   = "statsd"
+	TransportGraphite = "graphite"
+)
+
+// Source описывает источник записи метрик.
+type Source struct {
+	Transport string `json:"transport"`          // http, grpc или протокол приема метрик
+	IP        string `json:"ip"`                 // адрес клиента
+	AgentID   string `json:"agent_id,omitempty"` // идентификатор агента из заголовка или метаданных
+	Verified  bool   `json:"verified"`           // запрос прошел проверку цифровой подписи
+	TokenID   string `json:"token,omitempty"`    // идентификатор API токена, если запрос прошел с токеном
+	Client    string `json:"client,omitempty"`   // CommonName проверенного клиентского сертификата при mTLS
+}
+
+// Entry запись журнала аудита.
+type Entry struct {
+	Time    time.Time        `json:"time"`
+	Source  Source           `json:"source"`
+	Metrics []models.Metrics `json:"metrics"`
+}
+
+// Filter условия поиска по журналу. Пустые поля не фильтруют.
+type Filter struct {
+	Metric  string    // имя метрики или шаблон как у path.Match
+	Source  string    // адрес клиента
+	AgentID string    // идентификатор агента
+	Since   time.Time // не раньше
+	Until   time.Time // не позже
+	Limit   int       // максимум записей, самые свежие
+}
+
+// Match проверяет, подходит ли запись под фильтр.
+func (f Filter) Match(e *Entry) bool {
+	if f.Source != "" && e.Source.IP != f.Source {
+		return false
+	}
+	if f.AgentID != "" && e.Source.AgentID != f.AgentID {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	if f.Metric == "" {
+		return true
+	}
+	for _, m := range e.Metrics {
+		if ok, _ := path.Match(f.Metric, m.ID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Sink приемник записей журнала аудита. Scan передает подходящие записи в fn от старых к новым,
+// не собирая их в память, Limit при этом не учитывается. Ошибка fn прерывает обход.
+type Sink interface {
+	Write(entry *Entry) error
+	Query(ctx context.Context, filter Filter) ([]Entry, error)
+	Scan(ctx context.Context, filter Filter, fn func(e *Entry) error) error
+	Close() error
+}
+
+// Auditor записывает в приемник изменения метрик вместе с их источником.
+type Auditor struct {
+	sink Sink
+}
+
+// NewAuditor конструктор для Auditor.
+func NewAuditor(sink Sink) *Auditor {
+	return &Auditor{sink: sink}
+}
+
+// Record записывает принятые метрики. Источник берется из контекста запроса.
+// Ошибка записи журнала логгируется и не мешает сохранению метрик.
+func (a *Auditor) Record(ctx context.Context, metrics []models.Metrics) {
+	if a == nil || len(metrics) == 0 {
+		return
+	}
+	entry := &Entry{Time: time.Now().UTC(), Source: SourceFromContext(ctx), Metrics: metrics}
+	if err := a.sink.Write(entry); err != nil {
+		logger.Log.Error("failed to write audit entry", zap.Error(err))
+	}
+}
+
+// Query ищет записи журнала по фильтру.
+func (a *Auditor) Query(ctx context.Context, filter Filter) ([]Entry, error) {
+	return a.sink.Query(ctx, filter)
+}
+
+// Scan передает подходящие записи журнала в fn от старых к новым.
+func (a *Auditor) Scan(ctx context.Context, filter Filter, fn func(e *Entry) error) error {
+	return a.sink.Scan(ctx, filter, fn)
+}
+
+// Close закрывает приемник журнала.
+func (a *Auditor) Close() error {
+	return a.sink.Close()
+}
+
+// sourceKey ключ контекста для Source.
+type sourceKey struct{}
+
+// WithSource кладет источник записи в контекст.
+func WithSource(ctx context.Context, src Source) context.Context {
+	return context.WithValue(ctx, sourceKey{}, src)
+}
+
+// SourceFromContext достает источник записи из контекста. Если его нет, возвращает пустой Source.
+func SourceFromContext(ctx context.Context) Source {
+	src, _ := ctx.Value(sourceKey{}).(Source)
+	return src
+}
@@ -0,0 +1,65 @@
+package audit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sebasttiano/Blackbird.git/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter_Match(t *testing.T) {
+	now := time.Now()
+	entry := &Entry{
+		Time:    now,
+		Source:  Source{Transport: TransportHTTP, IP: "10.0.0.1", AgentID: "host-1"},
+		Metrics: []models.Metrics{{ID: "HeapAlloc", MType: "gauge"}, {ID: "PollCount", MType: "counter"}},
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{name: "empty filter", filter: Filter{}, want: true},
+		{name: "metric name", filter: Filter{Metric: "PollCount"}, want: true},
+		{name: "metric pattern", filter: Filter{Metric: "Heap*"}, want: true},
+		{name: "unknown metric", filter: Filter{Metric: "Alloc"}, want: false},
+		{name: "source", filter: Filter{Source: "10.0.0.1"}, want: true},
+		{name: "other source", filter: Filter{Source: "10.0.0.2"}, want: false},
+		{name: "agent", filter: Filter{AgentID: "host-1", Metric: "HeapAlloc"}, want: true},
+		{name: "other agent", filter: Filter{AgentID: "host-2"}, want: false},
+		{name: "since", filter: Filter{Since: now.Add(-time.Minute)}, want: true},
+		{name: "until", filter: Filter{Until: now.Add(-time.Minute)}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filter.Match(entry))
+		})
+	}
+}
+
+func TestAuditor_Record(t *testing.T) {
+	sink := NewMemorySink(2)
+	auditor := NewAuditor(sink)
+	value := 1.5
+
+	ctx := WithSource(context.Background(), Source{Transport: TransportGRPC, IP: "127.0.0.1", AgentID: "agent", Verified: true})
+	auditor.Record(ctx, []models.Metrics{{ID: "first", MType: "gauge", Value: &value}})
+	auditor.Record(ctx, nil)
+	auditor.Record(ctx, []models.Metrics{{ID: "second", MType: "gauge", Value: &value}})
+	auditor.Record(context.Background(), []models.Metrics{{ID: "third", MType: "gauge", Value: &value}})
+
+	entries, err := auditor.Query(context.Background(), Filter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "second", entries[0].Metrics[0].ID)
+	assert.Equal(t, Source{Transport: TransportGRPC, IP: "127.0.0.1", AgentID: "agent", Verified: true}, entries[0].Source)
+	assert.Equal(t, Source{}, entries[1].Source)
+
+	// nil аудитор ничего не пишет и не паникует
+	var disabled *Auditor
+	assert.NotPanics(t, func() { disabled.Record(ctx, []models.Metrics{{ID: "x"}}) })
+}
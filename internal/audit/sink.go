@@ -0,0 +1,275 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// FileSink пишет журнал в NDJSON файл и ротирует его по размеру.
+// Старые файлы получают суффиксы .1, .2 и т.д., самый старый удаляется.
+type FileSink struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// NewFileSink конструктор для FileSink. maxSize в байтах, 0 отключает ротацию.
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	s := &FileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// open открывает файл журнала на дозапись.
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file = f
+	s.size = info.Size()
+	return nil
+}
+
+// rotate сдвигает старые файлы журнала и открывает новый.
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	if s.maxBackups > 0 {
+		os.Remove(s.backupPath(s.maxBackups))
+		for i := s.maxBackups - 1; i >= 1; i-- {
+			os.Rename(s.backupPath(i), s.backupPath(i+1))
+		}
+		if err := os.Rename(s.path, s.backupPath(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(s.path); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+// backupPath путь до ротированного файла с номером n.
+func (s *FileSink) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", s.path, n)
+}
+
+// Write дописывает запись в файл одной строкой JSON.
+func (s *FileSink) Write(entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(data)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate audit file: %w", err)
+		}
+	}
+	n, err := s.file.Write(data)
+	s.size += int64(n)
+	return err
+}
+
+// Query читает текущий и ротированные файлы и возвращает подходящие записи от старых к новым.
+// В памяти держится не больше Limit последних записей, а не вся история на диске.
+func (s *FileSink) Query(ctx context.Context, filter Filter) ([]Entry, error) {
+	var entries []Entry
+	next := 0
+	err := s.Scan(ctx, filter, func(e *Entry) error {
+		if filter.Limit <= 0 || len(entries) < filter.Limit {
+			entries = append(entries, *e)
+			return nil
+		}
+		// кольцо заполнено, самая старая запись лежит в next
+		entries[next] = *e
+		next = (next + 1) % filter.Limit
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(entries[next:], entries[:next]...), nil
+}
+
+// Scan читает текущий и ротированные файлы построчно и передает подходящие записи в fn.
+// Файлы читаются без блокировки записи, поэтому долгий обход не останавливает запись журнала.
+func (s *FileSink) Scan(ctx context.Context, filter Filter, fn func(e *Entry) error) error {
+	files, err := s.snapshot()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := scanEntries(ctx, f, filter, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotFile открытый под блокировкой файл журнала, читается не дальше size.
+type snapshotFile struct {
+	*os.File
+	size int64
+}
+
+// snapshot под блокировкой открывает файлы журнала от старых к новым и запоминает размер текущего.
+// Открытые файлы читаются без блокировки: ротация их переименовывает или удаляет, но не меняет,
+// а дописанное в текущий файл после снимка не читается.
+func (s *FileSink) snapshot() ([]snapshotFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var files []snapshotFile
+	for i := s.maxBackups; i >= 0; i-- {
+		path := s.path
+		if i > 0 {
+			path = s.backupPath(i)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			for _, opened := range files {
+				opened.Close()
+			}
+			return nil, err
+		}
+		size := int64(-1)
+		if i == 0 {
+			size = s.size
+		}
+		files = append(files, snapshotFile{File: f, size: size})
+	}
+	return files, nil
+}
+
+// Close закрывает файл журнала.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// scanEntries читает NDJSON файл и передает в fn записи, подходящие под фильтр.
+func scanEntries(ctx context.Context, f snapshotFile, filter Filter, fn func(e *Entry) error) error {
+	var r io.Reader = f.File
+	if f.size >= 0 {
+		r = io.LimitReader(f.File, f.size)
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if !filter.Match(&e) {
+			continue
+		}
+		if err := fn(&e); err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// limitEntries оставляет limit самых свежих записей.
+func limitEntries(entries []Entry, limit int) []Entry {
+	if limit > 0 && len(entries) > limit {
+		return entries[len(entries)-limit:]
+	}
+	return entries
+}
+
+// MemorySink хранит последние записи журнала в памяти.
+type MemorySink struct {
+	mu      sync.RWMutex
+	entries []Entry
+	size    int
+}
+
+// NewMemorySink конструктор для MemorySink, size максимальное число хранимых записей.
+func NewMemorySink(size int) *MemorySink {
+	return &MemorySink{size: size}
+}
+
+// Write сохраняет запись, вытесняя самую старую при переполнении.
+func (m *MemorySink) Write(entry *Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries = append(m.entries, *entry)
+	if m.size > 0 && len(m.entries) > m.size {
+		m.entries = m.entries[len(m.entries)-m.size:]
+	}
+	return nil
+}
+
+// Query возвращает подходящие записи от старых к новым.
+func (m *MemorySink) Query(ctx context.Context, filter Filter) ([]Entry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var entries []Entry
+	for i := range m.entries {
+		if filter.Match(&m.entries[i]) {
+			entries = append(entries, m.entries[i])
+		}
+	}
+	return limitEntries(entries, filter.Limit), nil
+}
+
+// Scan передает подходящие записи в fn. Записи копируются под блокировкой, fn вызывается без нее.
+func (m *MemorySink) Scan(ctx context.Context, filter Filter, fn func(e *Entry) error) error {
+	filter.Limit = 0
+	entries, err := m.Query(ctx, filter)
+	if err != nil {
+		return err
+	}
+	for i := range entries {
+		if err := fn(&entries[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close ничего не делает, нужен для интерфейса Sink.
+func (m *MemorySink) Close() error {
+	return nil
+}
@@ -0,0 +1,154 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sebasttiano/Blackbird.git/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSink_WriteAndQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.ndjson")
+	sink, err := NewFileSink(path, 0, 0)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, sink.Write(&Entry{
+			Time:    time.Now(),
+			Source:  Source{IP: fmt.Sprintf("10.0.0.%d", i)},
+			Metrics: []models.Metrics{{ID: fmt.Sprintf("metric%d", i), MType: "gauge"}},
+		}))
+	}
+	require.NoError(t, sink.Close())
+
+	// файл переоткрывается на дозапись
+	sink, err = NewFileSink(path, 0, 0)
+	require.NoError(t, err)
+	defer sink.Close()
+	require.NoError(t, sink.Write(&Entry{Time: time.Now(), Source: Source{IP: "10.0.0.1"}, Metrics: []models.Metrics{{ID: "metric3"}}}))
+
+	entries, err := sink.Query(context.Background(), Filter{Source: "10.0.0.1"})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "metric1", entries[0].Metrics[0].ID)
+	assert.Equal(t, "metric3", entries[1].Metrics[0].ID)
+
+	entries, err = sink.Query(context.Background(), Filter{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "metric3", entries[0].Metrics[0].ID)
+
+	// кольцо из последних записей отдается от старых к новым
+	entries, err = sink.Query(context.Background(), Filter{Limit: 3})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	for i, entry := range entries {
+		assert.Equal(t, fmt.Sprintf("metric%d", i+1), entry.Metrics[0].ID)
+	}
+}
+
+func TestFileSink_Rotate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.ndjson")
+	sink, err := NewFileSink(path, 200, 2)
+	require.NoError(t, err)
+	defer sink.Close()
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, sink.Write(&Entry{
+			Time:    time.Unix(int64(i), 0).UTC(),
+			Metrics: []models.Metrics{{ID: fmt.Sprintf("metric%d", i), MType: "counter"}},
+		}))
+	}
+
+	files, err := filepath.Glob(path + "*")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{path, path + ".1", path + ".2"}, files)
+
+	for _, f := range files {
+		info, err := os.Stat(f)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, info.Size(), int64(200))
+	}
+
+	// самые старые записи вытеснены ротацией, порядок от старых к новым сохраняется
+	entries, err := sink.Query(context.Background(), Filter{})
+	require.NoError(t, err)
+	require.NotEmpty(t, entries)
+	assert.Less(t, len(entries), 10)
+	assert.Equal(t, "metric9", entries[len(entries)-1].Metrics[0].ID)
+	for i := 1; i < len(entries); i++ {
+		assert.True(t, entries[i-1].Time.Before(entries[i].Time))
+	}
+}
+
+func TestFileSink_snapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.ndjson")
+	sink, err := NewFileSink(path, 200, 2)
+	require.NoError(t, err)
+	defer sink.Close()
+
+	write := func(i int) {
+		require.NoError(t, sink.Write(&Entry{Time: time.Unix(int64(i), 0).UTC(), Metrics: []models.Metrics{{ID: fmt.Sprintf("metric%d", i)}}}))
+	}
+	for i := 0; i < 3; i++ {
+		write(i)
+	}
+	files, err := sink.snapshot()
+	require.NoError(t, err)
+
+	// запись и ротация после снимка не блокируются и не меняют его
+	for i := 3; i < 10; i++ {
+		write(i)
+	}
+	var ids []string
+	for _, f := range files {
+		err := scanEntries(context.Background(), f, Filter{}, func(e *Entry) error {
+			ids = append(ids, e.Metrics[0].ID)
+			return nil
+		})
+		require.NoError(t, err)
+		f.Close()
+	}
+	assert.Equal(t, []string{"metric0", "metric1", "metric2"}, ids)
+}
+
+func TestSink_Scan(t *testing.T) {
+	file, err := NewFileSink(filepath.Join(t.TempDir(), "audit.ndjson"), 0, 0)
+	require.NoError(t, err)
+	defer file.Close()
+
+	for name, sink := range map[string]Sink{"file": file, "memory": NewMemorySink(10)} {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 4; i++ {
+				require.NoError(t, sink.Write(&Entry{Time: time.Unix(int64(i), 0).UTC(), Metrics: []models.Metrics{{ID: fmt.Sprintf("metric%d", i)}}}))
+			}
+
+			// Limit не учитывается, записи идут от старых к новым
+			var ids []string
+			err := sink.Scan(context.Background(), Filter{Limit: 1}, func(e *Entry) error {
+				ids = append(ids, e.Metrics[0].ID)
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"metric0", "metric1", "metric2", "metric3"}, ids)
+
+			// ошибка fn прерывает обход
+			stop := errors.New("stop")
+			calls := 0
+			err = sink.Scan(context.Background(), Filter{}, func(e *Entry) error {
+				calls++
+				return stop
+			})
+			assert.ErrorIs(t, err, stop)
+			assert.Equal(t, 1, calls)
+		})
+	}
+}
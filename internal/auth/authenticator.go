@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Ошибки проверки токена. ErrDisabled и ErrInsufficientScope означают отказ в доступе,
+// остальные означают, что клиент не предъявил действующий токен.
+var (
+	ErrDisabled          = errors.New("admin api is disabled")
+	ErrMissingToken      = errors.New("bearer token is required")
+	ErrInvalidToken      = errors.New("invalid bearer token")
+	ErrTokenRevoked      = errors.New("token is revoked")
+	ErrTokenExpired      = errors.New("token is expired")
+	ErrInsufficientScope = errors.New("token doesn`t have the required scope")
+)
+
+// AdminTokenID идентификатор статического токена администратора из конфига.
+const AdminTokenID = "admin-token"
+
+// Authenticator проверяет заголовок Authorization: Bearer <token> и права токена.
+// Без хранилища токенов чтение и запись открыты, а admin API доступен только по статическому токену администратора.
+type Authenticator struct {
+	store      Store
+	adminToken string
+	now        func() time.Time
+}
+
+// NewAuthenticator конструктор для Authenticator. store и adminToken могут быть пустыми.
+func NewAuthenticator(store Store, adminToken string) *Authenticator {
+	return &Authenticator{store: store, adminToken: adminToken, now: time.Now}
+}
+
+// Enabled возвращает true, если токены проверяются на всех маршрутах, а не только на admin API.
+func (a *Authenticator) Enabled() bool {
+	return a != nil && a.store != nil
+}
+
+// Authorize проверяет, что токен из заголовка Authorization действует и имеет право scope.
+// Возвращает nil токен без ошибки, если проверка выключена.
+func (a *Authenticator) Authorize(ctx context.Context, authorization string, scope Scope) (*Token, error) {
+	if !a.Enabled() {
+		if scope != ScopeAdmin {
+			return nil, nil
+		}
+		if a == nil || a.adminToken == "" {
+			return nil, ErrDisabled
+		}
+	}
+
+	secret, ok := bearer(authorization)
+	if !ok {
+		return nil, ErrMissingToken
+	}
+	if a.adminToken != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(a.adminToken)) == 1 {
+		return &Token{ID: AdminTokenID, Name: "static admin token", Scopes: []Scope{ScopeAdmin}}, nil
+	}
+	if a.store == nil {
+		return nil, ErrInvalidToken
+	}
+
+	token, err := a.store.Lookup(ctx, HashSecret(secret))
+	if errors.Is(err, ErrTokenNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := token.Active(a.now()); err != nil {
+		return nil, err
+	}
+	if !token.Allows(scope) {
+		return token, ErrInsufficientScope
+	}
+	return token, nil
+}
+
+// bearer достает токен из заголовка Authorization вида "Bearer <token>".
+func bearer(authorization string) (string, bool) {
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
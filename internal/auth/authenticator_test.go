@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseScopes(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    []Scope
+		wantErr error
+	}{
+		{"one scope", "read", []Scope{ScopeRead}, nil},
+		{"several scopes", "read, write,admin", []Scope{ScopeRead, ScopeWrite, ScopeAdmin}, nil},
+		{"unknown scope", "read,delete", nil, ErrUnknownScope},
+		{"empty", "", nil, ErrUnknownScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseScopes(tt.in)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestToken(t *testing.T) {
+	token, secret, err := NewToken("ci", []Scope{ScopeWrite}, time.Hour)
+	require.NoError(t, err)
+	assert.Contains(t, secret, secretPrefix)
+	assert.Equal(t, HashSecret(secret), token.Hash)
+	assert.NotContains(t, token.Hash, secret)
+
+	assert.True(t, token.Allows(ScopeWrite))
+	assert.False(t, token.Allows(ScopeRead))
+	assert.True(t, (&Token{Scopes: []Scope{ScopeAdmin}}).Allows(ScopeRead))
+
+	assert.NoError(t, token.Active(time.Now()))
+	assert.ErrorIs(t, token.Active(time.Now().Add(2*time.Hour)), ErrTokenExpired)
+	token.RevokedAt = &token.CreatedAt
+	assert.ErrorIs(t, token.Active(time.Now()), ErrTokenRevoked)
+
+	forever, _, err := NewToken("forever", []Scope{ScopeRead}, 0)
+	require.NoError(t, err)
+	assert.Nil(t, forever.ExpiresAt)
+}
+
+func TestAuthenticator_Authorize(t *testing.T) {
+	store, err := NewFileStore(t.TempDir() + "/tokens.json")
+	require.NoError(t, err)
+
+	add := func(name string, scopes []Scope, ttl time.Duration) (*Token, string) {
+		token, secret, err := NewToken(name, scopes, ttl)
+		require.NoError(t, err)
+		require.NoError(t, store.Add(context.Background(), token))
+		return token, secret
+	}
+	_, reader := add("reader", []Scope{ScopeRead}, 0)
+	_, expired := add("expired", []Scope{ScopeRead}, time.Minute)
+	revokedToken, revoked := add("revoked", []Scope{ScopeRead}, 0)
+	require.NoError(t, store.Revoke(context.Background(), revokedToken.ID))
+
+	enabled := NewAuthenticator(store, "secret")
+	enabled.now = func() time.Time { return time.Now().Add(time.Hour) }
+
+	tests := []struct {
+		name          string
+		authenticator *Authenticator
+		authorization string
+		scope         Scope
+		wantToken     string
+		wantErr       error
+	}{
+		{"disabled allows read", NewAuthenticator(nil, ""), "", ScopeRead, "", nil},
+		{"disabled forbids admin", NewAuthenticator(nil, ""), "Bearer secret", ScopeAdmin, "", ErrDisabled},
+		{"nil authenticator", nil, "", ScopeAdmin, "", ErrDisabled},
+		{"static admin token", NewAuthenticator(nil, "secret"), "Bearer secret", ScopeAdmin, AdminTokenID, nil},
+		{"wrong admin token", NewAuthenticator(nil, "secret"), "Bearer wrong", ScopeAdmin, "", ErrInvalidToken},
+		{"missing token", enabled, "", ScopeRead, "", ErrMissingToken},
+		{"not a bearer token", enabled, "Basic dXNlcjpwYXNz", ScopeRead, "", ErrMissingToken},
+		{"unknown token", enabled, "Bearer bbt_unknown", ScopeRead, "", ErrInvalidToken},
+		{"valid token", enabled, "Bearer " + reader, ScopeRead, "reader", nil},
+		{"scheme is case insensitive", enabled, "bearer " + reader, ScopeRead, "reader", nil},
+		{"insufficient scope", enabled, "Bearer " + reader, ScopeWrite, "reader", ErrInsufficientScope},
+		{"expired token", enabled, "Bearer " + expired, ScopeRead, "", ErrTokenExpired},
+		{"revoked token", enabled, "Bearer " + revoked, ScopeRead, "", ErrTokenRevoked},
+		{"admin token with store", enabled, "Bearer secret", ScopeWrite, AdminTokenID, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := tt.authenticator.Authorize(context.Background(), tt.authorization, tt.scope)
+			assert.ErrorIs(t, err, tt.wantErr)
+			if tt.wantToken == "" {
+				assert.Nil(t, token)
+				return
+			}
+			require.NotNil(t, token)
+			if tt.wantToken == AdminTokenID {
+				assert.Equal(t, AdminTokenID, token.ID)
+			} else {
+				assert.Equal(t, tt.wantToken, token.Name)
+			}
+		})
+	}
+}
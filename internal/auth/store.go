@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sebasttiano/Blackbird.git/internal/jsonfile"
+	"github.com/sebasttiano/Blackbird.git/internal/logger"
+	"go.uber.org/zap"
+)
+
+// FileStore хранит токены в JSON файле. Файл перечитывается при изменении,
+// поэтому выпуск и отзыв токенов командой blackbirdctl действуют без перезапуска сервера.
+type FileStore struct {
+	mu   sync.Mutex
+	file *jsonfile.List[Token]
+}
+
+// NewFileStore конструктор для FileStore. Отсутствующий файл означает пустой список токенов.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{file: jsonfile.New[Token](path, "tokens")}
+	if err := s.file.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Lookup ищет токен по хешу секрета.
+func (s *FileStore) Lookup(ctx context.Context, hash string) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(); err != nil {
+		logger.Log.Error("failed to reload tokens file, using the previous version", zap.Error(err))
+	}
+	for i := range s.file.Items {
+		if s.file.Items[i].Hash == hash {
+			t := s.file.Items[i]
+			return &t, nil
+		}
+	}
+	return nil, ErrTokenNotFound
+}
+
+// List возвращает все токены, включая отозванные.
+func (s *FileStore) List(ctx context.Context) ([]Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(); err != nil {
+		return nil, err
+	}
+	return append([]Token(nil), s.file.Items...), nil
+}
+
+// Add сохраняет новый токен.
+func (s *FileStore) Add(ctx context.Context, token *Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(); err != nil {
+		return err
+	}
+	s.file.Items = append(s.file.Items, *token)
+	return s.file.Save()
+}
+
+// Revoke отзывает токен.
+func (s *FileStore) Revoke(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Reload(); err != nil {
+		return err
+	}
+	for i := range s.file.Items {
+		if s.file.Items[i].ID == id {
+			if s.file.Items[i].RevokedAt == nil {
+				now := time.Now().UTC()
+				s.file.Items[i].RevokedAt = &now
+			}
+			return s.file.Save()
+		}
+	}
+	return ErrTokenNotFound
+}
+
+// DBStore хранит токены в Postgres.
+type DBStore struct {
+	conn *sqlx.DB
+}
+
+// tokenRow строка таблицы api_tokens, права хранятся через запятую.
+type tokenRow struct {
+	Token
+	Scopes string `db:"scopes"`
+}
+
+func (r *tokenRow) token() (*Token, error) {
+	t := r.Token
+	scopes, err := ParseScopes(r.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	t.Scopes = scopes
+	return &t, nil
+}
+
+// NewDBStore конструктор для DBStore, при bootstrap создает таблицу токенов.
+func NewDBStore(conn *sqlx.DB, bootstrap bool) (*DBStore, error) {
+	s := &DBStore{conn: conn}
+	if bootstrap {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		if err := s.Bootstrap(ctx); err != nil {
+			logger.Log.Error("tokens table bootstrap failed", zap.Error(err))
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Lookup ищет токен по хешу секрета.
+func (s *DBStore) Lookup(ctx context.Context, hash string) (*Token, error) {
+	var row tokenRow
+	sqlSelect := `SELECT id, name, hash, scopes, created_at, expires_at, revoked_at FROM api_tokens WHERE hash = $1`
+	if err := s.conn.GetContext(ctx, &row, sqlSelect, hash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTokenNotFound
+		}
+		return nil, err
+	}
+	return row.token()
+}
+
+// List возвращает все токены, включая отозванные.
+func (s *DBStore) List(ctx context.Context) ([]Token, error) {
+	var rows []tokenRow
+	sqlSelect := `SELECT id, name, hash, scopes, created_at, expires_at, revoked_at FROM api_tokens ORDER BY created_at`
+	if err := s.conn.SelectContext(ctx, &rows, sqlSelect); err != nil {
+		return nil, err
+	}
+	tokens := make([]Token, 0, len(rows))
+	for i := range rows {
+		t, err := rows[i].token()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, nil
+}
+
+// Add сохраняет новый токен.
+func (s *DBStore) Add(ctx context.Context, token *Token) error {
+	scopes := make([]string, 0, len(token.Scopes))
+	for _, scope := range token.Scopes {
+		scopes = append(scopes, string(scope))
+	}
+	sqlInsert := `INSERT INTO api_tokens (id, name, hash, scopes, created_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := s.conn.ExecContext(ctx, sqlInsert, token.ID, token.Name, token.Hash, strings.Join(scopes, ","), token.CreatedAt, token.ExpiresAt)
+	return err
+}
+
+// Revoke отзывает токен.
+func (s *DBStore) Revoke(ctx context.Context, id string) error {
+	sqlUpdate := `UPDATE api_tokens SET revoked_at = COALESCE(revoked_at, now()) WHERE id = $1`
+	res, err := s.conn.ExecContext(ctx, sqlUpdate, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+// Bootstrap создает, если надо, таблицу токенов.
+func (s *DBStore) Bootstrap(ctx context.Context) error {
+	_, err := s.conn.ExecContext(ctx, `
+	   CREATE TABLE IF NOT EXISTS api_tokens (
+	       id varchar(64) PRIMARY KEY,
+	       name varchar(256),
+	       hash char(64) UNIQUE,
+	       scopes varchar(64),
+	       created_at timestamptz DEFAULT now(),
+	       expires_at timestamptz,
+	       revoked_at timestamptz
+	   )
+	`)
+	return err
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sqlxmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "tokens.json")
+
+	store, err := NewFileStore(path)
+	require.NoError(t, err)
+	tokens, err := store.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, tokens)
+
+	token, _, err := NewToken("ci", []Scope{ScopeRead, ScopeWrite}, 0)
+	require.NoError(t, err)
+	require.NoError(t, store.Add(ctx, token))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	got, err := store.Lookup(ctx, token.Hash)
+	require.NoError(t, err)
+	assert.Equal(t, token.Name, got.Name)
+	assert.Equal(t, token.Scopes, got.Scopes)
+
+	_, err = store.Lookup(ctx, HashSecret("unknown"))
+	assert.ErrorIs(t, err, ErrTokenNotFound)
+
+	// другой процесс, например blackbirdctl, отзывает токен в том же файле
+	other, err := NewFileStore(path)
+	require.NoError(t, err)
+	require.NoError(t, other.Revoke(ctx, token.ID))
+	assert.ErrorIs(t, other.Revoke(ctx, "unknown"), ErrTokenNotFound)
+
+	got, err = store.Lookup(ctx, token.Hash)
+	require.NoError(t, err)
+	assert.ErrorIs(t, got.Active(time.Now()), ErrTokenRevoked)
+}
+
+func TestFileStore_Broken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	require.NoError(t, os.WriteFile(path, []byte("{not json"), 0600))
+
+	_, err := NewFileStore(path)
+	assert.Error(t, err)
+}
+
+func TestDBStore(t *testing.T) {
+	db, mock, err := sqlxmock.Newx()
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	store, err := NewDBStore(db, false)
+	require.NoError(t, err)
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	columns := []string{"id", "name", "hash", "scopes", "created_at", "expires_at", "revoked_at"}
+
+	t.Run("lookup", func(t *testing.T) {
+		rows := sqlxmock.NewRows(columns).AddRow("abc", "ci", "hash", "read,write", created, nil, nil)
+		mock.ExpectQuery("SELECT (.+) FROM api_tokens WHERE hash").WithArgs("hash").WillReturnRows(rows)
+
+		token, err := store.Lookup(ctx, "hash")
+		require.NoError(t, err)
+		assert.Equal(t, &Token{ID: "abc", Name: "ci", Hash: "hash", Scopes: []Scope{ScopeRead, ScopeWrite}, CreatedAt: created}, token)
+	})
+	t.Run("lookup missing", func(t *testing.T) {
+		mock.ExpectQuery("SELECT (.+) FROM api_tokens WHERE hash").WithArgs("missing").WillReturnRows(sqlxmock.NewRows(columns))
+
+		_, err := store.Lookup(ctx, "missing")
+		assert.ErrorIs(t, err, ErrTokenNotFound)
+	})
+	t.Run("list", func(t *testing.T) {
+		rows := sqlxmock.NewRows(columns).
+			AddRow("abc", "ci", "hash", "read", created, nil, nil).
+			AddRow("def", "ops", "hash2", "admin", created, nil, created)
+		mock.ExpectQuery("SELECT (.+) FROM api_tokens ORDER BY").WillReturnRows(rows)
+
+		tokens, err := store.List(ctx)
+		require.NoError(t, err)
+		require.Len(t, tokens, 2)
+		assert.Equal(t, []Scope{ScopeAdmin}, tokens[1].Scopes)
+		assert.NotNil(t, tokens[1].RevokedAt)
+	})
+	t.Run("add", func(t *testing.T) {
+		token := &Token{ID: "abc", Name: "ci", Hash: "hash", Scopes: []Scope{ScopeRead, ScopeWrite}, CreatedAt: created}
+		mock.ExpectExec("INSERT INTO api_tokens").
+			WithArgs("abc", "ci", "hash", "read,write", created, nil).
+			WillReturnResult(sqlxmock.NewResult(1, 1))
+
+		assert.NoError(t, store.Add(ctx, token))
+	})
+	t.Run("revoke", func(t *testing.T) {
+		mock.ExpectExec("UPDATE api_tokens SET revoked_at").WithArgs("abc").WillReturnResult(sqlxmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE api_tokens SET revoked_at").WithArgs("missing").WillReturnResult(sqlxmock.NewResult(0, 0))
+
+		assert.NoError(t, store.Revoke(ctx, "abc"))
+		assert.ErrorIs(t, store.Revoke(ctx, "missing"), ErrTokenNotFound)
+	})
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,140 @@
+// Package auth проверяет API токены и их права: read на чтение метрик, write на запись, admin на admin API.
+// Токены хранятся в JSON файле или в Postgres, в хранилище попадает только хеш секрета.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Scope право токена.
+type Scope string
+
+// Права токенов. admin включает все остальные права.
+const (
+	ScopeRead  Scope = "read"
+	ScopeWrite Scope = "write"
+	ScopeAdmin Scope = "admin"
+)
+
+// secretPrefix префикс секрета токена, по нему токен легко найти в конфигах и логах.
+const secretPrefix = "bbt_"
+
+// ErrUnknownScope ошибка, если право токена неизвестно.
+var ErrUnknownScope = errors.New("unknown token scope. only read, write and admin are available")
+
+// ErrTokenNotFound ошибка, если токена нет в хранилище.
+var ErrTokenNotFound = errors.New("token not found")
+
+// ParseScopes разбирает права через запятую, например "read,write".
+func ParseScopes(s string) ([]Scope, error) {
+	var scopes []Scope
+	for _, part := range strings.Split(s, ",") {
+		scope := Scope(strings.TrimSpace(part))
+		switch scope {
+		case ScopeRead, ScopeWrite, ScopeAdmin:
+			scopes = append(scopes, scope)
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrUnknownScope, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// Token API токен. Секрет не хранится, только его хеш.
+type Token struct {
+	ID        string     `json:"id" db:"id"`
+	Name      string     `json:"name" db:"name"`
+	Hash      string     `json:"hash" db:"hash"` // sha256 секрета в hex
+	Scopes    []Scope    `json:"scopes" db:"-"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+// NewToken создает токен с новым секретом. Секрет возвращается один раз, сохранить его нужно сразу.
+// Нулевой ttl означает бессрочный токен.
+func NewToken(name string, scopes []Scope, ttl time.Duration) (*Token, string, error) {
+	id, err := randomString(6)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		return nil, "", err
+	}
+	secret = secretPrefix + secret
+
+	t := &Token{ID: id, Name: name, Hash: HashSecret(secret), Scopes: scopes, CreatedAt: time.Now().UTC()}
+	if ttl > 0 {
+		expires := t.CreatedAt.Add(ttl)
+		t.ExpiresAt = &expires
+	}
+	return t, secret, nil
+}
+
+// HashSecret возвращает хеш секрета, под которым токен лежит в хранилище.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Allows проверяет, есть ли у токена право. admin разрешает все.
+func (t *Token) Allows(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Active проверяет, что токен не отозван и не просрочен.
+func (t *Token) Active(now time.Time) error {
+	if t.RevokedAt != nil {
+		return ErrTokenRevoked
+	}
+	if t.ExpiresAt != nil && now.After(*t.ExpiresAt) {
+		return ErrTokenExpired
+	}
+	return nil
+}
+
+// Store хранилище токенов.
+type Store interface {
+	// Lookup ищет токен по хешу секрета, ErrTokenNotFound если такого нет.
+	Lookup(ctx context.Context, hash string) (*Token, error)
+	List(ctx context.Context) ([]Token, error)
+	Add(ctx context.Context, token *Token) error
+	// Revoke отзывает токен по идентификатору, ErrTokenNotFound если такого нет.
+	Revoke(ctx context.Context, id string) error
+}
+
+// randomString возвращает n случайных байт в base64 без паддинга.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+type tokenKey struct{}
+
+// WithToken кладет проверенный токен в контекст запроса.
+func WithToken(ctx context.Context, t *Token) context.Context {
+	return context.WithValue(ctx, tokenKey{}, t)
+}
+
+// TokenFromContext возвращает проверенный токен запроса, nil если запрос прошел без токена.
+func TokenFromContext(ctx context.Context) *Token {
+	t, _ := ctx.Value(tokenKey{}).(*Token)
+	return t
+}
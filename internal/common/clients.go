@@ -1,6 +1,8 @@
 package common
 
 import (
+	"bytes"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -11,6 +13,21 @@ import (
 	"go.uber.org/zap"
 )
 
+// AgentIDHeader заголовок и ключ gRPC метаданных с идентификатором агента.
+const AgentIDHeader = "X-Agent-ID"
+
+// RealIPHeader заголовок и ключ gRPC метаданных с адресом агента для проверки доверенной подсети.
+const RealIPHeader = "X-Real-IP"
+
+// SignKeyIDHeader заголовок и ключ gRPC метаданных с идентификатором ключа подписи HMAC.
+const SignKeyIDHeader = "X-Sign-Key-ID"
+
+// EncryptionKeyIDHeader заголовок и ключ gRPC метаданных с идентификатором ключа RSA, которым зашифрован запрос.
+const EncryptionKeyIDHeader = "X-Encryption-Key-ID"
+
+// BatchIDHeader заголовок с идентификатором пакета метрик для защиты от повторной доставки.
+const BatchIDHeader = "X-Batch-ID"
+
 // HTTPClientErrors структура со специфичнымы ошибками
 // Deprecated: не использовать
 type HTTPClientErrors struct {
@@ -43,19 +60,41 @@ func NewHTTPClient(url string, retries int, backoffFactor uint) HTTPClient {
 	return HTTPClient{url: url, client: &http.Client{}, retriesIn: ri, retries: retries, ClientErrors: NewHTTPClientErrors()}
 }
 
+// WithTLSConfig возвращает копию клиента, которая ходит на сервер по TLS с настройками cfg.
+func (c HTTPClient) WithTLSConfig(cfg *tls.Config) HTTPClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+	c.client = &http.Client{Transport: transport}
+	return c
+}
+
 // Post метод совершает одноименные http запросы
 func (c HTTPClient) Post(urlSuffix string, body io.Reader, headers map[string]string) (*http.Response, error) {
-	r, err := http.NewRequest("POST", c.url+urlSuffix, body)
+	b, err := io.ReadAll(body)
 	if err != nil {
-		logger.Log.Debug("failed to make http request", zap.Error(err))
 		return nil, err
 	}
-	for key, value := range headers {
-		r.Header.Add(key, value)
-	}
+	return c.PostWith(urlSuffix, b, func(r *http.Request) error {
+		for key, value := range headers {
+			r.Header.Add(key, value)
+		}
+		return nil
+	})
+}
 
+// PostWith совершает POST запрос с ретраем. Запрос собирается заново на каждую попытку, prepare
+// добавляет в него заголовки, например подпись с новым nonce, иначе сервер отклонит повтор.
+func (c HTTPClient) PostWith(urlSuffix string, body []byte, prepare func(r *http.Request) error) (*http.Response, error) {
 	var res *http.Response
 	for _, delay := range c.retriesIn {
+		r, err := http.NewRequest("POST", c.url+urlSuffix, bytes.NewReader(body))
+		if err != nil {
+			logger.Log.Debug("failed to make http request", zap.Error(err))
+			return nil, err
+		}
+		if err := prepare(r); err != nil {
+			return nil, err
+		}
 		res, err = c.client.Do(r)
 		if err != nil {
 			c.retries -= 1
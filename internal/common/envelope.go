@@ -0,0 +1,129 @@
+package common
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Шифрование конвертом: тело шифруется AES-256-GCM случайным ключом, ключ шифруется RSA-OAEP
+// публичным ключом сервера. В отличие от EncryptRSA размер тела не ограничен размером RSA ключа.
+//
+// Формат версии 1:
+//
+//	magic "BBE" | version 1 byte | wrapped key length uint16 BE | wrapped key | nonce 12 bytes | ciphertext+tag
+//
+// Все, что до nonce, входит в дополнительные данные GCM, поэтому подмена версии или ключа ломает расшифровку.
+const (
+	// EncryptionHeader заголовок со схемой шифрования тела запроса.
+	EncryptionHeader = "X-Encryption"
+	// EnvelopeV1 значение EncryptionHeader для конверта версии 1.
+	EnvelopeV1 = "envelope-v1"
+	// EnvelopeVersion текущая версия формата конверта.
+	EnvelopeVersion byte = 1
+)
+
+// envelopeMagic первые байты конверта.
+var envelopeMagic = []byte("BBE")
+
+// envelopeKeySize длина ключа AES-256.
+const envelopeKeySize = 32
+
+// ErrEnvelopeFormat ошибка, если данные не являются конвертом.
+var ErrEnvelopeFormat = errors.New("malformed encrypted envelope")
+
+// ErrEnvelopeVersion ошибка, если версия конверта не поддерживается.
+var ErrEnvelopeVersion = errors.New("unsupported encrypted envelope version")
+
+// ErrNoKey ошибка, если ключ шифрования не задан.
+var ErrNoKey = errors.New("rsa key is not set")
+
+// EncryptEnvelope шифрует данные конвертом для владельца приватной пары ключа pub.
+func EncryptEnvelope(plain []byte, pub *rsa.PublicKey) ([]byte, error) {
+	if pub == nil {
+		return nil, ErrNoKey
+	}
+	key := make([]byte, envelopeKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap envelope key: %w", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, len(envelopeMagic)+3+len(wrapped))
+	header = append(header, envelopeMagic...)
+	header = append(header, EnvelopeVersion)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(wrapped)))
+	header = append(header, wrapped...)
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(header)+len(nonce)+len(plain)+gcm.Overhead())
+	out = append(out, header...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, plain, header), nil
+}
+
+// DecryptEnvelope расшифровывает конверт приватным ключом.
+func DecryptEnvelope(data []byte, priv *rsa.PrivateKey) ([]byte, error) {
+	if priv == nil {
+		return nil, ErrNoKey
+	}
+	if len(data) < len(envelopeMagic)+3 || !bytes.Equal(data[:len(envelopeMagic)], envelopeMagic) {
+		return nil, ErrEnvelopeFormat
+	}
+	if version := data[len(envelopeMagic)]; version != EnvelopeVersion {
+		return nil, fmt.Errorf("%w: %d", ErrEnvelopeVersion, version)
+	}
+	offset := len(envelopeMagic) + 1
+	keyLen := int(binary.BigEndian.Uint16(data[offset:]))
+	offset += 2
+	if len(data) < offset+keyLen {
+		return nil, ErrEnvelopeFormat
+	}
+	header, wrapped := data[:offset+keyLen], data[offset:offset+keyLen]
+
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, wrapped, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap envelope key: %w", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	rest := data[len(header):]
+	if len(rest) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, ErrEnvelopeFormat
+	}
+	plain, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt envelope: %w", err)
+	}
+	return plain, nil
+}
+
+// newGCM создает AES-GCM для ключа конверта.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != envelopeKeySize {
+		return nil, ErrEnvelopeFormat
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,91 @@
+package common
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readTestKeys(t *testing.T) (*rsa.PublicKey, *rsa.PrivateKey) {
+	t.Helper()
+	publicKey, err := os.ReadFile("../../files/rsa_public")
+	require.NoError(t, err)
+	privateKey, err := os.ReadFile("../../files/rsa_private")
+	require.NoError(t, err)
+	return UnmarshalRSAPublic(publicKey), UnmarshalRSAPrivate(privateKey)
+}
+
+func TestEnvelope_RoundTrip(t *testing.T) {
+	pub, priv := readTestKeys(t)
+
+	testTable := []struct {
+		name string
+		msg  []byte
+	}{
+		{name: "empty", msg: []byte{}},
+		{name: "short", msg: []byte("hello world")},
+		{name: "utf-8", msg: []byte("фыыфввфы")},
+		{name: "larger than rsa key", msg: bytes.Repeat([]byte("metric"), 1000)},
+		{name: "1MB", msg: bytes.Repeat([]byte{0xAB}, 1<<20)},
+	}
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			enc, err := EncryptEnvelope(tt.msg, pub)
+			require.NoError(t, err)
+			// заголовок, обернутый ключ, nonce и тег GCM
+			assert.Equal(t, len(envelopeMagic)+3+pub.Size()+12+16+len(tt.msg), len(enc))
+
+			dec, err := DecryptEnvelope(enc, priv)
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(tt.msg, dec))
+		})
+	}
+
+	t.Run("legacy rsa can`t encrypt the same payload", func(t *testing.T) {
+		_, err := EncryptRSA(string(bytes.Repeat([]byte("metric"), 1000)), pub)
+		assert.Error(t, err)
+	})
+}
+
+func TestDecryptEnvelope_Errors(t *testing.T) {
+	pub, priv := readTestKeys(t)
+	enc, err := EncryptEnvelope([]byte("hello world"), pub)
+	require.NoError(t, err)
+
+	modify := func(fn func(b []byte) []byte) []byte {
+		b := append([]byte(nil), enc...)
+		return fn(b)
+	}
+
+	testTable := []struct {
+		name    string
+		data    []byte
+		priv    *rsa.PrivateKey
+		wantErr error
+	}{
+		{name: "no key", data: enc, wantErr: ErrNoKey},
+		{name: "not an envelope", data: []byte("hello world"), priv: priv, wantErr: ErrEnvelopeFormat},
+		{name: "too short", data: enc[:4], priv: priv, wantErr: ErrEnvelopeFormat},
+		{name: "unknown version", data: modify(func(b []byte) []byte { b[3] = 2; return b }), priv: priv, wantErr: ErrEnvelopeVersion},
+		{name: "truncated key", data: enc[:len(envelopeMagic)+3+10], priv: priv, wantErr: ErrEnvelopeFormat},
+		{name: "truncated ciphertext", data: enc[:len(envelopeMagic)+3+pub.Size()+12], priv: priv, wantErr: ErrEnvelopeFormat},
+		{name: "tampered ciphertext", data: modify(func(b []byte) []byte { b[len(b)-1] ^= 1; return b }), priv: priv},
+		{name: "tampered wrapped key", data: modify(func(b []byte) []byte { b[len(envelopeMagic)+3] ^= 1; return b }), priv: priv},
+	}
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecryptEnvelope(tt.data, tt.priv)
+			require.Error(t, err)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+
+	_, err = EncryptEnvelope([]byte("hello world"), nil)
+	assert.ErrorIs(t, err, ErrNoKey)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"slices"
 
@@ -16,13 +17,23 @@ import (
 var compressedTypes = []string{
 	"application/json",
 	"text/html",
+	"text/plain",
+	"text/css",
+	"text/javascript",
+	"application/javascript",
+	"application/openmetrics-text",
+	"application/problem+json",
+	"text/csv",
+	"application/x-ndjson",
 }
 
 // GZIPWriter реализует интерфейс http.ResponseWriter и позволяет прозрачно для сервера
 // сжимать передаваемые данные и выставлять правильные HTTP-заголовки
 type GZIPWriter struct {
-	w  http.ResponseWriter
-	zw *gzip.Writer
+	w           http.ResponseWriter
+	zw          *gzip.Writer
+	wroteHeader bool
+	compress    bool
 }
 
 // NewGZIPWriter конструктор для GZIPWriter
@@ -40,25 +51,49 @@ func (c *GZIPWriter) Header() http.Header {
 
 // Write отправляет сжатые данные
 func (c *GZIPWriter) Write(p []byte) (int, error) {
-	for _, t := range compressedTypes {
-		if slices.Contains(c.Header().Values("Content-Type"), t) {
-			c.WriteHeader(http.StatusOK)
-			return c.zw.Write(p)
-		}
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.compress {
+		return c.zw.Write(p)
 	}
 	return c.w.Write(p)
 }
 
-// WriteHeader добавляет статус код в заголовки
+// WriteHeader добавляет статус код в заголовки. Сжатие включается только для успешных ответов
+// с разрешенным Content-Type.
 func (c *GZIPWriter) WriteHeader(statusCode int) {
+	if c.wroteHeader {
+		return
+	}
+	c.wroteHeader = true
 	if statusCode < 300 {
-		c.w.Header().Set("Content-Encoding", "gzip")
+		mediaType, _, _ := mime.ParseMediaType(c.Header().Get("Content-Type"))
+		if slices.Contains(compressedTypes, mediaType) {
+			c.compress = true
+			c.w.Header().Set("Content-Encoding", "gzip")
+			// длина несжатого тела, выставленная обработчиком, после сжатия неверна
+			c.w.Header().Del("Content-Length")
+		}
 	}
 	c.w.WriteHeader(statusCode)
 }
 
+// Flush досылает сжатые данные из буфера клиенту, нужен для потоковых ответов.
+func (c *GZIPWriter) Flush() {
+	if c.compress {
+		c.zw.Flush()
+	}
+	if f, ok := c.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 // Close закрывает gzip.Writer и досылает все данные из буфера.
 func (c *GZIPWriter) Close() error {
+	if !c.compress {
+		return nil
+	}
 	return c.zw.Close()
 }
 
@@ -17,24 +17,60 @@ import (
 
 // Config содержит все передаваемые переменные нужные для приложения
 type Config struct {
-	ServerIPAddr     string `env:"ADDRESS" json:"address"`
-	FileStoragePath  string `env:"FILE_STORAGE_PATH" json:"store_file"`
-	DatabaseDSN      string `env:"DATABASE_DSN" json:"database_dsn"`
-	LogLevel         string `env:"LOG_LEVEL" envDefault:"DEBUG"`
-	SecretKey        string `env:"KEY"`
-	StoreInterval    int    `env:"STORE_INTERVAL" json:"store_interval"`
-	RestoreMetrics   *bool  `env:"RESTORE" json:"restore"`
-	PollInterval     int64  `env:"POLL_INTERVAL" json:"poll_interval"`
-	ReportInterval   int64  `env:"REPORT_INTERVAL" json:"report_interval"`
-	RateLimit        uint64 `env:"RATE_LIMIT"`
-	CryptoKey        string `env:"CRYPTO_KEY" json:"crypto_key"`
-	ConfigFile       string `env:"CONFIG"`
-	TrustedSubnet    string `env:"TRUSTED_SUBNET" json:"trusted_subnet"`
-	RetriesDB        uint
-	BackoffFactor    uint
-	Profiler         *bool  `env:"PROFILER"`
-	GRPSServerIPAddr string `env:"GRPS_SERVER_ADDRESS" json:"grps_server_address"`
-	WG               sync.WaitGroup
+	ServerIPAddr        string `env:"ADDRESS" json:"address"`
+	FileStoragePath     string `env:"FILE_STORAGE_PATH" json:"store_file"`
+	DatabaseDSN         string `env:"DATABASE_DSN" json:"database_dsn"`
+	LogLevel            string `env:"LOG_LEVEL" envDefault:"DEBUG"`
+	SecretKey           string `env:"KEY"`
+	StoreInterval       int    `env:"STORE_INTERVAL" json:"store_interval"`
+	RestoreMetrics      *bool  `env:"RESTORE" json:"restore"`
+	PollInterval        int64  `env:"POLL_INTERVAL" json:"poll_interval"`
+	ReportInterval      int64  `env:"REPORT_INTERVAL" json:"report_interval"`
+	RateLimit           uint64 `env:"RATE_LIMIT"`
+	CryptoKey           string `env:"CRYPTO_KEY" json:"crypto_key"`
+	ConfigFile          string `env:"CONFIG"`
+	TrustedSubnet       string `env:"TRUSTED_SUBNET" json:"trusted_subnet"`
+	TrustedProxies      string `env:"TRUSTED_PROXIES" json:"trusted_proxies"`
+	TrustedHeaders      string `env:"TRUSTED_HEADERS" json:"trusted_headers"`
+	TrustedProxyDepth   int    `env:"TRUSTED_PROXY_DEPTH" json:"trusted_proxy_depth"`
+	RetriesDB           uint
+	BackoffFactor       uint
+	Profiler            *bool  `env:"PROFILER"`
+	GRPSServerIPAddr    string `env:"GRPS_SERVER_ADDRESS" json:"grps_server_address"`
+	AgentID             string `env:"AGENT_ID" json:"agent_id"`
+	AuditFile           string `env:"AUDIT_FILE" json:"audit_file"`
+	AuditMaxSize        int64  `env:"AUDIT_MAX_SIZE" json:"audit_max_size"`
+	AuditMaxBackups     int    `env:"AUDIT_MAX_BACKUPS" json:"audit_max_backups"`
+	DedupWindow         int    `env:"DEDUP_WINDOW" json:"dedup_window"`
+	RemoteWriteRules    string `env:"REMOTE_WRITE_RULES" json:"remote_write_rules"`
+	InfluxNameTags      string `env:"INFLUX_NAME_TAGS" json:"influx_name_tags"`
+	StatsdAddr          string `env:"STATSD_ADDRESS" json:"statsd_address"`
+	StatsdFlush         int64  `env:"STATSD_FLUSH_INTERVAL" json:"statsd_flush_interval"`
+	GraphiteAddr        string `env:"GRAPHITE_ADDRESS" json:"graphite_address"`
+	GraphitePickle      string `env:"GRAPHITE_PICKLE_ADDRESS" json:"graphite_pickle_address"`
+	GraphiteTemplate    string `env:"GRAPHITE_TEMPLATES" json:"graphite_templates"`
+	OTLPResourceAttr    string `env:"OTLP_RESOURCE_ATTRIBUTES" json:"otlp_resource_attributes"`
+	SelfMetricsPrefix   string `env:"SELF_METRICS_PREFIX" json:"self_metrics_prefix"`
+	SelfMetricsInterval int64  `env:"SELF_METRICS_INTERVAL" json:"self_metrics_interval"`
+	ValidateRequests    bool   `env:"VALIDATE_REQUESTS" json:"validate_requests"`
+	AdminToken          string `env:"ADMIN_TOKEN" json:"admin_token"`
+	AuthTokens          string `env:"AUTH_TOKENS" json:"auth_tokens"`
+	AgentSecrets        string `env:"AGENT_SECRETS" json:"agent_secrets"`
+	AuthToken           string `env:"AUTH_TOKEN" json:"auth_token"`
+	TLSCert             string `env:"TLS_CERT" json:"tls_cert"`
+	TLSKey              string `env:"TLS_KEY" json:"tls_key"`
+	TLSClientCA         string `env:"TLS_CLIENT_CA" json:"tls_client_ca"`
+	TLSClientAuth       string `env:"TLS_CLIENT_AUTH" json:"tls_client_auth"`
+	TLSCA               string `env:"TLS_CA" json:"tls_ca"`
+	TLSServerName       string `env:"TLS_SERVER_NAME" json:"tls_server_name"`
+	Keyring             string `env:"KEYRING" json:"keyring"`
+	KeyGracePeriod      int64  `env:"KEY_GRACE_PERIOD" json:"key_grace_period"`
+	SecretKeyID         string `env:"KEY_ID" json:"key_id"`
+	CryptoKeyID         string `env:"CRYPTO_KEY_ID" json:"crypto_key_id"`
+	SignLegacy          bool   `env:"SIGN_LEGACY" json:"sign_legacy"`
+	SignMaxSkew         int64  `env:"SIGN_MAX_SKEW" json:"sign_max_skew"`
+	SignAllowUnsigned   bool   `env:"SIGN_ALLOW_UNSIGNED" json:"sign_allow_unsigned"`
+	WG                  sync.WaitGroup
 }
 
 func (c *Config) SetDefault() {
@@ -73,6 +109,38 @@ func (c *Config) SetDefault() {
 	if c.FileStoragePath == "" {
 		c.FileStoragePath = "/tmp/metrics-db.json"
 	}
+
+	if c.AuditMaxSize == 0 {
+		c.AuditMaxSize = 100
+	}
+
+	if c.AuditMaxBackups == 0 {
+		c.AuditMaxBackups = 5
+	}
+
+	if c.DedupWindow == 0 {
+		c.DedupWindow = 10000
+	}
+
+	if c.StatsdFlush == 0 {
+		c.StatsdFlush = 10
+	}
+
+	if c.OTLPResourceAttr == "" {
+		c.OTLPResourceAttr = "service.name"
+	}
+
+	if c.SelfMetricsInterval == 0 {
+		c.SelfMetricsInterval = 10
+	}
+
+	if c.KeyGracePeriod == 0 {
+		c.KeyGracePeriod = 86400
+	}
+
+	if c.SignMaxSkew == 0 {
+		c.SignMaxSkew = 300
+	}
 }
 
 // NewAgentConfig конструктор для Config
@@ -152,6 +220,83 @@ func NewAgentConfig() (*Config, error) {
 		}
 	}
 
+	if config.AgentID == "" {
+		config.AgentID = flags.AgentID
+		if config.AgentID == "" {
+			config.AgentID = configJSON.AgentID
+		}
+		if config.AgentID == "" {
+			config.AgentID, _ = os.Hostname()
+		}
+	}
+
+	if config.AuthToken == "" {
+		config.AuthToken = flags.AuthToken
+		if config.AuthToken == "" {
+			config.AuthToken = configJSON.AuthToken
+		}
+	}
+
+	if config.StatsdAddr == "" {
+		config.StatsdAddr = flags.StatsdAddr
+		if config.StatsdAddr == "" {
+			config.StatsdAddr = configJSON.StatsdAddr
+		}
+	}
+
+	if config.StatsdFlush == 0 {
+		config.StatsdFlush = flags.StatsdFlush
+		if config.StatsdFlush == 0 {
+			config.StatsdFlush = configJSON.StatsdFlush
+		}
+	}
+
+	if config.TLSCert == "" {
+		config.TLSCert = flags.TLSCert
+		if config.TLSCert == "" {
+			config.TLSCert = configJSON.TLSCert
+		}
+	}
+
+	if config.TLSKey == "" {
+		config.TLSKey = flags.TLSKey
+		if config.TLSKey == "" {
+			config.TLSKey = configJSON.TLSKey
+		}
+	}
+
+	if config.TLSCA == "" {
+		config.TLSCA = flags.TLSCA
+		if config.TLSCA == "" {
+			config.TLSCA = configJSON.TLSCA
+		}
+	}
+
+	if config.TLSServerName == "" {
+		config.TLSServerName = flags.TLSServerName
+		if config.TLSServerName == "" {
+			config.TLSServerName = configJSON.TLSServerName
+		}
+	}
+
+	if config.SecretKeyID == "" {
+		config.SecretKeyID = flags.SecretKeyID
+		if config.SecretKeyID == "" {
+			config.SecretKeyID = configJSON.SecretKeyID
+		}
+	}
+
+	if config.CryptoKeyID == "" {
+		config.CryptoKeyID = flags.CryptoKeyID
+		if config.CryptoKeyID == "" {
+			config.CryptoKeyID = configJSON.CryptoKeyID
+		}
+	}
+
+	if !config.SignLegacy {
+		config.SignLegacy = flags.SignLegacy || configJSON.SignLegacy
+	}
+
 	config.SetDefault()
 	return &config, nil
 }
@@ -168,6 +313,17 @@ func parseAgentFlags() Config {
 	flagCryptoKey := flag.String("crypto-key", "", "path to file with public key")
 	flagConfigFile := flag.String("config", "", "path to config file")
 	grpcServer := flag.String("g", "", "gRPC server address")
+	agentID := flag.String("agent-id", "", "agent identifier sent to server, hostname by default")
+	authToken := flag.String("auth-token", "", "API token with the write scope sent to server")
+	statsdAddr := flag.String("statsd", "", "address to accept StatsD metrics on, disabled if empty")
+	statsdFlush := flag.Int64("statsd-flush", 0, "interval in seconds between StatsD aggregation flushes")
+	tlsCA := flag.String("tls-ca", "", "path to CA certificate to verify server with, enables TLS")
+	tlsCert := flag.String("tls-cert", "", "path to client certificate for mutual TLS")
+	tlsKey := flag.String("tls-key", "", "path to client certificate key for mutual TLS")
+	tlsServerName := flag.String("tls-server-name", "", "server name expected in server certificate, host of server address by default")
+	secretKeyID := flag.String("key-id", "", "id of the signature key in the server keyring, the server default key if empty")
+	cryptoKeyID := flag.String("crypto-key-id", "", "id of the server private key matching the public key, the server default key if empty")
+	signLegacy := flag.Bool("sign-legacy", false, "sign only request body with the HashSHA256 header for servers without replay protection")
 
 	flag.Parse()
 
@@ -181,6 +337,17 @@ func parseAgentFlags() Config {
 		CryptoKey:        *flagCryptoKey,
 		ConfigFile:       *flagConfigFile,
 		GRPSServerIPAddr: *grpcServer,
+		AgentID:          *agentID,
+		AuthToken:        *authToken,
+		StatsdAddr:       *statsdAddr,
+		StatsdFlush:      *statsdFlush,
+		TLSCA:            *tlsCA,
+		TLSCert:          *tlsCert,
+		TLSKey:           *tlsKey,
+		TLSServerName:    *tlsServerName,
+		SecretKeyID:      *secretKeyID,
+		CryptoKeyID:      *cryptoKeyID,
+		SignLegacy:       *signLegacy,
 	}
 }
 
@@ -267,6 +434,27 @@ func NewServerConfig() (*Config, error) {
 		}
 	}
 
+	if config.TrustedProxies == "" {
+		config.TrustedProxies = flags.TrustedProxies
+		if config.TrustedProxies == "" {
+			config.TrustedProxies = configJSON.TrustedProxies
+		}
+	}
+
+	if config.TrustedHeaders == "" {
+		config.TrustedHeaders = flags.TrustedHeaders
+		if config.TrustedHeaders == "" {
+			config.TrustedHeaders = configJSON.TrustedHeaders
+		}
+	}
+
+	if config.TrustedProxyDepth == 0 {
+		config.TrustedProxyDepth = flags.TrustedProxyDepth
+		if config.TrustedProxyDepth == 0 {
+			config.TrustedProxyDepth = configJSON.TrustedProxyDepth
+		}
+	}
+
 	if config.GRPSServerIPAddr == "" {
 		config.GRPSServerIPAddr = flags.GRPSServerIPAddr
 		if config.GRPSServerIPAddr == "" {
@@ -274,6 +462,186 @@ func NewServerConfig() (*Config, error) {
 		}
 	}
 
+	if config.AuditFile == "" {
+		config.AuditFile = flags.AuditFile
+		if config.AuditFile == "" {
+			config.AuditFile = configJSON.AuditFile
+		}
+	}
+
+	if config.AuditMaxSize == 0 {
+		config.AuditMaxSize = flags.AuditMaxSize
+		if config.AuditMaxSize == 0 {
+			config.AuditMaxSize = configJSON.AuditMaxSize
+		}
+	}
+
+	if config.AuditMaxBackups == 0 {
+		config.AuditMaxBackups = flags.AuditMaxBackups
+		if config.AuditMaxBackups == 0 {
+			config.AuditMaxBackups = configJSON.AuditMaxBackups
+		}
+	}
+
+	if config.DedupWindow == 0 {
+		config.DedupWindow = flags.DedupWindow
+		if config.DedupWindow == 0 {
+			config.DedupWindow = configJSON.DedupWindow
+		}
+	}
+
+	if config.RemoteWriteRules == "" {
+		config.RemoteWriteRules = flags.RemoteWriteRules
+		if config.RemoteWriteRules == "" {
+			config.RemoteWriteRules = configJSON.RemoteWriteRules
+		}
+	}
+
+	if config.InfluxNameTags == "" {
+		config.InfluxNameTags = flags.InfluxNameTags
+		if config.InfluxNameTags == "" {
+			config.InfluxNameTags = configJSON.InfluxNameTags
+		}
+	}
+
+	if config.StatsdAddr == "" {
+		config.StatsdAddr = flags.StatsdAddr
+		if config.StatsdAddr == "" {
+			config.StatsdAddr = configJSON.StatsdAddr
+		}
+	}
+
+	if config.StatsdFlush == 0 {
+		config.StatsdFlush = flags.StatsdFlush
+		if config.StatsdFlush == 0 {
+			config.StatsdFlush = configJSON.StatsdFlush
+		}
+	}
+
+	if config.GraphiteAddr == "" {
+		config.GraphiteAddr = flags.GraphiteAddr
+		if config.GraphiteAddr == "" {
+			config.GraphiteAddr = configJSON.GraphiteAddr
+		}
+	}
+
+	if config.GraphitePickle == "" {
+		config.GraphitePickle = flags.GraphitePickle
+		if config.GraphitePickle == "" {
+			config.GraphitePickle = configJSON.GraphitePickle
+		}
+	}
+
+	if config.GraphiteTemplate == "" {
+		config.GraphiteTemplate = flags.GraphiteTemplate
+		if config.GraphiteTemplate == "" {
+			config.GraphiteTemplate = configJSON.GraphiteTemplate
+		}
+	}
+
+	if config.OTLPResourceAttr == "" {
+		config.OTLPResourceAttr = flags.OTLPResourceAttr
+		if config.OTLPResourceAttr == "" {
+			config.OTLPResourceAttr = configJSON.OTLPResourceAttr
+		}
+	}
+
+	if config.SelfMetricsPrefix == "" {
+		config.SelfMetricsPrefix = flags.SelfMetricsPrefix
+		if config.SelfMetricsPrefix == "" {
+			config.SelfMetricsPrefix = configJSON.SelfMetricsPrefix
+		}
+	}
+
+	if config.SelfMetricsInterval == 0 {
+		config.SelfMetricsInterval = flags.SelfMetricsInterval
+		if config.SelfMetricsInterval == 0 {
+			config.SelfMetricsInterval = configJSON.SelfMetricsInterval
+		}
+	}
+
+	if !config.ValidateRequests {
+		config.ValidateRequests = flags.ValidateRequests || configJSON.ValidateRequests
+	}
+
+	if config.AdminToken == "" {
+		config.AdminToken = flags.AdminToken
+		if config.AdminToken == "" {
+			config.AdminToken = configJSON.AdminToken
+		}
+	}
+
+	if config.AuthTokens == "" {
+		config.AuthTokens = flags.AuthTokens
+		if config.AuthTokens == "" {
+			config.AuthTokens = configJSON.AuthTokens
+		}
+	}
+
+	if config.AgentSecrets == "" {
+		config.AgentSecrets = flags.AgentSecrets
+		if config.AgentSecrets == "" {
+			config.AgentSecrets = configJSON.AgentSecrets
+		}
+	}
+
+	if config.TLSCert == "" {
+		config.TLSCert = flags.TLSCert
+		if config.TLSCert == "" {
+			config.TLSCert = configJSON.TLSCert
+		}
+	}
+
+	if config.TLSKey == "" {
+		config.TLSKey = flags.TLSKey
+		if config.TLSKey == "" {
+			config.TLSKey = configJSON.TLSKey
+		}
+	}
+
+	if config.TLSClientCA == "" {
+		config.TLSClientCA = flags.TLSClientCA
+		if config.TLSClientCA == "" {
+			config.TLSClientCA = configJSON.TLSClientCA
+		}
+	}
+
+	if config.TLSClientAuth == "" {
+		config.TLSClientAuth = flags.TLSClientAuth
+		if config.TLSClientAuth == "" {
+			config.TLSClientAuth = configJSON.TLSClientAuth
+		}
+	}
+
+	if config.Keyring == "" {
+		config.Keyring = flags.Keyring
+		if config.Keyring == "" {
+			config.Keyring = configJSON.Keyring
+		}
+	}
+
+	if config.KeyGracePeriod == 0 {
+		config.KeyGracePeriod = flags.KeyGracePeriod
+		if config.KeyGracePeriod == 0 {
+			config.KeyGracePeriod = configJSON.KeyGracePeriod
+		}
+	}
+
+	if !config.SignLegacy {
+		config.SignLegacy = flags.SignLegacy || configJSON.SignLegacy
+	}
+
+	if !config.SignAllowUnsigned {
+		config.SignAllowUnsigned = flags.SignAllowUnsigned || configJSON.SignAllowUnsigned
+	}
+
+	if config.SignMaxSkew == 0 {
+		config.SignMaxSkew = flags.SignMaxSkew
+		if config.SignMaxSkew == 0 {
+			config.SignMaxSkew = configJSON.SignMaxSkew
+		}
+	}
+
 	config.SetDefault()
 	return &config, nil
 }
@@ -288,8 +656,38 @@ func parseServerFlags() Config {
 	secretKey := flag.String("k", "", "secret key for digital signature")
 	cryptoKey := flag.String("crypto-key", "", "path to file with private key")
 	configFile := flag.String("config", "", "path to config file")
-	trustedSubnet := flag.String("t", "", "trusted subnet")
+	trustedSubnet := flag.String("t", "", "comma separated trusted IPv4 and IPv6 subnets in CIDR notation, all clients are allowed if empty")
+	trustedProxies := flag.String("trusted-proxies", "", "comma separated subnets of proxies allowed to pass client address in headers, headers are ignored if empty")
+	trustedHeaders := flag.String("trusted-headers", "", "comma separated proxy headers with client address to trust: X-Real-IP, X-Forwarded-For or none, X-Real-IP by default")
+	trustedProxyDepth := flag.Int("trusted-proxy-depth", 0, "number of proxies in front of server appending to X-Forwarded-For, 1 by default")
 	grpcServer := flag.String("g", "", "address and port to run gRPC server")
+	auditFile := flag.String("audit-file", "", "path to NDJSON audit log, in-memory audit if empty")
+	auditMaxSize := flag.Int64("audit-max-size", 0, "audit log size in megabytes before rotation")
+	auditMaxBackups := flag.Int("audit-max-backups", 0, "number of rotated audit log files to keep")
+	statsdAddr := flag.String("statsd", "", "address to accept StatsD metrics on, disabled if empty")
+	statsdFlush := flag.Int64("statsd-flush", 0, "interval in seconds between StatsD aggregation flushes")
+	graphiteAddr := flag.String("graphite", "", "address to accept Graphite plaintext metrics on, disabled if empty")
+	graphitePickle := flag.String("graphite-pickle", "", "address to accept Graphite pickle metrics on, disabled if empty")
+	graphiteTemplates := flag.String("graphite-templates", "", "path to file with Graphite path templates, one per line")
+	otlpResourceAttrs := flag.String("otlp-resource-attributes", "", "comma separated OTLP resource attributes prefixed to metric names, service.name by default")
+	influxNameTags := flag.String("influx-name-tags", "", "comma separated line protocol tags included in metric names")
+	remoteWriteRules := flag.String("remote-write-rules", "", "path to JSON file with Prometheus remote write mapping rules")
+	dedupWindow := flag.Int("dedup-window", 0, "number of recent batch ids remembered to ignore replays, negative disables")
+	selfMetricsPrefix := flag.String("self-metrics-prefix", "", "reserved prefix to store server self metrics under, disabled if empty")
+	selfMetricsInterval := flag.Int64("self-metrics-interval", 0, "interval in seconds between storing server self metrics")
+	adminToken := flag.String("admin-token", "", "bearer token for the admin API, disabled if empty")
+	authTokens := flag.String("auth-tokens", "", "path to JSON file with API tokens or \"db\" to keep them in the database, tokens are not required if empty")
+	agentSecrets := flag.String("agent-secrets", "", "path to JSON file with per-agent signature secrets or \"db\" to keep them in the database, disabled if empty")
+	tlsCert := flag.String("tls-cert", "", "path to server certificate, enables TLS for HTTP and gRPC servers")
+	tlsKey := flag.String("tls-key", "", "path to server certificate key")
+	tlsClientCA := flag.String("tls-client-ca", "", "path to CA certificate to verify client certificates with, enables mutual TLS")
+	tlsClientAuth := flag.String("tls-client-auth", "", "client certificate check: require, or optional to verify only presented certificates")
+	keyringFile := flag.String("keyring", "", "path to JSON file with signature and private keys identified by key id")
+	keyGracePeriod := flag.Int64("key-grace-period", 0, "seconds a retired key is still accepted, 86400 by default, negative disables")
+	signLegacy := flag.Bool("sign-legacy", false, "accept old HashSHA256 signatures covering only request body, they can be replayed")
+	signMaxSkew := flag.Int64("sign-max-skew", 0, "allowed difference in seconds between signature timestamp and server clock, 300 by default")
+	signAllowUnsigned := flag.Bool("sign-allow-unsigned", false, "accept unsigned agent writes while signature keys are set, they can be replayed")
+	validateRequests := flag.Bool("validate-requests", false, "reject REST requests that don`t match the OpenAPI specification")
 
 	var restoreOnStart *bool
 	flag.BoolFunc("r", "restore saved metrics on start", func(restore string) error {
@@ -307,15 +705,45 @@ func parseServerFlags() Config {
 	flag.Parse()
 
 	return Config{
-		ServerIPAddr:     *serverIPAddr,
-		StoreInterval:    *serverStoreInterval,
-		FileStoragePath:  *fileStoragePath,
-		RestoreMetrics:   restoreOnStart,
-		DatabaseDSN:      *databaseDSN,
-		SecretKey:        *secretKey,
-		CryptoKey:        *cryptoKey,
-		ConfigFile:       *configFile,
-		TrustedSubnet:    *trustedSubnet,
-		GRPSServerIPAddr: *grpcServer,
+		ServerIPAddr:        *serverIPAddr,
+		StoreInterval:       *serverStoreInterval,
+		FileStoragePath:     *fileStoragePath,
+		RestoreMetrics:      restoreOnStart,
+		DatabaseDSN:         *databaseDSN,
+		SecretKey:           *secretKey,
+		CryptoKey:           *cryptoKey,
+		ConfigFile:          *configFile,
+		TrustedSubnet:       *trustedSubnet,
+		TrustedProxies:      *trustedProxies,
+		TrustedHeaders:      *trustedHeaders,
+		TrustedProxyDepth:   *trustedProxyDepth,
+		GRPSServerIPAddr:    *grpcServer,
+		AuditFile:           *auditFile,
+		AuditMaxSize:        *auditMaxSize,
+		AuditMaxBackups:     *auditMaxBackups,
+		DedupWindow:         *dedupWindow,
+		RemoteWriteRules:    *remoteWriteRules,
+		InfluxNameTags:      *influxNameTags,
+		StatsdAddr:          *statsdAddr,
+		StatsdFlush:         *statsdFlush,
+		GraphiteAddr:        *graphiteAddr,
+		GraphitePickle:      *graphitePickle,
+		GraphiteTemplate:    *graphiteTemplates,
+		OTLPResourceAttr:    *otlpResourceAttrs,
+		SelfMetricsPrefix:   *selfMetricsPrefix,
+		SelfMetricsInterval: *selfMetricsInterval,
+		ValidateRequests:    *validateRequests,
+		AdminToken:          *adminToken,
+		AuthTokens:          *authTokens,
+		AgentSecrets:        *agentSecrets,
+		TLSCert:             *tlsCert,
+		TLSKey:              *tlsKey,
+		TLSClientCA:         *tlsClientCA,
+		TLSClientAuth:       *tlsClientAuth,
+		Keyring:             *keyringFile,
+		KeyGracePeriod:      *keyGracePeriod,
+		SignLegacy:          *signLegacy,
+		SignMaxSkew:         *signMaxSkew,
+		SignAllowUnsigned:   *signAllowUnsigned,
 	}
 }
@@ -1,11 +1,14 @@
 package config
 
 import (
+	"flag"
 	"os"
+	"path/filepath"
 	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type AgentArgs struct {
@@ -71,14 +74,22 @@ func TestNewServerConfig(t *testing.T) {
 		want *Config
 	}{
 		name: "default", want: &Config{
-			ServerIPAddr:    "localhost:8080",
-			PollInterval:    2,
-			ReportInterval:  5,
-			RateLimit:       1,
-			Profiler:        &f,
-			StoreInterval:   300,
-			RestoreMetrics:  &y,
-			FileStoragePath: "/tmp/metrics-db.json",
+			ServerIPAddr:        "localhost:8080",
+			PollInterval:        2,
+			ReportInterval:      5,
+			RateLimit:           1,
+			Profiler:            &f,
+			StoreInterval:       300,
+			RestoreMetrics:      &y,
+			FileStoragePath:     "/tmp/metrics-db.json",
+			AuditMaxSize:        100,
+			AuditMaxBackups:     5,
+			DedupWindow:         10000,
+			StatsdFlush:         10,
+			OTLPResourceAttr:    "service.name",
+			SelfMetricsInterval: 10,
+			KeyGracePeriod:      86400,
+			SignMaxSkew:         300,
 		},
 	}
 	t.Run(test.name, func(t *testing.T) {
@@ -87,3 +98,30 @@ func TestNewServerConfig(t *testing.T) {
 		assert.Equalf(t, test.want, config, "NewServerConfig()")
 	})
 }
+
+func TestNewServerConfig_AgentSecrets(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"agent_secrets": "/etc/blackbird/json-agents.json"}`), 0o600))
+
+	tests := []struct {
+		name string
+		env  string
+		args []string
+		want string
+	}{
+		{name: "env", env: "/etc/blackbird/env-agents.json", args: []string{"server", "-agent-secrets", "/etc/blackbird/flag-agents.json"}, want: "/etc/blackbird/env-agents.json"},
+		{name: "flag", args: []string{"server", "-agent-secrets", "/etc/blackbird/flag-agents.json", "-config", file}, want: "/etc/blackbird/flag-agents.json"},
+		{name: "json", args: []string{"server", "-config", file}, want: "/etc/blackbird/json-agents.json"},
+		{name: "empty", args: []string{"server"}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AGENT_SECRETS", tt.env)
+			flag.CommandLine = flag.NewFlagSet(tt.args[0], flag.ContinueOnError)
+			os.Args = tt.args
+			config, err := NewServerConfig()
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, config.AgentSecrets)
+		})
+	}
+}
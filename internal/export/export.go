@@ -0,0 +1,171 @@
+// Package export выгружает метрики в CSV и NDJSON для анализа в pandas и электронных таблицах.
+// Строки пишутся по одной, выгрузка целиком в памяти не собирается.
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/sebasttiano/Blackbird.git/internal/models"
+)
+
+// Format формат выгрузки.
+type Format string
+
+const (
+	// FormatCSV значения через запятую с заголовком.
+	FormatCSV Format = "csv"
+	// FormatNDJSON по одному JSON объекту на строку.
+	FormatNDJSON Format = "ndjson"
+)
+
+// Виды строк выгрузки.
+const (
+	KindCurrent = "current" // текущее значение метрики
+	KindHistory = "history" // принятое значение из журнала аудита
+)
+
+// ErrUnknownFormat ошибка, если формат выгрузки не поддерживается.
+var ErrUnknownFormat = errors.New("unknown export format. only csv and ndjson are available")
+
+// ErrInvalidFilter ошибка, если фильтр выгрузки некорректен.
+var ErrInvalidFilter = errors.New("invalid export filter")
+
+// csvHeader заголовок CSV выгрузки.
+var csvHeader = []string{"kind", "time", "type", "id", "value", "agent"}
+
+// ParseFormat разбирает формат выгрузки, по умолчанию CSV.
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case "", FormatCSV:
+		return FormatCSV, nil
+	case FormatNDJSON:
+		return FormatNDJSON, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnknownFormat, s)
+	}
+}
+
+// ContentType возвращает значение заголовка Content-Type для формата.
+func (f Format) ContentType() string {
+	if f == FormatNDJSON {
+		return "application/x-ndjson"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// Filter условия выгрузки. Пустые поля не фильтруют.
+type Filter struct {
+	Type  string // gauge или counter
+	Match string // шаблон имени метрики как у path.Match
+}
+
+// Validate проверяет тип метрики и синтаксис шаблона.
+func (f Filter) Validate() error {
+	if f.Type != "" && f.Type != "gauge" && f.Type != "counter" {
+		return fmt.Errorf("%w: unknown metric type %s", ErrInvalidFilter, f.Type)
+	}
+	if _, err := path.Match(f.Match, ""); err != nil {
+		return fmt.Errorf("%w: bad match pattern %s", ErrInvalidFilter, f.Match)
+	}
+	return nil
+}
+
+// Matches проверяет, подходит ли метрика под фильтр.
+func (f Filter) Matches(metricType, name string) bool {
+	if f.Type != "" && f.Type != metricType {
+		return false
+	}
+	if f.Match == "" {
+		return true
+	}
+	ok, _ := path.Match(f.Match, name)
+	return ok
+}
+
+// Row строка выгрузки. Для counter в истории Delta это принятое приращение, а не итоговое значение.
+type Row struct {
+	Kind string     `json:"kind"`
+	Time *time.Time `json:"time,omitempty"` // время обновления, nil если неизвестно
+	models.Metrics
+	AgentID string `json:"agent,omitempty"` // агент, приславший значение, только для истории
+}
+
+// Writer пишет строки выгрузки в выбранном формате.
+type Writer interface {
+	Write(row *Row) error
+	// Flush досылает буферизованные строки в нижележащий io.Writer.
+	Flush() error
+}
+
+// NewWriter возвращает Writer для формата.
+func NewWriter(w io.Writer, f Format) Writer {
+	if f == FormatNDJSON {
+		return &ndjsonWriter{enc: json.NewEncoder(w)}
+	}
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+// csvWriter пишет строки в CSV, заголовок идет первой строкой даже у пустой выгрузки.
+type csvWriter struct {
+	w           *csv.Writer
+	wroteHeader bool
+	record      []string
+}
+
+func (c *csvWriter) header() error {
+	if c.wroteHeader {
+		return nil
+	}
+	c.wroteHeader = true
+	return c.w.Write(csvHeader)
+}
+
+// Write пишет строку в CSV.
+func (c *csvWriter) Write(row *Row) error {
+	if err := c.header(); err != nil {
+		return err
+	}
+	var ts, value string
+	if row.Time != nil {
+		ts = row.Time.UTC().Format(time.RFC3339Nano)
+	}
+	switch {
+	case row.Value != nil:
+		value = strconv.FormatFloat(*row.Value, 'g', -1, 64)
+	case row.Delta != nil:
+		value = strconv.FormatInt(*row.Delta, 10)
+	}
+	c.record = append(c.record[:0], row.Kind, ts, row.MType, row.ID, value, row.AgentID)
+	return c.w.Write(c.record)
+}
+
+// Flush досылает строки из буфера csv.Writer.
+func (c *csvWriter) Flush() error {
+	if err := c.header(); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// ndjsonWriter пишет строки в NDJSON, json.Encoder не буферизует, поэтому Flush ничего не делает.
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+// Write пишет строку в NDJSON.
+func (n *ndjsonWriter) Write(row *Row) error {
+	return n.enc.Encode(row)
+}
+
+// Flush метод интерфейса Writer.
+func (n *ndjsonWriter) Flush() error {
+	return nil
+}
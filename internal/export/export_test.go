@@ -0,0 +1,88 @@
+package export
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/sebasttiano/Blackbird.git/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Format
+		wantErr bool
+	}{
+		{in: "", want: FormatCSV},
+		{in: "csv", want: FormatCSV},
+		{in: "ndjson", want: FormatNDJSON},
+		{in: "xlsx", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseFormat(tt.in)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrUnknownFormat)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	assert.ErrorIs(t, Filter{Type: "histogram"}.Validate(), ErrInvalidFilter)
+	assert.ErrorIs(t, Filter{Match: "Heap["}.Validate(), ErrInvalidFilter)
+	require.NoError(t, Filter{Type: "gauge", Match: "Heap*"}.Validate())
+
+	f := Filter{Type: "gauge", Match: "Heap*"}
+	assert.True(t, f.Matches("gauge", "HeapAlloc"))
+	assert.False(t, f.Matches("counter", "HeapAlloc"))
+	assert.False(t, f.Matches("gauge", "Alloc"))
+	assert.True(t, Filter{}.Matches("counter", "PollCount"))
+}
+
+func TestWriter(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	value, delta := 1.5, int64(3)
+	rows := []Row{
+		{Kind: KindCurrent, Time: &ts, Metrics: models.Metrics{ID: "Alloc", MType: "gauge", Value: &value}},
+		{Kind: KindHistory, Metrics: models.Metrics{ID: "Poll,Count", MType: "counter", Delta: &delta}, AgentID: "agent-1"},
+	}
+
+	tests := []struct {
+		format Format
+		want   string
+	}{
+		{
+			format: FormatCSV,
+			want: "kind,time,type,id,value,agent\n" +
+				"current,2024-05-01T12:00:00Z,gauge,Alloc,1.5,\n" +
+				"history,,counter,\"Poll,Count\",3,agent-1\n",
+		},
+		{
+			format: FormatNDJSON,
+			want: `{"kind":"current","time":"2024-05-01T12:00:00Z","id":"Alloc","type":"gauge","value":1.5}` + "\n" +
+				`{"kind":"history","id":"Poll,Count","type":"counter","delta":3,"agent":"agent-1"}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriter(&buf, tt.format)
+			for i := range rows {
+				require.NoError(t, w.Write(&rows[i]))
+			}
+			require.NoError(t, w.Flush())
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, NewWriter(&buf, FormatCSV).Flush())
+	assert.Equal(t, "kind,time,type,id,value,agent\n", buf.String(), "empty export keeps the header")
+}
@@ -0,0 +1,147 @@
+// Package exposition выводит метрики из хранилища в текстовых форматах Prometheus и OpenMetrics.
+package exposition
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sebasttiano/Blackbird.git/internal/logger"
+	"github.com/sebasttiano/Blackbird.git/internal/repository"
+	"go.uber.org/zap"
+)
+
+// Format формат вывода метрик.
+type Format int
+
+const (
+	// FormatText текстовый формат Prometheus 0.0.4.
+	FormatText Format = iota
+	// FormatOpenMetrics формат OpenMetrics 1.0.0.
+	FormatOpenMetrics
+)
+
+const (
+	textContentType        = "text/plain; version=0.0.4; charset=utf-8"
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	openMetricsMediaType   = "application/openmetrics-text"
+)
+
+// Negotiate выбирает формат по заголовку Accept. OpenMetrics отдается, только если клиент явно его запросил.
+func Negotiate(accept string) Format {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || mediaType != openMetricsMediaType {
+			continue
+		}
+		if q, ok := params["q"]; ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		return FormatOpenMetrics
+	}
+	return FormatText
+}
+
+// ContentType возвращает значение заголовка Content-Type для формата.
+func (f Format) ContentType() string {
+	if f == FormatOpenMetrics {
+		return openMetricsContentType
+	}
+	return textContentType
+}
+
+// SanitizeName приводит имя метрики к допустимому в Prometheus виду [a-zA-Z_:][a-zA-Z0-9_:]*.
+func SanitizeName(name string) string {
+	if name == "" {
+		return "_"
+	}
+	var b strings.Builder
+	b.Grow(len(name) + 1)
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// family семейство метрик с одним именем в выводе.
+type family struct {
+	name     string
+	original string
+	mType    string
+	value    string
+}
+
+// Write выводит все метрики в выбранном формате. Метрики сортируются по имени,
+// при совпадении имен после приведения выводится только первая из них.
+func Write(w io.Writer, sm *repository.StoreMetrics, f Format) error {
+	families := make([]family, 0, len(sm.Gauge)+len(sm.Counter))
+	for _, m := range sm.Gauge {
+		families = append(families, family{name: SanitizeName(m.Name), original: m.Name, mType: "gauge", value: formatFloat(m.Value)})
+	}
+	for _, m := range sm.Counter {
+		name := SanitizeName(m.Name)
+		if f == FormatOpenMetrics {
+			// в OpenMetrics суффикс _total принадлежит сэмплу, а не семейству
+			name = strings.TrimSuffix(name, "_total")
+		}
+		families = append(families, family{name: name, original: m.Name, mType: "counter", value: strconv.FormatInt(m.Value, 10)})
+	}
+	sort.SliceStable(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	bw := bufio.NewWriter(w)
+	seen := make(map[string]struct{}, len(families))
+	for _, fam := range families {
+		if _, ok := seen[fam.name]; ok {
+			logger.Log.Warn("duplicate metric name after sanitizing, skipped", zap.String("metric", fam.original), zap.String("name", fam.name))
+			continue
+		}
+		seen[fam.name] = struct{}{}
+
+		sample := fam.name
+		if f == FormatOpenMetrics && fam.mType == "counter" {
+			sample += "_total"
+		}
+		bw.WriteString("# HELP " + fam.name + " Blackbird " + fam.mType + " " + escapeHelp(fam.original) + "\n")
+		bw.WriteString("# TYPE " + fam.name + " " + fam.mType + "\n")
+		bw.WriteString(sample + " " + fam.value + "\n")
+	}
+	if f == FormatOpenMetrics {
+		bw.WriteString("# EOF\n")
+	}
+	return bw.Flush()
+}
+
+// formatFloat форматирует значение gauge, включая специальные значения.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// escapeHelp экранирует обратный слэш и перевод строки в тексте HELP.
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
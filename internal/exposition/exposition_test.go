@@ -0,0 +1,95 @@
+package exposition
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/sebasttiano/Blackbird.git/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "valid", in: "HeapAlloc", want: "HeapAlloc"},
+		{name: "colon and underscore", in: "job:rate_5m", want: "job:rate_5m"},
+		{name: "dots and dashes", in: "http.requests-total", want: "http_requests_total"},
+		{name: "leading digit", in: "5xx", want: "_5xx"},
+		{name: "unicode", in: "память", want: "______"},
+		{name: "empty", in: "", want: "_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, SanitizeName(tt.in))
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		want   Format
+	}{
+		{name: "empty", accept: "", want: FormatText},
+		{name: "text", accept: "text/plain;version=0.0.4", want: FormatText},
+		{name: "openmetrics", accept: "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5", want: FormatOpenMetrics},
+		{name: "openmetrics refused", accept: "application/openmetrics-text;q=0,text/plain", want: FormatText},
+		{name: "anything", accept: "*/*", want: FormatText},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Negotiate(tt.accept))
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	sm := &repository.StoreMetrics{
+		Gauge: []repository.GaugeMetric{
+			{Name: "Alloc", Value: 1.5},
+			{Name: "cpu.util", Value: math.Inf(1)},
+			{Name: "cpu_util", Value: 3},
+		},
+		Counter: []repository.CounterMetric{
+			{Name: "PollCount", Value: 42},
+			{Name: "requests_total", Value: 7},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		format Format
+		want   string
+	}{
+		{
+			name:   "text",
+			format: FormatText,
+			want: "# HELP Alloc Blackbird gauge Alloc\n# TYPE Alloc gauge\nAlloc 1.5\n" +
+				"# HELP PollCount Blackbird counter PollCount\n# TYPE PollCount counter\nPollCount 42\n" +
+				"# HELP cpu_util Blackbird gauge cpu.util\n# TYPE cpu_util gauge\ncpu_util +Inf\n" +
+				"# HELP requests_total Blackbird counter requests_total\n# TYPE requests_total counter\nrequests_total 7\n",
+		},
+		{
+			name:   "openmetrics",
+			format: FormatOpenMetrics,
+			want: "# HELP Alloc Blackbird gauge Alloc\n# TYPE Alloc gauge\nAlloc 1.5\n" +
+				"# HELP PollCount Blackbird counter PollCount\n# TYPE PollCount counter\nPollCount_total 42\n" +
+				"# HELP cpu_util Blackbird gauge cpu.util\n# TYPE cpu_util gauge\ncpu_util +Inf\n" +
+				"# HELP requests Blackbird counter requests_total\n# TYPE requests counter\nrequests_total 7\n" +
+				"# EOF\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, Write(&buf, sm, tt.format))
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sebasttiano/Blackbird.git/internal/logger"
+	pb "github.com/sebasttiano/Blackbird.git/internal/proto"
+	"github.com/sebasttiano/Blackbird.git/internal/service"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// logLevel тело запроса и ответа для уровня логирования.
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+// resetResult ответ на сброс counter метрики.
+type resetResult struct {
+	Previous int64 `json:"previous"`
+}
+
+// AdminStats отдает статистику хранилища.
+func (s *ServerViews) AdminStats(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancel()
+
+	stats, err := s.Service.Stats(ctx)
+	if err != nil {
+		logger.Log.Error("couldn`t get storage stats", zap.Error(err))
+		writeProblem(res, req, err)
+		return
+	}
+	writeJSON(res, stats)
+}
+
+// AdminGetLogLevel отдает текущий уровень логирования.
+func (s *ServerViews) AdminGetLogLevel(res http.ResponseWriter, req *http.Request) {
+	writeJSON(res, logLevel{Level: logger.Level.String()})
+}
+
+// AdminSetLogLevel меняет уровень логирования без перезапуска сервера.
+func (s *ServerViews) AdminSetLogLevel(res http.ResponseWriter, req *http.Request) {
+	var level logLevel
+	if err := decodeJSON(req, &level); err != nil {
+		writeProblem(res, req, err)
+		return
+	}
+	if err := logger.SetLevel(level.Level); err != nil {
+		writeProblem(res, req, service.NewError(service.ErrInvalidArgument, err))
+		return
+	}
+	logger.Log.Info("log level changed", zap.String("level", logger.Level.String()))
+	writeJSON(res, logLevel{Level: logger.Level.String()})
+}
+
+// AdminSave сразу сохраняет метрики в файл.
+func (s *ServerViews) AdminSave(res http.ResponseWriter, req *http.Request) {
+	if err := s.Service.Save(); err != nil {
+		logger.Log.Error("couldn`t save metrics", zap.Error(err))
+		writeProblem(res, req, err)
+		return
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// AdminRestore перечитывает метрики из файла.
+func (s *ServerViews) AdminRestore(res http.ResponseWriter, req *http.Request) {
+	if err := s.Service.Restore(); err != nil {
+		logger.Log.Error("couldn`t restore metrics", zap.Error(err))
+		writeProblem(res, req, err)
+		return
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// AdminResetCounter обнуляет counter метрику и возвращает ее значение до сброса.
+func (s *ServerViews) AdminResetCounter(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancel()
+
+	previous, err := s.Service.ResetCounter(ctx, chi.URLParam(req, "metricName"))
+	if err != nil {
+		logger.Log.Error("couldn`t reset counter metric", zap.Error(err))
+		writeProblem(res, req, err)
+		return
+	}
+	writeJSON(res, resetResult{Previous: previous})
+}
+
+// AdminDeleteMetric удаляет метрику.
+func (s *ServerViews) AdminDeleteMetric(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancel()
+
+	if err := s.Service.DeleteMetric(ctx, chi.URLParam(req, "metricName"), chi.URLParam(req, "metricType")); err != nil {
+		logger.Log.Error("couldn`t delete metric", zap.Error(err))
+		writeProblem(res, req, err)
+		return
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// writeJSON отвечает значением в JSON.
+func writeJSON(res http.ResponseWriter, v any) {
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(v); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+	}
+}
+
+// AdminServer реализует gRPC сервис Admin.
+type AdminServer struct {
+	Service service.AdminService
+	pb.UnimplementedAdminServer
+}
+
+// ResetCounter обнуляет counter метрику.
+func (a *AdminServer) ResetCounter(ctx context.Context, in *pb.ResetCounterRequest) (*pb.ResetCounterResponse, error) {
+	previous, err := a.Service.ResetCounter(ctx, in.Id)
+	if err != nil {
+		logger.Log.Error("couldn`t reset counter metric", zap.Error(err))
+		return nil, grpcError(err)
+	}
+	return &pb.ResetCounterResponse{Previous: previous}, nil
+}
+
+// DeleteMetric удаляет метрику.
+func (a *AdminServer) DeleteMetric(ctx context.Context, in *pb.DeleteMetricRequest) (*emptypb.Empty, error) {
+	if err := a.Service.DeleteMetric(ctx, in.Id, in.Type.String()); err != nil {
+		logger.Log.Error("couldn`t delete metric", zap.Error(err))
+		return nil, grpcError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// Save сразу сохраняет метрики в файл.
+func (a *AdminServer) Save(ctx context.Context, _ *emptypb.Empty) (*emptypb.Empty, error) {
+	if err := a.Service.Save(); err != nil {
+		logger.Log.Error("couldn`t save metrics", zap.Error(err))
+		return nil, grpcError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// Restore перечитывает метрики из файла.
+func (a *AdminServer) Restore(ctx context.Context, _ *emptypb.Empty) (*emptypb.Empty, error) {
+	if err := a.Service.Restore(); err != nil {
+		logger.Log.Error("couldn`t restore metrics", zap.Error(err))
+		return nil, grpcError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// GetLogLevel возвращает текущий уровень логирования.
+func (a *AdminServer) GetLogLevel(ctx context.Context, _ *emptypb.Empty) (*pb.LogLevel, error) {
+	return &pb.LogLevel{Level: logger.Level.String()}, nil
+}
+
+// SetLogLevel меняет уровень логирования.
+func (a *AdminServer) SetLogLevel(ctx context.Context, in *pb.LogLevel) (*pb.LogLevel, error) {
+	if err := logger.SetLevel(in.Level); err != nil {
+		return nil, grpcError(service.NewError(service.ErrInvalidArgument, err))
+	}
+	logger.Log.Info("log level changed", zap.String("level", logger.Level.String()))
+	return &pb.LogLevel{Level: logger.Level.String()}, nil
+}
+
+// GetStats возвращает статистику хранилища.
+func (a *AdminServer) GetStats(ctx context.Context, _ *emptypb.Empty) (*pb.StorageStats, error) {
+	stats, err := a.Service.Stats(ctx)
+	if err != nil {
+		logger.Log.Error("couldn`t get storage stats", zap.Error(err))
+		return nil, grpcError(err)
+	}
+	response := &pb.StorageStats{
+		Backend:  stats.Backend,
+		Series:   int64(stats.Series),
+		Gauges:   int64(stats.Gauges),
+		Counters: int64(stats.Counters),
+	}
+	if stats.LastSnapshot != nil {
+		response.LastSnapshot = timestamppb.New(*stats.LastSnapshot)
+	}
+	return response, nil
+}
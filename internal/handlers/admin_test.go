@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/sebasttiano/Blackbird.git/internal/auth"
+	"github.com/sebasttiano/Blackbird.git/internal/logger"
+	"github.com/sebasttiano/Blackbird.git/internal/models"
+	pb "github.com/sebasttiano/Blackbird.git/internal/proto"
+	"github.com/sebasttiano/Blackbird.git/internal/repository"
+	"github.com/sebasttiano/Blackbird.git/internal/service"
+	mockservice "github.com/sebasttiano/Blackbird.git/internal/service/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestAdminAPI(t *testing.T) {
+	settings := &service.Settings{Retries: 1, BackoffFactor: 1, SaveFilePath: t.TempDir() + "/metrics.json"}
+	views := NewServerViews(service.NewService(settings, repository.NewMemStorage()), Config{AdminToken: "secret"})
+	router := views.InitRouter()
+	require.NoError(t, views.Service.SetValue(context.Background(), "PollCount", "counter", "42"))
+	require.NoError(t, views.Service.SetValue(context.Background(), "Alloc", "gauge", "1.5"))
+	require.NoError(t, logger.SetLevel("info"))
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		token    string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{name: "no token", method: http.MethodGet, target: "/admin/stats", wantCode: http.StatusUnauthorized, wantBody: `"code":"unauthenticated"`},
+		{name: "wrong token", method: http.MethodGet, target: "/admin/stats", token: "Bearer guess", wantCode: http.StatusUnauthorized},
+		{name: "stats", method: http.MethodGet, target: "/admin/stats", token: "Bearer secret", wantCode: http.StatusOK, wantBody: `{"backend":"memory","series":2,"gauges":1,"counters":1}`},
+		{name: "reset counter", method: http.MethodPost, target: "/admin/metrics/counter/PollCount/reset", token: "Bearer secret", wantCode: http.StatusOK, wantBody: `{"previous":42}`},
+		{name: "reset unknown counter", method: http.MethodPost, target: "/admin/metrics/counter/unknown/reset", token: "Bearer secret", wantCode: http.StatusNotFound},
+		{name: "delete metric", method: http.MethodDelete, target: "/admin/metrics/gauge/Alloc", token: "bearer secret", wantCode: http.StatusNoContent},
+		{name: "delete unknown type", method: http.MethodDelete, target: "/admin/metrics/histogram/Alloc", token: "Bearer secret", wantCode: http.StatusBadRequest},
+		{name: "get log level", method: http.MethodGet, target: "/admin/log-level", token: "Bearer secret", wantCode: http.StatusOK, wantBody: `{"level":"info"}`},
+		{name: "set log level", method: http.MethodPut, target: "/admin/log-level", token: "Bearer secret", body: `{"level":"error"}`, wantCode: http.StatusOK, wantBody: `{"level":"error"}`},
+		{name: "set bad log level", method: http.MethodPut, target: "/admin/log-level", token: "Bearer secret", body: `{"level":"loud"}`, wantCode: http.StatusBadRequest},
+		{name: "save", method: http.MethodPost, target: "/admin/save", token: "Bearer secret", wantCode: http.StatusNoContent},
+		{name: "restore", method: http.MethodPost, target: "/admin/restore", token: "Bearer secret", wantCode: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.token != "" {
+				r.Header.Set("Authorization", tt.token)
+			}
+			if tt.body != "" {
+				r.Header.Set("Content-Type", "application/json")
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			require.Equal(t, tt.wantCode, w.Code, w.Body.String())
+			if tt.wantBody != "" {
+				assert.Contains(t, w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+
+	stats, err := views.Service.Stats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Series)
+	assert.NotNil(t, stats.LastSnapshot)
+
+	// без токена admin API выключен
+	views = NewServerViews(service.NewService(&service.Settings{Retries: 1, BackoffFactor: 1}, repository.NewMemStorage()), Config{})
+	r := httptest.NewRequest(http.MethodGet, "/admin/stats", nil)
+	r.Header.Set("Authorization", "Bearer ")
+	w := httptest.NewRecorder()
+	views.InitRouter().ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestAdminServer(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+	mock := mockservice.NewMockAdminService(c)
+
+	lis := bufconn.Listen(bufSize)
+	s := grpc.NewServer(grpc.UnaryInterceptor(AuthInterceptor(auth.NewAuthenticator(nil, "secret"))))
+	pb.RegisterAdminServer(s, &AdminServer{Service: mock})
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough://bufnet", grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := pb.NewAdminClient(conn)
+
+	_, err = client.GetStats(context.Background(), &emptypb.Empty{})
+	assertStatus(t, status.Error(codes.Unauthenticated, auth.ErrMissingToken.Error()), err)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret")
+	snapshot := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	mock.EXPECT().Stats(gomock.Any()).Return(&models.StorageStats{Backend: models.BackendPostgres, Series: 3, Gauges: 2, Counters: 1, LastSnapshot: &snapshot}, nil)
+	stats, err := client.GetStats(ctx, &emptypb.Empty{})
+	require.NoError(t, err)
+	assert.Equal(t, "postgres", stats.Backend)
+	assert.Equal(t, int64(3), stats.Series)
+	assert.Equal(t, snapshot, stats.LastSnapshot.AsTime())
+
+	mock.EXPECT().ResetCounter(gomock.Any(), "PollCount").Return(int64(7), nil)
+	reset, err := client.ResetCounter(ctx, &pb.ResetCounterRequest{Id: "PollCount"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), reset.Previous)
+
+	mock.EXPECT().DeleteMetric(gomock.Any(), "Alloc", "gauge").Return(service.Errorf(service.ErrNotFound, "gauge metric Alloc not found"))
+	_, err = client.DeleteMetric(ctx, &pb.DeleteMetricRequest{Id: "Alloc", Type: pb.MetricType_gauge})
+	assertStatus(t, status.Error(codes.NotFound, "gauge metric Alloc not found"), err)
+
+	mock.EXPECT().Save().Return(service.ErrNotSupported)
+	_, err = client.Save(ctx, &emptypb.Empty{})
+	assertStatus(t, status.Error(codes.FailedPrecondition, service.ErrNotSupported.Error()), err)
+
+	level, err := client.SetLogLevel(ctx, &pb.LogLevel{Level: "warn"})
+	require.NoError(t, err)
+	assert.Equal(t, "warn", level.Level)
+	_, err = client.SetLogLevel(ctx, &pb.LogLevel{Level: "loud"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
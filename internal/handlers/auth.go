@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/sebasttiano/Blackbird.git/internal/audit"
+	"github.com/sebasttiano/Blackbird.git/internal/auth"
+	"github.com/sebasttiano/Blackbird.git/internal/logger"
+	pb "github.com/sebasttiano/Blackbird.git/internal/proto"
+	"github.com/sebasttiano/Blackbird.git/internal/service"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+// methodScopes права, нужные для вызова gRPC методов. Методы не из списка и не из publicMethods отклоняются,
+// поэтому новый метод надо добавить сюда с нужным правом.
+var methodScopes = func() map[string]auth.Scope {
+	scopes := map[string]auth.Scope{
+		"/" + pb.Metrics_ServiceDesc.ServiceName + "/GetMetric":               auth.ScopeRead,
+		"/" + pb.Metrics_ServiceDesc.ServiceName + "/GetMetrics":              auth.ScopeRead,
+		"/" + pb.Metrics_ServiceDesc.ServiceName + "/ListAllMetrics":          auth.ScopeRead,
+		"/" + pb.Metrics_ServiceDesc.ServiceName + "/WatchMetrics":            auth.ScopeRead,
+		"/" + pb.Metrics_ServiceDesc.ServiceName + "/UpdateMetric":            auth.ScopeWrite,
+		"/" + pb.Metrics_ServiceDesc.ServiceName + "/UpdateMetrics":           auth.ScopeWrite,
+		"/" + pb.Metrics_ServiceDesc.ServiceName + "/UpdateMetricsEncrypted":  auth.ScopeWrite,
+		"/" + colmetricspb.MetricsService_ServiceDesc.ServiceName + "/Export": auth.ScopeWrite,
+	}
+	for _, m := range pb.Admin_ServiceDesc.Methods {
+		scopes["/"+pb.Admin_ServiceDesc.ServiceName+"/"+m.MethodName] = auth.ScopeAdmin
+	}
+	return scopes
+}()
+
+// publicMethods методы gRPC, которые вызываются без токена.
+var publicMethods = map[string]bool{
+	"/" + healthpb.Health_ServiceDesc.ServiceName + "/Check": true,
+	"/" + healthpb.Health_ServiceDesc.ServiceName + "/Watch": true,
+}
+
+// methodScope возвращает право, нужное для вызова метода. public означает вызов без токена.
+func methodScope(method string) (scope auth.Scope, public bool, err error) {
+	if publicMethods[method] {
+		return "", true, nil
+	}
+	scope, ok := methodScopes[method]
+	if !ok {
+		logger.Log.Warn("call of method without scope rejected", zap.String("target", method))
+		return "", false, service.Errorf(service.ErrPermissionDenied, "method %s is not allowed", method)
+	}
+	return scope, false, nil
+}
+
+// authError переводит ошибку проверки токена в ошибку сервиса с нужным видом.
+func authError(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrDisabled), errors.Is(err, auth.ErrInsufficientScope):
+		return service.NewError(service.ErrPermissionDenied, err)
+	case errors.Is(err, auth.ErrMissingToken), errors.Is(err, auth.ErrInvalidToken),
+		errors.Is(err, auth.ErrTokenRevoked), errors.Is(err, auth.ErrTokenExpired):
+		return service.NewError(service.ErrUnauthenticated, err)
+	default:
+		return service.NewError(service.ErrUnavailable, err)
+	}
+}
+
+// authorize проверяет токен и записывает его использование в лог. Идентификатор токена
+// добавляется к источнику записи в журнале аудита.
+func authorize(ctx context.Context, a *auth.Authenticator, authorization string, scope auth.Scope, target string) (context.Context, error) {
+	token, err := a.Authorize(ctx, authorization, scope)
+	src := audit.SourceFromContext(ctx)
+	if err != nil {
+		fields := []zap.Field{zap.String("target", target), zap.String("scope", string(scope)), zap.String("remote", src.IP), zap.Error(err)}
+		if token != nil {
+			fields = append(fields, zap.String("token", token.ID))
+		}
+		logger.Log.Warn("request rejected", fields...)
+		return ctx, authError(err)
+	}
+	if token == nil {
+		return ctx, nil
+	}
+	logger.Log.Info("token used", zap.String("token", token.ID), zap.String("name", token.Name),
+		zap.String("target", target), zap.String("scope", string(scope)), zap.String("remote", src.IP))
+	src.TokenID = token.ID
+	return auth.WithToken(audit.WithSource(ctx, src), token), nil
+}
+
+// RequireScope пропускает запросы только с токеном, у которого есть право scope.
+func RequireScope(a *auth.Authenticator, scope auth.Scope) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			ctx, err := authorize(req.Context(), a, req.Header.Get("Authorization"), scope, req.Method+" "+req.URL.Path)
+			if err != nil {
+				writeProblem(res, req, err)
+				return
+			}
+			next.ServeHTTP(res, req.WithContext(ctx))
+		})
+	}
+}
+
+// authorization достает токен из метаданных authorization gRPC вызова.
+func authorization(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// AuthInterceptor пропускает gRPC вызовы только с токеном, у которого есть право на метод.
+// Должен идти после AuditSourceInterceptor.
+func AuthInterceptor(a *auth.Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		scope, public, err := methodScope(info.FullMethod)
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		if public {
+			return handler(ctx, req)
+		}
+		ctx, err = authorize(ctx, a, authorization(ctx), scope, info.FullMethod)
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// authStream подменяет контекст потока на контекст с проверенным токеном.
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context возвращает контекст с проверенным токеном.
+func (s *authStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamAuthInterceptor проверяет токен потоковых gRPC вызовов.
+func StreamAuthInterceptor(a *auth.Authenticator) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		scope, public, err := methodScope(info.FullMethod)
+		if err != nil {
+			return grpcError(err)
+		}
+		if public {
+			return handler(srv, ss)
+		}
+		ctx, err := authorize(ss.Context(), a, authorization(ss.Context()), scope, info.FullMethod)
+		if err != nil {
+			return grpcError(err)
+		}
+		return handler(srv, &authStream{ServerStream: ss, ctx: ctx})
+	}
+}
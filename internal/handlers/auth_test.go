@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sebasttiano/Blackbird.git/internal/audit"
+	"github.com/sebasttiano/Blackbird.git/internal/auth"
+	pb "github.com/sebasttiano/Blackbird.git/internal/proto"
+	"github.com/sebasttiano/Blackbird.git/internal/repository"
+	"github.com/sebasttiano/Blackbird.git/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// newTokens создает файловое хранилище с токенами reader, writer и admin и возвращает их секреты.
+func newTokens(t *testing.T) (auth.Store, map[auth.Scope]string, map[auth.Scope]string) {
+	store, err := auth.NewFileStore(t.TempDir() + "/tokens.json")
+	require.NoError(t, err)
+	secrets := make(map[auth.Scope]string)
+	ids := make(map[auth.Scope]string)
+	for _, scope := range []auth.Scope{auth.ScopeRead, auth.ScopeWrite, auth.ScopeAdmin} {
+		token, secret, err := auth.NewToken(string(scope)+"er", []auth.Scope{scope}, 0)
+		require.NoError(t, err)
+		require.NoError(t, store.Add(context.Background(), token))
+		secrets[scope], ids[scope] = secret, token.ID
+	}
+	return store, secrets, ids
+}
+
+func TestRequireScope(t *testing.T) {
+	store, secrets, ids := newTokens(t)
+	auditor := audit.NewAuditor(audit.NewMemorySink(100))
+	settings := &service.Settings{Retries: 1, BackoffFactor: 1, Auditor: auditor}
+	views := NewServerViews(service.NewService(settings, repository.NewMemStorage()), Config{Tokens: store})
+	router := views.InitRouter()
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		scope    auth.Scope
+		wantCode int
+	}{
+		{name: "ping is open", method: http.MethodGet, target: "/ping", wantCode: http.StatusServiceUnavailable},
+		{name: "healthz is open", method: http.MethodGet, target: "/healthz", wantCode: http.StatusOK},
+		{name: "read without token", method: http.MethodGet, target: "/metrics", wantCode: http.StatusUnauthorized},
+		{name: "read with read token", method: http.MethodGet, target: "/metrics", scope: auth.ScopeRead, wantCode: http.StatusOK},
+		{name: "read with write token", method: http.MethodGet, target: "/metrics", scope: auth.ScopeWrite, wantCode: http.StatusForbidden},
+		{name: "write with read token", method: http.MethodPost, target: "/update/gauge/Alloc/1.5", scope: auth.ScopeRead, wantCode: http.StatusForbidden},
+		{name: "write with write token", method: http.MethodPost, target: "/update/gauge/Alloc/1.5", scope: auth.ScopeWrite, wantCode: http.StatusOK},
+		{name: "admin with write token", method: http.MethodGet, target: "/admin/stats", scope: auth.ScopeWrite, wantCode: http.StatusForbidden},
+		{name: "admin with admin token", method: http.MethodGet, target: "/admin/stats", scope: auth.ScopeAdmin, wantCode: http.StatusOK},
+		{name: "read with admin token", method: http.MethodGet, target: "/value/gauge/Alloc", scope: auth.ScopeAdmin, wantCode: http.StatusOK},
+		{name: "audit requires admin", method: http.MethodGet, target: "/audit", scope: auth.ScopeRead, wantCode: http.StatusForbidden},
+		{name: "debug requires admin", method: http.MethodGet, target: "/debug/", scope: auth.ScopeRead, wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.scope != "" {
+				r.Header.Set("Authorization", "Bearer "+secrets[tt.scope])
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			assert.Equal(t, tt.wantCode, w.Code, w.Body.String())
+		})
+	}
+
+	// запись в журнале аудита помечена токеном, которым она сделана
+	r := httptest.NewRequest(http.MethodGet, "/audit", nil)
+	r.Header.Set("Authorization", "Bearer "+secrets[auth.ScopeAdmin])
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"token":"`+ids[auth.ScopeWrite]+`"`)
+}
+
+func TestAuthInterceptor(t *testing.T) {
+	store, secrets, _ := newTokens(t)
+	views := NewServerViews(service.NewService(&service.Settings{Retries: 1, BackoffFactor: 1}, repository.NewMemStorage()), Config{})
+	a := auth.NewAuthenticator(store, "")
+
+	lis := bufconn.Listen(bufSize)
+	s := grpc.NewServer(grpc.UnaryInterceptor(AuthInterceptor(a)), grpc.StreamInterceptor(StreamAuthInterceptor(a)))
+	pb.RegisterMetricsServer(s, &MetricsServer{Service: views.Service})
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough://bufnet", grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := pb.NewMetricsClient(conn)
+
+	withToken := func(scope auth.Scope) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+secrets[scope])
+	}
+
+	_, err = client.ListAllMetrics(context.Background(), &emptypb.Empty{})
+	assertStatus(t, status.Error(codes.Unauthenticated, auth.ErrMissingToken.Error()), err)
+
+	_, err = client.ListAllMetrics(withToken(auth.ScopeRead), &emptypb.Empty{})
+	require.NoError(t, err)
+
+	_, err = client.UpdateMetric(withToken(auth.ScopeRead), &pb.UpdateMetricRequest{Id: "Alloc", Value: "1", Type: pb.MetricType_gauge})
+	assertStatus(t, status.Error(codes.PermissionDenied, auth.ErrInsufficientScope.Error()), err)
+
+	_, err = client.UpdateMetric(withToken(auth.ScopeWrite), &pb.UpdateMetricRequest{Id: "Alloc", Value: "1", Type: pb.MetricType_gauge})
+	require.NoError(t, err)
+
+	stream, err := client.WatchMetrics(withToken(auth.ScopeWrite), &pb.WatchMetricsRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestMethodScope(t *testing.T) {
+	// у каждого зарегистрированного метода должно быть право, иначе его нельзя вызвать
+	for _, desc := range []grpc.ServiceDesc{pb.Metrics_ServiceDesc, pb.Admin_ServiceDesc, colmetricspb.MetricsService_ServiceDesc, healthpb.Health_ServiceDesc} {
+		var names []string
+		for _, m := range desc.Methods {
+			names = append(names, m.MethodName)
+		}
+		for _, st := range desc.Streams {
+			names = append(names, st.StreamName)
+		}
+		for _, name := range names {
+			_, _, err := methodScope("/" + desc.ServiceName + "/" + name)
+			assert.NoError(t, err, desc.ServiceName+"/"+name)
+		}
+	}
+
+	_, public, err := methodScope("/" + healthpb.Health_ServiceDesc.ServiceName + "/Check")
+	require.NoError(t, err)
+	assert.True(t, public)
+
+	a := auth.NewAuthenticator(nil, "")
+	info := &grpc.UnaryServerInfo{FullMethod: "/blackbird.Unknown/Call"}
+	_, err = AuthInterceptor(a)(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		t.Fatal("unknown method must not be called")
+		return nil, nil
+	})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
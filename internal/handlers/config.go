@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"crypto/tls"
+
+	"github.com/sebasttiano/Blackbird.git/internal/auth"
+	"github.com/sebasttiano/Blackbird.git/internal/ingest/remotewrite"
+	"github.com/sebasttiano/Blackbird.git/internal/ipfilter"
+	"github.com/sebasttiano/Blackbird.git/internal/keyring"
+	"github.com/sebasttiano/Blackbird.git/internal/signing"
+)
+
+// Config настройки REST и gRPC серверов: транспорт, доступ и разбор сторонних протоколов.
+// Нулевое значение дает сервер без TLS, токенов, подписи и фильтра подсетей.
+type Config struct {
+	// TLS настройки TLS для HTTP и gRPC серверов, nil означает соединения без шифрования.
+	TLS *tls.Config
+	// TrustedSubnet пропускает к REST и gRPC только клиентов из доверенных подсетей, nil пропускает всех.
+	TrustedSubnet *ipfilter.Filter
+	// Keys ключи подписи и расшифровки запросов агентов по REST и gRPC, nil отключает проверку подписи и расшифровку.
+	Keys *keyring.Keyring
+	// Signatures проверяет подписи запросов по REST и gRPC с общим кэшем nonce.
+	Signatures *signing.Verifier
+	// Tokens хранилище API токенов, nil отключает проверку токенов на чтение и запись.
+	Tokens auth.Store
+	// AdminToken токен доступа к admin API, пустой отключает admin API.
+	AdminToken string
+	// ValidateRequests включает проверку REST запросов по спецификации OpenAPI.
+	ValidateRequests bool
+	// RemoteWriteRules правила маппинга рядов Prometheus remote_write на метрики.
+	RemoteWriteRules []remotewrite.Rule
+	// InfluxNameTags метки line protocol, значения которых входят в имя метрики.
+	InfluxNameTags []string
+	// OTLPResourceAttributes атрибуты ресурса OTLP, значения которых идут префиксом имени метрики.
+	OTLPResourceAttributes []string
+}
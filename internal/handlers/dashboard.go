@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sebasttiano/Blackbird.git/internal/exposition"
+	"github.com/sebasttiano/Blackbird.git/internal/logger"
+	"github.com/sebasttiano/Blackbird.git/internal/service"
+	"github.com/sebasttiano/Blackbird.git/templates"
+	"go.uber.org/zap"
+)
+
+// DashboardRefresh интервал автообновления дашборда в секундах.
+const DashboardRefresh = 5
+
+// MainHandle отрисовывает дашборд: таблицы gauge и counter метрик с поиском, сортировкой и автообновлением.
+func (s *ServerViews) MainHandle(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancel()
+
+	renderHTML(res, req, s.templates.IndexTemplate, s.dashboard(ctx, "", ""))
+}
+
+// renderHTML отрисовывает шаблон целиком в буфер, чтобы ошибка шаблона не оставила клиенту половину страницы.
+func renderHTML(res http.ResponseWriter, req *http.Request, tmpl *template.Template, data any) {
+	var page bytes.Buffer
+	if err := tmpl.Execute(&page, data); err != nil {
+		logger.Log.Error("couldn`t render the html template", zap.Error(err))
+		writeProblem(res, req, err)
+		return
+	}
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := page.WriteTo(res); err != nil {
+		logger.Log.Error("couldn`t write html page", zap.Error(err))
+	}
+}
+
+// DashboardData отдает строки дашборда в JSON для автообновления.
+// Параметры type и name оставляют одну метрику, их использует страница метрики.
+func (s *ServerViews) DashboardData(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancel()
+
+	query := req.URL.Query()
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(res).Encode(s.dashboard(ctx, query.Get("type"), query.Get("name"))); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+	}
+}
+
+// MetricPage отрисовывает страницу одной метрики.
+func (s *ServerViews) MetricPage(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancel()
+
+	metricType := chi.URLParam(req, "metricType")
+	metricName, err := url.PathUnescape(chi.URLParam(req, "metricName"))
+	if err != nil {
+		writeProblem(res, req, service.Errorf(service.ErrInvalidArgument, "bad metric name: %w", err))
+		return
+	}
+
+	value, err := s.Service.GetValue(ctx, metricName, metricType)
+	if err != nil {
+		logger.Log.Debug("metric for dashboard page not found", zap.String("name", metricName), zap.Error(err))
+		writeProblem(res, req, err)
+		return
+	}
+
+	var row templates.MetricRow
+	switch v := value.(type) {
+	case float64:
+		row = s.gaugeRow(metricName, v)
+	case int64:
+		row = s.counterRow(metricName, v)
+	}
+	page := templates.MetricPage{MetricRow: row, ExpositionName: exposition.SanitizeName(metricName), Refresh: DashboardRefresh}
+
+	renderHTML(res, req, s.templates.MetricTemplate, page)
+}
+
+// dashboard собирает строки таблиц, отсортированные по имени. Пустые metricType и metricName не фильтруют.
+func (s *ServerViews) dashboard(ctx context.Context, metricType, metricName string) templates.Dashboard {
+	sm := s.Service.GetAllValues(ctx)
+	d := templates.Dashboard{
+		Gauges:    make([]templates.MetricRow, 0, len(sm.Gauge)),
+		Counters:  make([]templates.MetricRow, 0, len(sm.Counter)),
+		Refresh:   DashboardRefresh,
+		Generated: time.Now(),
+	}
+	if metricType == "" || metricType == "gauge" {
+		for _, g := range sm.Gauge {
+			if metricName == "" || g.Name == metricName {
+				d.Gauges = append(d.Gauges, s.gaugeRow(g.Name, g.Value))
+			}
+		}
+	}
+	if metricType == "" || metricType == "counter" {
+		for _, c := range sm.Counter {
+			if metricName == "" || c.Name == metricName {
+				d.Counters = append(d.Counters, s.counterRow(c.Name, c.Value))
+			}
+		}
+	}
+	sort.Slice(d.Gauges, func(i, j int) bool { return d.Gauges[i].Name < d.Gauges[j].Name })
+	sort.Slice(d.Counters, func(i, j int) bool { return d.Counters[i].Name < d.Counters[j].Name })
+	return d
+}
+
+func (s *ServerViews) gaugeRow(name string, value float64) templates.MetricRow {
+	return templates.MetricRow{
+		Name:    name,
+		Type:    "gauge",
+		Value:   templates.FormatGauge(value),
+		Raw:     strconv.FormatFloat(value, 'f', -1, 64),
+		Number:  value,
+		Updated: s.updatedAt("gauge", name),
+	}
+}
+
+func (s *ServerViews) counterRow(name string, value int64) templates.MetricRow {
+	return templates.MetricRow{
+		Name:    name,
+		Type:    "counter",
+		Value:   templates.FormatCounter(value),
+		Raw:     strconv.FormatInt(value, 10),
+		Number:  float64(value),
+		Updated: s.updatedAt("counter", name),
+	}
+}
+
+func (s *ServerViews) updatedAt(metricType, name string) *time.Time {
+	if t, ok := s.Service.UpdatedAt(metricType, name); ok {
+		return &t
+	}
+	return nil
+}
+
+// dashboardAssets раздает встроенные стили и скрипты дашборда.
+func dashboardAssets() http.Handler {
+	files := http.FileServer(http.FS(templates.Assets()))
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Cache-Control", "public, max-age=300")
+		files.ServeHTTP(res, req)
+	})
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sebasttiano/Blackbird.git/internal/logger"
+	"github.com/sebasttiano/Blackbird.git/internal/service"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// ProblemContentType тип содержимого ответа об ошибке по RFC 7807.
+const ProblemContentType = "application/problem+json"
+
+// ErrorDomain домен ошибок в google.rpc.ErrorInfo.
+const ErrorDomain = "blackbird"
+
+// retryAfter через сколько клиенту стоит повторить запрос при временной недоступности.
+const retryAfter = time.Second
+
+// errorKind отображение вида ошибки сервиса на транспорты.
+type errorKind struct {
+	code   string
+	status int
+	grpc   codes.Code
+}
+
+// errorKinds единая таблица отображения видов ошибок на коды HTTP и gRPC.
+var errorKinds = map[error]errorKind{
+	service.ErrNotFound:           {code: "not_found", status: http.StatusNotFound, grpc: codes.NotFound},
+	service.ErrInvalidArgument:    {code: "invalid_argument", status: http.StatusBadRequest, grpc: codes.InvalidArgument},
+	service.ErrUnavailable:        {code: "unavailable", status: http.StatusServiceUnavailable, grpc: codes.Unavailable},
+	service.ErrConflict:           {code: "conflict", status: http.StatusConflict, grpc: codes.AlreadyExists},
+	service.ErrPermissionDenied:   {code: "permission_denied", status: http.StatusForbidden, grpc: codes.PermissionDenied},
+	service.ErrUnauthenticated:    {code: "unauthenticated", status: http.StatusUnauthorized, grpc: codes.Unauthenticated},
+	service.ErrFailedPrecondition: {code: "failed_precondition", status: http.StatusConflict, grpc: codes.FailedPrecondition},
+}
+
+// internalKind вид ошибок, не классифицированных сервисом. Их текст клиенту не отдается.
+var internalKind = errorKind{code: "internal", status: http.StatusInternalServerError, grpc: codes.Internal}
+
+// kindOf возвращает отображение для ошибки.
+func kindOf(err error) errorKind {
+	if kind, ok := errorKinds[service.KindOf(err)]; ok {
+		return kind
+	}
+	return internalKind
+}
+
+// Problem ответ об ошибке в формате RFC 7807.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Code вид ошибки, совпадает с причиной в google.rpc.ErrorInfo gRPC ответов.
+	Code string `json:"code"`
+}
+
+// newProblem собирает Problem для ошибки запроса.
+func newProblem(req *http.Request, err error) Problem {
+	kind := kindOf(err)
+	detail := err.Error()
+	if kind == internalKind {
+		detail = "internal server error"
+	}
+	return Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(kind.status),
+		Status:   kind.status,
+		Detail:   detail,
+		Instance: req.URL.Path,
+		Code:     kind.code,
+	}
+}
+
+// writeProblem отвечает на запрос ошибкой в формате RFC 7807. После нее обработчик должен завершиться.
+func writeProblem(res http.ResponseWriter, req *http.Request, err error) {
+	p := newProblem(req, err)
+	writeProblemBody(res, p.Status, p)
+}
+
+// writeProblemBody пишет документ ошибки, body может дополнять Problem своими полями.
+func writeProblemBody(res http.ResponseWriter, code int, body any) {
+	switch code {
+	case http.StatusServiceUnavailable:
+		res.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	case http.StatusUnauthorized:
+		res.Header().Set("WWW-Authenticate", `Bearer realm="blackbird"`)
+	}
+	res.Header().Del("Content-Length")
+	res.Header().Set("Content-Type", ProblemContentType)
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.WriteHeader(code)
+	if err := json.NewEncoder(res).Encode(body); err != nil {
+		logger.Log.Error("error encoding problem response", zap.Error(err))
+	}
+}
+
+// grpcError переводит ошибку сервиса в статус gRPC с google.rpc.ErrorInfo,
+// временная недоступность дополняется google.rpc.RetryInfo.
+func grpcError(err error) error {
+	kind := kindOf(err)
+	message := err.Error()
+	if kind == internalKind {
+		message = "internal server error"
+	}
+	st, errDetails := status.New(kind.grpc, message).WithDetails(&errdetails.ErrorInfo{Reason: kind.code, Domain: ErrorDomain})
+	if errDetails != nil {
+		logger.Log.Error("couldn`t attach error details", zap.Error(errDetails))
+		return status.Error(kind.grpc, message)
+	}
+	if kind.grpc == codes.Unavailable {
+		if retry, errRetry := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)}); errRetry == nil {
+			st = retry
+		}
+	}
+	return st.Err()
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/sebasttiano/Blackbird.git/internal/audit"
+	"github.com/sebasttiano/Blackbird.git/internal/export"
+	"github.com/sebasttiano/Blackbird.git/internal/logger"
+	"github.com/sebasttiano/Blackbird.git/internal/models"
+	"github.com/sebasttiano/Blackbird.git/internal/service"
+	"go.uber.org/zap"
+)
+
+// exportFlushRows через сколько строк выгрузки досылать данные клиенту.
+const exportFlushRows = 500
+
+// Export выгружает текущие метрики в CSV или NDJSON, параметр ?history=true добавляет историю значений
+// из журнала аудита. Строки отдаются потоком и досылаются клиенту частями.
+func (s *ServerViews) Export(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	format, err := export.ParseFormat(query.Get("format"))
+	if err != nil {
+		writeProblem(res, req, service.NewError(service.ErrInvalidArgument, err))
+		return
+	}
+	filter := export.Filter{Type: query.Get("type"), Match: query.Get("match")}
+	if err := filter.Validate(); err != nil {
+		writeProblem(res, req, service.NewError(service.ErrInvalidArgument, err))
+		return
+	}
+	history := false
+	if v := query.Get("history"); v != "" {
+		if history, err = strconv.ParseBool(v); err != nil {
+			writeProblem(res, req, service.Errorf(service.ErrInvalidArgument, "check your history param"))
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), time.Minute)
+	defer cancel()
+
+	// доступность истории проверяем до начала ответа, чтобы ошибку можно было отдать документом ошибки
+	auditor := s.Service.Settings.Auditor
+	if history && auditor == nil {
+		writeProblem(res, req, service.Errorf(service.ErrFailedPrecondition, "metric history is not available: audit is disabled"))
+		return
+	}
+
+	res.Header().Set("Content-Type", format.ContentType())
+	res.Header().Set("Content-Disposition", `attachment; filename="metrics.`+string(format)+`"`)
+	flusher, _ := res.(http.Flusher)
+	w := export.NewWriter(res, format)
+	rows := 0
+	write := func(row *export.Row) error {
+		if err := w.Write(row); err != nil {
+			return err
+		}
+		if rows++; rows%exportFlushRows == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return nil
+	}
+
+	if err := s.exportCurrent(ctx, filter, write); err != nil {
+		logger.Log.Error("couldn`t export metrics", zap.Error(err))
+		return
+	}
+	if history {
+		if err := exportHistory(ctx, auditor, filter, write); err != nil {
+			logger.Log.Error("couldn`t export metric history", zap.Error(err))
+			return
+		}
+	}
+	if err := w.Flush(); err != nil {
+		logger.Log.Error("couldn`t export metrics", zap.Error(err))
+	}
+}
+
+// exportCurrent выгружает текущие значения метрик, отсортированные по типу и имени.
+func (s *ServerViews) exportCurrent(ctx context.Context, filter export.Filter, write func(row *export.Row) error) error {
+	sm := s.Service.GetAllValues(ctx)
+	sort.Slice(sm.Gauge, func(i, j int) bool { return sm.Gauge[i].Name < sm.Gauge[j].Name })
+	sort.Slice(sm.Counter, func(i, j int) bool { return sm.Counter[i].Name < sm.Counter[j].Name })
+
+	for _, c := range sm.Counter {
+		if !filter.Matches("counter", c.Name) {
+			continue
+		}
+		value := c.Value
+		row := export.Row{Kind: export.KindCurrent, Metrics: models.Metrics{ID: c.Name, MType: "counter", Delta: &value}}
+		if t, ok := s.Service.UpdatedAt("counter", c.Name); ok {
+			row.Time = &t
+		}
+		if err := write(&row); err != nil {
+			return err
+		}
+	}
+	for _, g := range sm.Gauge {
+		if !filter.Matches("gauge", g.Name) {
+			continue
+		}
+		value := g.Value
+		row := export.Row{Kind: export.KindCurrent, Metrics: models.Metrics{ID: g.Name, MType: "gauge", Value: &value}}
+		if t, ok := s.Service.UpdatedAt("gauge", g.Name); ok {
+			row.Time = &t
+		}
+		if err := write(&row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportHistory выгружает историю значений метрик из журнала аудита, записи читаются по одной.
+func exportHistory(ctx context.Context, auditor *audit.Auditor, filter export.Filter, write func(row *export.Row) error) error {
+	return auditor.Scan(ctx, audit.Filter{Metric: filter.Match}, func(e *audit.Entry) error {
+		for _, m := range e.Metrics {
+			if !filter.Matches(m.MType, m.ID) {
+				continue
+			}
+			row := export.Row{Kind: export.KindHistory, Time: &e.Time, Metrics: m, AgentID: e.Source.AgentID}
+			if err := write(&row); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
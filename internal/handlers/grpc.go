@@ -4,15 +4,20 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+
+	"github.com/sebasttiano/Blackbird.git/internal/common"
+	"github.com/sebasttiano/Blackbird.git/internal/keyring"
 	"github.com/sebasttiano/Blackbird.git/internal/logger"
 	"github.com/sebasttiano/Blackbird.git/internal/models"
 	pb "github.com/sebasttiano/Blackbird.git/internal/proto"
 	"github.com/sebasttiano/Blackbird.git/internal/service"
 	"go.uber.org/zap"
-	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 var ErrInternalGrpc = errors.New("internal grpc server error")
@@ -21,6 +26,8 @@ var ErrBadRequestGrpc = errors.New("bad request")
 type MetricsServer struct {
 	//Service *service.Service
 	Service service.MetricService
+	// Keys ключи RSA для UpdateMetricsEncrypted, без них зашифрованные запросы отклоняются.
+	Keys *keyring.Keyring
 	pb.UnimplementedMetricsServer
 }
 
@@ -51,11 +58,7 @@ func (m *MetricsServer) GetMetric(ctx context.Context, in *pb.GetMetricRequest)
 	value, err := m.Service.GetValue(ctx, in.Metric.Id, in.Metric.Type.String())
 	if err != nil {
 		logger.Log.Error("couldn`t find requested metric. ", zap.Error(err))
-		if errors.Is(err, service.ErrUnknownMetricType) {
-			return nil, status.Errorf(codes.InvalidArgument, `invalid argument: %s - %s`, in.Metric.Id, in.Metric.Type)
-		} else {
-			return nil, status.Errorf(codes.NotFound, "couldn`t find requested metric. %s", in.Metric.Id)
-		}
+		return nil, grpcError(err)
 	}
 
 	response.Metric = in.Metric
@@ -73,42 +76,165 @@ func (m *MetricsServer) GetMetric(ctx context.Context, in *pb.GetMetricRequest)
 
 }
 
+// GetMetrics возвращает несколько метрик одним запросом, ненайденные метрики отмечаются статусом missing
+func (m *MetricsServer) GetMetrics(ctx context.Context, in *pb.GetMetricsRequest) (*pb.GetMetricsResponse, error) {
+	metrics := make([]*models.Metrics, 0, len(in.Metrics))
+	for _, metric := range in.Metrics {
+		metrics = append(metrics, &models.Metrics{ID: metric.Id, MType: metric.Type.String()})
+	}
+
+	values, err := m.Service.GetModelValues(ctx, metrics)
+	if err != nil {
+		logger.Log.Error("couldn`t get metrics. ", zap.Error(err))
+		return nil, grpcError(err)
+	}
+
+	response := &pb.GetMetricsResponse{Metrics: make([]*pb.MetricValue, 0, len(values))}
+	for i, value := range values {
+		metric := &pb.Metric{Id: value.ID, Type: in.Metrics[i].Type}
+		if value.Value != nil {
+			metric.Value = *value.Value
+		}
+		if value.Delta != nil {
+			metric.Delta = *value.Delta
+		}
+		response.Metrics = append(response.Metrics, &pb.MetricValue{Metric: metric, Status: value.Status, Reason: value.Reason})
+	}
+	return response, nil
+}
+
 // UpdateMetric обновляет одну метрику
 func (m *MetricsServer) UpdateMetric(ctx context.Context, in *pb.UpdateMetricRequest) (*pb.UpdateMetricResponse, error) {
 	var response pb.UpdateMetricResponse
 
 	if err := m.Service.SetValue(ctx, in.Id, in.Type.String(), in.Value); err != nil {
 		logger.Log.Error("couldn`t save metric. error: ", zap.Error(err))
-		if errors.Is(err, service.ErrUnknownMetricType) {
-			return nil, status.Errorf(codes.InvalidArgument, `invalid argument: %s - %s`, in.Id, in.Type)
-		}
-		return nil, status.Errorf(codes.Unknown, "failed to save metric: %s", in.Id)
+		return nil, grpcError(err)
 	}
 	return &response, nil
 }
 
-// UpdateMetrics обновляет сет метрик
-func (m *MetricsServer) UpdateMetrics(ctx context.Context, in *pb.UpdateMetricsRequest) (*pb.UpdateMetricResponse, error) {
+// UpdateMetrics обновляет сет метрик и возвращает результат обработки по каждой метрике
+func (m *MetricsServer) UpdateMetrics(ctx context.Context, in *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
 	var metricSet models.MetricSet
 
 	marshaller := protojson.MarshalOptions{EmitDefaultValues: true}
 	jsonMetrics, err := marshaller.Marshal(in)
 	if err != nil {
 		logger.Log.Error("failed to marshal metrics to json", zap.Error(err))
-		return nil, status.Errorf(codes.Unknown, "%s", ErrInternalGrpc.Error())
+		return nil, grpcError(ErrInternalGrpc)
 	}
 
 	if err := json.Unmarshal(jsonMetrics, &metricSet); err != nil {
 		logger.Log.Error("couldn`t unmarshal json metrics", zap.Error(err))
-		return nil, status.Errorf(codes.Unknown, "%s", ErrInternalGrpc.Error())
+		return nil, grpcError(service.NewError(service.ErrInvalidArgument, err))
 	}
 
-	if err := m.Service.SetModelValue(ctx, metricSet.CastToMetrics()); err != nil {
-		logger.Log.Error("couldn`t save metric. error: ", zap.Error(err))
-		if errors.Is(err, service.ErrUnknownMetricType) {
-			return nil, status.Errorf(codes.InvalidArgument, "invalid argument")
+	metrics := metricSet.CastToMetrics()
+	result, err := m.Service.SetModelValueBatch(ctx, in.BatchId, metrics, in.Atomic)
+	err = batchRejected(result, err, len(metrics))
+	if err != nil {
+		logger.Log.Error("couldn`t save metrics. error: ", zap.Error(err))
+		if result == nil {
+			return nil, grpcError(err)
+		}
+		return nil, batchError(err, result)
+	}
+	return batchResultToProto(result), nil
+}
+
+// batchError ошибка пакетного обновления, результат по каждой метрике идет деталью статуса
+// UpdateMetricsResponse, как расширение документа ошибки в REST.
+func batchError(err error, result *models.BatchResult) error {
+	st := status.Convert(grpcError(err))
+	withResult, errDetails := st.WithDetails(batchResultToProto(result))
+	if errDetails != nil {
+		logger.Log.Error("couldn`t attach batch result", zap.Error(errDetails))
+		return st.Err()
+	}
+	return withResult.Err()
+}
+
+// UpdateMetricsEncrypted расшифровывает конверт с UpdateMetricsRequest и обновляет метрики как UpdateMetrics.
+func (m *MetricsServer) UpdateMetricsEncrypted(ctx context.Context, in *pb.EncryptedMessage) (*pb.UpdateMetricsResponse, error) {
+	if !m.Keys.Has(keyring.TypeRSA) {
+		return nil, grpcError(service.Errorf(service.ErrFailedPrecondition, "request is encrypted, but server has no private key"))
+	}
+	var keyID string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(common.EncryptionKeyIDHeader); len(values) > 0 {
+			keyID = values[0]
+		}
+	}
+	key, err := m.Keys.Get(keyring.TypeRSA, keyID)
+	if err != nil {
+		return nil, grpcError(service.NewError(service.ErrInvalidArgument, err))
+	}
+	plain, err := common.DecryptEnvelope(in.Envelope, key.PrivateKey())
+	if err != nil {
+		logger.Log.Error("failed to decrypt request", zap.String("key_id", key.ID), zap.Error(err))
+		return nil, grpcError(service.Errorf(service.ErrInvalidArgument, "failed to decrypt request, check your request"))
+	}
+	var req pb.UpdateMetricsRequest
+	if err := proto.Unmarshal(plain, &req); err != nil {
+		return nil, grpcError(service.Errorf(service.ErrInvalidArgument, "encrypted message is not UpdateMetricsRequest: %v", err))
+	}
+	return m.UpdateMetrics(ctx, &req)
+}
+
+// batchResultToProto конвертирует результат пакетного обновления в protobuf сообщение
+func batchResultToProto(result *models.BatchResult) *pb.UpdateMetricsResponse {
+	response := &pb.UpdateMetricsResponse{
+		Accepted:  int32(result.Accepted),
+		Rejected:  int32(result.Rejected),
+		Results:   make([]*pb.MetricResult, 0, len(result.Results)),
+		Duplicate: result.Duplicate,
+	}
+	for _, r := range result.Results {
+		mType := pb.MetricType_counter
+		if r.MType == "gauge" {
+			mType = pb.MetricType_gauge
+		}
+		response.Results = append(response.Results, &pb.MetricResult{
+			Id:       r.ID,
+			Type:     mType,
+			Accepted: r.Status == models.StatusAccepted,
+			Reason:   r.Reason,
+			Message:  r.Message,
+		})
+	}
+	return response
+}
+
+// WatchMetrics стримит клиенту принятые обновления метрик, имена которых подходят под шаблон match
+func (m *MetricsServer) WatchMetrics(in *pb.WatchMetricsRequest, stream pb.Metrics_WatchMetricsServer) error {
+	sub, err := m.Service.Subscribe(in.Match)
+	if err != nil {
+		logger.Log.Error("couldn`t subscribe to metric updates", zap.Error(err))
+		return grpcError(err)
+	}
+	defer sub.Close()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case update, ok := <-sub.Updates():
+			if !ok {
+				return grpcError(service.Errorf(service.ErrUnavailable, "server is shutting down"))
+			}
+			metric := &pb.Metric{Id: update.ID, Type: pb.MetricType_counter}
+			if update.Value != nil {
+				metric.Value = *update.Value
+				metric.Type = pb.MetricType_gauge
+			}
+			if update.Delta != nil {
+				metric.Delta = *update.Delta
+			}
+			if err := stream.Send(&pb.MetricUpdate{Metric: metric, Time: timestamppb.New(update.Time)}); err != nil {
+				logger.Log.Debug("failed to send metric update", zap.Error(err))
+				return err
+			}
 		}
-		return nil, status.Errorf(codes.Unknown, "failed to save metrics")
 	}
-	return &pb.UpdateMetricResponse{}, nil
 }
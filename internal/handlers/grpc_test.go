@@ -2,25 +2,59 @@ package handlers
 
 import (
 	"context"
+	"crypto/rsa"
 	"errors"
 	"github.com/golang/mock/gomock"
+	"github.com/sebasttiano/Blackbird.git/internal/audit"
+	"github.com/sebasttiano/Blackbird.git/internal/common"
+	"github.com/sebasttiano/Blackbird.git/internal/ingest/otlp"
+	"github.com/sebasttiano/Blackbird.git/internal/ipfilter"
+	"github.com/sebasttiano/Blackbird.git/internal/keyring"
+	"github.com/sebasttiano/Blackbird.git/internal/models"
 	pb "github.com/sebasttiano/Blackbird.git/internal/proto"
 	"github.com/sebasttiano/Blackbird.git/internal/repository"
 	"github.com/sebasttiano/Blackbird.git/internal/service"
 	mockservice "github.com/sebasttiano/Blackbird.git/internal/service/mocks"
+	"github.com/sebasttiano/Blackbird.git/internal/signing"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/emptypb"
 	"net"
 	"testing"
+	"time"
 )
 
 const bufSize = 1024 * 1024
 
+// assertStatus сравнивает код и сообщение статуса gRPC и проверяет, что в деталях есть google.rpc.ErrorInfo.
+func assertStatus(t *testing.T, want error, got error) {
+	t.Helper()
+	wantStatus, gotStatus := status.Convert(want), status.Convert(got)
+	assert.Equal(t, wantStatus.Code(), gotStatus.Code())
+	assert.Equal(t, wantStatus.Message(), gotStatus.Message())
+
+	var info *errdetails.ErrorInfo
+	for _, detail := range gotStatus.Details() {
+		if d, ok := detail.(*errdetails.ErrorInfo); ok {
+			info = d
+		}
+	}
+	if assert.NotNil(t, info) {
+		assert.Equal(t, ErrorDomain, info.Domain)
+	}
+}
+
 var lis *bufconn.Listener
 
 func TestMetricsServer_GetMetric(t *testing.T) {
@@ -57,7 +91,7 @@ func TestMetricsServer_GetMetric(t *testing.T) {
 				s.EXPECT().GetValue(gomock.Any(), in.Metric.Id, in.Metric.Type.String()).Return(nil, service.ErrUnknownMetricType)
 			},
 			expected: nil,
-			err:      status.Errorf(codes.InvalidArgument, "invalid argument: test_counter - counter"),
+			err:      status.Error(codes.InvalidArgument, service.ErrUnknownMetricType.Error()),
 		},
 		{
 			name: "NOT OK, metric not found",
@@ -65,10 +99,10 @@ func TestMetricsServer_GetMetric(t *testing.T) {
 				Metric: &pb.Metric{Id: "alloc", Delta: 0, Value: 0, Type: pb.MetricType_counter},
 			},
 			mockBehaviour: func(s *mockservice.MockMetricService, in *pb.GetMetricRequest) {
-				s.EXPECT().GetValue(gomock.Any(), in.Metric.Id, in.Metric.Type.String()).Return(nil, errors.New("metric not found"))
+				s.EXPECT().GetValue(gomock.Any(), in.Metric.Id, in.Metric.Type.String()).Return(nil, service.Errorf(service.ErrNotFound, "counter metric alloc not found"))
 			},
 			expected: nil,
-			err:      status.Errorf(codes.NotFound, "couldn`t find requested metric. alloc"),
+			err:      status.Error(codes.NotFound, "counter metric alloc not found"),
 		},
 		{
 			name: "OK gauge metric",
@@ -118,8 +152,7 @@ func TestMetricsServer_GetMetric(t *testing.T) {
 
 			resp, err := client.GetMetric(ctx, tt.in)
 			if tt.err != nil {
-				assert.Errorf(t, err, tt.err.Error())
-				assert.Equal(t, tt.err, err)
+				assertStatus(t, tt.err, err)
 			} else {
 				assert.Equal(t, resp.Metric.Id, tt.expected.Metric.Id)
 				assert.Equal(t, resp.Metric.Delta, tt.expected.Metric.Delta)
@@ -130,6 +163,46 @@ func TestMetricsServer_GetMetric(t *testing.T) {
 	}
 }
 
+func TestMetricsServer_GetMetrics(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+	mock := mockservice.NewMockMetricService(c)
+
+	lis := bufconn.Listen(bufSize)
+	s := grpc.NewServer()
+	pb.RegisterMetricsServer(s, &MetricsServer{Service: mock})
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough://bufnet", grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := pb.NewMetricsClient(conn)
+
+	alloc := 4.5
+	mock.EXPECT().GetModelValues(gomock.Any(), []*models.Metrics{{ID: "Alloc", MType: "gauge"}, {ID: "PollCount", MType: "counter"}}).Return([]models.MetricValue{
+		{Metrics: models.Metrics{ID: "Alloc", MType: "gauge", Value: &alloc}, Status: models.StatusFound},
+		{Metrics: models.Metrics{ID: "PollCount", MType: "counter"}, Status: models.StatusMissing},
+	}, nil)
+	resp, err := client.GetMetrics(context.Background(), &pb.GetMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "Alloc", Type: pb.MetricType_gauge},
+		{Id: "PollCount", Type: pb.MetricType_counter},
+	}})
+	require.NoError(t, err)
+	require.Len(t, resp.Metrics, 2)
+	assert.Equal(t, "found", resp.Metrics[0].Status)
+	assert.Equal(t, alloc, resp.Metrics[0].Metric.Value)
+	assert.Equal(t, pb.MetricType_gauge, resp.Metrics[0].Metric.Type)
+	assert.Equal(t, "missing", resp.Metrics[1].Status)
+	assert.Equal(t, pb.MetricType_counter, resp.Metrics[1].Metric.Type)
+
+	mock.EXPECT().GetModelValues(gomock.Any(), gomock.Any()).Return(nil, service.Errorf(service.ErrInvalidArgument, "too many metrics requested"))
+	_, err = client.GetMetrics(context.Background(), &pb.GetMetricsRequest{})
+	assertStatus(t, status.Error(codes.InvalidArgument, "too many metrics requested"), err)
+}
+
 func TestMetricsServer_UpdateMetric(t *testing.T) {
 	type mockBehaviour func(s *mockservice.MockMetricService, in *pb.UpdateMetricRequest)
 
@@ -161,7 +234,7 @@ func TestMetricsServer_UpdateMetric(t *testing.T) {
 			mockBehaviour: func(s *mockservice.MockMetricService, in *pb.UpdateMetricRequest) {
 				s.EXPECT().SetValue(gomock.Any(), in.Id, in.Type.String(), in.Value).Return(service.ErrUnknownMetricType)
 			},
-			err: status.Errorf(codes.InvalidArgument, "invalid argument: test_gauge - gauge"),
+			err: status.Error(codes.InvalidArgument, service.ErrUnknownMetricType.Error()),
 		},
 		{
 			name: "NOT OK, failed to save metric",
@@ -169,7 +242,7 @@ func TestMetricsServer_UpdateMetric(t *testing.T) {
 			mockBehaviour: func(s *mockservice.MockMetricService, in *pb.UpdateMetricRequest) {
 				s.EXPECT().SetValue(gomock.Any(), in.Id, in.Type.String(), in.Value).Return(errors.New("failed to save metric"))
 			},
-			err: status.Errorf(codes.Unknown, "failed to save metric: test_gauge"),
+			err: status.Error(codes.Internal, "internal server error"),
 		},
 	}
 
@@ -205,8 +278,7 @@ func TestMetricsServer_UpdateMetric(t *testing.T) {
 
 			_, err = client.UpdateMetric(ctx, tt.in)
 			if tt.err != nil {
-				assert.Errorf(t, err, tt.err.Error())
-				assert.Equal(t, tt.err, err)
+				assertStatus(t, tt.err, err)
 			} else {
 				assert.NoError(t, err)
 			}
@@ -221,6 +293,7 @@ func TestMetricsServer_UpdateMetrics(t *testing.T) {
 		name          string
 		in            *pb.UpdateMetricsRequest
 		mockBehaviour mockBehaviour
+		expected      *pb.UpdateMetricsResponse
 		err           error
 	}{
 		{
@@ -229,9 +302,64 @@ func TestMetricsServer_UpdateMetrics(t *testing.T) {
 				{Id: "test_gauge", Delta: 0, Value: -100.33, Type: pb.MetricType_gauge},
 				{Id: "test_counter", Delta: 30, Value: 0, Type: pb.MetricType_counter}}},
 			mockBehaviour: func(s *mockservice.MockMetricService, in *pb.UpdateMetricsRequest) {
-				s.EXPECT().SetModelValue(gomock.Any(), gomock.Any()).Return(nil)
+				s.EXPECT().SetModelValueBatch(gomock.Any(), gomock.Any(), gomock.Any(), false).Return(&models.BatchResult{
+					Accepted: 2,
+					Results: []models.MetricResult{
+						{ID: "test_gauge", MType: "gauge", Status: models.StatusAccepted},
+						{ID: "test_counter", MType: "counter", Status: models.StatusAccepted},
+					},
+				}, nil)
 			},
-			err: nil,
+			expected: &pb.UpdateMetricsResponse{Accepted: 2},
+			err:      nil,
+		},
+		{
+			name: "Ok partial success",
+			in: &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+				{Id: "test_gauge", Delta: 0, Value: -100.33, Type: pb.MetricType_gauge},
+				{Id: "", Delta: 30, Value: 0, Type: pb.MetricType_counter}}},
+			mockBehaviour: func(s *mockservice.MockMetricService, in *pb.UpdateMetricsRequest) {
+				s.EXPECT().SetModelValueBatch(gomock.Any(), gomock.Any(), gomock.Any(), false).Return(&models.BatchResult{
+					Accepted: 1,
+					Rejected: 1,
+					Results: []models.MetricResult{
+						{ID: "test_gauge", MType: "gauge", Status: models.StatusAccepted},
+						{ID: "", MType: "counter", Status: models.StatusRejected, Reason: models.ReasonEmptyID},
+					},
+				}, nil)
+			},
+			expected: &pb.UpdateMetricsResponse{Accepted: 1, Rejected: 1},
+			err:      nil,
+		},
+		{
+			name: "NOT OK, atomic batch rejected",
+			in: &pb.UpdateMetricsRequest{Atomic: true, Metrics: []*pb.Metric{
+				{Id: "test_gauge", Delta: 0, Value: -100.33, Type: pb.MetricType_gauge},
+				{Id: "", Delta: 30, Value: 0, Type: pb.MetricType_counter}}},
+			mockBehaviour: func(s *mockservice.MockMetricService, in *pb.UpdateMetricsRequest) {
+				s.EXPECT().SetModelValueBatch(gomock.Any(), gomock.Any(), gomock.Any(), true).Return(&models.BatchResult{
+					Rejected: 2,
+					Results: []models.MetricResult{
+						{ID: "test_gauge", MType: "gauge", Status: models.StatusRejected, Reason: models.ReasonBatchAborted},
+						{ID: "", MType: "counter", Status: models.StatusRejected, Reason: models.ReasonEmptyID},
+					},
+				}, service.ErrBatchRejected)
+			},
+			expected: &pb.UpdateMetricsResponse{Rejected: 2},
+			err:      status.Error(codes.InvalidArgument, service.ErrBatchRejected.Error()),
+		},
+		{
+			name: "NOT OK, all metrics rejected",
+			in: &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+				{Id: "", Delta: 30, Value: 0, Type: pb.MetricType_counter}}},
+			mockBehaviour: func(s *mockservice.MockMetricService, in *pb.UpdateMetricsRequest) {
+				s.EXPECT().SetModelValueBatch(gomock.Any(), gomock.Any(), gomock.Any(), false).Return(&models.BatchResult{
+					Rejected: 1,
+					Results:  []models.MetricResult{{ID: "", MType: "counter", Status: models.StatusRejected, Reason: models.ReasonEmptyID}},
+				}, nil)
+			},
+			expected: &pb.UpdateMetricsResponse{Rejected: 1},
+			err:      status.Error(codes.InvalidArgument, "all metrics of the batch were rejected"),
 		},
 		{
 			name: "NOT OK, unknown metric type",
@@ -239,19 +367,19 @@ func TestMetricsServer_UpdateMetrics(t *testing.T) {
 				{Id: "test_gauge", Delta: 0, Value: 0, Type: pb.MetricType_gauge},
 				{Id: "test_counter", Delta: 30, Value: 0, Type: pb.MetricType_counter}}},
 			mockBehaviour: func(s *mockservice.MockMetricService, in *pb.UpdateMetricsRequest) {
-				s.EXPECT().SetModelValue(gomock.Any(), gomock.Any()).Return(service.ErrUnknownMetricType)
+				s.EXPECT().SetModelValueBatch(gomock.Any(), gomock.Any(), gomock.Any(), false).Return(nil, service.ErrUnknownMetricType)
 			},
-			err: status.Errorf(codes.InvalidArgument, "invalid argument"),
+			err: status.Error(codes.InvalidArgument, service.ErrUnknownMetricType.Error()),
 		},
 		{
-			name: "NOT OK, unknown metric type",
+			name: "NOT OK, storage error",
 			in: &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
 				{Id: "test_gauge", Delta: 0, Value: 0, Type: pb.MetricType_gauge},
 				{Id: "test_counter", Delta: 30, Value: 0, Type: pb.MetricType_counter}}},
 			mockBehaviour: func(s *mockservice.MockMetricService, in *pb.UpdateMetricsRequest) {
-				s.EXPECT().SetModelValue(gomock.Any(), gomock.Any()).Return(errors.New("failed to save metric"))
+				s.EXPECT().SetModelValueBatch(gomock.Any(), gomock.Any(), gomock.Any(), false).Return(nil, service.NewRetryDBError(0, errors.New("connection refused")))
 			},
-			err: status.Errorf(codes.Unknown, "failed to save metrics"),
+			err: status.Error(codes.Unavailable, "function failed after 0 retries. last error was connection refused"),
 		},
 	}
 
@@ -285,12 +413,27 @@ func TestMetricsServer_UpdateMetrics(t *testing.T) {
 			defer conn.Close()
 			client := pb.NewMetricsClient(conn)
 
-			_, err = client.UpdateMetrics(ctx, tt.in)
+			resp, err := client.UpdateMetrics(ctx, tt.in)
 			if tt.err != nil {
-				assert.Errorf(t, err, tt.err.Error())
-				assert.Equal(t, tt.err, err)
+				assertStatus(t, tt.err, err)
+				if tt.expected == nil {
+					return
+				}
+				// результат по каждой метрике приходит деталью статуса
+				var result *pb.UpdateMetricsResponse
+				for _, detail := range status.Convert(err).Details() {
+					if r, ok := detail.(*pb.UpdateMetricsResponse); ok {
+						result = r
+					}
+				}
+				require.NotNil(t, result)
+				assert.Equal(t, tt.expected.Rejected, result.Rejected)
+				assert.Len(t, result.Results, len(tt.in.Metrics))
 			} else {
 				assert.NoError(t, err)
+				assert.Equal(t, tt.expected.Accepted, resp.Accepted)
+				assert.Equal(t, tt.expected.Rejected, resp.Rejected)
+				assert.Len(t, resp.Results, len(tt.in.Metrics))
 			}
 		})
 	}
@@ -360,3 +503,313 @@ func TestMetricsServer_ListAllMetrics(t *testing.T) {
 		})
 	}
 }
+
+func TestMetricsServer_WatchMetrics(t *testing.T) {
+	srv := service.NewService(&service.Settings{Retries: 1, BackoffFactor: 1}, repository.NewMemStorage())
+
+	lis = bufconn.Listen(bufSize)
+	s := grpc.NewServer()
+	pb.RegisterMetricsServer(s, &MetricsServer{Service: srv})
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			t.Errorf("Server exited with error: %v", err)
+		}
+	}()
+	defer s.Stop()
+
+	bufDialer := func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}
+	conn, err := grpc.NewClient("passthrough://bufnet", grpc.WithContextDialer(bufDialer), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Errorf("NewClientConn err: %v", err)
+	}
+	defer conn.Close()
+	client := pb.NewMetricsClient(conn)
+
+	t.Run("NOT OK, bad match pattern", func(t *testing.T) {
+		stream, err := client.WatchMetrics(context.TODO(), &pb.WatchMetricsRequest{Match: "Heap["})
+		assert.NoError(t, err)
+		_, err = stream.Recv()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("OK stream matched updates", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		stream, err := client.WatchMetrics(ctx, &pb.WatchMetricsRequest{Match: "Poll*"})
+		assert.NoError(t, err)
+
+		// подписка оформляется асинхронно, публикуем пока клиент не получит событие
+		go func() {
+			for ctx.Err() == nil {
+				srv.SetValue(ctx, "Alloc", "gauge", "1.5")
+				srv.SetValue(ctx, "PollCount", "counter", "3")
+				time.Sleep(50 * time.Millisecond)
+			}
+		}()
+
+		update, err := stream.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, "PollCount", update.Metric.Id)
+		assert.Equal(t, pb.MetricType_counter, update.Metric.Type)
+		assert.Equal(t, int64(3), update.Metric.Delta)
+		assert.NotNil(t, update.Time)
+	})
+}
+
+func TestOTLPServer_Export(t *testing.T) {
+	repo := repository.NewMemStorage()
+	srv := service.NewService(&service.Settings{Retries: 1, BackoffFactor: 1}, repo)
+
+	lis = bufconn.Listen(bufSize)
+	s := grpc.NewServer()
+	colmetricspb.RegisterMetricsServiceServer(s, &OTLPServer{Receiver: otlp.NewReceiver(srv, otlp.Namer{})})
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			t.Errorf("Server exited with error: %v", err)
+		}
+	}()
+	defer s.Stop()
+
+	bufDialer := func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}
+	conn, err := grpc.NewClient("passthrough://bufnet", grpc.WithContextDialer(bufDialer), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Errorf("NewClientConn err: %v", err)
+	}
+	defer conn.Close()
+	client := colmetricspb.NewMetricsServiceClient(conn)
+
+	resp, err := client.Export(context.TODO(), &colmetricspb.ExportMetricsServiceRequest{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{
+			{Name: "temperature", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{
+				{Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 21.5}},
+				{},
+			}}}},
+		}}},
+	}}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), resp.GetPartialSuccess().GetRejectedDataPoints())
+	assert.Equal(t, 21.5, repo.Gauge["temperature"])
+}
+
+func TestTrustedSubnetInterceptor(t *testing.T) {
+	prefixes, err := ipfilter.ParseSubnets("192.168.1.0/24")
+	require.NoError(t, err)
+	// тест подключается через loopback, то есть как доверенный прокси
+	proxies, err := ipfilter.ParseSubnets("127.0.0.0/8")
+	require.NoError(t, err)
+	filter, err := ipfilter.New(prefixes, proxies, nil, 0)
+	require.NoError(t, err)
+	views := NewServerViews(service.NewService(&service.Settings{Retries: 1, BackoffFactor: 1}, repository.NewMemStorage()), Config{})
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(TrustedSubnetInterceptor(filter)),
+		grpc.StreamInterceptor(StreamTrustedSubnetInterceptor(filter)),
+	)
+	pb.RegisterMetricsServer(s, &MetricsServer{Service: views.Service})
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := pb.NewMetricsClient(conn)
+
+	// без x-real-ip адрес клиента это адрес прокси, он не из доверенной подсети
+	_, err = client.ListAllMetrics(context.Background(), &emptypb.Empty{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	trusted := metadata.AppendToOutgoingContext(context.Background(), "x-real-ip", "192.168.1.10")
+	_, err = client.ListAllMetrics(trusted, &emptypb.Empty{})
+	require.NoError(t, err)
+
+	untrusted := metadata.AppendToOutgoingContext(context.Background(), "x-real-ip", "10.0.0.1")
+	_, err = client.ListAllMetrics(untrusted, &emptypb.Empty{})
+	assertStatus(t, status.Error(codes.PermissionDenied, ipfilter.ErrForbidden.Error()), err)
+
+	stream, err := client.WatchMetrics(untrusted, &pb.WatchMetricsRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestAuditSourceInterceptor(t *testing.T) {
+	proxies, err := ipfilter.ParseSubnets("127.0.0.0/8")
+	require.NoError(t, err)
+	filter, err := ipfilter.New(nil, proxies, nil, 0)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		filter *ipfilter.Filter
+		peer   string
+		realIP string
+		want   string
+	}{
+		{name: "direct client", filter: filter, peer: "10.0.0.5", want: "10.0.0.5"},
+		{name: "client behind trusted proxy", filter: filter, peer: "127.0.0.1", realIP: "192.168.1.10", want: "192.168.1.10"},
+		{name: "header from untrusted peer is ignored", filter: filter, peer: "10.0.0.5", realIP: "192.168.1.10", want: "10.0.0.5"},
+		{name: "without filter", peer: "127.0.0.1", realIP: "192.168.1.10", want: "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(tt.peer), Port: 4000}})
+			md := metadata.Pairs(common.AgentIDHeader, "agent-1")
+			if tt.realIP != "" {
+				md.Set(ipfilter.HeaderRealIP, tt.realIP)
+			}
+			ctx = metadata.NewIncomingContext(ctx, md)
+
+			var src audit.Source
+			_, err := AuditSourceInterceptor(tt.filter)(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req any) (any, error) {
+				src = audit.SourceFromContext(ctx)
+				return nil, nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, src.IP)
+			assert.Equal(t, "agent-1", src.AgentID)
+			assert.Equal(t, audit.TransportGRPC, src.Transport)
+		})
+	}
+}
+
+func TestMetricsServer_UpdateMetricsEncrypted(t *testing.T) {
+	pub, priv := readTestKeys(t)
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "test_gauge", Value: 1.5, Type: pb.MetricType_gauge},
+		{Id: "test_counter", Delta: 3, Type: pb.MetricType_counter}}}
+	plain, err := proto.Marshal(req)
+	require.NoError(t, err)
+	envelope, err := common.EncryptEnvelope(plain, pub)
+	require.NoError(t, err)
+	notRequest, err := common.EncryptEnvelope([]byte{0xff, 0xff}, pub)
+	require.NoError(t, err)
+
+	testTable := []struct {
+		name     string
+		key      *rsa.PrivateKey
+		envelope []byte
+		expected *pb.UpdateMetricsResponse
+		err      error
+	}{
+		{name: "Ok encrypted batch", key: priv, envelope: envelope, expected: &pb.UpdateMetricsResponse{Accepted: 2}},
+		{name: "NOT OK, server has no key", envelope: envelope,
+			err: status.Error(codes.FailedPrecondition, "request is encrypted, but server has no private key")},
+		{name: "NOT OK, broken envelope", key: priv, envelope: envelope[:100],
+			err: status.Error(codes.InvalidArgument, "failed to decrypt request, check your request")},
+	}
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			views := NewServerViews(service.NewService(&service.Settings{Retries: 1, BackoffFactor: 1}, repository.NewMemStorage()), Config{})
+			lis := bufconn.Listen(bufSize)
+			s := grpc.NewServer()
+			var keys *keyring.Keyring
+			if tt.key != nil {
+				keys = keyring.New(0)
+				require.NoError(t, keys.Add(keyring.WithPrivateKey(keyring.DefaultID, tt.key)))
+			}
+			pb.RegisterMetricsServer(s, &MetricsServer{Service: views.Service, Keys: keys})
+			go s.Serve(lis)
+			defer s.Stop()
+
+			conn, err := grpc.NewClient("passthrough://bufnet", grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+				return lis.Dial()
+			}), grpc.WithTransportCredentials(insecure.NewCredentials()))
+			require.NoError(t, err)
+			defer conn.Close()
+
+			resp, err := pb.NewMetricsClient(conn).UpdateMetricsEncrypted(context.Background(), &pb.EncryptedMessage{Envelope: tt.envelope})
+			if tt.err != nil {
+				assertStatus(t, tt.err, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected.Accepted, resp.Accepted)
+		})
+	}
+
+	t.Run("NOT OK, envelope is not a request", func(t *testing.T) {
+		keys := keyring.New(0)
+		require.NoError(t, keys.Add(keyring.WithPrivateKey(keyring.DefaultID, priv)))
+		m := &MetricsServer{Keys: keys}
+		_, err := m.UpdateMetricsEncrypted(context.Background(), &pb.EncryptedMessage{Envelope: notRequest})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+func TestSignatureInterceptor(t *testing.T) {
+	keys := keyring.New(0)
+	require.NoError(t, keys.Add(keyring.Key{ID: "k1", Type: keyring.TypeHMAC, Secret: "secret"}))
+	auditor := audit.NewAuditor(audit.NewMemorySink(10))
+	views := NewServerViews(service.NewService(&service.Settings{Retries: 1, BackoffFactor: 1, Auditor: auditor}, repository.NewMemStorage()), Config{})
+
+	lis := bufconn.Listen(bufSize)
+	verifier := signing.NewVerifier(keys, 0, false)
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(SignatureInterceptor(verifier), RequireSignInterceptor(verifier), AuditSourceInterceptor(nil)))
+	pb.RegisterMetricsServer(s, &MetricsServer{Service: views.Service})
+	go s.Serve(lis)
+	defer s.Stop()
+
+	dial := func(opts ...grpc.DialOption) pb.MetricsClient {
+		conn, err := grpc.NewClient("passthrough://bufnet", append(opts, grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}), grpc.WithTransportCredentials(insecure.NewCredentials()))...)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return pb.NewMetricsClient(conn)
+	}
+	req := &pb.UpdateMetricRequest{Id: "test_counter", Value: "1", Type: pb.MetricType_counter}
+
+	signed := dial(grpc.WithUnaryInterceptor(signing.UnaryClientInterceptor("secret", "k1")))
+	_, err := signed.UpdateMetric(context.Background(), req)
+	require.NoError(t, err)
+
+	_, err = dial().UpdateMetric(context.Background(), req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "unsigned calls are rejected when keys are set")
+
+	// чтение подписи не требует
+	_, err = dial().GetMetric(context.Background(), &pb.GetMetricRequest{Metric: &pb.Metric{Id: "test_counter", Type: pb.MetricType_counter}})
+	require.NoError(t, err)
+
+	_, err = dial(grpc.WithUnaryInterceptor(signing.UnaryClientInterceptor("wrong", "k1"))).UpdateMetric(context.Background(), req)
+	assertStatus(t, status.Error(codes.InvalidArgument, signing.ErrBadSignature.Error()), err)
+
+	// повтор перехваченных метаданных с тем же запросом
+	headers, err := signing.Sign("secret", "k1", "POST", pb.Metrics_UpdateMetric_FullMethodName, "", mustBody(t, req))
+	require.NoError(t, err)
+	replay := metadata.New(headers)
+	_, err = dial().UpdateMetric(metadata.NewOutgoingContext(context.Background(), replay), req)
+	require.NoError(t, err)
+	_, err = dial().UpdateMetric(metadata.NewOutgoingContext(context.Background(), replay), req)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	// подпись другого запроса
+	other := &pb.UpdateMetricRequest{Id: "test_counter", Value: "100", Type: pb.MetricType_counter}
+	headers, err = signing.Sign("secret", "k1", "POST", pb.Metrics_UpdateMetric_FullMethodName, "", mustBody(t, req))
+	require.NoError(t, err)
+	_, err = dial().UpdateMetric(metadata.NewOutgoingContext(context.Background(), metadata.New(headers)), other)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	entries, err := auditor.Query(context.Background(), audit.Filter{})
+	require.NoError(t, err)
+	var verified int
+	for _, e := range entries {
+		if e.Source.Verified {
+			verified++
+		}
+	}
+	assert.Equal(t, 2, verified)
+}
+
+func mustBody(t *testing.T, msg any) []byte {
+	t.Helper()
+	b, err := signing.Body(msg)
+	require.NoError(t, err)
+	return b
+}
@@ -3,56 +3,140 @@ package handlers
 import (
 	"context"
 	"crypto/hmac"
-	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/jmoiron/sqlx"
+	"github.com/sebasttiano/Blackbird.git/internal/audit"
+	"github.com/sebasttiano/Blackbird.git/internal/auth"
+	"github.com/sebasttiano/Blackbird.git/internal/common"
+	"github.com/sebasttiano/Blackbird.git/internal/exposition"
+	"github.com/sebasttiano/Blackbird.git/internal/health"
+	"github.com/sebasttiano/Blackbird.git/internal/ingest/influx"
+	"github.com/sebasttiano/Blackbird.git/internal/ingest/otlp"
+	"github.com/sebasttiano/Blackbird.git/internal/ingest/remotewrite"
+	"github.com/sebasttiano/Blackbird.git/internal/ipfilter"
+	"github.com/sebasttiano/Blackbird.git/internal/keyring"
 	"github.com/sebasttiano/Blackbird.git/internal/logger"
 	"github.com/sebasttiano/Blackbird.git/internal/models"
+	"github.com/sebasttiano/Blackbird.git/internal/openapi"
 	"github.com/sebasttiano/Blackbird.git/internal/service"
+	"github.com/sebasttiano/Blackbird.git/internal/signing"
 	"github.com/sebasttiano/Blackbird.git/templates"
 	"go.uber.org/zap"
 )
 
+// defaultAuditLimit сколько записей журнала аудита отдавать, если limit не передан.
+const defaultAuditLimit = 100
+
+// streamHeartbeat интервал комментариев-пингов в SSE потоке, не дает прокси закрыть соединение.
+const streamHeartbeat = 15 * time.Second
+
 // ServerViews реализует методы-обработчики http запросов
 type ServerViews struct {
-	Service       *service.Service
-	templates     templates.HTMLTemplates
-	DB            *sqlx.DB
-	SignKey       string
-	PrivateKey    *rsa.PrivateKey
-	TrustedSubnet *net.IPNet
+	Service   *service.Service
+	templates templates.HTMLTemplates
+	DB        *sqlx.DB
+	// TLS настройки TLS для HTTP сервера, nil означает соединения без шифрования.
+	TLS *tls.Config
+	// Keys ключи подписи и расшифровки запросов, nil отключает подпись и расшифровку.
+	Keys *keyring.Keyring
+	// Signatures проверяет подписи запросов, nil отклоняет все подписанные запросы.
+	Signatures *signing.Verifier
+	// TrustedSubnet пропускает только клиентов из доверенных подсетей, nil пропускает всех.
+	TrustedSubnet *ipfilter.Filter
+	RemoteWriter  *remotewrite.Receiver
+	InfluxWriter  *influx.Receiver
+	OTLPReceiver  *otlp.Receiver
+	// APISpec спецификация OpenAPI для проверки запросов, nil отключает проверку.
+	APISpec *openapi.Spec
+	// Health проверки готовности для /readyz.
+	Health *health.Health
+	// Auth проверяет токены и их права на маршрутах.
+	Auth *auth.Authenticator
 }
 
-// NewServerViews конструктор для ServerViews
-func NewServerViews(service *service.Service) ServerViews {
-	return ServerViews{Service: service, templates: templates.ParseTemplates()}
+// NewServerViews конструктор для ServerViews. Транспорт, доступ и разбор сторонних протоколов
+// настраиваются через cfg, спецификация OpenAPI для cfg.ValidateRequests загружается отдельно в APISpec.
+func NewServerViews(service *service.Service, cfg Config) ServerViews {
+	return ServerViews{
+		Service:       service,
+		templates:     templates.ParseTemplates(),
+		RemoteWriter:  remotewrite.NewReceiver(service, cfg.RemoteWriteRules),
+		InfluxWriter:  influx.NewReceiver(service, influx.Namer{Tags: cfg.InfluxNameTags}),
+		OTLPReceiver:  otlp.NewReceiver(service, otlp.Namer{ResourceAttributes: cfg.OTLPResourceAttributes}),
+		Health:        health.New(0),
+		Auth:          auth.NewAuthenticator(cfg.Tokens, cfg.AdminToken),
+		TLS:           cfg.TLS,
+		Keys:          cfg.Keys,
+		Signatures:    cfg.Signatures,
+		TrustedSubnet: cfg.TrustedSubnet,
+	}
 }
 
 // InitRouter метод инициализирующий роутер endpoint`ов
 func (s *ServerViews) InitRouter() chi.Router {
 	r := chi.NewRouter()
 
-	r.Use(middleware.RealIP)
+	r.Use(WithSelfMetrics(s.Service.Settings.SelfMetrics))
 	if s.TrustedSubnet != nil {
+		// фильтр сам определяет адрес клиента по заголовкам, которым доверяет
 		r.Use(CheckTrustedSubnet(s.TrustedSubnet))
+	} else {
+		r.Use(middleware.RealIP)
+	}
+	r.Use(WithLogging, WithRSADecryption(s.Keys), CheckSign(s.Signatures), WithClientIdentity, WithAuditSource, GzipMiddleware)
+	if s.APISpec != nil {
+		r.Use(ValidateRequests(s.APISpec))
 	}
-	r.Use(WithLogging, WithRSADecryption(s.PrivateKey), CheckSign(s.SignKey), GzipMiddleware)
-	r.Mount("/debug", middleware.Profiler())
+	if s.Auth.Enabled() {
+		// с токенами профилировщик доступен только администратору
+		r.With(RequireScope(s.Auth, auth.ScopeAdmin)).Mount("/debug", middleware.Profiler())
+	} else {
+		r.Mount("/debug", middleware.Profiler())
+	}
+	r.NotFound(func(res http.ResponseWriter, req *http.Request) {
+		writeProblem(res, req, service.Errorf(service.ErrNotFound, "no route for %s", req.URL.Path))
+	})
+	r.MethodNotAllowed(func(res http.ResponseWriter, req *http.Request) {
+		writeProblemBody(res, http.StatusMethodNotAllowed, Problem{
+			Type:     "about:blank",
+			Title:    http.StatusText(http.StatusMethodNotAllowed),
+			Status:   http.StatusMethodNotAllowed,
+			Detail:   "method " + req.Method + " is not allowed",
+			Instance: req.URL.Path,
+			Code:     "method_not_allowed",
+		})
+	})
+
+	r.Get("/openapi.json", s.GetOpenAPI)
+	r.Get("/ping", s.PingDB)
+	r.Get("/healthz", s.Liveness)
+	r.Get("/readyz", s.Readiness)
 
-	r.Route("/", func(r chi.Router) {
+	r.Group(func(r chi.Router) {
+		r.Use(RequireScope(s.Auth, auth.ScopeRead))
 		r.Get("/", s.MainHandle)
-		r.Get("/ping", s.PingDB)
-		r.Post("/updates/", s.UpdateMetricsJSON)
+		r.Route("/ui", func(r chi.Router) {
+			r.Get("/data", s.DashboardData)
+			r.Get("/metric/{metricType}/{metricName}", s.MetricPage)
+			r.Get("/assets/*", http.StripPrefix("/ui/assets/", dashboardAssets()).ServeHTTP)
+		})
+		r.Get("/stream", s.StreamMetrics)
+		r.Get("/metrics", s.GetPrometheusMetrics)
+		r.Get("/export", s.Export)
+		r.Get("/internal/metrics", s.GetSelfMetrics)
+		r.Post("/values/", s.GetMetricsJSON)
 		r.Route("/value", func(r chi.Router) {
 			r.Post("/", s.GetMetricJSON)
 			r.Route("/{metricType}", func(r chi.Router) {
@@ -61,30 +145,72 @@ func (s *ServerViews) InitRouter() chi.Router {
 				})
 			})
 		})
-		r.Route("/update", func(r chi.Router) {
-			r.Post("/", s.UpdateMetricJSON)
-			r.Route("/{metricType}", func(r chi.Router) {
-				r.Route("/{metricName}", func(r chi.Router) {
-					r.Route("/{metricValue}", func(r chi.Router) {
-						r.Post("/", s.UpdateMetric)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(RequireScope(s.Auth, auth.ScopeWrite))
+		// Prometheus, Influx и OTLP клиенты не умеют подписывать запросы, их защищают токены
+		r.Post("/api/v1/write", s.RemoteWrite)
+		r.Post("/influx/write", s.InfluxWrite)
+		r.Post("/v1/metrics", s.OTLPExport)
+		r.Group(func(r chi.Router) {
+			r.Use(RequireSign(s.Signatures))
+			r.Post("/updates/", s.UpdateMetricsJSON)
+			r.Route("/update", func(r chi.Router) {
+				r.Post("/", s.UpdateMetricJSON)
+				r.Route("/{metricType}", func(r chi.Router) {
+					r.Route("/{metricName}", func(r chi.Router) {
+						r.Route("/{metricValue}", func(r chi.Router) {
+							r.Post("/", s.UpdateMetric)
+						})
 					})
 				})
 			})
 		})
 	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(RequireScope(s.Auth, auth.ScopeAdmin))
+		// в журнале аудита адреса клиентов, агенты и токены, поэтому он доступен только администратору
+		r.Get("/audit", s.GetAudit)
+		r.Route("/admin", func(r chi.Router) {
+			r.Get("/stats", s.AdminStats)
+			r.Get("/log-level", s.AdminGetLogLevel)
+			r.Put("/log-level", s.AdminSetLogLevel)
+			r.Post("/save", s.AdminSave)
+			r.Post("/restore", s.AdminRestore)
+			r.Post("/metrics/counter/{metricName}/reset", s.AdminResetCounter)
+			r.Delete("/metrics/{metricType}/{metricName}", s.AdminDeleteMetric)
+		})
+	})
 	return r
 }
 
-// MainHandle отристовывает главную html страницу с метриками
-func (s *ServerViews) MainHandle(res http.ResponseWriter, req *http.Request) {
+// GetPrometheusMetrics отдает все метрики в формате Prometheus или OpenMetrics в зависимости от заголовка Accept.
+func (s *ServerViews) GetPrometheusMetrics(res http.ResponseWriter, req *http.Request) {
 	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
 	defer cancel()
 
-	res.Header().Set("Content-Type", "text/html")
-	data := s.Service.GetAllValues(ctx)
-	if err := s.templates.IndexTemplate.Execute(res, data); err != nil {
-		logger.Log.Error("couldn`t render the html template", zap.Error(err))
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+	format := exposition.Negotiate(req.Header.Get("Accept"))
+	res.Header().Set("Content-Type", format.ContentType())
+	if err := exposition.Write(res, s.Service.GetAllValues(ctx), format); err != nil {
+		logger.Log.Error("couldn`t write metrics exposition", zap.Error(err))
+	}
+}
+
+// GetOpenAPI отдает спецификацию OpenAPI REST API сервера.
+func (s *ServerViews) GetOpenAPI(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	if _, err := res.Write(openapi.Document); err != nil {
+		logger.Log.Error("couldn`t write openapi document", zap.Error(err))
+	}
+}
+
+// GetSelfMetrics отдает метрики работы самого сервера в текстовом формате Prometheus.
+func (s *ServerViews) GetSelfMetrics(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", exposition.FormatText.ContentType())
+	if err := s.Service.Settings.SelfMetrics.Write(res); err != nil {
+		logger.Log.Error("couldn`t write self metrics", zap.Error(err))
 	}
 }
 
@@ -99,31 +225,21 @@ func (s *ServerViews) GetMetric(res http.ResponseWriter, req *http.Request) {
 	value, err := s.Service.GetValue(ctx, metricName, metricType)
 	if err != nil {
 		logger.Log.Error("couldn`t find requested metric. ", zap.Error(err))
-		http.Error(res, err.Error(), http.StatusNotFound)
-	} else {
-		if s.SignKey != "" {
-			res.Header().Add("HashSHA256", sign(value, s.SignKey))
-		}
-
-		io.WriteString(res, fmt.Sprintf("%v\n", value))
+		writeProblem(res, req, err)
+		return
 	}
+	s.signResponse(res, value)
+
+	io.WriteString(res, fmt.Sprintf("%v\n", value))
 }
 
 // GetMetricJSON через сервис возвращает одну из типов метрик: counter или gauge
 // в JSON виде
 func (s *ServerViews) GetMetricJSON(res http.ResponseWriter, req *http.Request) {
-	res.Header().Set("Content-Type", "application/json")
-	if req.Header.Get("Content-Type") != "application/json" {
-		logger.Log.Error("got request with wrong header", zap.String("Content-Type", req.Header.Get("Content-Type")))
-		http.Error(res, "error: check your header Content-Type\n", http.StatusBadRequest)
-	}
-
-	logger.Log.Debug("decoding incoming request")
 	var metrics models.Metrics
-	dec := json.NewDecoder(req.Body)
-	if err := dec.Decode(&metrics); err != nil {
-		logger.Log.Error("cannot decode request JSON body", zap.Error(err))
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+	if err := decodeJSON(req, &metrics); err != nil {
+		writeProblem(res, req, err)
+		return
 	}
 
 	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
@@ -131,20 +247,42 @@ func (s *ServerViews) GetMetricJSON(res http.ResponseWriter, req *http.Request)
 
 	if err := s.Service.GetModelValue(ctx, &metrics); err != nil {
 		logger.Log.Debug("couldn`t get model", zap.Error(err))
-		http.Error(res, "couldn`t get model", http.StatusNotFound)
+		writeProblem(res, req, err)
+		return
 	}
 
-	if s.SignKey != "" {
-		res.Header().Add("HashSHA256", sign(metrics, s.SignKey))
-	}
+	s.signResponse(res, metrics)
 
+	res.Header().Set("Content-Type", "application/json")
 	enc := json.NewEncoder(res)
 	if err := enc.Encode(metrics); err != nil {
 		logger.Log.Error("error encoding response", zap.Error(err))
-		http.Error(res, err.Error(), http.StatusInternalServerError)
 	}
 }
 
+// GetMetricsJSON принимает в JSON массив запрошенных метрик {id, type} и отдает значения всех метрик одним ответом.
+// Ненайденные метрики отмечаются статусом missing.
+func (s *ServerViews) GetMetricsJSON(res http.ResponseWriter, req *http.Request) {
+	var metrics []*models.Metrics
+	if err := decodeJSON(req, &metrics); err != nil {
+		writeProblem(res, req, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancel()
+
+	values, err := s.Service.GetModelValues(ctx, metrics)
+	if err != nil {
+		logger.Log.Error("couldn`t get metrics", zap.Error(err))
+		writeProblem(res, req, err)
+		return
+	}
+
+	s.signResponse(res, values)
+	writeJSON(res, values)
+}
+
 // UpdateMetric передает в сервис на сохранение одну из типов метрик: counter или gauge
 func (s *ServerViews) UpdateMetric(res http.ResponseWriter, req *http.Request) {
 	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
@@ -156,79 +294,309 @@ func (s *ServerViews) UpdateMetric(res http.ResponseWriter, req *http.Request) {
 
 	if err := s.Service.SetValue(ctx, metricName, metricType, metricValue); err != nil {
 		logger.Log.Error("couldn`t save metric. error: ", zap.Error(err))
-		http.Error(res, err.Error(), http.StatusBadRequest)
+		writeProblem(res, req, err)
 	}
 }
 
 // UpdateMetricJSON принимает в JSON передает в сервис на сохранение одну из типов метрик: counter или gauge
 func (s *ServerViews) UpdateMetricJSON(res http.ResponseWriter, req *http.Request) {
+	var metrics models.Metrics
+	if err := decodeJSON(req, &metrics); err != nil {
+		writeProblem(res, req, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancel()
+
+	if err := s.Service.SetModelValue(ctx, []*models.Metrics{&metrics}); err != nil {
+		logger.Log.Error("couldn`t save metric. error: ", zap.Error(err))
+		writeProblem(res, req, err)
+		return
+	}
+
 	res.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(res)
+	if err := enc.Encode(metrics); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+	}
+}
+
+// decodeJSON проверяет заголовок Content-Type и декодирует JSON тело запроса.
+func decodeJSON(req *http.Request, v any) error {
 	if req.Header.Get("Content-Type") != "application/json" {
 		logger.Log.Error("got request with wrong header", zap.String("Content-Type", req.Header.Get("Content-Type")))
-		http.Error(res, "error: check your header Content-Type", http.StatusBadRequest)
+		return service.Errorf(service.ErrInvalidArgument, "check your header Content-Type")
 	}
 
 	logger.Log.Debug("decoding incoming request")
-	var metrics models.Metrics
-	dec := json.NewDecoder(req.Body)
-	if err := dec.Decode(&metrics); err != nil {
+	if err := json.NewDecoder(req.Body).Decode(v); err != nil {
 		logger.Log.Error("cannot decode request JSON body", zap.Error(err))
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return service.Errorf(service.ErrInvalidArgument, "cannot decode request JSON body: %w", err)
+	}
+	return nil
+}
+
+// UpdateMetricsJSON принимает в JSON массив с одним из типов метрик: counter или gauge.
+// В ответе возвращается результат обработки каждой метрики. Параметр ?atomic=true включает режим "все или ничего".
+func (s *ServerViews) UpdateMetricsJSON(res http.ResponseWriter, req *http.Request) {
+	atomic := false
+	if v := req.URL.Query().Get("atomic"); v != "" {
+		var err error
+		if atomic, err = strconv.ParseBool(v); err != nil {
+			logger.Log.Error("got request with wrong atomic param", zap.String("atomic", v))
+			writeProblem(res, req, service.Errorf(service.ErrInvalidArgument, "check your atomic param"))
+			return
+		}
+	}
+
+	var metrics []*models.Metrics
+	if err := decodeJSON(req, &metrics); err != nil {
+		writeProblem(res, req, err)
+		return
 	}
 
 	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
 	defer cancel()
 
-	if err := s.Service.SetModelValue(ctx, []*models.Metrics{&metrics}); err != nil {
-		logger.Log.Error("couldn`t save metric. error: ", zap.Error(err))
-		http.Error(res, err.Error(), http.StatusBadRequest)
+	result, err := s.Service.SetModelValueBatch(ctx, req.Header.Get(common.BatchIDHeader), metrics, atomic)
+	err = batchRejected(result, err, len(metrics))
+	if err != nil {
+		logger.Log.Error("couldn`t save metrics. error: ", zap.Error(err))
+		if result == nil {
+			writeProblem(res, req, err)
+			return
+		}
+		// результат по каждой метрике идет расширением документа ошибки
+		p := newProblem(req, err)
+		writeProblemBody(res, p.Status, batchProblem{Problem: p, BatchResult: result})
+		return
 	}
 
+	res.Header().Set("Content-Type", "application/json")
 	enc := json.NewEncoder(res)
-	if err := enc.Encode(metrics); err != nil {
+	if err := enc.Encode(result); err != nil {
 		logger.Log.Error("error encoding response", zap.Error(err))
-		http.Error(res, err.Error(), http.StatusInternalServerError)
 	}
 }
 
-// UpdateMetricsJSON принимает в JSON массив с одним из типов метрик: counter или gauge
-func (s *ServerViews) UpdateMetricsJSON(res http.ResponseWriter, req *http.Request) {
+// batchProblem ответ об ошибке пакетного обновления с результатом по каждой метрике.
+type batchProblem struct {
+	Problem
+	*models.BatchResult
+}
+
+// batchRejected превращает пакет, в котором не принята ни одна метрика, в ошибку неверного запроса.
+// Правило общее для REST и gRPC, повтор уже принятого пакета ошибкой не считается.
+func batchRejected(result *models.BatchResult, err error, total int) error {
+	if err == nil && result.Accepted == 0 && total > 0 && !result.Duplicate {
+		return service.Errorf(service.ErrInvalidArgument, "all metrics of the batch were rejected")
+	}
+	return err
+}
+
+// RemoteWrite принимает метрики по протоколу Prometheus remote_write.
+// Ошибки в данных возвращают 400, чтобы Prometheus не повторял запрос, ошибки хранилища - 500.
+func (s *ServerViews) RemoteWrite(res http.ResponseWriter, req *http.Request) {
+	wr, err := remotewrite.Decode(req.Body)
+	if err != nil {
+		logger.Log.Error("couldn`t decode remote write request", zap.Error(err))
+		writeProblem(res, req, service.NewError(service.ErrInvalidArgument, err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancel()
+
+	stored, err := s.RemoteWriter.Write(ctx, wr)
+	if err != nil {
+		logger.Log.Error("couldn`t save remote write metrics", zap.Error(err))
+		writeProblem(res, req, err)
+		return
+	}
+	logger.Log.Debug("remote write metrics saved", zap.Int("series", len(wr.Timeseries)), zap.Int("stored", stored))
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// InfluxWrite принимает метрики в формате InfluxDB line protocol. Тело читается потоково,
+// сжатое gzip тело распаковывает GzipMiddleware. Ошибки отдаются в формате InfluxDB {"code", "message"}.
+func (s *ServerViews) InfluxWrite(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), 30*time.Second)
+	defer cancel()
+
+	stored, err := s.InfluxWriter.Write(ctx, req.Body, req.URL.Query().Get("precision"))
+	if err != nil {
+		logger.Log.Error("couldn`t save line protocol metrics", zap.Int("stored", stored), zap.Error(err))
+		code := http.StatusInternalServerError
+		if errors.Is(err, influx.ErrParse) || errors.Is(err, influx.ErrPrecision) {
+			code = http.StatusBadRequest
+		}
+		influxError(res, code, err)
+		return
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// influxError пишет ошибку в формате ответа InfluxDB.
+func influxError(res http.ResponseWriter, code int, err error) {
+	kind := "internal error"
+	if code == http.StatusBadRequest {
+		kind = "invalid"
+	}
 	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("X-Influxdb-Error", err.Error())
+	res.WriteHeader(code)
+	if errEnc := json.NewEncoder(res).Encode(map[string]string{"code": kind, "message": err.Error()}); errEnc != nil {
+		logger.Log.Error("error encoding response", zap.Error(errEnc))
+	}
+}
 
-	if req.Header.Get("Content-Type") != "application/json" {
-		logger.Log.Error("got request with wrong header", zap.String("Content-Type", req.Header.Get("Content-Type")))
-		http.Error(res, "error: check your header Content-Type", http.StatusBadRequest)
+// StreamMetrics отдает принятые обновления метрик как Server-Sent Events.
+// Параметр match фильтрует метрики по шаблону имени, например Heap*.
+func (s *ServerViews) StreamMetrics(res http.ResponseWriter, req *http.Request) {
+	flusher, ok := res.(http.Flusher)
+	if !ok {
+		logger.Log.Error("response writer doesn`t support flushing")
+		writeProblem(res, req, errors.New("streaming unsupported"))
+		return
 	}
-	var metrics []*models.Metrics
-	dec := json.NewDecoder(req.Body)
-	if err := dec.Decode(&metrics); err != nil {
-		logger.Log.Error("cannot decode request JSON body", zap.Error(err))
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+
+	sub, err := s.Service.Subscribe(req.URL.Query().Get("match"))
+	if err != nil {
+		logger.Log.Error("couldn`t subscribe to metric updates", zap.Error(err))
+		writeProblem(res, req, err)
+		return
+	}
+	defer sub.Close()
+
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-heartbeat.C:
+			io.WriteString(res, ": ping\n\n")
+			flusher.Flush()
+		case update, ok := <-sub.Updates():
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(update)
+			if err != nil {
+				logger.Log.Error("error encoding metric update", zap.Error(err))
+				continue
+			}
+			fmt.Fprintf(res, "event: metric\ndata: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
+
+// GetAudit возвращает записи журнала аудита. Фильтры передаются параметрами:
+// metric (имя или шаблон), source, agent, since и until в RFC3339, limit.
+func (s *ServerViews) GetAudit(res http.ResponseWriter, req *http.Request) {
+	auditor := s.Service.Settings.Auditor
+	if auditor == nil {
+		writeProblem(res, req, service.Errorf(service.ErrNotFound, "audit is disabled"))
+		return
+	}
+
+	query := req.URL.Query()
+	filter := audit.Filter{
+		Metric:  query.Get("metric"),
+		Source:  query.Get("source"),
+		AgentID: query.Get("agent"),
+		Limit:   defaultAuditLimit,
+	}
+	var err error
+	if v := query.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 0 {
+			writeProblem(res, req, service.Errorf(service.ErrInvalidArgument, "check your limit param"))
+			return
+		}
+	}
+	if v := query.Get("since"); v != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			writeProblem(res, req, service.Errorf(service.ErrInvalidArgument, "check your since param"))
+			return
+		}
+	}
+	if v := query.Get("until"); v != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			writeProblem(res, req, service.Errorf(service.ErrInvalidArgument, "check your until param"))
+			return
+		}
 	}
 
 	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
 	defer cancel()
 
-	if err := s.Service.SetModelValue(ctx, metrics); err != nil {
-		logger.Log.Error("couldn`t save metric. error: ", zap.Error(err))
-		http.Error(res, err.Error(), http.StatusBadRequest)
+	entries, err := auditor.Query(ctx, filter)
+	if err != nil {
+		logger.Log.Error("couldn`t query audit log", zap.Error(err))
+		writeProblem(res, req, err)
+		return
+	}
+	if entries == nil {
+		entries = []audit.Entry{}
 	}
 
-	enc := json.NewEncoder(res)
-	if err := enc.Encode(metrics); err != nil {
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(entries); err != nil {
 		logger.Log.Error("error encoding response", zap.Error(err))
-		http.Error(res, err.Error(), http.StatusInternalServerError)
 	}
 }
 
+// Liveness отвечает, что процесс жив. Зависимости не проверяются, для этого есть /readyz.
+func (s *ServerViews) Liveness(res http.ResponseWriter, req *http.Request) {
+	writeJSON(res, health.Report{Status: health.StatusUp})
+}
+
+// Readiness выполняет проверки готовности и отдает результат каждой. Если хоть одна не прошла, отвечает 503.
+func (s *ServerViews) Readiness(res http.ResponseWriter, req *http.Request) {
+	report := s.Health.Check(req.Context())
+	if !report.Up() {
+		logger.Log.Warn("server is not ready", zap.Any("checks", report.Checks))
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(http.StatusServiceUnavailable)
+		if err := json.NewEncoder(res).Encode(report); err != nil {
+			logger.Log.Error("error encoding response", zap.Error(err))
+		}
+		return
+	}
+	writeJSON(res, report)
+}
+
 // PingDB healthchecker базы данных
 func (s *ServerViews) PingDB(res http.ResponseWriter, req *http.Request) {
 	ctx, cancel := context.WithTimeout(req.Context(), 1*time.Second)
 	defer cancel()
 
+	if s.DB == nil {
+		writeProblem(res, req, service.Errorf(service.ErrUnavailable, "database is not configured"))
+		return
+	}
 	if err := s.DB.PingContext(ctx); err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		writeProblem(res, req, service.NewError(service.ErrUnavailable, err))
+	}
+}
+
+// signResponse подписывает ответ основным ключом HMAC и сообщает его идентификатор.
+func (s *ServerViews) signResponse(res http.ResponseWriter, value any) {
+	key := s.Keys.Primary(keyring.TypeHMAC)
+	if key == nil {
+		return
 	}
+	res.Header().Add("HashSHA256", sign(value, key.Secret))
+	res.Header().Set(common.SignKeyIDHeader, key.ID)
 }
 
 // sign подписывает цифровой подписью любую строку
@@ -1,18 +1,39 @@
 package handlers
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/sebasttiano/Blackbird.git/internal/models"
+	"github.com/sebasttiano/Blackbird.git/internal/openapi"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/klauspost/compress/snappy"
+	"github.com/sebasttiano/Blackbird.git/internal/audit"
+	"github.com/sebasttiano/Blackbird.git/internal/common"
+	"github.com/sebasttiano/Blackbird.git/internal/health"
+	"github.com/sebasttiano/Blackbird.git/internal/keyring"
+	"github.com/sebasttiano/Blackbird.git/internal/proto/prompb"
 	"github.com/sebasttiano/Blackbird.git/internal/repository"
 	"github.com/sebasttiano/Blackbird.git/internal/service"
+	"github.com/sebasttiano/Blackbird.git/internal/service/dedup"
+	"github.com/sebasttiano/Blackbird.git/internal/signing"
+	"github.com/sebasttiano/Blackbird.git/templates"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestUpdateMetric(t *testing.T) {
@@ -39,7 +60,7 @@ func TestUpdateMetric(t *testing.T) {
 
 	views := NewServerViews(service.NewService(
 		&service.Settings{SyncSave: false, Retries: 1, BackoffFactor: 1},
-		repository.NewMemStorage()))
+		repository.NewMemStorage()), Config{})
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -90,7 +111,7 @@ func TestUpdateMetricJSON(t *testing.T) {
 
 	views := NewServerViews(service.NewService(
 		&service.Settings{SyncSave: false, Retries: 1, BackoffFactor: 1},
-		repository.NewMemStorage()))
+		repository.NewMemStorage()), Config{})
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -120,6 +141,8 @@ func TestUpdateMetricsJSON(t *testing.T) {
 	tests := []struct {
 		name         string
 		method       string
+		url          string
+		batchID      string
 		body         string
 		expectedCode int
 		expectedBody string
@@ -127,16 +150,47 @@ func TestUpdateMetricsJSON(t *testing.T) {
 		{
 			name:         "OK Check POST /updates",
 			method:       http.MethodPost,
-			body:         `[{"id": "PollCount", "type": "counter", "delta": 33, "value": "124,5"}, {"id": "allocMem", "type": "gauge", "delta": 0, "value": "124,5"}]`,
+			body:         `[{"id": "PollCount", "type": "counter", "delta": 33, "value": "124,5"}, {"id": "allocMem", "type": "gauge", "delta": 0, "value": 124.5}]`,
 			expectedCode: http.StatusOK,
-			expectedBody: `[{"id":"PollCount","type":"counter","delta":33,"value":0},{"id":"allocMem","type":"gauge","delta":0,"value":0}]`,
+			expectedBody: `{"accepted":2,"rejected":0,"results":[{"id":"PollCount","type":"counter","status":"accepted"},{"id":"allocMem","type":"gauge","status":"accepted"}]}`,
+		},
+		{
+			name:         "OK Check POST /updates partial success",
+			method:       http.MethodPost,
+			body:         `[{"id": "PollCount", "type": "counter", "delta": 33}, {"id": "allocMem", "type": "gauge"}, {"id": "", "type": "gauge", "value": 1.5}, {"id": "x", "type": "histogram", "value": 1.5}]`,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"accepted":1,"rejected":3,"results":[{"id":"PollCount","type":"counter","status":"accepted"},{"id":"allocMem","type":"gauge","status":"rejected","reason":"missing_value","message":"value of the gauge is required. allocMem"},{"id":"","type":"gauge","status":"rejected","reason":"empty_id","message":"name of the metric is required"},{"id":"x","type":"histogram","status":"rejected","reason":"unknown_type","message":"unknown metric type. only gauge and counter are available: histogram"}]}`,
+		},
+		{
+			name:         "NOT OK Check POST /updates?atomic=true",
+			method:       http.MethodPost,
+			url:          "/updates/?atomic=true",
+			body:         `[{"id": "PollCount", "type": "counter", "delta": 33}, {"id": "allocMem", "type": "gauge"}]`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"type":"about:blank","title":"Bad Request","status":400,"detail":"batch rejected: value of the gauge is required. allocMem","instance":"/updates/","code":"invalid_argument","accepted":0,"rejected":2,"results":[{"id":"PollCount","type":"counter","status":"rejected","reason":"batch_aborted","message":"batch rejected"},{"id":"allocMem","type":"gauge","status":"rejected","reason":"missing_value","message":"value of the gauge is required. allocMem"}]}`,
+		},
+		{
+			name:         "OK Check POST /updates with batch id",
+			method:       http.MethodPost,
+			batchID:      "agent:1",
+			body:         `[{"id": "PollCount", "type": "counter", "delta": 1}]`,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"accepted":1,"rejected":0,"results":[{"id":"PollCount","type":"counter","status":"accepted"}]}`,
+		},
+		{
+			name:         "OK Check POST /updates replayed batch",
+			method:       http.MethodPost,
+			batchID:      "agent:1",
+			body:         `[{"id": "PollCount", "type": "counter", "delta": 1}]`,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"accepted":0,"rejected":0,"results":[],"duplicate":true}`,
 		},
 		{
 			name:         "NOT OK Check POST /updates",
 			method:       http.MethodPost,
 			body:         `[{"metrica": "PollCount", "code": "counter", "delta": 33, "value": "124,5"}, {"metrica": "allocMem", "type": "gauge", "delta": 0, "value": "124,5"}]`,
 			expectedCode: http.StatusBadRequest,
-			expectedBody: `[{"id":"PollCount","type":"counter","delta":33,"value":0},{"id":"allocMem","type":"gauge","delta":0,"value":0}]`,
+			expectedBody: "",
 		},
 		{
 			name:         "OK Check POST /updates",
@@ -148,8 +202,8 @@ func TestUpdateMetricsJSON(t *testing.T) {
 	}
 
 	views := NewServerViews(service.NewService(
-		&service.Settings{SyncSave: false, Retries: 1, BackoffFactor: 1},
-		repository.NewMemStorage()))
+		&service.Settings{SyncSave: false, Retries: 1, BackoffFactor: 1, Dedup: dedup.NewMemoryWindow(10)},
+		repository.NewMemStorage()), Config{})
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -159,15 +213,22 @@ func TestUpdateMetricsJSON(t *testing.T) {
 			jsonValue, err := json.Marshal(metrics)
 			assert.NoErrorf(t, err, "Ошибка при сериализации в JSON")
 
-			r := httptest.NewRequest(tt.method, "/updates/", bytes.NewBuffer(jsonValue))
+			url := "/updates/"
+			if tt.url != "" {
+				url = tt.url
+			}
+			r := httptest.NewRequest(tt.method, url, bytes.NewBuffer(jsonValue))
 			w := httptest.NewRecorder()
 
 			r.Header.Set("Content-Type", "application/json")
+			if tt.batchID != "" {
+				r.Header.Set(common.BatchIDHeader, tt.batchID)
+			}
 
 			router := views.InitRouter()
 			router.ServeHTTP(w, r)
 			assert.Equal(t, tt.expectedCode, w.Code, "Код ответа не совпадает с ожидаемым")
-			if w.Code == http.StatusOK {
+			if w.Code != http.StatusMethodNotAllowed {
 				if tt.expectedBody != "" {
 					assert.JSONEq(t, tt.expectedBody, w.Body.String())
 				}
@@ -213,7 +274,7 @@ func TestGetMetricJSON(t *testing.T) {
 
 	views := NewServerViews(service.NewService(
 		&service.Settings{SyncSave: false, Retries: 1, BackoffFactor: 1},
-		repository.NewMemStorage()))
+		repository.NewMemStorage()), Config{})
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -284,3 +345,784 @@ func Example_sign() {
 	// 800b896fe5bb8bce7d8a3d3dae28fbd4e8968cde2271449704645796902aed04
 	// 4e54afaf64269c1c0c1a0857c0066393eb14b1c63fc87dd66624dbd8acef6eb8
 }
+
+func TestStreamMetrics(t *testing.T) {
+	views := NewServerViews(service.NewService(
+		&service.Settings{SyncSave: false, Retries: 1, BackoffFactor: 1},
+		repository.NewMemStorage()), Config{})
+	srv := httptest.NewServer(views.InitRouter())
+	defer srv.Close()
+
+	t.Run("bad match pattern", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/stream?match=Heap[")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("stream matched updates", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/stream?match=Heap*", nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		for _, url := range []string{"/update/gauge/Alloc/1.5", "/update/gauge/HeapAlloc/2.5"} {
+			r, err := http.Post(srv.URL+url, "text/plain", nil)
+			require.NoError(t, err)
+			r.Body.Close()
+		}
+
+		scanner := bufio.NewScanner(resp.Body)
+		var data string
+		for scanner.Scan() {
+			if line := scanner.Text(); strings.HasPrefix(line, "data: ") {
+				data = strings.TrimPrefix(line, "data: ")
+				break
+			}
+		}
+
+		var update models.MetricUpdate
+		require.NoError(t, json.Unmarshal([]byte(data), &update))
+		assert.Equal(t, "HeapAlloc", update.ID)
+		assert.Equal(t, "gauge", update.MType)
+		assert.Equal(t, 2.5, *update.Value)
+	})
+}
+
+func TestGetAudit(t *testing.T) {
+	auditor := audit.NewAuditor(audit.NewMemorySink(100))
+	views := NewServerViews(service.NewService(
+		&service.Settings{SyncSave: false, Retries: 1, BackoffFactor: 1, Auditor: auditor},
+		repository.NewMemStorage()), Config{AdminToken: "admin"})
+	views.Keys = keyring.New(0)
+	require.NoError(t, views.Keys.Add(keyring.Key{ID: keyring.DefaultID, Type: keyring.TypeHMAC, Secret: "SECRET"}))
+	// неподписанные записи разрешены, чтобы в журнале были оба вида источников
+	views.Signatures = signing.NewVerifier(views.Keys, 0, false).AllowUnsigned(true)
+	router := views.InitRouter()
+
+	// запись без подписи
+	r := httptest.NewRequest(http.MethodPost, "/update/gauge/Alloc/1.5", nil)
+	r.RemoteAddr = "10.0.0.1:5555"
+	r.Header.Set(common.AgentIDHeader, "agent-1")
+	router.ServeHTTP(httptest.NewRecorder(), r)
+
+	// подписанная пачка
+	body := []byte(`[{"id":"PollCount","type":"counter","delta":3},{"id":"bad","type":"gauge"}]`)
+	signHeaders, err := signing.Sign("SECRET", "", http.MethodPost, "/updates/", "", body)
+	require.NoError(t, err)
+	r = httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+	r.RemoteAddr = "10.0.0.2:5555"
+	r.Header.Set("Content-Type", "application/json")
+	for k, v := range signHeaders {
+		r.Header.Set(k, v)
+	}
+	r.Header.Set(common.AgentIDHeader, "agent-2")
+	router.ServeHTTP(httptest.NewRecorder(), r)
+
+	tests := []struct {
+		name         string
+		url          string
+		token        string
+		expectedCode int
+		expected     []audit.Source
+		metrics      [][]string
+	}{
+		{
+			name:         "all entries",
+			url:          "/audit",
+			expectedCode: http.StatusOK,
+			expected: []audit.Source{
+				{Transport: audit.TransportHTTP, IP: "10.0.0.1", AgentID: "agent-1"},
+				{Transport: audit.TransportHTTP, IP: "10.0.0.2", AgentID: "agent-2", Verified: true},
+			},
+			metrics: [][]string{{"Alloc"}, {"PollCount"}},
+		},
+		{
+			name:         "by metric",
+			url:          "/audit?metric=Poll*",
+			expectedCode: http.StatusOK,
+			expected:     []audit.Source{{Transport: audit.TransportHTTP, IP: "10.0.0.2", AgentID: "agent-2", Verified: true}},
+			metrics:      [][]string{{"PollCount"}},
+		},
+		{
+			name:         "by source",
+			url:          "/audit?source=10.0.0.1",
+			expectedCode: http.StatusOK,
+			expected:     []audit.Source{{Transport: audit.TransportHTTP, IP: "10.0.0.1", AgentID: "agent-1"}},
+			metrics:      [][]string{{"Alloc"}},
+		},
+		{
+			name:         "nothing found",
+			url:          "/audit?agent=unknown",
+			expectedCode: http.StatusOK,
+			expected:     []audit.Source{},
+		},
+		{
+			name:         "bad limit",
+			url:          "/audit?limit=abc",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "bad since",
+			url:          "/audit?since=yesterday",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "without admin token",
+			url:          "/audit",
+			token:        "-",
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.token != "-" {
+				r.Header.Set("Authorization", "Bearer admin")
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			assert.Equal(t, tt.expectedCode, w.Code, "Код ответа не совпадает с ожидаемым")
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var entries []audit.Entry
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &entries))
+			require.Len(t, entries, len(tt.expected))
+			for i, entry := range entries {
+				assert.Equal(t, tt.expected[i], entry.Source)
+				ids := make([]string, 0, len(entry.Metrics))
+				for _, m := range entry.Metrics {
+					ids = append(ids, m.ID)
+				}
+				assert.Equal(t, tt.metrics[i], ids)
+			}
+		})
+	}
+}
+
+func TestGetPrometheusMetrics(t *testing.T) {
+	repo := repository.NewMemStorage()
+	repo.Gauge["heap.alloc"] = 2.5
+	repo.Counter["PollCount"] = 10
+	views := NewServerViews(service.NewService(&service.Settings{Retries: 1, BackoffFactor: 1}, repo), Config{})
+	router := views.InitRouter()
+
+	tests := []struct {
+		name         string
+		accept       string
+		expectedType string
+		expectedBody string
+	}{
+		{
+			name:         "text format by default",
+			expectedType: "text/plain; version=0.0.4; charset=utf-8",
+			expectedBody: "# HELP PollCount Blackbird counter PollCount\n# TYPE PollCount counter\nPollCount 10\n" +
+				"# HELP heap_alloc Blackbird gauge heap.alloc\n# TYPE heap_alloc gauge\nheap_alloc 2.5\n",
+		},
+		{
+			name:         "openmetrics on request",
+			accept:       "application/openmetrics-text; version=1.0.0",
+			expectedType: "application/openmetrics-text; version=1.0.0; charset=utf-8",
+			expectedBody: "# HELP PollCount Blackbird counter PollCount\n# TYPE PollCount counter\nPollCount_total 10\n" +
+				"# HELP heap_alloc Blackbird gauge heap.alloc\n# TYPE heap_alloc gauge\nheap_alloc 2.5\n# EOF\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.expectedType, w.Header().Get("Content-Type"))
+			assert.Equal(t, tt.expectedBody, w.Body.String())
+		})
+	}
+}
+
+func TestRemoteWrite(t *testing.T) {
+	repo := repository.NewMemStorage()
+	views := NewServerViews(service.NewService(&service.Settings{Retries: 1, BackoffFactor: 1}, repo), Config{})
+	router := views.InitRouter()
+
+	wr := &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{
+		{
+			Labels:  []*prompb.Label{{Name: "__name__", Value: "node_load1"}, {Name: "instance", Value: "a"}},
+			Samples: []*prompb.Sample{{Value: 0.75, Timestamp: 1000}},
+		},
+		{
+			Labels:  []*prompb.Label{{Name: "__name__", Value: "requests_total"}},
+			Samples: []*prompb.Sample{{Value: 12, Timestamp: 1000}},
+		},
+	}}
+	data, err := proto.Marshal(wr)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		body         []byte
+		expectedCode int
+	}{
+		{name: "OK snappy protobuf", body: snappy.Encode(nil, data), expectedCode: http.StatusNoContent},
+		{name: "NOT OK not compressed", body: data, expectedCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/x-protobuf")
+			r.Header.Set("Content-Encoding", "snappy")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			assert.Equal(t, tt.expectedCode, w.Code, "Код ответа не совпадает с ожидаемым")
+		})
+	}
+	assert.Equal(t, 0.75, repo.Gauge["node_load1"])
+	assert.Equal(t, int64(12), repo.Counter["requests_total"])
+}
+
+func TestInfluxWrite(t *testing.T) {
+	repo := repository.NewMemStorage()
+	views := NewServerViews(service.NewService(&service.Settings{Retries: 1, BackoffFactor: 1}, repo), Config{})
+	router := views.InitRouter()
+
+	gzipped, err := common.Compress([]byte("cpu,host=a usage=0.5 1000000000\nhttp requests=3i 1000000000\n"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		body         []byte
+		gzip         bool
+		precision    string
+		expectedCode int
+	}{
+		{name: "OK plain", body: []byte("mem used=42.5\n"), expectedCode: http.StatusNoContent},
+		{name: "OK gzip", body: gzipped.Bytes(), gzip: true, precision: "s", expectedCode: http.StatusNoContent},
+		{name: "NOT OK broken gzip", body: []byte("not gzip"), gzip: true, expectedCode: http.StatusBadRequest},
+		{name: "NOT OK precision", body: []byte("mem used=1\n"), precision: "d", expectedCode: http.StatusBadRequest},
+		{name: "NOT OK partial write", body: []byte("disk free=7i\nbroken\n"), expectedCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/influx/write?precision="+tt.precision, bytes.NewReader(tt.body))
+			if tt.gzip {
+				r.Header.Set("Content-Encoding", "gzip")
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			assert.Equal(t, tt.expectedCode, w.Code, "Код ответа не совпадает с ожидаемым")
+		})
+	}
+	assert.Equal(t, 42.5, repo.Gauge["mem.used"])
+	assert.Equal(t, 0.5, repo.Gauge["cpu.usage"])
+	assert.Equal(t, int64(3), repo.Counter["http.requests"])
+	assert.Equal(t, int64(7), repo.Counter["disk.free"])
+}
+
+func TestOTLPExport(t *testing.T) {
+	repo := repository.NewMemStorage()
+	views := NewServerViews(service.NewService(&service.Settings{Retries: 1, BackoffFactor: 1}, repo), Config{})
+	router := views.InitRouter()
+
+	req := &colmetricspb.ExportMetricsServiceRequest{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{
+			{Name: "requests", Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+				IsMonotonic:            true,
+				DataPoints:             []*metricspb.NumberDataPoint{{Value: &metricspb.NumberDataPoint_AsInt{AsInt: 2}}},
+			}}},
+		}}},
+	}}}
+	pbBody, err := proto.Marshal(req)
+	require.NoError(t, err)
+	jsonBody, err := protojson.Marshal(req)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name                string
+		body                []byte
+		contentType         string
+		expectedCode        int
+		expectedContentType string
+	}{
+		{name: "OK protobuf", body: pbBody, contentType: "application/x-protobuf", expectedCode: http.StatusOK, expectedContentType: "application/x-protobuf"},
+		{name: "OK json", body: jsonBody, contentType: "application/json", expectedCode: http.StatusOK, expectedContentType: "application/json"},
+		{name: "NOT OK broken protobuf", body: []byte("broken"), contentType: "application/x-protobuf", expectedCode: http.StatusBadRequest, expectedContentType: "application/x-protobuf"},
+		{name: "NOT OK content type", body: pbBody, contentType: "text/plain", expectedCode: http.StatusUnsupportedMediaType, expectedContentType: "application/x-protobuf"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			assert.Equal(t, tt.expectedCode, w.Code, "Код ответа не совпадает с ожидаемым")
+			assert.Equal(t, tt.expectedContentType, w.Header().Get("Content-Type"))
+		})
+	}
+	assert.Equal(t, int64(4), repo.Counter["requests"])
+}
+
+func TestDashboard(t *testing.T) {
+	views := NewServerViews(service.NewService(&service.Settings{Retries: 1, BackoffFactor: 1},
+		repository.NewMemStorage()), Config{})
+	router := views.InitRouter()
+
+	for _, url := range []string{"/update/gauge/cpu.load/1234.5", "/update/counter/PollCount/2048"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, url, nil))
+	}
+
+	t.Run("index", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+		assert.Contains(t, w.Body.String(), "cpu.load")
+		assert.Contains(t, w.Body.String(), "1,234.5")
+	})
+
+	t.Run("data", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ui/data", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var d templates.Dashboard
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&d))
+		require.Len(t, d.Gauges, 1)
+		require.Len(t, d.Counters, 1)
+		assert.Equal(t, "2,048", d.Counters[0].Value)
+		assert.NotNil(t, d.Gauges[0].Updated)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ui/data?type=counter&name=PollCount", nil))
+		d = templates.Dashboard{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&d))
+		assert.Empty(t, d.Gauges)
+		assert.Len(t, d.Counters, 1)
+	})
+
+	t.Run("metric page", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ui/metric/gauge/cpu.load", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "cpu_load")
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ui/metric/gauge/unknown", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("assets", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/ui/assets/dashboard.js", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+		assert.Empty(t, w.Header().Get("Content-Length"))
+	})
+}
+
+func TestGetSelfMetrics(t *testing.T) {
+	settings := &service.Settings{Retries: 1, BackoffFactor: 1, SelfMetricsPrefix: "_bb."}
+	views := NewServerViews(service.NewService(settings, repository.NewMemStorage()), Config{})
+	router := views.InitRouter()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/update/gauge/Alloc/1.5", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// префикс метрик сервера зарезервирован
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/update/counter/_bb.requests/1", nil))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	value := 1.0
+	require.NoError(t, views.Service.SetSelfMetrics(context.Background(), []*models.Metrics{{ID: "_bb.up", MType: "gauge", Value: &value}}))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/internal/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+
+	body := w.Body.String()
+	assert.Contains(t, body, `blackbird_http_requests_total{route="/update/{metricType}/{metricName}/{metricValue}",method="POST",code="200"} 1`)
+	assert.Contains(t, body, `blackbird_http_requests_total{route="/update/{metricType}/{metricName}/{metricValue}",method="POST",code="409"} 1`)
+	assert.Contains(t, body, `blackbird_storage_operations_total{operation="set_gauge",result="ok"} 1`)
+	assert.Contains(t, body, `blackbird_storage_operations_total{operation="set_metrics",result="ok"} 1`)
+	assert.Contains(t, body, `blackbird_http_request_duration_seconds_count{route="/update/{metricType}/{metricName}/{metricValue}",method="POST"} 2`)
+}
+
+func TestProblemResponses(t *testing.T) {
+	views := NewServerViews(service.NewService(&service.Settings{Retries: 1, BackoffFactor: 1}, repository.NewMemStorage()), Config{})
+	router := views.InitRouter()
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		want   Problem
+	}{
+		{
+			name:   "metric not found",
+			method: http.MethodGet,
+			target: "/value/gauge/unknown",
+			want:   Problem{Type: "about:blank", Title: "Not Found", Status: http.StatusNotFound, Detail: "gauge metric unknown not found", Instance: "/value/gauge/unknown", Code: "not_found"},
+		},
+		{
+			name:   "bad value",
+			method: http.MethodPost,
+			target: "/update/gauge/Alloc/abc",
+			want:   Problem{Type: "about:blank", Title: "Bad Request", Status: http.StatusBadRequest, Instance: "/update/gauge/Alloc/abc", Code: "invalid_argument"},
+		},
+		{
+			name:   "unknown route",
+			method: http.MethodGet,
+			target: "/unknown",
+			want:   Problem{Type: "about:blank", Title: "Not Found", Status: http.StatusNotFound, Instance: "/unknown", Code: "not_found"},
+		},
+		{
+			name:   "method not allowed",
+			method: http.MethodDelete,
+			target: "/value/",
+			want:   Problem{Type: "about:blank", Title: "Method Not Allowed", Status: http.StatusMethodNotAllowed, Instance: "/value/", Code: "method_not_allowed"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+			require.Equal(t, tt.want.Status, w.Code)
+			assert.Equal(t, ProblemContentType, w.Header().Get("Content-Type"))
+
+			var got Problem
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&got))
+			if tt.want.Detail == "" {
+				tt.want.Detail = got.Detail
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestOpenAPISpecMatchesRouter(t *testing.T) {
+	spec, err := openapi.Load()
+	require.NoError(t, err)
+	views := NewServerViews(service.NewService(&service.Settings{Retries: 1, BackoffFactor: 1}, repository.NewMemStorage()), Config{})
+
+	var routes []string
+	err = chi.Walk(views.InitRouter(), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		// профилировщик подключается из chi и в API не входит
+		if strings.HasPrefix(route, "/debug/") {
+			return nil
+		}
+		// в спецификации шаблоны путей без завершающего слэша, wildcard описан параметром
+		if strings.HasSuffix(route, "}/") {
+			route = strings.TrimSuffix(route, "/")
+		}
+		if strings.HasSuffix(route, "/*") {
+			route = strings.TrimSuffix(route, "*") + "{asset}"
+		}
+		routes = append(routes, method+" "+route)
+		return nil
+	})
+	require.NoError(t, err)
+	sort.Strings(routes)
+	assert.Equal(t, spec.Operations(), routes)
+}
+
+func TestGetOpenAPI(t *testing.T) {
+	views := NewServerViews(service.NewService(&service.Settings{Retries: 1, BackoffFactor: 1}, repository.NewMemStorage()), Config{})
+	w := httptest.NewRecorder()
+	views.InitRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, string(openapi.Document), w.Body.String())
+}
+
+func TestValidateRequests(t *testing.T) {
+	spec, err := openapi.Load()
+	require.NoError(t, err)
+	views := NewServerViews(service.NewService(&service.Settings{Retries: 1, BackoffFactor: 1}, repository.NewMemStorage()), Config{})
+	views.APISpec = spec
+	router := views.InitRouter()
+
+	tests := []struct {
+		name     string
+		target   string
+		body     string
+		gzip     bool
+		wantCode int
+		wantBody string
+	}{
+		{name: "valid", target: "/update/", body: `{"id":"Alloc","type":"gauge","value":1.5}`, wantCode: http.StatusOK},
+		{name: "valid gzip", target: "/updates/", body: `[{"id":"PollCount","type":"counter","delta":2}]`, gzip: true, wantCode: http.StatusOK},
+		{name: "unknown type", target: "/update/", body: `{"id":"Alloc","type":"histogram","value":1.5}`, wantCode: http.StatusBadRequest, wantBody: "body.type: must be one of [gauge counter]"},
+		{name: "gzip without id", target: "/updates/", body: `[{"type":"counter","delta":2}]`, gzip: true, wantCode: http.StatusBadRequest, wantBody: "body[0]: property id is required"},
+		{name: "path parameter", target: "/update/histogram/Alloc/1", wantCode: http.StatusBadRequest, wantBody: "path parameter metricType must be one of [gauge counter]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := bytes.NewBufferString(tt.body)
+			if tt.gzip {
+				var err error
+				body, err = common.Compress([]byte(tt.body))
+				require.NoError(t, err)
+			}
+			r := httptest.NewRequest(http.MethodPost, tt.target, body)
+			if tt.body != "" {
+				r.Header.Set("Content-Type", "application/json")
+			}
+			if tt.gzip {
+				r.Header.Set("Content-Encoding", "gzip")
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			require.Equal(t, tt.wantCode, w.Code, w.Body.String())
+			if tt.wantBody != "" {
+				assert.Equal(t, ProblemContentType, w.Header().Get("Content-Type"))
+				assert.Contains(t, w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestHealthProbes(t *testing.T) {
+	views := NewServerViews(service.NewService(&service.Settings{Retries: 1, BackoffFactor: 1}, repository.NewMemStorage()), Config{})
+	restored := health.NewFlag("metrics are not restored yet")
+	views.Health.Register("restore", restored)
+	views.Health.Register("snapshot", health.WritableDir(t.TempDir()))
+	router := views.InitRouter()
+
+	probe := func(target string) (int, health.Report) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		var report health.Report
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+		return w.Code, report
+	}
+
+	code, report := probe("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, health.StatusUp, report.Status)
+
+	code, report = probe("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, health.StatusDown, report.Status)
+	assert.Equal(t, "metrics are not restored yet", report.Checks["restore"].Error)
+	assert.Equal(t, health.StatusUp, report.Checks["snapshot"].Status)
+
+	restored.Done()
+	code, report = probe("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, health.StatusUp, report.Status)
+	assert.Len(t, report.Checks, 2)
+}
+
+func TestPingDBWithoutDatabase(t *testing.T) {
+	views := NewServerViews(service.NewService(&service.Settings{Retries: 1, BackoffFactor: 1}, repository.NewMemStorage()), Config{})
+	w := httptest.NewRecorder()
+	views.InitRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ping", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "database is not configured")
+}
+
+func TestGetMetricsJSON(t *testing.T) {
+	views := NewServerViews(service.NewService(&service.Settings{Retries: 1, BackoffFactor: 1}, repository.NewMemStorage()), Config{})
+	router := views.InitRouter()
+	require.NoError(t, views.Service.SetValue(context.Background(), "PollCount", "counter", "7"))
+	require.NoError(t, views.Service.SetValue(context.Background(), "Alloc", "gauge", "2.5"))
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantCode    int
+		wantBody    string
+	}{
+		{
+			name:        "found and missing",
+			contentType: "application/json",
+			body:        `[{"id":"Alloc","type":"gauge"},{"id":"PollCount","type":"counter"},{"id":"Frees","type":"gauge"}]`,
+			wantCode:    http.StatusOK,
+			wantBody: `[{"id":"Alloc","type":"gauge","value":2.5,"status":"found"},{"id":"PollCount","type":"counter","delta":7,"status":"found"},` +
+				`{"id":"Frees","type":"gauge","status":"missing"}]`,
+		},
+		{
+			name:        "rejected item",
+			contentType: "application/json",
+			body:        `[{"id":"Alloc","type":"histogram"}]`,
+			wantCode:    http.StatusOK,
+			wantBody:    `[{"id":"Alloc","type":"histogram","status":"rejected","reason":"unknown_type"}]`,
+		},
+		{
+			name:        "empty list",
+			contentType: "application/json",
+			body:        `[]`,
+			wantCode:    http.StatusOK,
+			wantBody:    `[]`,
+		},
+		{
+			name:        "wrong content type",
+			contentType: "text/plain",
+			body:        `[]`,
+			wantCode:    http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/values/", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			require.Equal(t, tt.wantCode, w.Code, w.Body.String())
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRouterWithDecryptionKey(t *testing.T) {
+	pub, priv := readTestKeys(t)
+	keys := keyring.New(0)
+	require.NoError(t, keys.Add(keyring.WithPrivateKey(keyring.DefaultID, priv)))
+	repo := repository.NewMemStorage()
+	views := NewServerViews(service.NewService(&service.Settings{Retries: 1, BackoffFactor: 1}, repo), Config{AdminToken: "secret", Keys: keys})
+	router := views.InitRouter()
+	require.NoError(t, views.Service.SetValue(context.Background(), "Alloc", "gauge", "2.5"))
+
+	wr, err := proto.Marshal(&prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{{
+		Labels:  []*prompb.Label{{Name: "__name__", Value: "node_load1"}},
+		Samples: []*prompb.Sample{{Value: 0.75, Timestamp: 1000}},
+	}}})
+	require.NoError(t, err)
+	legacy, err := common.EncryptRSA(`[{"id":"PollCount","type":"counter","delta":3}]`, pub)
+	require.NoError(t, err)
+
+	// на маршрутах без шифрования старых агентов открытое тело не расшифровывается
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		header   map[string]string
+		body     []byte
+		wantCode int
+	}{
+		{name: "remote write", method: http.MethodPost, target: "/api/v1/write", header: map[string]string{"Content-Encoding": "snappy"}, body: snappy.Encode(nil, wr), wantCode: http.StatusNoContent},
+		{name: "influx", method: http.MethodPost, target: "/influx/write", body: []byte("mem used=42.5\n"), wantCode: http.StatusNoContent},
+		{name: "values", method: http.MethodPost, target: "/values/", header: map[string]string{"Content-Type": "application/json"}, body: []byte(`[{"id":"Alloc","type":"gauge"}]`), wantCode: http.StatusOK},
+		{name: "admin delete", method: http.MethodDelete, target: "/admin/metrics/gauge/Alloc", header: map[string]string{"Authorization": "Bearer secret"}, wantCode: http.StatusNoContent},
+		{name: "legacy agent batch", method: http.MethodPost, target: "/updates/", header: map[string]string{"Content-Type": "application/json"}, body: []byte(legacy), wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, bytes.NewReader(tt.body))
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			assert.Equal(t, tt.wantCode, w.Code, w.Body.String())
+		})
+	}
+	assert.Equal(t, 0.75, repo.Gauge["node_load1"])
+	assert.Equal(t, 42.5, repo.Gauge["mem.used"])
+	assert.NotContains(t, repo.Gauge, "Alloc")
+	assert.Equal(t, int64(3), repo.Counter["PollCount"])
+}
+
+func TestExport(t *testing.T) {
+	settings := &service.Settings{Retries: 1, BackoffFactor: 1, Auditor: audit.NewAuditor(audit.NewMemorySink(100))}
+	views := NewServerViews(service.NewService(settings, repository.NewMemStorage()), Config{})
+	router := views.InitRouter()
+	ctx := context.Background()
+	require.NoError(t, views.Service.SetValue(ctx, "HeapAlloc", "gauge", "2.5"))
+	require.NoError(t, views.Service.SetValue(ctx, "PollCount", "counter", "3"))
+	require.NoError(t, views.Service.SetValue(ctx, "PollCount", "counter", "4"))
+
+	tests := []struct {
+		name        string
+		target      string
+		wantCode    int
+		wantType    string
+		wantRows    []string
+		wantNotRows []string
+	}{
+		{
+			name:     "csv by default",
+			target:   "/export",
+			wantCode: http.StatusOK,
+			wantType: "text/csv; charset=utf-8",
+			wantRows: []string{"kind,time,type,id,value,agent", ",counter,PollCount,7,", ",gauge,HeapAlloc,2.5,"},
+		},
+		{
+			name:        "ndjson filtered by type",
+			target:      "/export?format=ndjson&type=gauge",
+			wantCode:    http.StatusOK,
+			wantType:    "application/x-ndjson",
+			wantRows:    []string{`"id":"HeapAlloc","type":"gauge","value":2.5`},
+			wantNotRows: []string{"PollCount"},
+		},
+		{
+			name:        "history with match",
+			target:      "/export?match=Poll*&history=true",
+			wantCode:    http.StatusOK,
+			wantType:    "text/csv; charset=utf-8",
+			wantRows:    []string{"current,", ",counter,PollCount,7,", ",counter,PollCount,3,", ",counter,PollCount,4,"},
+			wantNotRows: []string{"HeapAlloc"},
+		},
+		{name: "unknown format", target: "/export?format=xlsx", wantCode: http.StatusBadRequest},
+		{name: "bad pattern", target: "/export?match=Heap[", wantCode: http.StatusBadRequest},
+		{name: "unknown type", target: "/export?type=histogram", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			require.Equal(t, tt.wantCode, w.Code, w.Body.String())
+			if tt.wantType != "" {
+				assert.Equal(t, tt.wantType, w.Header().Get("Content-Type"))
+			}
+			for _, row := range tt.wantRows {
+				assert.Contains(t, w.Body.String(), row)
+			}
+			for _, row := range tt.wantNotRows {
+				assert.NotContains(t, w.Body.String(), row)
+			}
+		})
+	}
+
+	// ответ сжимается, если клиент умеет gzip
+	r := httptest.NewRequest(http.MethodGet, "/export?format=ndjson", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	zr, err := common.NewZIPReader(io.NopCloser(w.Body))
+	require.NoError(t, err)
+	body, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, 2, bytes.Count(body, []byte("\n")))
+
+	// без журнала аудита истории нет
+	views = NewServerViews(service.NewService(&service.Settings{Retries: 1, BackoffFactor: 1}, repository.NewMemStorage()), Config{})
+	w = httptest.NewRecorder()
+	views.InitRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/export?history=true", nil))
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
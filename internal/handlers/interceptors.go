@@ -2,9 +2,25 @@ package handlers
 
 import (
 	"context"
+	"net/http"
+	"time"
 
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
+	"github.com/sebasttiano/Blackbird.git/internal/audit"
+	"github.com/sebasttiano/Blackbird.git/internal/common"
+	"github.com/sebasttiano/Blackbird.git/internal/ipfilter"
+	"github.com/sebasttiano/Blackbird.git/internal/logger"
+	pb "github.com/sebasttiano/Blackbird.git/internal/proto"
+	"github.com/sebasttiano/Blackbird.git/internal/selfmetrics"
+	"github.com/sebasttiano/Blackbird.git/internal/service"
+	"github.com/sebasttiano/Blackbird.git/internal/signing"
+	"github.com/sebasttiano/Blackbird.git/internal/tlsconfig"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 func InterceptorLogger(l *zap.Logger) logging.Logger {
@@ -43,3 +59,180 @@ func InterceptorLogger(l *zap.Logger) logging.Logger {
 		}
 	})
 }
+
+// checkPeer проверяет, что клиент вызова из доверенной подсети. Адрес берется из метаданных,
+// которым доверяет фильтр, иначе из адреса соединения.
+func checkPeer(ctx context.Context, filter *ipfilter.Filter, method string) error {
+	var remote string
+	if p, ok := peer.FromContext(ctx); ok {
+		remote = p.Addr.String()
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	addr, err := filter.Check(remote, md.Get)
+	if err != nil {
+		logger.Log.Warn("call from untrusted address rejected", zap.String("remote", remote),
+			zap.Stringer("client", addr), zap.String("target", method), zap.Error(err))
+		return grpcError(service.NewError(service.ErrPermissionDenied, err))
+	}
+	return nil
+}
+
+// TrustedSubnetInterceptor пропускает unary вызовы только от клиентов из доверенных подсетей.
+func TrustedSubnetInterceptor(filter *ipfilter.Filter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := checkPeer(ctx, filter, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamTrustedSubnetInterceptor пропускает потоковые вызовы только от клиентов из доверенных подсетей.
+func StreamTrustedSubnetInterceptor(filter *ipfilter.Filter) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := checkPeer(ss.Context(), filter, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// peerIdentity возвращает клиента, предъявившего проверенный сертификат при mTLS.
+func peerIdentity(ctx context.Context) *tlsconfig.Identity {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil
+	}
+	return tlsconfig.PeerIdentity(&info.State)
+}
+
+// IdentityInterceptor кладет в контекст вызова клиента, предъявившего проверенный сертификат при mTLS.
+func IdentityInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if id := peerIdentity(ctx); id != nil {
+		ctx = tlsconfig.WithIdentity(ctx, id)
+	}
+	return handler(ctx, req)
+}
+
+// identityStream подменяет контекст потока на контекст с клиентом.
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context возвращает контекст с клиентом.
+func (s *identityStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamIdentityInterceptor кладет в контекст потокового вызова клиента, предъявившего проверенный сертификат.
+func StreamIdentityInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	id := peerIdentity(ss.Context())
+	if id == nil {
+		return handler(srv, ss)
+	}
+	return handler(srv, &identityStream{ServerStream: ss, ctx: tlsconfig.WithIdentity(ss.Context(), id)})
+}
+
+// SignatureInterceptor проверяет подпись unary вызова из метаданных, если она есть. Подписан метод POST
+// по полному имени метода gRPC с детерминированно сериализованным запросом в качестве тела.
+func SignatureInterceptor(verifier *signing.Verifier) grpc.UnaryServerInterceptor {
+	if verifier == nil {
+		verifier = signing.NewVerifier(nil, 0, false)
+	}
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if !signing.Signed(md.Get) {
+			return handler(ctx, req)
+		}
+		body, err := signing.Body(req)
+		if err != nil {
+			return nil, grpcError(service.NewError(service.ErrInvalidArgument, err))
+		}
+		if _, err := verifier.Verify(ctx, md.Get, http.MethodPost, info.FullMethod, body); err != nil {
+			logger.Log.Error("error: signature validation failed", zap.String("target", info.FullMethod), zap.Error(err))
+			return nil, grpcError(signatureError(err))
+		}
+		return handler(context.WithValue(ctx, signVerifiedKey{}, true), req)
+	}
+}
+
+// signedMethods методы агентов, которые при заданных ключах вызываются только с подписью.
+// OTLP Export не подписывают сторонние клиенты, его защищают токены.
+var signedMethods = map[string]bool{
+	"/" + pb.Metrics_ServiceDesc.ServiceName + "/UpdateMetric":           true,
+	"/" + pb.Metrics_ServiceDesc.ServiceName + "/UpdateMetrics":          true,
+	"/" + pb.Metrics_ServiceDesc.ServiceName + "/UpdateMetricsEncrypted": true,
+}
+
+// RequireSignInterceptor отклоняет неподписанные вызовы методов записи агентов, если verifier требует подпись.
+// Должен идти после SignatureInterceptor.
+func RequireSignInterceptor(verifier *signing.Verifier) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		verified, _ := ctx.Value(signVerifiedKey{}).(bool)
+		if !verified && signedMethods[info.FullMethod] && verifier.Required() {
+			logger.Log.Warn("unsigned call rejected", zap.String("target", info.FullMethod))
+			return nil, grpcError(signatureError(signing.ErrNoSignature))
+		}
+		return handler(ctx, req)
+	}
+}
+
+// AuditSourceInterceptor кладет в контекст вызова источник записи для журнала аудита:
+// адрес клиента, клиентский сертификат, идентификатор агента из метаданных и признак проверенной подписи.
+// Адрес клиента определяется так же, как в TrustedSubnetInterceptor: по метаданным прокси, которым доверяет
+// filter. Без фильтра записывается адрес соединения.
+func AuditSourceInterceptor(filter *ipfilter.Filter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		verified, _ := ctx.Value(signVerifiedKey{}).(bool)
+		md, _ := metadata.FromIncomingContext(ctx)
+		src := audit.Source{Transport: audit.TransportGRPC, Verified: verified}
+		if p, ok := peer.FromContext(ctx); ok {
+			src.IP = clientIP(filter, p.Addr.String(), md.Get)
+		}
+		if id := tlsconfig.IdentityFromContext(ctx); id != nil {
+			src.Client = id.CommonName
+		}
+		if values := md.Get(common.AgentIDHeader); len(values) > 0 {
+			src.AgentID = values[0]
+		}
+		return handler(audit.WithSource(ctx, src), req)
+	}
+}
+
+// clientIP определяет адрес клиента через filter. Если фильтра нет или адрес не разбирается,
+// возвращается адрес соединения без порта.
+func clientIP(filter *ipfilter.Filter, remote string, get ipfilter.Getter) string {
+	if filter != nil {
+		if addr, err := filter.ClientIP(remote, get); err == nil {
+			return addr.String()
+		}
+	}
+	return remoteIP(remote)
+}
+
+// MetricsInterceptor считает вызовы gRPC и их длительность по методу и коду статуса.
+func MetricsInterceptor(m *selfmetrics.Metrics) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		m.GRPCRequests.Inc(info.FullMethod, status.Code(err).String())
+		m.GRPCDuration.Observe(time.Since(start).Seconds(), info.FullMethod)
+		return resp, err
+	}
+}
+
+// StreamMetricsInterceptor считает потоковые вызовы gRPC, длительность считается до закрытия потока.
+func StreamMetricsInterceptor(m *selfmetrics.Metrics) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		m.GRPCRequests.Inc(info.FullMethod, status.Code(err).String())
+		m.GRPCDuration.Observe(time.Since(start).Seconds(), info.FullMethod)
+		return err
+	}
+}
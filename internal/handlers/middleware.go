@@ -2,18 +2,27 @@ package handlers
 
 import (
 	"bytes"
-	"crypto/hmac"
-	"crypto/rsa"
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
+	"errors"
 	"io"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/sebasttiano/Blackbird.git/internal/agentkeys"
+	"github.com/sebasttiano/Blackbird.git/internal/audit"
 	"github.com/sebasttiano/Blackbird.git/internal/common"
+	"github.com/sebasttiano/Blackbird.git/internal/ipfilter"
+	"github.com/sebasttiano/Blackbird.git/internal/keyring"
 	"github.com/sebasttiano/Blackbird.git/internal/logger"
+	"github.com/sebasttiano/Blackbird.git/internal/openapi"
+	"github.com/sebasttiano/Blackbird.git/internal/selfmetrics"
+	"github.com/sebasttiano/Blackbird.git/internal/service"
+	"github.com/sebasttiano/Blackbird.git/internal/signing"
+	"github.com/sebasttiano/Blackbird.git/internal/tlsconfig"
 	"go.uber.org/zap"
 )
 
@@ -59,6 +68,29 @@ func WithLogging(next http.Handler) http.Handler {
 	return http.HandlerFunc(logFn)
 }
 
+// WithSelfMetrics считает запросы и их длительность по шаблону маршрута chi, методу и коду ответа.
+// Запросы, не попавшие ни в один маршрут, учитываются с маршрутом unmatched.
+func WithSelfMetrics(m *selfmetrics.Metrics) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			lw := loggingResponseWriter{
+				ResponseWriter: res,
+				responseData:   &responseData{status: http.StatusOK},
+			}
+
+			next.ServeHTTP(&lw, req)
+
+			route := "unmatched"
+			if rctx := chi.RouteContext(req.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+			m.HTTPRequests.Inc(route, req.Method, strconv.Itoa(lw.responseData.status))
+			m.HTTPDuration.Observe(time.Since(start).Seconds(), route, req.Method)
+		})
+	}
+}
+
 type (
 	// responseData хранит статус коди и размер ответа
 	responseData struct {
@@ -85,6 +117,13 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.responseData.status = statusCode
 }
 
+// Flush проксирует сброс буфера, нужен для потоковых ответов
+func (r *loggingResponseWriter) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 // GzipMiddleware сжимает и распаковывает gzip данные из запроса и ответа
 func GzipMiddleware(next http.Handler) http.Handler {
 	gzipFn := func(res http.ResponseWriter, req *http.Request) {
@@ -102,8 +141,8 @@ func GzipMiddleware(next http.Handler) http.Handler {
 		if sendsGzip {
 			cr, err := common.NewZIPReader(req.Body)
 			if err != nil {
-				logger.Log.Error("couldn`t decompress request")
-				res.WriteHeader(http.StatusInternalServerError)
+				logger.Log.Error("couldn`t decompress request", zap.Error(err))
+				writeProblem(res, req, service.Errorf(service.ErrInvalidArgument, "couldn`t decompress request: %w", err))
 				return
 			}
 			req.Body = cr
@@ -115,88 +154,212 @@ func GzipMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(gzipFn)
 }
 
-// WithRSADecryption decrypts incoming requests body
-func WithRSADecryption(priv *rsa.PrivateKey) func(next http.Handler) http.Handler {
+// legacyEncryptedPaths маршруты старых агентов, которые шифруют тело без заголовка X-Encryption.
+var legacyEncryptedPaths = []string{"/update/", "/updates/", "/value/"}
+
+// legacyEncrypted возвращает true, если на маршрут старые агенты могут слать тело RSA-OAEP без заголовка.
+func legacyEncrypted(path string) bool {
+	for _, prefix := range legacyEncryptedPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithRSADecryption decrypts incoming requests body. Тело с заголовком X-Encryption: envelope-v1
+// расшифровывается как конверт RSA + AES-GCM, тело без заголовка на маршрутах старых агентов
+// (/update/, /updates/, /value/) как base64 RSA-OAEP. На остальных маршрутах тело без заголовка не трогается.
+// Ключ выбирается по заголовку X-Encryption-Key-ID, без него берется ключ по умолчанию.
+func WithRSADecryption(keys *keyring.Keyring) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		encFn := func(res http.ResponseWriter, req *http.Request) {
-			if priv == nil || req.Method == http.MethodGet {
+			scheme := req.Header.Get(common.EncryptionHeader)
+			if scheme == "" && (!keys.Has(keyring.TypeRSA) || req.Method == http.MethodGet || !legacyEncrypted(req.URL.Path)) {
 				next.ServeHTTP(res, req)
 				return
 			}
+			if scheme != "" && scheme != common.EnvelopeV1 {
+				writeProblem(res, req, service.Errorf(service.ErrInvalidArgument, "unsupported encryption %q, only %s is available", scheme, common.EnvelopeV1))
+				return
+			}
+			if !keys.Has(keyring.TypeRSA) {
+				writeProblem(res, req, service.Errorf(service.ErrInvalidArgument, "request is encrypted, but server has no private key"))
+				return
+			}
+			key, err := keys.Get(keyring.TypeRSA, req.Header.Get(common.EncryptionKeyIDHeader))
+			if err != nil {
+				writeProblem(res, req, service.NewError(service.ErrInvalidArgument, err))
+				return
+			}
+			priv := key.PrivateKey()
 
 			b, err := io.ReadAll(req.Body)
 			if err != nil {
 				logger.Log.Error("failed to read request body")
-				http.Error(res, "failed to read request body, check your request", http.StatusBadRequest)
+				writeProblem(res, req, service.Errorf(service.ErrInvalidArgument, "failed to read request body, check your request"))
 				return
 			}
 
-			decrypted, err := common.DecryptRSA(string(b), priv)
+			var decrypted []byte
+			if scheme == common.EnvelopeV1 {
+				decrypted, err = common.DecryptEnvelope(b, priv)
+			} else {
+				var legacy string
+				legacy, err = common.DecryptRSA(string(b), priv)
+				decrypted = []byte(legacy)
+			}
 			if err != nil {
-				logger.Log.Error("failed to decrypt request")
-				http.Error(res, "failed to decrypt request, check your request", http.StatusBadRequest)
+				logger.Log.Error("failed to decrypt request", zap.String("encryption", scheme), zap.String("key_id", key.ID), zap.Error(err))
+				writeProblem(res, req, service.Errorf(service.ErrInvalidArgument, "failed to decrypt request, check your request"))
+				return
 			}
-			req.Body = io.NopCloser(bytes.NewBufferString(decrypted))
+			req.Header.Del(common.EncryptionHeader)
+			req.Header.Del(common.EncryptionKeyIDHeader)
+			req.ContentLength = int64(len(decrypted))
+			req.Body = io.NopCloser(bytes.NewReader(decrypted))
 			next.ServeHTTP(res, req)
 		}
 		return http.HandlerFunc(encFn)
 	}
 }
 
-// CheckSign проверяет цифровую подпись, если есть соответсвующий заголовок
-func CheckSign(key string) func(next http.Handler) http.Handler {
+// CheckSign проверяет цифровую подпись, если есть соответсвующий заголовок. Подпись покрывает метод,
+// путь, время и nonce, старая подпись HashSHA256 только по телу принимается, если ее разрешает verifier.
+// Ключ выбирается по заголовку X-Sign-Key-ID, без него берется ключ по умолчанию.
+func CheckSign(verifier *signing.Verifier) func(next http.Handler) http.Handler {
+	if verifier == nil {
+		verifier = signing.NewVerifier(nil, 0, false)
+	}
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
-			hashSHA256 := req.Header.Get("HashSHA256")
-			if hashSHA256 == "" {
+			if !signing.Signed(req.Header.Values) {
 				next.ServeHTTP(res, req)
 				return
 			}
 
-			h := hmac.New(sha256.New, []byte(key))
-
 			b, err := io.ReadAll(req.Body)
 			if err != nil {
 				logger.Log.Error("failed to read request body")
-				http.Error(res, "failed to read request body, check your request", http.StatusBadRequest)
+				writeProblem(res, req, service.Errorf(service.ErrInvalidArgument, "failed to read request body, check your request"))
 				return
 			}
 
-			if _, errWr := h.Write(b); errWr != nil {
-				logger.Log.Error("failed to write bytes to hmac")
-				http.Error(res, "internal server error", http.StatusInternalServerError)
+			if _, err := verifier.Verify(req.Context(), req.Header.Values, req.Method, req.URL.RequestURI(), b); err != nil {
+				logger.Log.Error("error: signature validation failed", zap.String("agent_id", req.Header.Get(common.AgentIDHeader)), zap.Error(err))
+				writeProblem(res, req, signatureError(err))
 				return
 			}
 
-			sign := h.Sum(nil)
-			headerSign, err := hex.DecodeString(hashSHA256)
-			if err != nil {
-				logger.Log.Error("failed to decode hashSHA256 header hash")
-				http.Error(res, "failed to decode hashSHA256", http.StatusInternalServerError)
+			req.Body = io.NopCloser(bytes.NewReader(b))
+			next.ServeHTTP(res, req.WithContext(context.WithValue(req.Context(), signVerifiedKey{}, true)))
+		})
+	}
+}
+
+// RequireSign отклоняет неподписанные запросы агентов, если verifier требует подпись. Ставится после CheckSign:
+// без него перехваченный запрос можно повторить, убрав заголовки подписи.
+func RequireSign(verifier *signing.Verifier) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			verified, _ := req.Context().Value(signVerifiedKey{}).(bool)
+			if !verified && verifier.Required() {
+				logger.Log.Warn("unsigned request rejected", zap.String("target", req.Method+" "+req.URL.Path),
+					zap.String("agent_id", req.Header.Get(common.AgentIDHeader)), zap.String("remote", remoteIP(req.RemoteAddr)))
+				writeProblem(res, req, signatureError(signing.ErrNoSignature))
 				return
 			}
+			next.ServeHTTP(res, req)
+		})
+	}
+}
+
+// signatureError переводит ошибку проверки подписи в ошибку сервиса: повтор запроса конфликтует
+// с уже принятым, отозванному агенту запрещено писать, запрос без подписи не аутентифицирован,
+// остальное ошибка запроса.
+func signatureError(err error) error {
+	switch {
+	case errors.Is(err, signing.ErrReplay):
+		return service.NewError(service.ErrConflict, err)
+	case errors.Is(err, agentkeys.ErrAgentRevoked):
+		return service.NewError(service.ErrPermissionDenied, err)
+	case errors.Is(err, signing.ErrRegistryUnavailable):
+		return service.NewError(service.ErrUnavailable, err)
+	case errors.Is(err, signing.ErrNoSignature), errors.Is(err, signing.ErrNoAgentID):
+		return service.NewError(service.ErrUnauthenticated, err)
+	default:
+		return service.NewError(service.ErrInvalidArgument, err)
+	}
+}
 
-			if !hmac.Equal(sign, headerSign) {
-				logger.Log.Error("error: signature validation failed")
-				http.Error(res, "error: signature validation failed", http.StatusInternalServerError)
+// signVerifiedKey ключ контекста, отмечает запросы с проверенной цифровой подписью.
+type signVerifiedKey struct{}
+
+// WithClientIdentity кладет в контекст запроса клиента, предъявившего проверенный сертификат при mTLS.
+func WithClientIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if id := tlsconfig.PeerIdentity(req.TLS); id != nil {
+			req = req.WithContext(tlsconfig.WithIdentity(req.Context(), id))
+		}
+		next.ServeHTTP(res, req)
+	})
+}
+
+// WithAuditSource кладет в контекст запроса источник записи для журнала аудита:
+// адрес клиента, идентификатор агента, клиентский сертификат и признак проверенной подписи.
+func WithAuditSource(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		verified, _ := req.Context().Value(signVerifiedKey{}).(bool)
+		src := audit.Source{
+			Transport: audit.TransportHTTP,
+			IP:        remoteIP(req.RemoteAddr),
+			AgentID:   req.Header.Get(common.AgentIDHeader),
+			Verified:  verified,
+		}
+		if id := tlsconfig.IdentityFromContext(req.Context()); id != nil {
+			src.Client = id.CommonName
+		}
+		next.ServeHTTP(res, req.WithContext(audit.WithSource(req.Context(), src)))
+	})
+}
+
+// remoteIP отрезает порт от адреса клиента, если он есть.
+func remoteIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// ValidateRequests отклоняет запросы, не соответствующие спецификации OpenAPI.
+// Должен идти после распаковки и расшифровки тела, чтобы проверять исходный JSON.
+func ValidateRequests(spec *openapi.Spec) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			if err := spec.ValidateRequest(req); err != nil {
+				logger.Log.Info("request doesn`t match the api specification", zap.String("path", req.URL.Path), zap.Error(err))
+				writeProblem(res, req, service.NewError(service.ErrInvalidArgument, err))
 				return
 			}
-
-			req.Body = io.NopCloser(bytes.NewReader(b))
 			next.ServeHTTP(res, req)
 		})
 	}
 }
 
-// CheckTrustedSubnet проверяет, что remoteAddr относится к доверенной подсети
-func CheckTrustedSubnet(trustedSubnet *net.IPNet) func(next http.Handler) http.Handler {
+// CheckTrustedSubnet пропускает только клиентов из доверенных подсетей. Адрес клиента определяется
+// по заголовкам прокси, которым доверяет фильтр, и записывается в RemoteAddr для логов и журнала аудита.
+func CheckTrustedSubnet(filter *ipfilter.Filter) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
-		chSubnetFn := func(res http.ResponseWriter, req *http.Request) {
-			if !trustedSubnet.Contains(net.ParseIP(req.RemoteAddr)) {
-				logger.Log.Error("error: client address is forbidden")
-				http.Error(res, "error: client address is forbidden", http.StatusForbidden)
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			addr, err := filter.Check(req.RemoteAddr, req.Header.Values)
+			if err != nil {
+				logger.Log.Warn("request from untrusted address rejected", zap.String("remote", req.RemoteAddr),
+					zap.Stringer("client", addr), zap.String("target", req.Method+" "+req.URL.Path), zap.Error(err))
+				writeProblem(res, req, service.NewError(service.ErrPermissionDenied, err))
+				return
 			}
-		}
-		return http.HandlerFunc(chSubnetFn)
+			req.RemoteAddr = addr.String()
+			next.ServeHTTP(res, req)
+		})
 	}
 }
@@ -1,14 +1,34 @@
 package handlers
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"io"
-	"net"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/sebasttiano/Blackbird.git/internal/agentkeys"
+	"github.com/sebasttiano/Blackbird.git/internal/audit"
+	"github.com/sebasttiano/Blackbird.git/internal/common"
+	"github.com/sebasttiano/Blackbird.git/internal/ipfilter"
+	"github.com/sebasttiano/Blackbird.git/internal/keyring"
 	"github.com/sebasttiano/Blackbird.git/internal/repository"
 	"github.com/sebasttiano/Blackbird.git/internal/service"
+	"github.com/sebasttiano/Blackbird.git/internal/signing"
+	"github.com/sebasttiano/Blackbird.git/internal/tlsconfig"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -45,7 +65,7 @@ func TestOnlyPostAllowed(t *testing.T) {
 }
 
 func TestGzipMiddleware(t *testing.T) {
-	views := NewServerViews(service.NewService(&service.Settings{SyncSave: false}, repository.NewMemStorage()))
+	views := NewServerViews(service.NewService(&service.Settings{SyncSave: false}, repository.NewMemStorage()), Config{})
 	srv := httptest.NewServer(views.InitRouter())
 	defer srv.Close()
 
@@ -98,44 +118,314 @@ func TestGzipMiddleware(t *testing.T) {
 }
 
 func TestCheckTrustedSubnet(t *testing.T) {
+	prefixes, err := ipfilter.ParseSubnets("192.168.1.0/24,2001:db8::/32")
+	require.NoError(t, err)
+	proxies, err := ipfilter.ParseSubnets("10.0.0.0/8")
+	require.NoError(t, err)
+	filter, err := ipfilter.New(prefixes, proxies, nil, 0)
+	require.NoError(t, err)
 
 	tests := []struct {
-		name          string
-		requestURL    string
-		trustedSubnet *net.IPNet
-		remoteAddr    string
-		expectedCode  int
-		expectedBody  string
+		name         string
+		remoteAddr   string
+		realIP       string
+		forwardedFor string
+		expectedCode int
+	}{
+		{name: "Check ipv4 ok", remoteAddr: "192.168.1.100:5555", expectedCode: http.StatusOK},
+		{name: "Check ipv6 ok", remoteAddr: "[2001:db8::7]:5555", expectedCode: http.StatusOK},
+		{name: "Check forbidden", remoteAddr: "10.0.0.1:5555", expectedCode: http.StatusForbidden},
+		{name: "Check proxy x-real-ip", remoteAddr: "10.0.0.1:5555", realIP: "192.168.1.100", expectedCode: http.StatusOK},
+		{name: "Check forbidden x-real-ip", remoteAddr: "10.0.0.2:5555", realIP: "10.0.0.1", expectedCode: http.StatusForbidden},
+		{name: "Check spoofed x-real-ip", remoteAddr: "203.0.113.5:5555", realIP: "192.168.1.100", expectedCode: http.StatusForbidden},
+		{name: "Check x-forwarded-for is not trusted", remoteAddr: "10.0.0.1:5555", forwardedFor: "192.168.1.100", expectedCode: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			views := NewServerViews(service.NewService(&service.Settings{SyncSave: false, Retries: 1, BackoffFactor: 1}, repository.NewMemStorage()), Config{})
+			views.TrustedSubnet = filter
+			router := views.InitRouter()
+
+			r := httptest.NewRequest(http.MethodPost, "/update/counter/TestMetric/10", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if tt.forwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.expectedCode, w.Code, "Код ответа не совпадает с ожидаемым")
+			delta, err := views.Service.GetValue(context.Background(), "TestMetric", "counter")
+			if tt.expectedCode == http.StatusOK {
+				require.NoError(t, err)
+				assert.EqualValues(t, 10, delta)
+			} else {
+				assert.Error(t, err, "rejected request must not reach the handler")
+			}
+		})
+	}
+}
+
+func TestWithClientIdentity(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "agent-1"}}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		state      *tls.ConnectionState
+		wantClient string
 	}{
+		{name: "plain http"},
+		{name: "tls without client certificate", state: &tls.ConnectionState{}},
+		{name: "unverified certificate", state: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
 		{
-			name:          "Check ipv4 ok",
-			requestURL:    "/update/counter/TestMetric/10",
-			trustedSubnet: &net.IPNet{IP: []byte("192.168.1.0"), Mask: net.IPv4Mask(255, 255, 255, 0)},
-			remoteAddr:    "192.168.1.100",
-			expectedCode:  http.StatusOK,
-			expectedBody:  "",
+			name:       "verified certificate",
+			state:      &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}},
+			wantClient: "agent-1",
 		},
 	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			r.TLS = tt.state
+			var src audit.Source
+			var id *tlsconfig.Identity
+			WithClientIdentity(WithAuditSource(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				src = audit.SourceFromContext(req.Context())
+				id = tlsconfig.IdentityFromContext(req.Context())
+			}))).ServeHTTP(httptest.NewRecorder(), r)
+
+			assert.Equal(t, tt.wantClient, src.Client)
+			if tt.wantClient == "" {
+				assert.Nil(t, id)
+			} else {
+				require.NotNil(t, id)
+				assert.Equal(t, tt.wantClient, id.CommonName)
+			}
+		})
+	}
+}
+
+// readTestKeys читает тестовую пару RSA ключей.
+func readTestKeys(t *testing.T) (*rsa.PublicKey, *rsa.PrivateKey) {
+	t.Helper()
+	publicKey, err := os.ReadFile("../../files/rsa_public")
+	require.NoError(t, err)
+	privateKey, err := os.ReadFile("../../files/rsa_private")
+	require.NoError(t, err)
+	return common.UnmarshalRSAPublic(publicKey), common.UnmarshalRSAPrivate(privateKey)
+}
+
+func TestWithRSADecryption(t *testing.T) {
+	pub, priv := readTestKeys(t)
+	body := strings.Repeat(`{"id":"test_gauge","type":"gauge","value":1.5}`, 100)
+	envelope, err := common.EncryptEnvelope([]byte(body), pub)
+	require.NoError(t, err)
+	legacy, err := common.EncryptRSA("hello world", pub)
+	require.NoError(t, err)
 
+	tests := []struct {
+		name     string
+		key      *rsa.PrivateKey
+		method   string
+		path     string
+		scheme   string
+		keyID    string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{name: "envelope", key: priv, method: http.MethodPost, scheme: common.EnvelopeV1, body: string(envelope), wantCode: http.StatusOK, wantBody: body},
+		{name: "legacy rsa without header", key: priv, method: http.MethodPost, body: legacy, wantCode: http.StatusOK, wantBody: "hello world"},
+		{name: "legacy rsa on batch route", key: priv, method: http.MethodPost, path: "/updates/", body: legacy, wantCode: http.StatusOK, wantBody: "hello world"},
+		{name: "plain body on other route", key: priv, method: http.MethodPost, path: "/api/v1/write", body: "plain", wantCode: http.StatusOK, wantBody: "plain"},
+		{name: "envelope on other route", key: priv, method: http.MethodPost, path: "/influx/write", scheme: common.EnvelopeV1, body: string(envelope), wantCode: http.StatusOK, wantBody: body},
+		{name: "get is not decrypted", key: priv, method: http.MethodGet, body: "plain", wantCode: http.StatusOK, wantBody: "plain"},
+		{name: "no key and no header", method: http.MethodPost, body: "plain", wantCode: http.StatusOK, wantBody: "plain"},
+		{name: "unknown scheme", key: priv, method: http.MethodPost, scheme: "rot13", body: "plain", wantCode: http.StatusBadRequest},
+		{name: "envelope without server key", method: http.MethodPost, scheme: common.EnvelopeV1, body: string(envelope), wantCode: http.StatusBadRequest},
+		{name: "unknown key id", key: priv, method: http.MethodPost, scheme: common.EnvelopeV1, keyID: "rsa-2", body: string(envelope), wantCode: http.StatusBadRequest},
+		{name: "default key by id", key: priv, method: http.MethodPost, scheme: common.EnvelopeV1, keyID: keyring.DefaultID, body: string(envelope), wantCode: http.StatusOK, wantBody: body},
+		{name: "broken envelope", key: priv, method: http.MethodPost, scheme: common.EnvelopeV1, body: string(envelope[:100]), wantCode: http.StatusBadRequest},
+	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			views := NewServerViews(service.NewService(&service.Settings{SyncSave: false}, repository.NewMemStorage()))
-			views.TrustedSubnet = tt.trustedSubnet
-			srv := httptest.NewServer(views.InitRouter())
-			defer srv.Close()
+			path := tt.path
+			if path == "" {
+				path = "/update/"
+			}
+			r := httptest.NewRequest(tt.method, path, strings.NewReader(tt.body))
+			if tt.scheme != "" {
+				r.Header.Set(common.EncryptionHeader, tt.scheme)
+			}
+			if tt.keyID != "" {
+				r.Header.Set(common.EncryptionKeyIDHeader, tt.keyID)
+			}
+			w := httptest.NewRecorder()
+			var keys *keyring.Keyring
+			if tt.key != nil {
+				keys = keyring.New(0)
+				require.NoError(t, keys.Add(keyring.WithPrivateKey(keyring.DefaultID, tt.key)))
+			}
+			WithRSADecryption(keys)(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				assert.Empty(t, req.Header.Get(common.EncryptionHeader))
+				assert.Empty(t, req.Header.Get(common.EncryptionKeyIDHeader))
+				b, _ := io.ReadAll(req.Body)
+				res.Write(b)
+			})).ServeHTTP(w, r)
 
-			r := httptest.NewRequest(http.MethodPost, srv.URL+tt.requestURL, nil)
-			r.RemoteAddr = tt.remoteAddr
-			r.RequestURI = ""
+			assert.Equal(t, tt.wantCode, w.Code)
+			if tt.wantCode == http.StatusOK {
+				assert.Equal(t, tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
 
-			resp, err := http.DefaultClient.Do(r)
-			status := resp.StatusCode
-			assert.Equal(t, tt.expectedCode, status, "Код ответа не совпадает с ожидаемым")
-			require.NoError(t, err)
-			defer resp.Body.Close()
+func TestRequireSign(t *testing.T) {
+	keys := keyring.New(0)
+	require.NoError(t, keys.Add(keyring.Key{ID: keyring.DefaultID, Type: keyring.TypeHMAC, Secret: "secret"}))
+	agents, err := agentkeys.NewFileStore(filepath.Join(t.TempDir(), "agents.json"))
+	require.NoError(t, err)
 
-			_, err = io.ReadAll(resp.Body)
-			require.NoError(t, err)
+	tests := []struct {
+		name     string
+		verifier *signing.Verifier
+		verified bool
+		wantCode int
+	}{
+		{name: "no keys", verifier: signing.NewVerifier(nil, 0, false), wantCode: http.StatusOK},
+		{name: "no verifier", wantCode: http.StatusOK},
+		{name: "unsigned with keys", verifier: signing.NewVerifier(keys, 0, false), wantCode: http.StatusUnauthorized},
+		{name: "signed with keys", verifier: signing.NewVerifier(keys, 0, false), verified: true, wantCode: http.StatusOK},
+		{name: "unsigned allowed", verifier: signing.NewVerifier(keys, 0, false).AllowUnsigned(true), wantCode: http.StatusOK},
+		{name: "unsigned with agent registry", verifier: signing.NewVerifier(nil, 0, false).WithAgents(agents), wantCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+			if tt.verified {
+				r = r.WithContext(context.WithValue(r.Context(), signVerifiedKey{}, true))
+			}
+			w := httptest.NewRecorder()
+			RequireSign(tt.verifier)(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {})).ServeHTTP(w, r)
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
+
+func TestCheckSign(t *testing.T) {
+	retired := time.Now().Add(-time.Minute)
+	expired := time.Now().Add(-2 * time.Hour)
+	keys := keyring.New(time.Hour)
+	require.NoError(t, keys.Add(keyring.Key{ID: "new", Type: keyring.TypeHMAC, Secret: "new-secret"}))
+	require.NoError(t, keys.Add(keyring.Key{ID: "old", Type: keyring.TypeHMAC, Secret: "old-secret", RetiredAt: &retired}))
+	require.NoError(t, keys.Add(keyring.Key{ID: "ancient", Type: keyring.TypeHMAC, Secret: "ancient-secret", RetiredAt: &expired}))
+	require.NoError(t, keys.Add(keyring.Key{ID: keyring.DefaultID, Type: keyring.TypeHMAC, Secret: "legacy-secret"}))
+
+	body := `[{"id":"PollCount","type":"counter","delta":3}]`
+	sign := func(secret, keyID string) map[string]string {
+		headers, err := signing.Sign(secret, keyID, http.MethodPost, "/updates/", "", []byte(body))
+		require.NoError(t, err)
+		return headers
+	}
+	stale := func(secret string, at time.Time) map[string]string {
+		ts := at.Unix()
+		return map[string]string{
+			signing.HeaderTimestamp: strconv.FormatInt(ts, 10),
+			signing.HeaderNonce:     "0123456789abcdef0123456789abcdef",
+			signing.HeaderSignature: signing.Sum(secret, http.MethodPost, "/updates/", ts, "0123456789abcdef0123456789abcdef", "", []byte(body)),
+		}
+	}
+	replayed := sign("new-secret", "new")
+	signBatch := func(batchID string) map[string]string {
+		headers, err := signing.Sign("new-secret", "new", http.MethodPost, "/updates/", batchID, []byte(body))
+		require.NoError(t, err)
+		return headers
+	}
+	tampered := signBatch("agent-1:7")
+	tampered[common.BatchIDHeader] = "agent-1:8"
+	agents, err := agentkeys.NewFileStore(filepath.Join(t.TempDir(), "agents.json"))
+	require.NoError(t, err)
+	for _, id := range []string{"web-01", "web-02"} {
+		require.NoError(t, agents.Add(context.Background(), &agentkeys.Agent{ID: id, Secret: id + "-secret"}))
+	}
+	require.NoError(t, agents.Revoke(context.Background(), "web-02"))
+	signAgent := func(agentID string) map[string]string {
+		headers := sign(agentID+"-secret", "")
+		headers[common.AgentIDHeader] = agentID
+		return headers
+	}
+
+	tests := []struct {
+		name         string
+		legacy       bool
+		agents       bool
+		path         string
+		headers      map[string]string
+		wantCode     int
+		wantVerified bool
+	}{
+		{name: "no signature", wantCode: http.StatusOK},
+		{name: "current key", headers: sign("new-secret", "new"), wantCode: http.StatusOK, wantVerified: true},
+		{name: "retired key in grace period", headers: sign("old-secret", "old"), wantCode: http.StatusOK, wantVerified: true},
+		{name: "default key without id", headers: sign("legacy-secret", ""), wantCode: http.StatusOK, wantVerified: true},
+		{name: "expired key", headers: sign("ancient-secret", "ancient"), wantCode: http.StatusBadRequest},
+		{name: "unknown key", headers: sign("new-secret", "future"), wantCode: http.StatusBadRequest},
+		{name: "signed by another key", headers: sign("old-secret", "new"), wantCode: http.StatusBadRequest},
+		{name: "signed for another path", path: "/update/counter/PollCount/3", headers: sign("new-secret", "new"), wantCode: http.StatusBadRequest},
+		{name: "timestamp too old", headers: stale("legacy-secret", time.Now().Add(-10*time.Minute)), wantCode: http.StatusBadRequest},
+		{name: "timestamp in future", headers: stale("legacy-secret", time.Now().Add(10*time.Minute)), wantCode: http.StatusBadRequest},
+		{name: "first delivery", headers: replayed, wantCode: http.StatusOK, wantVerified: true},
+		{name: "replay", headers: replayed, wantCode: http.StatusConflict},
+		{name: "signed batch id", headers: signBatch("agent-1:7"), wantCode: http.StatusOK, wantVerified: true},
+		{name: "batch id replaced", headers: tampered, wantCode: http.StatusBadRequest},
+		{name: "agent secret", agents: true, headers: signAgent("web-01"), wantCode: http.StatusOK, wantVerified: true},
+		{name: "revoked agent", agents: true, headers: signAgent("web-02"), wantCode: http.StatusForbidden},
+		{name: "revoked agent without agent id", agents: true, headers: sign("legacy-secret", ""), wantCode: http.StatusUnauthorized},
+		{name: "legacy signature is disabled", headers: map[string]string{signing.HeaderLegacy: signing.LegacySum("legacy-secret", []byte(body))}, wantCode: http.StatusBadRequest},
+		{name: "legacy signature", legacy: true, headers: map[string]string{signing.HeaderLegacy: signing.LegacySum("legacy-secret", []byte(body))}, wantCode: http.StatusOK, wantVerified: true},
+		{name: "legacy signature by key id", legacy: true, headers: map[string]string{signing.HeaderLegacy: signing.LegacySum("new-secret", []byte(body)), common.SignKeyIDHeader: "new"}, wantCode: http.StatusOK, wantVerified: true},
+		{name: "bad legacy signature", legacy: true, headers: map[string]string{signing.HeaderLegacy: "zz"}, wantCode: http.StatusBadRequest},
+	}
+	strict, legacy := signing.NewVerifier(keys, 0, false), signing.NewVerifier(keys, 0, true)
+	registry := signing.NewVerifier(keys, 0, false).WithAgents(agents)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tt.path
+			if path == "" {
+				path = "/updates/"
+			}
+			r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			verifier := strict
+			switch {
+			case tt.legacy:
+				verifier = legacy
+			case tt.agents:
+				verifier = registry
+			}
+			w := httptest.NewRecorder()
+			var verified bool
+			CheckSign(verifier)(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				verified, _ = req.Context().Value(signVerifiedKey{}).(bool)
+				b, _ := io.ReadAll(req.Body)
+				assert.Equal(t, body, string(b))
+			})).ServeHTTP(w, r)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Equal(t, tt.wantVerified, verified)
 		})
 	}
 }
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/sebasttiano/Blackbird.git/internal/ingest/otlp"
+	"github.com/sebasttiano/Blackbird.git/internal/logger"
+	"github.com/sebasttiano/Blackbird.git/internal/service"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"go.uber.org/zap"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+)
+
+// OTLPExport принимает метрики по протоколу OTLP/HTTP в формате protobuf или JSON.
+// Ответ и ошибки (google.rpc.Status) кодируются в формате запроса.
+func (s *ServerViews) OTLPExport(res http.ResponseWriter, req *http.Request) {
+	contentType := req.Header.Get("Content-Type")
+	in, err := otlp.DecodeRequest(req.Body, contentType)
+	if err != nil {
+		logger.Log.Error("couldn`t decode otlp request", zap.Error(err))
+		code := http.StatusBadRequest
+		if errors.Is(err, otlp.ErrContentType) {
+			code, contentType = http.StatusUnsupportedMediaType, otlp.ContentTypeProtobuf
+		}
+		otlpResponse(res, contentType, code, &spb.Status{Code: int32(codes.InvalidArgument), Message: err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancel()
+
+	result, err := s.OTLPReceiver.Export(ctx, in)
+	if err != nil {
+		logger.Log.Error("couldn`t save otlp metrics", zap.Error(err))
+		if errors.Is(err, service.ErrBatchRejected) {
+			otlpResponse(res, contentType, http.StatusBadRequest, &spb.Status{Code: int32(codes.InvalidArgument), Message: err.Error()})
+			return
+		}
+		otlpResponse(res, contentType, http.StatusServiceUnavailable, &spb.Status{Code: int32(codes.Unavailable), Message: "failed to save metrics"})
+		return
+	}
+	logger.Log.Debug("otlp metrics saved", zap.Int("stored", result.Stored), zap.Int64("rejected", result.Rejected))
+	otlpResponse(res, contentType, http.StatusOK, result.Response())
+}
+
+// otlpResponse пишет сообщение ответа OTLP/HTTP.
+func otlpResponse(res http.ResponseWriter, contentType string, code int, msg proto.Message) {
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == otlp.ContentTypeJSON {
+		contentType = otlp.ContentTypeJSON
+	} else {
+		contentType = otlp.ContentTypeProtobuf
+	}
+	body, err := otlp.EncodeResponse(contentType, msg)
+	if err != nil {
+		logger.Log.Error("couldn`t encode otlp response", zap.Error(err))
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("Content-Type", contentType)
+	res.WriteHeader(code)
+	if _, err := res.Write(body); err != nil {
+		logger.Log.Error("couldn`t write otlp response", zap.Error(err))
+	}
+}
+
+// OTLPServer реализует gRPC сервис OTLP MetricsService.
+type OTLPServer struct {
+	Receiver *otlp.Receiver
+	colmetricspb.UnimplementedMetricsServiceServer
+}
+
+// Export сохраняет метрики запроса OTLP/gRPC.
+func (o *OTLPServer) Export(ctx context.Context, in *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	result, err := o.Receiver.Export(ctx, in)
+	if err != nil {
+		logger.Log.Error("couldn`t save otlp metrics", zap.Error(err))
+		if service.KindOf(err) == nil {
+			// по спецификации OTLP клиент повторяет только Unavailable и подобные коды
+			err = service.Errorf(service.ErrUnavailable, "failed to save metrics")
+		}
+		return nil, grpcError(err)
+	}
+	return result.Response(), nil
+}
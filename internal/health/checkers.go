@@ -0,0 +1,84 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// probeSize сколько байт пишет проверка записи снимка. Запись падает, если на диске нет места.
+const probeSize = 4096
+
+// Pinger зависимость, которую можно проверить пингом, например *sqlx.DB.
+type Pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// Ping проверяет соединение с базой данных.
+func Ping(db Pinger) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		if err := db.PingContext(ctx); err != nil {
+			return fmt.Errorf("database is unavailable: %w", err)
+		}
+		return nil
+	})
+}
+
+// WritableDir проверяет, что в каталог снимка метрик можно записать файл: каталог существует,
+// доступен на запись и на диске есть место.
+func WritableDir(dir string) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		f, err := os.CreateTemp(dir, ".healthcheck-*")
+		if err != nil {
+			return fmt.Errorf("snapshot directory is not writable: %w", err)
+		}
+		defer os.Remove(f.Name())
+
+		_, err = f.Write(make([]byte, probeSize))
+		if err == nil {
+			err = f.Sync()
+		}
+		if errClose := f.Close(); err == nil {
+			err = errClose
+		}
+		if err != nil {
+			return fmt.Errorf("couldn`t write to snapshot directory: %w", err)
+		}
+		return nil
+	})
+}
+
+// Flag проверка, которая проходит после вызова Done, например по окончании восстановления метрик.
+// Fail оставляет проверку непройденной с причиной сбоя.
+type Flag struct {
+	done   atomic.Bool
+	reason atomic.Pointer[error]
+}
+
+// NewFlag конструктор для Flag, reason возвращается как ошибка проверки до вызова Done.
+func NewFlag(reason string) *Flag {
+	f := &Flag{}
+	err := errors.New(reason)
+	f.reason.Store(&err)
+	return f
+}
+
+// Done отмечает, что условие выполнено.
+func (f *Flag) Done() {
+	f.done.Store(true)
+}
+
+// Fail отмечает, что условие не выполнится, проверка возвращает err.
+func (f *Flag) Fail(err error) {
+	f.reason.Store(&err)
+}
+
+// Check метод интерфейса Checker.
+func (f *Flag) Check(ctx context.Context) error {
+	if f.done.Load() {
+		return nil
+	}
+	return *f.reason.Load()
+}
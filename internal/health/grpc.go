@@ -0,0 +1,78 @@
+package health
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// WatchInterval как часто Watch перепроверяет состояние.
+const WatchInterval = 5 * time.Second
+
+// GRPCServer реализует стандартный gRPC сервис grpc.health.v1.Health поверх Health.
+// Пустое имя сервиса означает все проверки, иначе имя сервиса это имя одной проверки.
+type GRPCServer struct {
+	health   *Health
+	interval time.Duration
+	healthpb.UnimplementedHealthServer
+}
+
+// NewGRPCServer конструктор для GRPCServer.
+func NewGRPCServer(h *Health) *GRPCServer {
+	return &GRPCServer{health: h, interval: WatchInterval}
+}
+
+// Check возвращает текущее состояние сервиса.
+func (g *GRPCServer) Check(ctx context.Context, in *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	st, err := g.status(ctx, in.Service)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", in.Service)
+	}
+	return &healthpb.HealthCheckResponse{Status: st}, nil
+}
+
+// Watch отправляет состояние сервиса сразу и затем при каждом его изменении.
+func (g *GRPCServer) Watch(in *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+
+	last := healthpb.HealthCheckResponse_UNKNOWN
+	for first := true; ; first = false {
+		st, err := g.status(stream.Context(), in.Service)
+		if err != nil {
+			st = healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+		}
+		if first || st != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: st}); err != nil {
+				return err
+			}
+			last = st
+		}
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// status переводит результат проверок в статус gRPC health.
+func (g *GRPCServer) status(ctx context.Context, service string) (healthpb.HealthCheckResponse_ServingStatus, error) {
+	up := false
+	if service == "" {
+		up = g.health.Check(ctx).Up()
+	} else {
+		result, err := g.health.CheckOne(ctx, service)
+		if err != nil {
+			return healthpb.HealthCheckResponse_SERVICE_UNKNOWN, err
+		}
+		up = result.Status == StatusUp
+	}
+	if up {
+		return healthpb.HealthCheckResponse_SERVING, nil
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING, nil
+}
@@ -0,0 +1,143 @@
+// Package health проверяет готовность сервера: базу данных, запись снимка метрик, gRPC сервер и восстановление метрик.
+// Результат отдается REST эндпоинтами /healthz и /readyz и стандартным gRPC сервисом grpc.health.v1.Health.
+package health
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Статусы проверок.
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// DefaultTimeout сколько ждать одну проверку, если таймаут не задан.
+const DefaultTimeout = 2 * time.Second
+
+// ErrUnknownCheck ошибка, если проверки с таким именем нет.
+var ErrUnknownCheck = errors.New("unknown health check")
+
+// Checker проверка одной зависимости сервера. nil означает, что зависимость готова.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc функция-проверка.
+type CheckerFunc func(ctx context.Context) error
+
+// Check метод интерфейса Checker.
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Result результат одной проверки.
+type Result struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// Report результат всех проверок. Сервер готов, если все проверки прошли.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Up возвращает true, если все проверки прошли.
+func (r Report) Up() bool {
+	return r.Status == StatusUp
+}
+
+// Health набор именованных проверок готовности. Проверки можно добавлять во время работы сервера.
+type Health struct {
+	mu      sync.RWMutex
+	checks  map[string]Checker
+	timeout time.Duration
+}
+
+// New конструктор для Health. Нулевой timeout заменяется на DefaultTimeout.
+func New(timeout time.Duration) *Health {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Health{checks: make(map[string]Checker), timeout: timeout}
+}
+
+// Register добавляет проверку, проверка с тем же именем заменяется.
+func (h *Health) Register(name string, checker Checker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks[name] = checker
+}
+
+// Names возвращает имена проверок, отсортированные.
+func (h *Health) Names() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	names := make([]string, 0, len(h.checks))
+	for name := range h.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check выполняет все проверки параллельно, каждую со своим таймаутом.
+func (h *Health) Check(ctx context.Context) Report {
+	h.mu.RLock()
+	checks := make(map[string]Checker, len(h.checks))
+	for name, checker := range h.checks {
+		checks[name] = checker
+	}
+	h.mu.RUnlock()
+
+	report := Report{Status: StatusUp, Checks: make(map[string]Result, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, checker := range checks {
+		wg.Add(1)
+		go func(name string, checker Checker) {
+			defer wg.Done()
+			result := h.run(ctx, checker)
+			mu.Lock()
+			report.Checks[name] = result
+			if result.Status != StatusUp {
+				report.Status = StatusDown
+			}
+			mu.Unlock()
+		}(name, checker)
+	}
+	wg.Wait()
+	return report
+}
+
+// CheckOne выполняет одну проверку по имени.
+func (h *Health) CheckOne(ctx context.Context, name string) (Result, error) {
+	h.mu.RLock()
+	checker, ok := h.checks[name]
+	h.mu.RUnlock()
+	if !ok {
+		return Result{}, ErrUnknownCheck
+	}
+	return h.run(ctx, checker), nil
+}
+
+// run выполняет проверку с таймаутом и замеряет ее длительность.
+func (h *Health) run(ctx context.Context, checker Checker) Result {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := checker.Check(ctx)
+	result := Result{Status: StatusUp, DurationMS: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	return result
+}
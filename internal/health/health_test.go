@@ -0,0 +1,148 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type pinger struct {
+	err error
+}
+
+func (p pinger) PingContext(ctx context.Context) error {
+	return p.err
+}
+
+func TestHealth_Check(t *testing.T) {
+	slow := CheckerFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	tests := []struct {
+		name       string
+		checks     map[string]Checker
+		wantStatus string
+		wantErrors map[string]string
+	}{
+		{
+			name:       "no checks",
+			wantStatus: StatusUp,
+			wantErrors: map[string]string{},
+		},
+		{
+			name:       "all up",
+			checks:     map[string]Checker{"db": Ping(pinger{}), "snapshot": WritableDir(t.TempDir())},
+			wantStatus: StatusUp,
+			wantErrors: map[string]string{"db": "", "snapshot": ""},
+		},
+		{
+			name:       "db down",
+			checks:     map[string]Checker{"db": Ping(pinger{err: errors.New("connection refused")}), "snapshot": WritableDir(t.TempDir())},
+			wantStatus: StatusDown,
+			wantErrors: map[string]string{"db": "database is unavailable: connection refused", "snapshot": ""},
+		},
+		{
+			name:       "timeout",
+			checks:     map[string]Checker{"slow": slow},
+			wantStatus: StatusDown,
+			wantErrors: map[string]string{"slow": context.DeadlineExceeded.Error()},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(50 * time.Millisecond)
+			for name, checker := range tt.checks {
+				h.Register(name, checker)
+			}
+			report := h.Check(context.Background())
+			assert.Equal(t, tt.wantStatus, report.Status)
+			require.Len(t, report.Checks, len(tt.wantErrors))
+			for name, wantErr := range tt.wantErrors {
+				assert.Equal(t, wantErr, report.Checks[name].Error, name)
+			}
+		})
+	}
+}
+
+func TestWritableDir(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, WritableDir(dir).Check(context.Background()))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "probe file must be removed")
+
+	assert.Error(t, WritableDir(filepath.Join(dir, "missing")).Check(context.Background()))
+}
+
+func TestFlag(t *testing.T) {
+	f := NewFlag("metrics are not restored yet")
+	assert.EqualError(t, f.Check(context.Background()), "metrics are not restored yet")
+	f.Done()
+	assert.NoError(t, f.Check(context.Background()))
+
+	failed := NewFlag("metrics are not restored yet")
+	failed.Fail(errors.New("restore failed: unexpected end of JSON input"))
+	assert.EqualError(t, failed.Check(context.Background()), "restore failed: unexpected end of JSON input")
+}
+
+func TestGRPCServer(t *testing.T) {
+	restored := NewFlag("metrics are not restored yet")
+	h := New(0)
+	h.Register("db", Ping(pinger{}))
+	h.Register("restore", restored)
+
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	srv := NewGRPCServer(h)
+	srv.interval = 10 * time.Millisecond
+	healthpb.RegisterHealthServer(s, srv)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough://bufnet", grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+	ctx := context.Background()
+
+	resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+
+	resp, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "db"})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "cache"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	watchCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	stream, err := client.Watch(watchCtx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	resp, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+
+	restored.Done()
+	resp, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+}
@@ -0,0 +1,101 @@
+// Package graphite принимает метрики по plaintext и pickle протоколам Graphite.
+// Путь через точку превращается в имя метрики Blackbird, шаблоны позволяют выделить из пути метки.
+package graphite
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/sebasttiano/Blackbird.git/internal/models"
+)
+
+// Типы метрик, в которые пишутся значения Graphite.
+const (
+	// TypeGauge значение сохраняется как gauge, последнее по времени побеждает.
+	TypeGauge = "gauge"
+	// TypeCounter значение считается приращением и округляется до целого.
+	TypeCounter = "counter"
+)
+
+// Mapper строит имя и тип метрики Blackbird по пути Graphite. Применяется первый подходящий шаблон.
+type Mapper struct {
+	templates []Template
+}
+
+// NewMapper конструктор для Mapper.
+func NewMapper(templates []Template) *Mapper {
+	return &Mapper{templates: templates}
+}
+
+// Map возвращает имя метрики: узлы measurement, значения меток в порядке шаблона,
+// затем метки tagged series по алфавиту ключей и узлы field, все через точку.
+// Без подходящего шаблона или если шаблон не оставил ни одного узла имя совпадает с путем.
+func (m *Mapper) Map(path string) (string, string) {
+	nodes, pathTags := SplitPath(path)
+	measurement, tags, field, mtype := nodes, []Tag(nil), []string(nil), ""
+	for _, t := range m.templates {
+		if t.Match(nodes) {
+			measurement, tags, field = t.Apply(nodes)
+			mtype = t.Type
+			break
+		}
+	}
+	if mtype == "" {
+		mtype = TypeGauge
+	}
+
+	parts := make([]string, 0, len(nodes)+len(pathTags))
+	parts = append(parts, measurement...)
+	for _, tag := range append(tags, pathTags...) {
+		parts = append(parts, tag.Value)
+	}
+	parts = append(parts, field...)
+	if len(parts) == 0 {
+		parts = nodes
+	}
+	return strings.Join(parts, "."), mtype
+}
+
+// batch копит метрики: приращения counter складываются, для gauge остается значение с самой поздней меткой.
+type batch struct {
+	metrics []*models.Metrics
+	index   map[string]int
+	times   map[string]time.Time
+}
+
+func newBatch() *batch {
+	return &batch{index: make(map[string]int), times: make(map[string]time.Time)}
+}
+
+func (b *batch) len() int {
+	return len(b.metrics)
+}
+
+func (b *batch) add(name, mtype string, s Sample) {
+	key := mtype + ":" + name
+	i, ok := b.index[key]
+	if mtype == TypeCounter {
+		delta := int64(math.Round(s.Value))
+		if ok {
+			*b.metrics[i].Delta += delta
+			return
+		}
+		b.index[key] = len(b.metrics)
+		b.metrics = append(b.metrics, &models.Metrics{ID: name, MType: TypeCounter, Delta: &delta})
+		return
+	}
+
+	value := s.Value
+	if ok {
+		if s.Time.Before(b.times[key]) {
+			return
+		}
+		*b.metrics[i].Value = value
+		b.times[key] = s.Time
+		return
+	}
+	b.index[key] = len(b.metrics)
+	b.times[key] = s.Time
+	b.metrics = append(b.metrics, &models.Metrics{ID: name, MType: TypeGauge, Value: &value})
+}
@@ -0,0 +1,181 @@
+package graphite
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sebasttiano/Blackbird.git/internal/ingest/ingesttest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Сообщения сериализованы python pickle.dumps([("servers.web01.cpu.load", (1700000000, 1.5)), ("jobs.count", (1700000000, 7))]).
+var pickled = map[string][]byte{
+	"protocol 0": []byte("(lp0\n(Vservers.web01.cpu.load\np1\n(I1700000000\nF1.5\ntp2\ntp3\na(Vjobs.count\np4\n(I1700000000\nI7\ntp5\ntp6\na."),
+	"protocol 2": []byte("\x80\x02]q\x00(X\x16\x00\x00\x00servers.web01.cpu.loadq\x01J\x00\xf1SeG?\xf8\x00\x00\x00\x00\x00\x00\x86q\x02\x86q\x03X\n\x00\x00\x00jobs.countq\x04J\x00\xf1SeK\x07\x86q\x05\x86q\x06e."),
+	"protocol 4": []byte("\x80\x04\x95H\x00\x00\x00\x00\x00\x00\x00]\x94(\x8c\x16servers.web01.cpu.load\x94J\x00\xf1SeG?\xf8\x00\x00\x00\x00\x00\x00\x86\x94\x86\x94\x8c\njobs.count\x94J\x00\xf1SeK\x07\x86\x94\x86\x94e."),
+}
+
+func TestParseLine(t *testing.T) {
+	now := time.Unix(1700000100, 0)
+	tests := []struct {
+		name    string
+		line    string
+		want    Sample
+		wantErr bool
+	}{
+		{name: "OK full", line: "servers.web01.cpu 0.5 1700000000", want: Sample{Path: "servers.web01.cpu", Value: 0.5, Time: time.Unix(1700000000, 0)}},
+		{name: "OK no timestamp", line: "jobs.count 3", want: Sample{Path: "jobs.count", Value: 3, Time: now}},
+		{name: "OK timestamp -1", line: "jobs.count 3 -1", want: Sample{Path: "jobs.count", Value: 3, Time: now}},
+		{name: "OK tagged", line: "disk.free;host=a 10 1700000000", want: Sample{Path: "disk.free;host=a", Value: 10, Time: time.Unix(1700000000, 0)}},
+		{name: "NOT OK value", line: "jobs.count abc 1700000000", wantErr: true},
+		{name: "NOT OK nan", line: "jobs.count nan 1700000000", wantErr: true},
+		{name: "NOT OK timestamp", line: "jobs.count 1 yesterday", wantErr: true},
+		{name: "NOT OK fields", line: "jobs.count", wantErr: true},
+		{name: "NOT OK empty path", line: ".. 1 1700000000", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLine(tt.line, now)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrParse)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    Template
+		wantErr bool
+	}{
+		{name: "OK pattern", line: "measurement.host", want: Template{Parts: []string{"measurement", "host"}}},
+		{name: "OK pattern and type", line: "measurement* counter", want: Template{Parts: []string{"measurement*"}, Type: TypeCounter}},
+		{name: "OK filter", line: "servers.* .host.measurement*", want: Template{Filter: []string{"servers", "*"}, Parts: []string{"", "host", "measurement*"}}},
+		{name: "OK filter and type", line: "stats.* _.measurement* counter", want: Template{Filter: []string{"stats", "*"}, Parts: []string{"_", "measurement*"}, Type: TypeCounter}},
+		{name: "NOT OK type", line: "a.* measurement* histogram", wantErr: true},
+		{name: "NOT OK no measurement", line: "host.field", wantErr: true},
+		{name: "NOT OK measurement* not last", line: "measurement*.host", wantErr: true},
+		{name: "NOT OK bad filter", line: "a.[ measurement", wantErr: true},
+		{name: "NOT OK too many fields", line: "a b c d", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTemplate(tt.line)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidTemplate)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMapper_Map(t *testing.T) {
+	var templates []Template
+	for _, line := range []string{
+		"servers.* .host.measurement*",
+		"stats.counters.* ..measurement.field counter",
+		"env.app.measurement*",
+	} {
+		tmpl, err := ParseTemplate(line)
+		require.NoError(t, err)
+		templates = append(templates, tmpl)
+	}
+	m := NewMapper(templates)
+
+	tests := []struct {
+		path     string
+		wantName string
+		wantType string
+	}{
+		{path: "servers.web01.cpu.load", wantName: "cpu.load.web01", wantType: TypeGauge},
+		{path: "stats.counters.requests.count", wantName: "requests.count", wantType: TypeCounter},
+		{path: "prod.api.latency.p99", wantName: "latency.p99.prod.api", wantType: TypeGauge},
+		{path: "prod.api.latency;dc=eu;az=1", wantName: "latency.prod.api.1.eu", wantType: TypeGauge},
+		{path: "servers", wantName: "servers", wantType: TypeGauge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			name, mtype := m.Map(tt.path)
+			assert.Equal(t, tt.wantName, name)
+			assert.Equal(t, tt.wantType, mtype)
+		})
+	}
+
+	name, mtype := NewMapper(nil).Map("a..b.c;host=x")
+	assert.Equal(t, "a.b.c.x", name)
+	assert.Equal(t, TypeGauge, mtype)
+}
+
+func TestDecodePickle(t *testing.T) {
+	want := []Sample{
+		{Path: "servers.web01.cpu.load", Value: 1.5, Time: time.Unix(1700000000, 0)},
+		{Path: "jobs.count", Value: 7, Time: time.Unix(1700000000, 0)},
+	}
+	for name, data := range pickled {
+		t.Run(name, func(t *testing.T) {
+			got, err := DecodePickle(data, time.Now())
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+		})
+	}
+
+	_, err := DecodePickle([]byte("\x80\x02]q\x00"), time.Now())
+	assert.ErrorIs(t, err, ErrPickle)
+	_, err = DecodePickle([]byte("\x80\x02}q\x00."), time.Now())
+	assert.ErrorIs(t, err, ErrPickle)
+}
+
+func TestServer(t *testing.T) {
+	w := ingesttest.NewWriter()
+	tmpl, err := ParseTemplate("jobs.* measurement.field counter")
+	require.NoError(t, err)
+	s := NewServer("127.0.0.1:0", "127.0.0.1:0", NewMapper([]Template{tmpl}), w)
+	require.NoError(t, s.Listen())
+	go s.Serve()
+
+	plain, err := net.Dial("tcp", s.Addr())
+	require.NoError(t, err)
+	_, err = plain.Write([]byte("jobs.runs 2 1700000000\njobs.runs 3 1700000001\ntemp.room 21.5 1700000000\nbroken\n"))
+	require.NoError(t, err)
+	plain.Close()
+
+	pickle, err := net.Dial("tcp", s.PickleAddr())
+	require.NoError(t, err)
+	msg := pickled["protocol 2"]
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(msg)))
+	_, err = pickle.Write(append(header, msg...))
+	require.NoError(t, err)
+	pickle.Close()
+
+	assert.Eventually(t, func() bool {
+		require.NoError(t, s.Flush(context.Background()))
+		return w.Value("jobs.count") == 7 && w.Value("jobs.runs") == 5 && s.BadLines() == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, s.Shutdown(context.Background()))
+	assert.Equal(t, map[string]float64{"jobs.runs": 5, "jobs.count": 7, "temp.room": 21.5, "servers.web01.cpu.load": 1.5}, w.Values())
+}
+
+func TestBatch_add(t *testing.T) {
+	b := newBatch()
+	b.add("hits", TypeCounter, Sample{Value: 1.4})
+	b.add("hits", TypeCounter, Sample{Value: 2})
+	b.add("temp", TypeGauge, Sample{Value: 21.5, Time: time.Unix(20, 0)})
+	b.add("temp", TypeGauge, Sample{Value: 20, Time: time.Unix(10, 0)})
+
+	require.Equal(t, 2, b.len())
+	assert.Equal(t, int64(3), *b.metrics[0].Delta)
+	assert.Equal(t, 21.5, *b.metrics[1].Value)
+}
@@ -0,0 +1,92 @@
+package graphite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrParse ошибка разбора строки протокола Graphite.
+var ErrParse = errors.New("failed to parse graphite line")
+
+// Tag метка ряда: из шаблона или из пути в формате Graphite tagged series (path;key=value).
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// Sample одно значение из протокола Graphite.
+type Sample struct {
+	Path  string
+	Value float64
+	Time  time.Time
+}
+
+// ParseLine разбирает строку plaintext протокола "path value [timestamp]".
+// Отсутствующая метка времени или -1 заменяются на now.
+func ParseLine(line string, now time.Time) (Sample, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 && len(fields) != 3 {
+		return Sample{}, fmt.Errorf("%w: %q: expected \"path value timestamp\"", ErrParse, line)
+	}
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return Sample{}, fmt.Errorf("%w: %q: bad value", ErrParse, line)
+	}
+	s := Sample{Path: fields[0], Value: value, Time: now}
+	if len(fields) == 3 {
+		ts, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return Sample{}, fmt.Errorf("%w: %q: bad timestamp", ErrParse, line)
+		}
+		s.Time = timestamp(ts, now)
+	}
+	if err := s.validate(); err != nil {
+		return Sample{}, fmt.Errorf("%w: %q: %v", ErrParse, line, err)
+	}
+	return s, nil
+}
+
+// validate проверяет путь и значение.
+func (s Sample) validate() error {
+	if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+		return errors.New("value must be finite")
+	}
+	if nodes, _ := SplitPath(s.Path); len(nodes) == 0 {
+		return errors.New("empty path")
+	}
+	return nil
+}
+
+// SplitPath делит путь на узлы и метки tagged series. Пустые узлы отбрасываются.
+func SplitPath(p string) ([]string, []Tag) {
+	parts := strings.Split(p, ";")
+	var nodes []string
+	for _, node := range strings.Split(parts[0], ".") {
+		if node != "" {
+			nodes = append(nodes, node)
+		}
+	}
+	var tags []Tag
+	for _, kv := range parts[1:] {
+		key, value, ok := strings.Cut(kv, "=")
+		if ok && key != "" && value != "" {
+			tags = append(tags, Tag{Key: key, Value: value})
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Key < tags[j].Key })
+	return nodes, tags
+}
+
+// timestamp переводит секунды Unix в время, -1 означает текущее время.
+func timestamp(ts float64, now time.Time) time.Time {
+	if ts < 0 {
+		return now
+	}
+	sec, frac := math.Modf(ts)
+	return time.Unix(int64(sec), int64(frac*1e9))
+}
@@ -0,0 +1,472 @@
+package graphite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"time"
+)
+
+// MaxPickleSize максимальный размер одного pickle сообщения, как у carbon.
+const MaxPickleSize = 1 << 20
+
+// ErrPickle ошибка разбора сообщения pickle протокола.
+var ErrPickle = errors.New("failed to decode graphite pickle")
+
+// Опкоды pickle, которые встречаются в сообщениях carbon (протоколы 0-4).
+const (
+	opMark            = '('
+	opStop            = '.'
+	opPop             = '0'
+	opPopMark         = '1'
+	opFloat           = 'F'
+	opInt             = 'I'
+	opBinInt          = 'J'
+	opBinInt1         = 'K'
+	opBinInt2         = 'M'
+	opLong            = 'L'
+	opNone            = 'N'
+	opString          = 'S'
+	opBinString       = 'T'
+	opShortBinString  = 'U'
+	opUnicode         = 'V'
+	opBinUnicode      = 'X'
+	opAppend          = 'a'
+	opGet             = 'g'
+	opBinGet          = 'h'
+	opLongBinGet      = 'j'
+	opList            = 'l'
+	opEmptyList       = ']'
+	opAppends         = 'e'
+	opPut             = 'p'
+	opBinPut          = 'q'
+	opLongBinPut      = 'r'
+	opTuple           = 't'
+	opEmptyTuple      = ')'
+	opBinFloat        = 'G'
+	opProto           = 0x80
+	opTuple1          = 0x85
+	opTuple2          = 0x86
+	opTuple3          = 0x87
+	opNewTrue         = 0x88
+	opNewFalse        = 0x89
+	opLong1           = 0x8a
+	opShortBinUnicode = 0x8c
+	opBinUnicode8     = 0x8d
+	opBinBytes        = 'B'
+	opShortBinBytes   = 'C'
+	opMemoize         = 0x94
+	opFrame           = 0x95
+)
+
+// mark отметка на стеке unpickler.
+type mark struct{}
+
+// ReadPickle читает одно сообщение pickle протокола: 4 байта длины (big endian)
+// и список [(path, (timestamp, value)), ...].
+func ReadPickle(r io.Reader, now time.Time) ([]Sample, error) {
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size > MaxPickleSize {
+		return nil, fmt.Errorf("%w: message of %d bytes is too large", ErrPickle, size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return DecodePickle(data, now)
+}
+
+// DecodePickle разбирает тело pickle сообщения. Записи с некорректным путем или значением пропускаются.
+func DecodePickle(data []byte, now time.Time) ([]Sample, error) {
+	obj, err := unpickle(data)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := obj.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: expected list, got %T", ErrPickle, obj)
+	}
+
+	samples := make([]Sample, 0, len(list))
+	for _, item := range list {
+		entry, ok := item.([]any)
+		if !ok || len(entry) != 2 {
+			return nil, fmt.Errorf("%w: expected (path, (timestamp, value))", ErrPickle)
+		}
+		point, ok := entry[1].([]any)
+		if !ok || len(point) != 2 {
+			return nil, fmt.Errorf("%w: expected (timestamp, value)", ErrPickle)
+		}
+		path, okPath := entry[0].(string)
+		ts, okTS := toFloat(point[0])
+		value, okValue := toFloat(point[1])
+		if !okPath || !okTS || !okValue {
+			continue
+		}
+		s := Sample{Path: path, Value: value, Time: timestamp(ts, now)}
+		if s.validate() != nil {
+			continue
+		}
+		samples = append(samples, s)
+	}
+	return samples, nil
+}
+
+// unpickle минимальная стековая машина pickle. Поддерживает только списки, кортежи,
+// строки и числа - этого достаточно для сообщений carbon. Кортежи представлены как []any.
+func unpickle(data []byte) (any, error) {
+	r := bytes.NewReader(data)
+	var stack []any
+	memo := make(map[int]any)
+
+	pop := func() (any, error) {
+		if len(stack) == 0 {
+			return nil, fmt.Errorf("%w: stack underflow", ErrPickle)
+		}
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v, nil
+	}
+	popMark := func() ([]any, error) {
+		for i := len(stack) - 1; i >= 0; i-- {
+			if _, ok := stack[i].(mark); ok {
+				items := append([]any(nil), stack[i+1:]...)
+				stack = stack[:i]
+				return items, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: mark not found", ErrPickle)
+	}
+	readN := func(n int) ([]byte, error) {
+		if n < 0 || n > r.Len() {
+			return nil, fmt.Errorf("%w: unexpected end of data", ErrPickle)
+		}
+		buf := make([]byte, n)
+		_, err := io.ReadFull(r, buf)
+		return buf, err
+	}
+	readUint := func(n int) (int, error) {
+		buf, err := readN(n)
+		if err != nil {
+			return 0, err
+		}
+		var v uint64
+		for i := n - 1; i >= 0; i-- {
+			v = v<<8 | uint64(buf[i])
+		}
+		if v > math.MaxInt32 {
+			return 0, fmt.Errorf("%w: length %d is too large", ErrPickle, v)
+		}
+		return int(v), nil
+	}
+	readLine := func() (string, error) {
+		line, err := readUntil(r, '\n')
+		if err != nil {
+			return "", fmt.Errorf("%w: unexpected end of data", ErrPickle)
+		}
+		return line, nil
+	}
+	appendTo := func(items ...any) error {
+		v, err := pop()
+		if err != nil {
+			return err
+		}
+		list, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%w: append to %T", ErrPickle, v)
+		}
+		stack = append(stack, append(list, items...))
+		return nil
+	}
+
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("%w: missing STOP opcode", ErrPickle)
+		}
+		switch op {
+		case opProto:
+			if _, err := readN(1); err != nil {
+				return nil, err
+			}
+		case opFrame:
+			if _, err := readN(8); err != nil {
+				return nil, err
+			}
+		case opStop:
+			return pop()
+		case opMark:
+			stack = append(stack, mark{})
+		case opPop:
+			if _, err := pop(); err != nil {
+				return nil, err
+			}
+		case opPopMark:
+			if _, err := popMark(); err != nil {
+				return nil, err
+			}
+		case opNone:
+			stack = append(stack, nil)
+		case opNewTrue:
+			stack = append(stack, true)
+		case opNewFalse:
+			stack = append(stack, false)
+		case opInt:
+			line, err := readLine()
+			if err != nil {
+				return nil, err
+			}
+			switch line {
+			case "00":
+				stack = append(stack, false)
+			case "01":
+				stack = append(stack, true)
+			default:
+				v, err := strconv.ParseInt(line, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("%w: bad INT %q", ErrPickle, line)
+				}
+				stack = append(stack, v)
+			}
+		case opLong:
+			line, err := readLine()
+			if err != nil {
+				return nil, err
+			}
+			v, ok := new(big.Int).SetString(trimSuffix(line, 'L'), 10)
+			if !ok {
+				return nil, fmt.Errorf("%w: bad LONG %q", ErrPickle, line)
+			}
+			stack = append(stack, bigToNumber(v))
+		case opBinInt:
+			buf, err := readN(4)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, int64(int32(binary.LittleEndian.Uint32(buf))))
+		case opBinInt1:
+			v, err := readUint(1)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, int64(v))
+		case opBinInt2:
+			v, err := readUint(2)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, int64(v))
+		case opLong1:
+			n, err := readUint(1)
+			if err != nil {
+				return nil, err
+			}
+			buf, err := readN(n)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, bigToNumber(decodeLong(buf)))
+		case opFloat:
+			line, err := readLine()
+			if err != nil {
+				return nil, err
+			}
+			v, err := strconv.ParseFloat(line, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: bad FLOAT %q", ErrPickle, line)
+			}
+			stack = append(stack, v)
+		case opBinFloat:
+			buf, err := readN(8)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, math.Float64frombits(binary.BigEndian.Uint64(buf)))
+		case opString, opUnicode:
+			line, err := readLine()
+			if err != nil {
+				return nil, err
+			}
+			if op == opString {
+				s, err := strconv.Unquote(line)
+				if err != nil {
+					// строки протокола 0 в одинарных кавычках
+					if len(line) >= 2 && line[0] == '\'' && line[len(line)-1] == '\'' {
+						s = line[1 : len(line)-1]
+					} else {
+						return nil, fmt.Errorf("%w: bad STRING %q", ErrPickle, line)
+					}
+				}
+				line = s
+			}
+			stack = append(stack, line)
+		case opShortBinString, opShortBinUnicode, opShortBinBytes, opBinString, opBinUnicode, opBinBytes, opBinUnicode8:
+			var n int
+			switch op {
+			case opShortBinString, opShortBinUnicode, opShortBinBytes:
+				n, err = readUint(1)
+			case opBinUnicode8:
+				n, err = readUint(8)
+			default:
+				n, err = readUint(4)
+			}
+			if err != nil {
+				return nil, err
+			}
+			buf, err := readN(n)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, string(buf))
+		case opEmptyList:
+			stack = append(stack, []any{})
+		case opEmptyTuple:
+			stack = append(stack, []any{})
+		case opList, opTuple:
+			items, err := popMark()
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, items)
+		case opTuple1, opTuple2, opTuple3:
+			n := int(op-opTuple1) + 1
+			if len(stack) < n {
+				return nil, fmt.Errorf("%w: stack underflow", ErrPickle)
+			}
+			items := append([]any(nil), stack[len(stack)-n:]...)
+			stack = append(stack[:len(stack)-n], items)
+		case opAppend:
+			v, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			if err := appendTo(v); err != nil {
+				return nil, err
+			}
+		case opAppends:
+			items, err := popMark()
+			if err != nil {
+				return nil, err
+			}
+			if err := appendTo(items...); err != nil {
+				return nil, err
+			}
+		case opPut, opBinPut, opLongBinPut, opMemoize:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("%w: stack underflow", ErrPickle)
+			}
+			var idx int
+			switch op {
+			case opPut:
+				line, err := readLine()
+				if err != nil {
+					return nil, err
+				}
+				if idx, err = strconv.Atoi(line); err != nil {
+					return nil, fmt.Errorf("%w: bad PUT %q", ErrPickle, line)
+				}
+			case opBinPut:
+				idx, err = readUint(1)
+			case opLongBinPut:
+				idx, err = readUint(4)
+			case opMemoize:
+				idx = len(memo)
+			}
+			if err != nil {
+				return nil, err
+			}
+			memo[idx] = stack[len(stack)-1]
+		case opGet, opBinGet, opLongBinGet:
+			var idx int
+			switch op {
+			case opGet:
+				line, err := readLine()
+				if err != nil {
+					return nil, err
+				}
+				if idx, err = strconv.Atoi(line); err != nil {
+					return nil, fmt.Errorf("%w: bad GET %q", ErrPickle, line)
+				}
+			case opBinGet:
+				idx, err = readUint(1)
+			case opLongBinGet:
+				idx, err = readUint(4)
+			}
+			if err != nil {
+				return nil, err
+			}
+			v, ok := memo[idx]
+			if !ok {
+				return nil, fmt.Errorf("%w: memo key %d not found", ErrPickle, idx)
+			}
+			stack = append(stack, v)
+		default:
+			return nil, fmt.Errorf("%w: unsupported opcode 0x%x", ErrPickle, op)
+		}
+	}
+}
+
+// readUntil читает до разделителя и возвращает строку без него.
+func readUntil(r *bytes.Reader, delim byte) (string, error) {
+	var buf []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == delim {
+			return string(buf), nil
+		}
+		buf = append(buf, b)
+	}
+}
+
+func trimSuffix(s string, suffix byte) string {
+	if len(s) > 0 && s[len(s)-1] == suffix {
+		return s[:len(s)-1]
+	}
+	return s
+}
+
+// decodeLong разбирает целое со знаком в дополнительном коде, little endian.
+func decodeLong(buf []byte) *big.Int {
+	be := make([]byte, len(buf))
+	for i, b := range buf {
+		be[len(buf)-1-i] = b
+	}
+	v := new(big.Int).SetBytes(be)
+	if len(buf) > 0 && buf[len(buf)-1]&0x80 != 0 {
+		v.Sub(v, new(big.Int).Lsh(big.NewInt(1), uint(len(buf)*8)))
+	}
+	return v
+}
+
+// bigToNumber возвращает int64, если значение помещается, иначе float64.
+func bigToNumber(v *big.Int) any {
+	if v.IsInt64() {
+		return v.Int64()
+	}
+	f, _ := new(big.Float).SetInt(v).Float64()
+	return f
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
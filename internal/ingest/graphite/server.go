@@ -0,0 +1,301 @@
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sebasttiano/Blackbird.git/internal/audit"
+	"github.com/sebasttiano/Blackbird.git/internal/ingest"
+	"github.com/sebasttiano/Blackbird.git/internal/logger"
+	"go.uber.org/zap"
+)
+
+// BatchSize сколько метрик копить перед записью в хранилище.
+const BatchSize = 5000
+
+// FlushInterval как часто записывать неполную пачку.
+const FlushInterval = time.Second
+
+// Server слушает plaintext протокол Graphite по TCP и, если задан адрес, pickle протокол.
+type Server struct {
+	addr       string
+	pickleAddr string
+	mapper     *Mapper
+	writer     ingest.Writer
+
+	plain  net.Listener
+	pickle net.Listener
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	wg     sync.WaitGroup
+	done   chan struct{}
+	stop   sync.Once
+
+	// flushMu упорядочивает записи пачек в хранилище.
+	flushMu sync.Mutex
+	batchMu sync.Mutex
+	pending *batch
+
+	bad      atomic.Int64
+	rejected atomic.Int64
+}
+
+// NewServer конструктор для Server. Пустой pickleAddr отключает pickle протокол.
+func NewServer(addr, pickleAddr string, mapper *Mapper, writer ingest.Writer) *Server {
+	if mapper == nil {
+		mapper = NewMapper(nil)
+	}
+	return &Server{
+		addr:       addr,
+		pickleAddr: pickleAddr,
+		mapper:     mapper,
+		writer:     writer,
+		conns:      make(map[net.Conn]struct{}),
+		done:       make(chan struct{}),
+		pending:    newBatch(),
+	}
+}
+
+// Listen открывает листенеры.
+func (s *Server) Listen() error {
+	plain, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	if s.pickleAddr != "" {
+		pickle, err := net.Listen("tcp", s.pickleAddr)
+		if err != nil {
+			plain.Close()
+			return err
+		}
+		s.pickle = pickle
+	}
+	s.plain = plain
+	return nil
+}
+
+// Addr возвращает адрес plaintext листенера.
+func (s *Server) Addr() string {
+	if s.plain == nil {
+		return s.addr
+	}
+	return s.plain.Addr().String()
+}
+
+// PickleAddr возвращает адрес pickle листенера.
+func (s *Server) PickleAddr() string {
+	if s.pickle == nil {
+		return s.pickleAddr
+	}
+	return s.pickle.Addr().String()
+}
+
+// Serve принимает соединения и периодически сбрасывает пачку, пока сервер не остановлен.
+func (s *Server) Serve() {
+	s.wg.Add(2)
+	go s.accept(s.plain, s.servePlain)
+	go s.flushLoop()
+	if s.pickle != nil {
+		s.wg.Add(1)
+		go s.accept(s.pickle, s.servePickle)
+	}
+	s.wg.Wait()
+}
+
+// Start открывает листенеры и обслуживает их до остановки.
+func (s *Server) Start() {
+	if err := s.Listen(); err != nil {
+		logger.Log.Error("failed to start graphite listener", zap.String("address", s.addr), zap.Error(err))
+		return
+	}
+	logger.Log.Info("Running graphite listener", zap.String("address", s.Addr()), zap.String("pickle_address", s.PickleAddr()))
+	s.Serve()
+}
+
+// Shutdown закрывает листенеры и соединения, дожидается обработчиков и записывает последнюю пачку.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stop.Do(func() {
+		close(s.done)
+		for _, l := range []net.Listener{s.plain, s.pickle} {
+			if l != nil {
+				l.Close()
+			}
+		}
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+	})
+	s.wg.Wait()
+	return s.Flush(ctx)
+}
+
+// HandleShutdown останавливает сервер по сигналу.
+func (s *Server) HandleShutdown(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	<-ctx.Done()
+	logger.Log.Info("shutdown signal caught. shutting down graphite listener")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		logger.Log.Error("couldn`t flush graphite metrics on shutdown", zap.Error(err))
+		return
+	}
+	logger.Log.Info("graphite listener gracefully shutdown")
+}
+
+// Flush записывает накопленную пачку. При ошибке записи метрики пачки теряются, метрика,
+// отклоненная сервисом, не мешает записи остальных.
+func (s *Server) Flush(ctx context.Context) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.batchMu.Lock()
+	b := s.pending
+	s.pending = newBatch()
+	s.batchMu.Unlock()
+	return s.write(ctx, b)
+}
+
+// BadLines возвращает количество строк и pickle сообщений, которые не удалось разобрать.
+func (s *Server) BadLines() int64 {
+	return s.bad.Load()
+}
+
+// Rejected возвращает количество метрик, которые отклонил сервис.
+func (s *Server) Rejected() int64 {
+	return s.rejected.Load()
+}
+
+func (s *Server) write(ctx context.Context, b *batch) error {
+	if b.len() == 0 {
+		return nil
+	}
+	ctx = audit.WithSource(ctx, audit.Source{Transport: audit.TransportGraphite})
+	result, err := ingest.Store(ctx, s.writer, "graphite", b.metrics)
+	if err != nil {
+		return err
+	}
+	s.rejected.Add(int64(result.Rejected))
+	logger.Log.Debug("graphite metrics flushed", zap.Int("metrics", result.Accepted))
+	return nil
+}
+
+// add кладет значение в пачку. Заполненная пачка записывается сразу в горутине соединения,
+// так медленное хранилище притормаживает клиентов, а не копит память.
+func (s *Server) add(samples ...Sample) {
+	s.batchMu.Lock()
+	for _, sample := range samples {
+		name, mtype := s.mapper.Map(sample.Path)
+		s.pending.add(name, mtype, sample)
+	}
+	full := s.pending.len() >= BatchSize
+	s.batchMu.Unlock()
+
+	if full {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*FlushInterval)
+		defer cancel()
+		if err := s.Flush(ctx); err != nil {
+			logger.Log.Error("couldn`t flush graphite metrics", zap.Error(err))
+		}
+	}
+}
+
+func (s *Server) flushLoop() {
+	defer s.wg.Done()
+	tick := time.NewTicker(FlushInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 10*FlushInterval)
+			if err := s.Flush(ctx); err != nil {
+				logger.Log.Error("couldn`t flush graphite metrics", zap.Error(err))
+			}
+			cancel()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Server) accept(l net.Listener, serve func(net.Conn)) {
+	defer s.wg.Done()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Log.Error("graphite accept failed", zap.Error(err))
+			}
+			return
+		}
+		s.mu.Lock()
+		select {
+		case <-s.done:
+			// сервер уже останавливается, соединение не попадет в список на закрытие
+			s.mu.Unlock()
+			conn.Close()
+			return
+		default:
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+				conn.Close()
+			}()
+			serve(conn)
+		}()
+	}
+}
+
+func (s *Server) servePlain(conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		sample, err := ParseLine(line, time.Now())
+		if err != nil {
+			s.bad.Add(1)
+			logger.Log.Debug("bad graphite line", zap.Error(err))
+			continue
+		}
+		s.add(sample)
+	}
+}
+
+func (s *Server) servePickle(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		samples, err := ReadPickle(r, time.Now())
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.bad.Add(1)
+			logger.Log.Debug("bad graphite pickle message", zap.Error(err))
+			// после ошибки граница следующего сообщения неизвестна
+			return
+		}
+		s.add(samples...)
+	}
+}
@@ -0,0 +1,145 @@
+package graphite
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Части шаблона со специальным значением.
+const (
+	// partMeasurement узел входит в имя метрики.
+	partMeasurement = "measurement"
+	// partMeasurementRest этот и все оставшиеся узлы входят в имя метрики.
+	partMeasurementRest = "measurement*"
+	// partField узел дописывается в конец имени, после значений меток.
+	partField = "field"
+	// partSkip узел отбрасывается.
+	partSkip = "_"
+)
+
+// ErrInvalidTemplate ошибка в шаблоне.
+var ErrInvalidTemplate = errors.New("invalid graphite template")
+
+// Template описывает, как разложить путь Graphite на имя метрики и метки.
+//
+// Шаблон записывается строкой "[filter] pattern [type]", например
+// "servers.* .host.measurement* gauge". Filter - узлы в синтаксисе path.Match,
+// шаблон применяется, если первые узлы пути им соответствуют. Узлы pattern:
+// measurement, measurement*, field, _ (пропустить) или имя метки.
+type Template struct {
+	Filter []string
+	Parts  []string
+	// Type counter или gauge, по умолчанию gauge.
+	Type string
+}
+
+// ParseTemplate разбирает строку шаблона.
+func ParseTemplate(s string) (Template, error) {
+	fields := strings.Fields(s)
+	var t Template
+	switch len(fields) {
+	case 1:
+		t.Parts = strings.Split(fields[0], ".")
+	case 2:
+		if isType(fields[1]) {
+			t.Parts, t.Type = strings.Split(fields[0], "."), fields[1]
+		} else {
+			t.Filter, t.Parts = strings.Split(fields[0], "."), strings.Split(fields[1], ".")
+		}
+	case 3:
+		t.Filter, t.Parts, t.Type = strings.Split(fields[0], "."), strings.Split(fields[1], "."), fields[2]
+	default:
+		return Template{}, fmt.Errorf("%w: %q", ErrInvalidTemplate, s)
+	}
+
+	if t.Type != "" && !isType(t.Type) {
+		return Template{}, fmt.Errorf("%w: %q: unknown type %q", ErrInvalidTemplate, s, t.Type)
+	}
+	for _, node := range t.Filter {
+		if _, err := path.Match(node, ""); err != nil {
+			return Template{}, fmt.Errorf("%w: %q: bad filter", ErrInvalidTemplate, s)
+		}
+	}
+	hasMeasurement := false
+	for i, part := range t.Parts {
+		switch part {
+		case partMeasurement:
+			hasMeasurement = true
+		case partMeasurementRest:
+			hasMeasurement = true
+			if i != len(t.Parts)-1 {
+				return Template{}, fmt.Errorf("%w: %q: measurement* must be the last part", ErrInvalidTemplate, s)
+			}
+		}
+	}
+	if !hasMeasurement {
+		return Template{}, fmt.Errorf("%w: %q: no measurement part", ErrInvalidTemplate, s)
+	}
+	return t, nil
+}
+
+// LoadTemplates читает шаблоны из файла, по одному на строку. Пустые строки и строки с # пропускаются.
+func LoadTemplates(filename string) ([]Template, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var templates []Template
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		t, err := ParseTemplate(line)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, scanner.Err()
+}
+
+// Match проверяет, подходит ли шаблон к узлам пути.
+func (t Template) Match(nodes []string) bool {
+	if len(t.Filter) > len(nodes) {
+		return false
+	}
+	for i, pattern := range t.Filter {
+		if ok, _ := path.Match(pattern, nodes[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply раскладывает узлы пути по шаблону. Узлы без пары в шаблоне отбрасываются.
+func (t Template) Apply(nodes []string) (measurement []string, tags []Tag, field []string) {
+	for i, part := range t.Parts {
+		if i >= len(nodes) {
+			break
+		}
+		switch part {
+		case partMeasurement:
+			measurement = append(measurement, nodes[i])
+		case partMeasurementRest:
+			measurement = append(measurement, nodes[i:]...)
+		case partField:
+			field = append(field, nodes[i])
+		case partSkip, "":
+		default:
+			tags = append(tags, Tag{Key: part, Value: nodes[i]})
+		}
+	}
+	return measurement, tags, field
+}
+
+func isType(s string) bool {
+	return s == TypeCounter || s == TypeGauge
+}
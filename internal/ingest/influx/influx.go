@@ -0,0 +1,235 @@
+// Package influx принимает метрики в формате InfluxDB line protocol.
+// Целочисленные поля становятся counter, поля с плавающей точкой и булевы - gauge, строковые поля пропускаются.
+package influx
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/sebasttiano/Blackbird.git/internal/ingest"
+	"github.com/sebasttiano/Blackbird.git/internal/models"
+)
+
+// BatchSize сколько метрик копить перед записью в хранилище.
+const BatchSize = 5000
+
+// MaxLineSize максимальная длина строки.
+const MaxLineSize = 1 << 20
+
+// maxReportedErrors сколько ошибок разбора перечислять в ответе.
+const maxReportedErrors = 10
+
+// LineError ошибка разбора с номером строки, начиная с 1.
+type LineError struct {
+	Line int
+	Err  error
+}
+
+// Error метод интерфейса.
+func (e LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// Unwrap метод интерфейса.
+func (e LineError) Unwrap() error {
+	return e.Err
+}
+
+// PartialWriteError ошибка, если часть строк не удалось разобрать или часть метрик отклонил сервис.
+// Остальные метрики при этом сохранены.
+type PartialWriteError struct {
+	Errors  []LineError
+	Skipped int
+	// Rejected сколько разобранных метрик отклонил сервис, например под зарезервированным префиксом.
+	Rejected int
+}
+
+// Error метод интерфейса, перечисляет первые ошибки с номерами строк.
+func (e *PartialWriteError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, le := range e.Errors {
+		msgs = append(msgs, le.Error())
+	}
+	msg := fmt.Sprintf("partial write: %d lines rejected", e.Skipped)
+	if len(msgs) > 0 {
+		msg += ": " + strings.Join(msgs, "; ")
+	}
+	if e.Skipped > len(e.Errors) {
+		msg += "; ..."
+	}
+	if e.Rejected > 0 {
+		msg += fmt.Sprintf("; %d metrics rejected by server", e.Rejected)
+	}
+	return msg
+}
+
+// Unwrap метод интерфейса.
+func (e *PartialWriteError) Unwrap() error {
+	return ErrParse
+}
+
+// Namer строит имя метрики Blackbird из точки и поля: measurement[.значения меток].field.
+type Namer struct {
+	// Tags значения этих меток в заданном порядке вставляются между measurement и field.
+	Tags []string
+	// Separator разделитель частей имени, по умолчанию точка.
+	Separator string
+}
+
+// Name возвращает имя метрики для поля точки.
+func (n Namer) Name(p *Point, field string) string {
+	sep := n.Separator
+	if sep == "" {
+		sep = "."
+	}
+	parts := []string{p.Measurement}
+	for _, key := range n.Tags {
+		for _, tag := range p.Tags {
+			if tag.Key == key {
+				parts = append(parts, tag.Value)
+				break
+			}
+		}
+	}
+	return strings.Join(append(parts, field), sep)
+}
+
+// Receiver читает line protocol из потока и сохраняет метрики пачками.
+type Receiver struct {
+	writer ingest.Writer
+	namer  Namer
+}
+
+// NewReceiver конструктор для Receiver.
+func NewReceiver(writer ingest.Writer, namer Namer) *Receiver {
+	return &Receiver{writer: writer, namer: namer}
+}
+
+// Write разбирает тело запроса построчно, не загружая его в память целиком.
+// Некорректные строки и отклоненные сервисом метрики пропускаются и возвращаются в *PartialWriteError,
+// ошибка хранилища прерывает запись.
+func (r *Receiver) Write(ctx context.Context, body io.Reader, precision string) (int, error) {
+	unit, err := PrecisionMultiplier(precision)
+	if err != nil {
+		return 0, err
+	}
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxLineSize)
+
+	b := newBatch()
+	var stored int
+	var partial PartialWriteError
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		p, err := ParseLine(line, unit)
+		if err != nil {
+			partial.Skipped++
+			if len(partial.Errors) < maxReportedErrors {
+				partial.Errors = append(partial.Errors, LineError{Line: lineNum, Err: err})
+			}
+			continue
+		}
+		r.add(b, &p)
+		if b.len() >= BatchSize {
+			n, err := r.flush(ctx, b, &partial)
+			if err != nil {
+				return stored, err
+			}
+			stored += n
+			b = newBatch()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			err = LineError{Line: lineNum + 1, Err: fmt.Errorf("%w: line is longer than %d bytes", ErrParse, MaxLineSize)}
+		}
+		return stored, err
+	}
+
+	n, err := r.flush(ctx, b, &partial)
+	if err != nil {
+		return stored, err
+	}
+	stored += n
+	if partial.Skipped > 0 || partial.Rejected > 0 {
+		return stored, &partial
+	}
+	return stored, nil
+}
+
+// add раскладывает поля точки по метрикам пачки.
+func (r *Receiver) add(b *batch, p *Point) {
+	for _, f := range p.Fields {
+		name := r.namer.Name(p, f.Key)
+		switch f.Kind {
+		case KindInteger, KindUnsigned:
+			b.addCounter(name, f.Int)
+		case KindFloat, KindBoolean:
+			b.setGauge(name, f.Float, p.Time)
+		}
+	}
+}
+
+// flush сохраняет пачку и возвращает сколько метрик записано, отклоненные сервисом считаются в partial.
+func (r *Receiver) flush(ctx context.Context, b *batch, partial *PartialWriteError) (int, error) {
+	if b.len() == 0 {
+		return 0, nil
+	}
+	result, err := ingest.Store(ctx, r.writer, "influx", b.metrics)
+	if err != nil {
+		return 0, err
+	}
+	partial.Rejected += result.Rejected
+	return result.Accepted, nil
+}
+
+// batch копит метрики: приращения counter складываются, для gauge остается значение с самой поздней меткой.
+type batch struct {
+	metrics []*models.Metrics
+	index   map[string]int
+	times   map[string]time.Time
+}
+
+func newBatch() *batch {
+	return &batch{index: make(map[string]int), times: make(map[string]time.Time)}
+}
+
+func (b *batch) len() int {
+	return len(b.metrics)
+}
+
+func (b *batch) addCounter(name string, delta int64) {
+	key := "counter:" + name
+	if i, ok := b.index[key]; ok {
+		*b.metrics[i].Delta += delta
+		return
+	}
+	b.index[key] = len(b.metrics)
+	b.metrics = append(b.metrics, &models.Metrics{ID: name, MType: "counter", Delta: &delta})
+}
+
+func (b *batch) setGauge(name string, value float64, t time.Time) {
+	key := "gauge:" + name
+	if i, ok := b.index[key]; ok {
+		if t.Before(b.times[key]) {
+			return
+		}
+		*b.metrics[i].Value = value
+		b.times[key] = t
+		return
+	}
+	b.index[key] = len(b.metrics)
+	b.times[key] = t
+	b.metrics = append(b.metrics, &models.Metrics{ID: name, MType: "gauge", Value: &value})
+}
@@ -0,0 +1,130 @@
+package influx
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sebasttiano/Blackbird.git/internal/ingest/ingesttest"
+	"github.com/sebasttiano/Blackbird.git/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    Point
+		wantErr bool
+	}{
+		{
+			name: "full line",
+			line: `cpu,host=server01,region=us-west usage_idle=92.5,procs=12i,ok=true,msg="all \"good\"" 1465839830100400200`,
+			want: Point{
+				Measurement: "cpu",
+				Tags:        []Tag{{Key: "host", Value: "server01"}, {Key: "region", Value: "us-west"}},
+				Fields: []Field{
+					{Key: "usage_idle", Kind: KindFloat, Float: 92.5},
+					{Key: "procs", Kind: KindInteger, Int: 12},
+					{Key: "ok", Kind: KindBoolean, Float: 1},
+					{Key: "msg", Kind: KindString, Str: `all "good"`},
+				},
+				Time: time.Unix(0, 1465839830100400200),
+			},
+		},
+		{
+			name: "escaped names and no timestamp",
+			line: `disk\ io,path=/var\,log bytes=7u,space\ left=1e3`,
+			want: Point{
+				Measurement: "disk io",
+				Tags:        []Tag{{Key: "path", Value: "/var,log"}},
+				Fields:      []Field{{Key: "bytes", Kind: KindUnsigned, Int: 7}, {Key: "space left", Kind: KindFloat, Float: 1000}},
+			},
+		},
+		{
+			name: "string with spaces and commas",
+			line: `log text="a b, c=d" 10`,
+			want: Point{Measurement: "log", Fields: []Field{{Key: "text", Kind: KindString, Str: "a b, c=d"}}, Time: time.Unix(0, 10)},
+		},
+		{name: "no fields", line: "cpu", wantErr: true},
+		{name: "bad tag", line: "cpu,host value=1", wantErr: true},
+		{name: "bad integer", line: "cpu value=1.5i", wantErr: true},
+		{name: "bad float", line: "cpu value=abc", wantErr: true},
+		{name: "nan", line: "cpu value=NaN", wantErr: true},
+		{name: "unterminated string", line: `cpu value="abc`, wantErr: true},
+		{name: "bad timestamp", line: "cpu value=1 yesterday", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLine(tt.line, time.Nanosecond)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrParse)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestPrecisionMultiplier(t *testing.T) {
+	p, err := ParseLine("cpu value=1 1465839830", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1465839830, 0), p.Time)
+
+	unit, err := PrecisionMultiplier("ms")
+	require.NoError(t, err)
+	assert.Equal(t, time.Millisecond, unit)
+
+	_, err = PrecisionMultiplier("weeks")
+	assert.ErrorIs(t, err, ErrPrecision)
+}
+
+func TestReceiver_Write(t *testing.T) {
+	body := strings.Join([]string{
+		"# comment",
+		"cpu,host=a,cpu=cpu0 usage=10.5,ctx=3i 2",
+		"cpu,host=a,cpu=cpu0 usage=20.5,ctx=4i 1",
+		"",
+		"cpu,host=b usage",
+		"mem,host=a used=1u,name=\"ram\"",
+		"broken line here",
+	}, "\n")
+
+	w := ingesttest.NewWriter()
+	r := NewReceiver(w, Namer{Tags: []string{"host", "cpu"}})
+	stored, err := r.Write(context.Background(), strings.NewReader(body), "s")
+
+	var partial *PartialWriteError
+	require.ErrorAs(t, err, &partial)
+	assert.ErrorIs(t, err, ErrParse)
+	assert.Equal(t, 2, partial.Skipped)
+	assert.Equal(t, 5, partial.Errors[0].Line)
+	assert.Equal(t, 7, partial.Errors[1].Line)
+	assert.Contains(t, err.Error(), "line 5:")
+
+	assert.Equal(t, 3, stored)
+	assert.Equal(t, map[string]float64{"cpu.a.cpu0.usage": 10.5, "cpu.a.cpu0.ctx": 7, "mem.a.used": 1}, w.Values())
+	assert.Equal(t, "gauge", w.Type("cpu.a.cpu0.usage"))
+	assert.Equal(t, "counter", w.Type("cpu.a.cpu0.ctx"))
+	assert.Equal(t, "counter", w.Type("mem.a.used"))
+
+	_, err = r.Write(context.Background(), strings.NewReader("cpu value=1"), "weeks")
+	assert.ErrorIs(t, err, ErrPrecision)
+
+	// метрика, отклоненная сервисом, не мешает записи остальных и попадает в partial write
+	w.Reject(models.ReasonReservedName, "_bb.value")
+	stored, err = r.Write(context.Background(), strings.NewReader("_bb value=1\nroom temp=20"), "")
+	require.ErrorAs(t, err, &partial)
+	assert.Equal(t, 1, stored)
+	assert.Equal(t, 1, partial.Rejected)
+	assert.Equal(t, 0, partial.Skipped)
+	assert.Equal(t, 20.0, w.Value("room.temp"))
+
+	w.Fail(errors.New("storage is down"))
+	_, err = r.Write(context.Background(), strings.NewReader("cpu value=1"), "")
+	assert.EqualError(t, err, "storage is down")
+}
@@ -0,0 +1,238 @@
+package influx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrParse ошибка разбора строки line protocol.
+var ErrParse = errors.New("failed to parse line protocol")
+
+// ErrPrecision ошибка, если точность временных меток не поддерживается.
+var ErrPrecision = errors.New("unsupported precision")
+
+// FieldKind тип значения поля.
+type FieldKind int
+
+const (
+	// KindFloat число с плавающей точкой, например 1.5 или 1.
+	KindFloat FieldKind = iota
+	// KindInteger целое со суффиксом i.
+	KindInteger
+	// KindUnsigned беззнаковое целое со суффиксом u.
+	KindUnsigned
+	// KindBoolean t, true, f, false и их варианты регистра.
+	KindBoolean
+	// KindString строка в двойных кавычках.
+	KindString
+)
+
+// Tag метка точки.
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// Field поле точки. Для KindBoolean Float равно 0 или 1.
+type Field struct {
+	Key   string
+	Kind  FieldKind
+	Float float64
+	Int   int64
+	Str   string
+}
+
+// Point одна строка line protocol.
+type Point struct {
+	Measurement string
+	Tags        []Tag
+	Fields      []Field
+	// Time нулевое, если временная метка не передана.
+	Time time.Time
+}
+
+// PrecisionMultiplier возвращает длительность единицы временной метки.
+// Поддерживаются значения InfluxDB 1.x и 2.x: ns, n, us, u, ms, s, m, h. Пустая точность означает наносекунды.
+func PrecisionMultiplier(precision string) (time.Duration, error) {
+	switch precision {
+	case "", "ns", "n":
+		return time.Nanosecond, nil
+	case "us", "u":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	default:
+		return 0, fmt.Errorf("%w: %q", ErrPrecision, precision)
+	}
+}
+
+// ParseLine разбирает строку вида measurement[,tag=value...] field=value[,field=value...] [timestamp].
+func ParseLine(line string, precision time.Duration) (Point, error) {
+	var p Point
+
+	key, rest, err := splitUnescaped(line, ' ', false)
+	if err != nil {
+		return p, err
+	}
+	if rest == "" {
+		return p, fmt.Errorf("%w: missing fields", ErrParse)
+	}
+
+	parts, err := splitAll(key, ',', false)
+	if err != nil {
+		return p, err
+	}
+	p.Measurement = unescape(parts[0])
+	if p.Measurement == "" {
+		return p, fmt.Errorf("%w: missing measurement", ErrParse)
+	}
+	for _, part := range parts[1:] {
+		k, v, ok := cutUnescaped(part, '=')
+		if !ok || k == "" || v == "" {
+			return p, fmt.Errorf("%w: bad tag %q", ErrParse, part)
+		}
+		p.Tags = append(p.Tags, Tag{Key: unescape(k), Value: unescape(v)})
+	}
+
+	fieldSet, timestamp, err := splitUnescaped(rest, ' ', true)
+	if err != nil {
+		return p, err
+	}
+	fields, err := splitAll(fieldSet, ',', true)
+	if err != nil {
+		return p, err
+	}
+	for _, f := range fields {
+		field, err := parseField(f)
+		if err != nil {
+			return p, err
+		}
+		p.Fields = append(p.Fields, field)
+	}
+
+	if timestamp = strings.TrimSpace(timestamp); timestamp != "" {
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return p, fmt.Errorf("%w: bad timestamp %q", ErrParse, timestamp)
+		}
+		p.Time = time.Unix(0, 0).Add(time.Duration(ts) * precision)
+	}
+	return p, nil
+}
+
+// parseField разбирает пару key=value из набора полей.
+func parseField(s string) (Field, error) {
+	k, v, ok := cutUnescaped(s, '=')
+	if !ok || k == "" || v == "" {
+		return Field{}, fmt.Errorf("%w: bad field %q", ErrParse, s)
+	}
+	f := Field{Key: unescape(k)}
+
+	switch {
+	case v[0] == '"':
+		if len(v) < 2 || v[len(v)-1] != '"' {
+			return f, fmt.Errorf("%w: unterminated string in field %q", ErrParse, f.Key)
+		}
+		f.Kind = KindString
+		f.Str = strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(v[1 : len(v)-1])
+	case strings.HasSuffix(v, "i"):
+		n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+		if err != nil {
+			return f, fmt.Errorf("%w: bad integer in field %q", ErrParse, f.Key)
+		}
+		f.Kind, f.Int = KindInteger, n
+	case strings.HasSuffix(v, "u"):
+		n, err := strconv.ParseUint(v[:len(v)-1], 10, 63)
+		if err != nil {
+			return f, fmt.Errorf("%w: bad unsigned integer in field %q", ErrParse, f.Key)
+		}
+		f.Kind, f.Int = KindUnsigned, int64(n)
+	default:
+		switch v {
+		case "t", "T", "true", "True", "TRUE":
+			f.Kind, f.Float = KindBoolean, 1
+			return f, nil
+		case "f", "F", "false", "False", "FALSE":
+			f.Kind, f.Float = KindBoolean, 0
+			return f, nil
+		}
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+			return f, fmt.Errorf("%w: bad float in field %q", ErrParse, f.Key)
+		}
+		f.Kind, f.Float = KindFloat, n
+	}
+	return f, nil
+}
+
+// splitUnescaped делит строку по первому неэкранированному разделителю вне кавычек.
+func splitUnescaped(s string, sep byte, quotes bool) (string, string, error) {
+	parts, err := split(s, sep, quotes, 2)
+	if err != nil {
+		return "", "", err
+	}
+	if len(parts) == 1 {
+		return parts[0], "", nil
+	}
+	return parts[0], parts[1], nil
+}
+
+// splitAll делит строку по всем неэкранированным разделителям вне кавычек.
+func splitAll(s string, sep byte, quotes bool) ([]string, error) {
+	return split(s, sep, quotes, -1)
+}
+
+func split(s string, sep byte, quotes bool, n int) ([]string, error) {
+	var parts []string
+	start := 0
+	inQuotes := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quotes && s[i] == '"':
+			inQuotes = !inQuotes
+		case s[i] == sep && !inQuotes:
+			if n > 0 && len(parts) == n-1 {
+				continue
+			}
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	if inQuotes {
+		return nil, fmt.Errorf("%w: unterminated string", ErrParse)
+	}
+	return append(parts, s[start:]), nil
+}
+
+// cutUnescaped делит строку по первому неэкранированному разделителю.
+func cutUnescaped(s string, sep byte) (string, string, bool) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			return s[:i], s[i+1:], true
+		}
+	}
+	return s, "", false
+}
+
+// unescape снимает экранирование запятых, пробелов и знаков равенства.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	return strings.NewReplacer(`\,`, ",", `\ `, " ", `\=`, "=", `\\`, `\`).Replace(s)
+}
@@ -0,0 +1,47 @@
+// Package ingest общее для приемников метрик сторонних протоколов: remote_write, StatsD, line protocol,
+// Graphite и OTLP. Сами протоколы разбираются в подпакетах.
+package ingest
+
+import (
+	"context"
+
+	"github.com/sebasttiano/Blackbird.git/internal/logger"
+	"github.com/sebasttiano/Blackbird.git/internal/models"
+	"go.uber.org/zap"
+)
+
+// Writer сохраняет пачку метрик, его реализует service.Service.
+type Writer interface {
+	SetModelValueBatch(ctx context.Context, batchID string, metrics []*models.Metrics, atomic bool) (*models.BatchResult, error)
+}
+
+// Store сохраняет пачку без отката: метрика, отклоненная сервисом, например под зарезервированным
+// префиксом, не мешает записи остальных. Отклоненные метрики пишутся в лог с протоколом source.
+func Store(ctx context.Context, w Writer, source string, metrics []*models.Metrics) (*models.BatchResult, error) {
+	result, err := w.SetModelValueBatch(ctx, "", metrics, false)
+	if err != nil {
+		return nil, err
+	}
+	if result.Rejected > 0 {
+		for _, r := range result.Results {
+			if r.Status == models.StatusRejected {
+				logger.Log.Warn("ingested metrics rejected", zap.String("source", source), zap.Int("rejected", result.Rejected),
+					zap.String("first_metric", r.ID), zap.String("reason", r.Reason), zap.String("message", r.Message))
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// Unsaved возвращает имена метрик, которые не записаны из-за ошибки хранилища. Приемники накопительных
+// рядов не запоминают их последнее значение, и прирост уходит со следующим запросом.
+func Unsaved(result *models.BatchResult) map[string]bool {
+	unsaved := make(map[string]bool)
+	for _, r := range result.Results {
+		if r.Status == models.StatusRejected && r.Reason == models.ReasonStorageError {
+			unsaved[r.ID] = true
+		}
+	}
+	return unsaved
+}
@@ -0,0 +1,110 @@
+// Package ingesttest тестовый Writer для приемников метрик и записи метрик сервера.
+package ingesttest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sebasttiano/Blackbird.git/internal/models"
+)
+
+// Writer запоминает сохраненные метрики и может вернуть ошибку. Безопасен для вызова из нескольких горутин.
+type Writer struct {
+	mu      sync.Mutex
+	metrics []models.Metrics
+	err     error
+	reject  map[string]string
+}
+
+// NewWriter конструктор для Writer.
+func NewWriter() *Writer {
+	return &Writer{reject: make(map[string]string)}
+}
+
+// SetModelValueBatch реализует ingest.Writer. Метрики, заданные через Reject, отклоняются, остальные
+// запоминаются, режим atomic не учитывается.
+func (w *Writer) SetModelValueBatch(ctx context.Context, batchID string, metrics []*models.Metrics, atomic bool) (*models.BatchResult, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return nil, w.err
+	}
+	result := &models.BatchResult{Results: make([]models.MetricResult, 0, len(metrics))}
+	for _, m := range metrics {
+		r := models.MetricResult{ID: m.ID, MType: m.MType, Status: models.StatusAccepted}
+		if reason, ok := w.reject[m.ID]; ok {
+			r.Status, r.Reason = models.StatusRejected, reason
+			result.Rejected++
+		} else {
+			w.metrics = append(w.metrics, *m)
+			result.Accepted++
+		}
+		result.Results = append(result.Results, r)
+	}
+	return result, nil
+}
+
+// SetSelfMetrics реализует selfmetrics.Writer.
+func (w *Writer) SetSelfMetrics(ctx context.Context, metrics []*models.Metrics) error {
+	_, err := w.SetModelValueBatch(ctx, "", metrics, true)
+	return err
+}
+
+// Reject отклоняет метрики с этими именами по причине reason, пустая причина снова их принимает.
+func (w *Writer) Reject(reason string, names ...string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, name := range names {
+		if reason == "" {
+			delete(w.reject, name)
+		} else {
+			w.reject[name] = reason
+		}
+	}
+}
+
+// Fail задает ошибку, которую вернут следующие записи, nil снова разрешает запись.
+func (w *Writer) Fail(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.err = err
+}
+
+// Reset забывает сохраненные метрики.
+func (w *Writer) Reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.metrics = nil
+}
+
+// Values раскладывает сохраненные метрики в карту имя -> значение: счетчики суммируются, gauge берется последний.
+func (w *Writer) Values() map[string]float64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := make(map[string]float64)
+	for _, m := range w.metrics {
+		if m.MType == "counter" {
+			out[m.ID] += float64(*m.Delta)
+		} else {
+			out[m.ID] = *m.Value
+		}
+	}
+	return out
+}
+
+// Value значение метрики, как в Values.
+func (w *Writer) Value(name string) float64 {
+	return w.Values()[name]
+}
+
+// Type тип последней сохраненной метрики с таким именем или пустая строка.
+func (w *Writer) Type(name string) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i := len(w.metrics) - 1; i >= 0; i-- {
+		if w.metrics[i].ID == name {
+			return w.metrics[i].MType
+		}
+	}
+	return ""
+}
@@ -0,0 +1,437 @@
+// Package otlp принимает метрики OpenTelemetry по протоколу OTLP.
+// Монотонные суммы становятся counter, gauge и немонотонные суммы - gauge,
+// гистограммы и summary сворачиваются в набор производных метрик.
+package otlp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sebasttiano/Blackbird.git/internal/ingest"
+	"github.com/sebasttiano/Blackbird.git/internal/models"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// MaxBodySize максимальный размер тела запроса OTLP/HTTP.
+const MaxBodySize = 32 << 20
+
+// Content-Type тел запросов OTLP/HTTP.
+const (
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeJSON     = "application/json"
+)
+
+// Percentiles процентили, которые оцениваются по корзинам гистограммы.
+var Percentiles = []float64{50, 90, 99}
+
+// ErrDecode ошибка, если тело запроса не является ExportMetricsServiceRequest.
+var ErrDecode = errors.New("failed to decode otlp request")
+
+// ErrContentType ошибка, если Content-Type не поддерживается.
+var ErrContentType = errors.New("unsupported otlp content type")
+
+// Namer строит имя метрики Blackbird: значения атрибутов ресурса, имя метрики OTLP
+// и значения атрибутов точки по алфавиту ключей, все через точку.
+type Namer struct {
+	// ResourceAttributes значения этих атрибутов ресурса в заданном порядке идут префиксом имени.
+	ResourceAttributes []string
+}
+
+// Name возвращает имя метрики для точки.
+func (n Namer) Name(resource []*commonpb.KeyValue, metric string, attrs []*commonpb.KeyValue) string {
+	parts := make([]string, 0, len(n.ResourceAttributes)+1+len(attrs))
+	for _, key := range n.ResourceAttributes {
+		for _, kv := range resource {
+			if kv.Key == key {
+				if v := anyValueString(kv.Value); v != "" {
+					parts = append(parts, v)
+				}
+				break
+			}
+		}
+	}
+	parts = append(parts, metric)
+	for _, kv := range sortedAttrs(attrs) {
+		if v := anyValueString(kv.Value); v != "" {
+			parts = append(parts, v)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// DecodeRequest читает тело OTLP/HTTP запроса в формате protobuf или JSON.
+func DecodeRequest(body io.Reader, contentType string) (*colmetricspb.ExportMetricsServiceRequest, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != ContentTypeProtobuf && mediaType != ContentTypeJSON {
+		return nil, fmt.Errorf("%w: %q", ErrContentType, contentType)
+	}
+	data, err := io.ReadAll(io.LimitReader(body, MaxBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecode, err)
+	}
+	if len(data) > MaxBodySize {
+		return nil, fmt.Errorf("%w: body is larger than %d bytes", ErrDecode, MaxBodySize)
+	}
+
+	var req colmetricspb.ExportMetricsServiceRequest
+	if mediaType == ContentTypeJSON {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, &req)
+	} else {
+		err = proto.Unmarshal(data, &req)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecode, err)
+	}
+	return &req, nil
+}
+
+// EncodeResponse сериализует ответ в формате запроса.
+func EncodeResponse(contentType string, msg proto.Message) ([]byte, error) {
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == ContentTypeJSON {
+		return protojson.Marshal(msg)
+	}
+	return proto.Marshal(msg)
+}
+
+// Result итог приема запроса.
+type Result struct {
+	// Stored сколько метрик Blackbird записано.
+	Stored int
+	// Rejected сколько точек OTLP отброшено: без значения, NaN, бесконечности и неизвестные типы,
+	// плюс метрики, которые отклонил сервис.
+	Rejected int64
+}
+
+// Response возвращает ответ OTLP с частичным успехом, если часть точек отброшена.
+func (r Result) Response() *colmetricspb.ExportMetricsServiceResponse {
+	resp := &colmetricspb.ExportMetricsServiceResponse{}
+	if r.Rejected > 0 {
+		resp.PartialSuccess = &colmetricspb.ExportMetricsPartialSuccess{
+			RejectedDataPoints: r.Rejected,
+			ErrorMessage:       fmt.Sprintf("%d data points rejected: missing or non-finite values or unsupported types", r.Rejected),
+		}
+	}
+	return resp
+}
+
+// cumulative последнее значение накопительного ряда.
+type cumulative struct {
+	start uint64
+	value float64
+	sum   float64
+}
+
+// Receiver превращает запросы OTLP в метрики и сохраняет их. Для накопительных сумм и гистограмм
+// хранит последнее значение ряда и пишет только прирост. Первое значение ряда и значение после сброса
+// (уменьшение или новое время старта) пишутся целиком. Один Receiver обслуживает и HTTP, и gRPC.
+type Receiver struct {
+	writer ingest.Writer
+	namer  Namer
+	last   *ingest.Series[cumulative]
+}
+
+// NewReceiver конструктор для Receiver.
+func NewReceiver(writer ingest.Writer, namer Namer) *Receiver {
+	return &Receiver{writer: writer, namer: namer, last: ingest.NewSeries[cumulative](ingest.DefaultSeriesTTL)}
+}
+
+// Export сохраняет все точки запроса одной пачкой.
+func (r *Receiver) Export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (Result, error) {
+	b := &batch{receiver: r, pending: make(map[string]cumulative), pendingIDs: make(map[string]string), index: make(map[string]int), times: make(map[string]uint64)}
+	for _, rm := range req.GetResourceMetrics() {
+		resource := rm.GetResource().GetAttributes()
+		resourceKey := attrsKey(resource)
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, m := range sm.GetMetrics() {
+				b.addMetric(resource, resourceKey, m)
+			}
+		}
+	}
+
+	result := Result{Rejected: b.rejected}
+	if len(b.metrics) == 0 {
+		return result, nil
+	}
+	stored, err := ingest.Store(ctx, r.writer, "otlp", b.metrics)
+	if err != nil {
+		return result, err
+	}
+	unsaved := ingest.Unsaved(stored)
+	for key := range b.pending {
+		if unsaved[b.pendingIDs[key]] {
+			delete(b.pending, key)
+		}
+	}
+	r.last.Store(b.pending)
+	result.Stored = stored.Accepted
+	result.Rejected += int64(stored.Rejected)
+	return result, nil
+}
+
+// batch копит метрики одного запроса.
+type batch struct {
+	receiver *Receiver
+	metrics  []*models.Metrics
+	index    map[string]int
+	times    map[string]uint64
+	pending  map[string]cumulative
+	// pendingIDs имя counter, в который пишется прирост накопительного ряда
+	pendingIDs map[string]string
+	rejected   int64
+}
+
+func (b *batch) addMetric(resource []*commonpb.KeyValue, resourceKey string, m *metricspb.Metric) {
+	name := func(attrs []*commonpb.KeyValue) string {
+		return b.receiver.namer.Name(resource, m.GetName(), attrs)
+	}
+	key := func(attrs []*commonpb.KeyValue) string {
+		return resourceKey + "\xfd" + m.GetName() + "\xfd" + attrsKey(attrs)
+	}
+
+	switch data := m.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		for _, dp := range data.Gauge.GetDataPoints() {
+			value, ok := numberValue(dp)
+			if !ok {
+				b.rejected++
+				continue
+			}
+			b.setGauge(name(dp.GetAttributes()), value, dp.GetTimeUnixNano())
+		}
+	case *metricspb.Metric_Sum:
+		sum := data.Sum
+		for _, dp := range sum.GetDataPoints() {
+			value, ok := numberValue(dp)
+			if !ok {
+				b.rejected++
+				continue
+			}
+			id := name(dp.GetAttributes())
+			switch {
+			case !sum.GetIsMonotonic():
+				b.setGauge(id, value, dp.GetTimeUnixNano())
+			case sum.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE:
+				delta, _ := b.cumulativeDelta(key(dp.GetAttributes()), id, dp.GetStartTimeUnixNano(), value, 0)
+				b.addCounter(id, int64(delta))
+			default:
+				b.addCounter(id, int64(math.Round(value)))
+			}
+		}
+	case *metricspb.Metric_Histogram:
+		cumulativeTemp := data.Histogram.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+		for _, dp := range data.Histogram.GetDataPoints() {
+			if !finite(dp.GetSum()) || !finite(dp.GetMin()) || !finite(dp.GetMax()) {
+				b.rejected++
+				continue
+			}
+			id := name(dp.GetAttributes())
+			b.addDistribution(id, key(dp.GetAttributes()), cumulativeTemp, dp.GetStartTimeUnixNano(), dp.GetTimeUnixNano(),
+				dp.GetCount(), dp.Sum, dp.Min, dp.Max)
+			for _, p := range Percentiles {
+				if v, ok := bucketQuantile(dp, p/100); ok {
+					b.setGauge(id+".p"+strconv.FormatFloat(p, 'f', -1, 64), v, dp.GetTimeUnixNano())
+				}
+			}
+		}
+	case *metricspb.Metric_ExponentialHistogram:
+		cumulativeTemp := data.ExponentialHistogram.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+		for _, dp := range data.ExponentialHistogram.GetDataPoints() {
+			if !finite(dp.GetSum()) || !finite(dp.GetMin()) || !finite(dp.GetMax()) {
+				b.rejected++
+				continue
+			}
+			b.addDistribution(name(dp.GetAttributes()), key(dp.GetAttributes()), cumulativeTemp, dp.GetStartTimeUnixNano(), dp.GetTimeUnixNano(),
+				dp.GetCount(), dp.Sum, dp.Min, dp.Max)
+		}
+	case *metricspb.Metric_Summary:
+		for _, dp := range data.Summary.GetDataPoints() {
+			if !finite(dp.GetSum()) {
+				b.rejected++
+				continue
+			}
+			id := name(dp.GetAttributes())
+			sum := dp.GetSum()
+			// summary в OTLP всегда накопительный
+			b.addDistribution(id, key(dp.GetAttributes()), true, dp.GetStartTimeUnixNano(), dp.GetTimeUnixNano(),
+				dp.GetCount(), &sum, nil, nil)
+			for _, q := range dp.GetQuantileValues() {
+				if finite(q.GetValue()) {
+					b.setGauge(id+".p"+strconv.FormatFloat(q.GetQuantile()*100, 'f', -1, 64), q.GetValue(), dp.GetTimeUnixNano())
+				}
+			}
+		}
+	default:
+		b.rejected++
+	}
+}
+
+// addDistribution сворачивает гистограмму в .count (counter), .sum, .mean, .min и .max (gauge).
+// .sum, .min и .max пишутся как есть, а для накопительной гистограммы .count и .mean
+// считаются по приросту с прошлого запроса.
+func (b *batch) addDistribution(id, key string, cumulativeTemp bool, start, ts uint64, count uint64, sum, min, max *float64) {
+	countDelta, sumDelta := float64(count), sum
+	if cumulativeTemp {
+		var s float64
+		if sum != nil {
+			s = *sum
+		}
+		var ds float64
+		countDelta, ds = b.cumulativeDelta(key, id+".count", start, float64(count), s)
+		if sum != nil {
+			sumDelta = &ds
+		}
+	}
+	b.addCounter(id+".count", int64(countDelta))
+	if sum != nil {
+		b.setGauge(id+".sum", *sum, ts)
+		if countDelta > 0 {
+			b.setGauge(id+".mean", *sumDelta/countDelta, ts)
+		}
+	}
+	if min != nil {
+		b.setGauge(id+".min", *min, ts)
+	}
+	if max != nil {
+		b.setGauge(id+".max", *max, ts)
+	}
+}
+
+// cumulativeDelta возвращает прирост накопительного значения (округленный вниз, как в remote_write)
+// и прирост сопутствующей суммы с прошлой точки ряда. Прирост пишется в counter counterID.
+func (b *batch) cumulativeDelta(key, counterID string, start uint64, value, sum float64) (float64, float64) {
+	last, seen := b.pending[key]
+	if !seen {
+		last, seen = b.receiver.last.Load(key)
+	}
+	b.pending[key] = cumulative{start: start, value: value, sum: sum}
+	b.pendingIDs[key] = counterID
+	if !seen || value < last.value || (start != 0 && start != last.start) {
+		// первое значение ряда или сброс
+		return math.Floor(value), sum
+	}
+	return math.Floor(value) - math.Floor(last.value), sum - last.sum
+}
+
+func (b *batch) addCounter(name string, delta int64) {
+	key := "counter:" + name
+	if i, ok := b.index[key]; ok {
+		*b.metrics[i].Delta += delta
+		return
+	}
+	b.index[key] = len(b.metrics)
+	b.metrics = append(b.metrics, &models.Metrics{ID: name, MType: "counter", Delta: &delta})
+}
+
+func (b *batch) setGauge(name string, value float64, ts uint64) {
+	key := "gauge:" + name
+	if i, ok := b.index[key]; ok {
+		if ts < b.times[key] {
+			return
+		}
+		*b.metrics[i].Value = value
+		b.times[key] = ts
+		return
+	}
+	b.index[key] = len(b.metrics)
+	b.times[key] = ts
+	b.metrics = append(b.metrics, &models.Metrics{ID: name, MType: "gauge", Value: &value})
+}
+
+// bucketQuantile оценивает квантиль линейной интерполяцией внутри корзины.
+func bucketQuantile(dp *metricspb.HistogramDataPoint, q float64) (float64, bool) {
+	bounds, counts := dp.GetExplicitBounds(), dp.GetBucketCounts()
+	if len(bounds) == 0 || len(counts) != len(bounds)+1 {
+		return 0, false
+	}
+	var total uint64
+	for _, c := range counts {
+		total += c
+	}
+	if total == 0 {
+		return 0, false
+	}
+
+	rank := q * float64(total)
+	var seen uint64
+	for i, c := range counts {
+		if c == 0 || float64(seen+c) < rank {
+			seen += c
+			continue
+		}
+		var lower, upper float64
+		switch {
+		case i == 0:
+			lower, upper = math.Min(0, bounds[0]), bounds[0]
+			if dp.Min != nil {
+				lower = *dp.Min
+			}
+		case i == len(bounds):
+			lower, upper = bounds[i-1], bounds[i-1]
+			if dp.Max != nil {
+				upper = *dp.Max
+			}
+		default:
+			lower, upper = bounds[i-1], bounds[i]
+		}
+		return lower + (upper-lower)*(rank-float64(seen))/float64(c), true
+	}
+	return bounds[len(bounds)-1], true
+}
+
+// numberValue возвращает значение точки, если оно задано и конечно.
+func numberValue(dp *metricspb.NumberDataPoint) (float64, bool) {
+	switch v := dp.GetValue().(type) {
+	case *metricspb.NumberDataPoint_AsDouble:
+		return v.AsDouble, finite(v.AsDouble)
+	case *metricspb.NumberDataPoint_AsInt:
+		return float64(v.AsInt), true
+	}
+	return 0, false
+}
+
+// sortedAttrs возвращает атрибуты, отсортированные по ключу.
+func sortedAttrs(attrs []*commonpb.KeyValue) []*commonpb.KeyValue {
+	sorted := append([]*commonpb.KeyValue(nil), attrs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+	return sorted
+}
+
+// attrsKey однозначно идентифицирует набор атрибутов.
+func attrsKey(attrs []*commonpb.KeyValue) string {
+	pairs := make([]string, 0, len(attrs))
+	for _, kv := range sortedAttrs(attrs) {
+		pairs = append(pairs, kv.Key+"\xff"+anyValueString(kv.Value))
+	}
+	return strings.Join(pairs, "\xfe")
+}
+
+// anyValueString приводит значение атрибута к строке. Массивы и словари не поддерживаются.
+func anyValueString(v *commonpb.AnyValue) string {
+	switch val := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return val.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(val.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(val.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(val.DoubleValue, 'f', -1, 64)
+	}
+	return ""
+}
+
+// finite отсекает NaN и бесконечности, которые нельзя сохранить.
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
@@ -0,0 +1,148 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/sebasttiano/Blackbird.git/internal/ingest/ingesttest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+func attr(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func request(metrics ...*metricspb.Metric) *colmetricspb.ExportMetricsServiceRequest {
+	return &colmetricspb.ExportMetricsServiceRequest{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		Resource:     &resourcepb.Resource{Attributes: []*commonpb.KeyValue{attr("service.name", "checkout"), attr("host.name", "web01")}},
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: metrics}},
+	}}}
+}
+
+func sum(name string, temporality metricspb.AggregationTemporality, monotonic bool, start uint64, value float64) *metricspb.Metric {
+	return &metricspb.Metric{Name: name, Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+		AggregationTemporality: temporality,
+		IsMonotonic:            monotonic,
+		DataPoints: []*metricspb.NumberDataPoint{{
+			StartTimeUnixNano: start,
+			Attributes:        []*commonpb.KeyValue{attr("method", "GET")},
+			Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+		}},
+	}}}
+}
+
+func TestNamer_Name(t *testing.T) {
+	resource := []*commonpb.KeyValue{attr("service.name", "checkout"), attr("host.name", "web01")}
+	attrs := []*commonpb.KeyValue{attr("status", "200"), attr("method", "GET")}
+
+	assert.Equal(t, "http.requests.GET.200", Namer{}.Name(resource, "http.requests", attrs))
+	assert.Equal(t, "checkout.http.requests", Namer{ResourceAttributes: []string{"service.name"}}.Name(resource, "http.requests", nil))
+	assert.Equal(t, "web01.checkout.up", Namer{ResourceAttributes: []string{"host.name", "missing", "service.name"}}.Name(resource, "up", nil))
+}
+
+func TestReceiver_Export(t *testing.T) {
+	w := ingesttest.NewWriter()
+	r := NewReceiver(w, Namer{ResourceAttributes: []string{"service.name"}})
+	ctx := context.Background()
+
+	cumulative := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	delta := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+	minV, maxV, sumV := 0.5, 8.0, 30.0
+
+	first := request(
+		&metricspb.Metric{Name: "memory.used", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{
+			{TimeUnixNano: 2, Value: &metricspb.NumberDataPoint_AsInt{AsInt: 512}},
+			{TimeUnixNano: 1, Value: &metricspb.NumberDataPoint_AsInt{AsInt: 100}},
+			{TimeUnixNano: 3, Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: math.NaN()}},
+		}}}},
+		sum("requests", cumulative, true, 100, 10),
+		sum("jobs", delta, true, 0, 3),
+		sum("queue.size", cumulative, false, 100, 7),
+		&metricspb.Metric{Name: "latency", Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+			AggregationTemporality: delta,
+			DataPoints: []*metricspb.HistogramDataPoint{{
+				Count: 10, Sum: &sumV, Min: &minV, Max: &maxV,
+				ExplicitBounds: []float64{1, 5},
+				BucketCounts:   []uint64{2, 6, 2},
+			}},
+		}}},
+		&metricspb.Metric{Name: "rpc", Data: &metricspb.Metric_Summary{Summary: &metricspb.Summary{DataPoints: []*metricspb.SummaryDataPoint{{
+			StartTimeUnixNano: 100, Count: 4, Sum: 2,
+			QuantileValues: []*metricspb.SummaryDataPoint_ValueAtQuantile{{Quantile: 0.99, Value: 0.9}},
+		}}}}},
+		&metricspb.Metric{Name: "empty"},
+	)
+	result, err := r.Export(ctx, first)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), result.Rejected)
+	assert.Equal(t, int64(2), result.Response().PartialSuccess.RejectedDataPoints)
+
+	assert.Equal(t, 512.0, w.Value("checkout.memory.used"))
+	assert.Equal(t, 10.0, w.Value("checkout.requests.GET"))
+	assert.Equal(t, 3.0, w.Value("checkout.jobs.GET"))
+	assert.Equal(t, 7.0, w.Value("checkout.queue.size.GET"))
+	assert.Equal(t, 10.0, w.Value("checkout.latency.count"))
+	assert.Equal(t, 30.0, w.Value("checkout.latency.sum"))
+	assert.Equal(t, 3.0, w.Value("checkout.latency.mean"))
+	assert.Equal(t, 0.5, w.Value("checkout.latency.min"))
+	assert.Equal(t, 8.0, w.Value("checkout.latency.max"))
+	assert.InDelta(t, 3.0, w.Value("checkout.latency.p50"), 1e-9)
+	assert.InDelta(t, 6.5, w.Value("checkout.latency.p90"), 1e-9)
+	assert.Equal(t, 4.0, w.Value("checkout.rpc.count"))
+	assert.Equal(t, 0.9, w.Value("checkout.rpc.p99"))
+
+	// накопительная сумма пишется приростом, после ошибки записи базовое значение не сдвигается
+	w.Fail(errors.New("storage is down"))
+	_, err = r.Export(ctx, request(sum("requests", cumulative, true, 100, 15)))
+	require.Error(t, err)
+	w.Fail(nil)
+	_, err = r.Export(ctx, request(sum("requests", cumulative, true, 100, 15)))
+	require.NoError(t, err)
+	assert.Equal(t, 15.0, w.Value("checkout.requests.GET"))
+
+	// новое время старта означает перезапуск источника, значение пишется целиком
+	_, err = r.Export(ctx, request(sum("requests", cumulative, true, 200, 4)))
+	require.NoError(t, err)
+	assert.Equal(t, 19.0, w.Value("checkout.requests.GET"))
+}
+
+func TestDecodeRequest(t *testing.T) {
+	req := request(sum("requests", metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA, true, 0, 1))
+	pbBody, err := proto.Marshal(req)
+	require.NoError(t, err)
+	jsonBody, err := protojson.Marshal(req)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		body        []byte
+		contentType string
+		wantErr     error
+	}{
+		{name: "OK protobuf", body: pbBody, contentType: ContentTypeProtobuf},
+		{name: "OK json", body: jsonBody, contentType: "application/json; charset=utf-8"},
+		{name: "NOT OK content type", body: pbBody, contentType: "text/plain", wantErr: ErrContentType},
+		{name: "NOT OK broken json", body: []byte("{"), contentType: ContentTypeJSON, wantErr: ErrDecode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeRequest(bytes.NewReader(tt.body), tt.contentType)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, proto.Equal(req, got))
+		})
+	}
+}
@@ -0,0 +1,298 @@
+// Package remotewrite принимает метрики по протоколу Prometheus remote_write
+// и превращает временные ряды в gauge и counter метрики Blackbird.
+package remotewrite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/sebasttiano/Blackbird.git/internal/ingest"
+	"github.com/sebasttiano/Blackbird.git/internal/models"
+	"github.com/sebasttiano/Blackbird.git/internal/proto/prompb"
+	"google.golang.org/protobuf/proto"
+)
+
+// MaxBodySize максимальный размер сжатого тела запроса.
+const MaxBodySize = 32 << 20
+
+// nameLabel метка с именем метрики в Prometheus.
+const nameLabel = "__name__"
+
+const (
+	// TypeGauge ряд сохраняется как gauge, последнее значение побеждает.
+	TypeGauge = "gauge"
+	// TypeCounter ряд считается накопительным счетчиком, в Blackbird пишется прирост.
+	TypeCounter = "counter"
+	// TypeDrop ряд отбрасывается.
+	TypeDrop = "drop"
+)
+
+// ErrDecode ошибка, если тело запроса не является сжатым snappy WriteRequest.
+var ErrDecode = errors.New("failed to decode remote write request")
+
+// ErrInvalidRule ошибка в правиле маппинга.
+var ErrInvalidRule = errors.New("invalid remote write rule")
+
+// Rule правило маппинга временного ряда на метрику Blackbird. Применяется первое подходящее правило.
+type Rule struct {
+	// Match шаблон имени метрики в синтаксисе path.Match.
+	Match string `json:"match"`
+	// Type gauge, counter или drop. Пустой тип определяется по метаданным и суффиксу имени.
+	Type string `json:"type"`
+	// Name новое имя метрики, по умолчанию имя ряда.
+	Name string `json:"name"`
+	// Labels значения этих меток дописываются к имени через точку, например http_requests_total.GET.
+	Labels []string `json:"labels"`
+}
+
+// LoadRules читает правила маппинга из JSON файла.
+func LoadRules(filename string) ([]Rule, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRule, err)
+	}
+	if err := ValidateRules(rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// ValidateRules проверяет шаблоны и типы правил.
+func ValidateRules(rules []Rule) error {
+	for i, rule := range rules {
+		if _, err := path.Match(rule.Match, ""); err != nil {
+			return fmt.Errorf("%w: rule %d: bad match %q", ErrInvalidRule, i, rule.Match)
+		}
+		switch rule.Type {
+		case "", TypeGauge, TypeCounter, TypeDrop:
+		default:
+			return fmt.Errorf("%w: rule %d: unknown type %q", ErrInvalidRule, i, rule.Type)
+		}
+	}
+	return nil
+}
+
+// Decode читает и распаковывает тело запроса remote_write.
+func Decode(body io.Reader) (*prompb.WriteRequest, error) {
+	compressed, err := io.ReadAll(io.LimitReader(body, MaxBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecode, err)
+	}
+	if len(compressed) > MaxBodySize {
+		return nil, fmt.Errorf("%w: body is larger than %d bytes", ErrDecode, MaxBodySize)
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecode, err)
+	}
+	var req prompb.WriteRequest
+	if err := proto.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecode, err)
+	}
+	return &req, nil
+}
+
+// Receiver превращает WriteRequest в метрики и сохраняет их.
+// Для накопительных счетчиков хранит последнее значение каждого ряда и пишет в Blackbird только прирост.
+// Первое значение ряда и значение после сброса счетчика пишутся целиком.
+type Receiver struct {
+	writer ingest.Writer
+	rules  []Rule
+	last   *ingest.Series[float64]
+}
+
+// NewReceiver конструктор для Receiver.
+func NewReceiver(writer ingest.Writer, rules []Rule) *Receiver {
+	return &Receiver{writer: writer, rules: rules, last: ingest.NewSeries[float64](ingest.DefaultSeriesTTL)}
+}
+
+// Write сохраняет все ряды запроса одной пачкой и возвращает количество записанных метрик.
+// Сэмплы NaN, включая stale маркеры, и бесконечности пропускаются. Метрики, отклоненные сервисом,
+// не мешают записи остальных.
+func (r *Receiver) Write(ctx context.Context, req *prompb.WriteRequest) (int, error) {
+	types := make(map[string]string, len(req.Metadata))
+	for _, md := range req.Metadata {
+		switch md.Type {
+		case prompb.MetricMetadata_COUNTER:
+			types[md.MetricFamilyName] = TypeCounter
+		case prompb.MetricMetadata_GAUGE:
+			types[md.MetricFamilyName] = TypeGauge
+		}
+	}
+
+	gauges := make(map[string]*models.Metrics)
+	gaugeTimes := make(map[string]int64)
+	counters := make(map[string]*models.Metrics)
+	pending := make(map[string]float64)
+	pendingIDs := make(map[string]string)
+	var order []string
+
+	for _, ts := range req.Timeseries {
+		labels := labelMap(ts.Labels)
+		name := labels[nameLabel]
+		if name == "" {
+			continue
+		}
+		rule := r.match(name)
+		mType := rule.Type
+		if mType == "" {
+			mType = inferType(name, types)
+		}
+		if mType == TypeDrop {
+			continue
+		}
+		id := metricName(name, rule, labels)
+
+		switch mType {
+		case TypeGauge:
+			for _, sample := range ts.Samples {
+				if !isFinite(sample.Value) {
+					continue
+				}
+				if t, ok := gaugeTimes[id]; ok && sample.Timestamp < t {
+					continue
+				}
+				value := sample.Value
+				if _, ok := gauges[id]; !ok {
+					order = append(order, id)
+				}
+				gauges[id] = &models.Metrics{ID: id, MType: TypeGauge, Value: &value}
+				gaugeTimes[id] = sample.Timestamp
+			}
+		case TypeCounter:
+			key := seriesKey(ts.Labels)
+			last, seen := pending[key]
+			if !seen {
+				last, seen = r.last.Load(key)
+			}
+			var delta int64
+			for _, sample := range ts.Samples {
+				if !isFinite(sample.Value) {
+					continue
+				}
+				if !seen || sample.Value < last {
+					// первое значение ряда или сброс счетчика
+					delta += int64(math.Floor(sample.Value))
+				} else {
+					delta += int64(math.Floor(sample.Value)) - int64(math.Floor(last))
+				}
+				last, seen = sample.Value, true
+				pending[key], pendingIDs[key] = last, id
+			}
+			if delta == 0 {
+				continue
+			}
+			if m, ok := counters[id]; ok {
+				*m.Delta += delta
+			} else {
+				counters[id] = &models.Metrics{ID: id, MType: TypeCounter, Delta: &delta}
+				order = append(order, id)
+			}
+		}
+	}
+
+	metrics := make([]*models.Metrics, 0, len(order))
+	for _, id := range order {
+		if m, ok := gauges[id]; ok {
+			metrics = append(metrics, m)
+			delete(gauges, id)
+			continue
+		}
+		if m, ok := counters[id]; ok {
+			metrics = append(metrics, m)
+			delete(counters, id)
+		}
+	}
+	if len(metrics) == 0 {
+		return 0, nil
+	}
+
+	result, err := ingest.Store(ctx, r.writer, "remote_write", metrics)
+	if err != nil {
+		return 0, err
+	}
+	unsaved := ingest.Unsaved(result)
+	for key := range pending {
+		if unsaved[pendingIDs[key]] {
+			delete(pending, key)
+		}
+	}
+	r.last.Store(pending)
+	return result.Accepted, nil
+}
+
+// match возвращает первое подходящее под имя правило или пустое правило.
+func (r *Receiver) match(name string) Rule {
+	for _, rule := range r.rules {
+		if ok, _ := path.Match(rule.Match, name); ok {
+			return rule
+		}
+	}
+	return Rule{}
+}
+
+// inferType определяет тип ряда по метаданным, а без них по суффиксу имени.
+func inferType(name string, types map[string]string) string {
+	if t, ok := types[name]; ok {
+		return t
+	}
+	for _, suffix := range []string{"_total", "_count", "_sum", "_bucket"} {
+		if strings.HasSuffix(name, suffix) {
+			if t, ok := types[strings.TrimSuffix(name, suffix)]; ok && t == TypeGauge {
+				return TypeGauge
+			}
+			return TypeCounter
+		}
+	}
+	return TypeGauge
+}
+
+// metricName строит имя метрики Blackbird из правила и меток ряда.
+func metricName(name string, rule Rule, labels map[string]string) string {
+	if rule.Name != "" {
+		name = rule.Name
+	}
+	for _, label := range rule.Labels {
+		if v := labels[label]; v != "" {
+			name += "." + v
+		}
+	}
+	return name
+}
+
+// labelMap собирает метки ряда в карту.
+func labelMap(labels []*prompb.Label) map[string]string {
+	m := make(map[string]string, len(labels))
+	for _, l := range labels {
+		m[l.Name] = l.Value
+	}
+	return m
+}
+
+// seriesKey однозначно идентифицирует ряд по полному набору меток.
+func seriesKey(labels []*prompb.Label) string {
+	pairs := make([]string, 0, len(labels))
+	for _, l := range labels {
+		pairs = append(pairs, l.Name+"\xff"+l.Value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "\xfe")
+}
+
+// isFinite отсекает NaN и бесконечности, которые нельзя сохранить.
+func isFinite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
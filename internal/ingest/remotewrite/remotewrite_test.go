@@ -0,0 +1,145 @@
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/sebasttiano/Blackbird.git/internal/ingest/ingesttest"
+	"github.com/sebasttiano/Blackbird.git/internal/models"
+	"github.com/sebasttiano/Blackbird.git/internal/proto/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func series(name string, value float64, ts int64, labels ...string) *prompb.TimeSeries {
+	s := &prompb.TimeSeries{Labels: []*prompb.Label{{Name: nameLabel, Value: name}}}
+	for i := 0; i+1 < len(labels); i += 2 {
+		s.Labels = append(s.Labels, &prompb.Label{Name: labels[i], Value: labels[i+1]})
+	}
+	s.Samples = []*prompb.Sample{{Value: value, Timestamp: ts}}
+	return s
+}
+
+func TestDecode(t *testing.T) {
+	req := &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{series("up", 1, 1000)}}
+	data, err := proto.Marshal(req)
+	require.NoError(t, err)
+
+	got, err := Decode(bytes.NewReader(snappy.Encode(nil, data)))
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(req, got))
+
+	_, err = Decode(bytes.NewReader(data))
+	assert.ErrorIs(t, err, ErrDecode)
+}
+
+func TestReceiver_Write(t *testing.T) {
+	w := ingesttest.NewWriter()
+	r := NewReceiver(w, []Rule{
+		{Match: "http_requests_total", Labels: []string{"method"}},
+		{Match: "go_*", Type: TypeDrop},
+		{Match: "temperature", Type: TypeGauge, Name: "room.temp", Labels: []string{"room"}},
+	})
+	ctx := context.Background()
+
+	first := &prompb.WriteRequest{
+		Timeseries: []*prompb.TimeSeries{
+			series("http_requests_total", 10, 1000, "method", "GET", "instance", "a"),
+			series("http_requests_total", 5, 1000, "method", "GET", "instance", "b"),
+			series("go_goroutines", 12, 1000),
+			series("temperature", 21.5, 1000, "room", "lab"),
+			series("jobs", 3, 1000),
+			series("up", math.NaN(), 1000),
+		},
+		Metadata: []*prompb.MetricMetadata{{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "jobs"}},
+	}
+	n, err := r.Write(ctx, first)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, map[string]float64{"http_requests_total.GET": 15, "room.temp.lab": 21.5, "jobs": 3}, w.Values())
+
+	// второй запрос пишет только прирост, а сброс счетчика пишет значение целиком
+	w.Reset()
+	second := &prompb.WriteRequest{
+		Timeseries: []*prompb.TimeSeries{
+			series("http_requests_total", 12.7, 2000, "method", "GET", "instance", "a"),
+			series("http_requests_total", 2, 2000, "method", "GET", "instance", "b"),
+			series("jobs", 3, 2000),
+		},
+		Metadata: []*prompb.MetricMetadata{{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "jobs"}},
+	}
+	n, err = r.Write(ctx, second)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, map[string]float64{"http_requests_total.GET": 4}, w.Values())
+
+	// при ошибке сохранения прирост не теряется
+	w.Reset()
+	w.Fail(errors.New("storage is down"))
+	third := &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{series("http_requests_total", 20, 3000, "method", "GET", "instance", "a")}}
+	_, err = r.Write(ctx, third)
+	assert.Error(t, err)
+	w.Fail(nil)
+	_, err = r.Write(ctx, third)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"http_requests_total.GET": 8}, w.Values())
+}
+
+func TestReceiver_WriteRejected(t *testing.T) {
+	w := ingesttest.NewWriter()
+	r := NewReceiver(w, nil)
+	ctx := context.Background()
+	metadata := []*prompb.MetricMetadata{
+		{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "jobs"},
+		{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "temp"},
+		{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "_bb.up"},
+	}
+
+	// отклоненная сервисом метрика не мешает записи остальных
+	w.Reject(models.ReasonReservedName, "_bb.up")
+	w.Reject(models.ReasonStorageError, "jobs")
+	n, err := r.Write(ctx, &prompb.WriteRequest{
+		Timeseries: []*prompb.TimeSeries{series("_bb.up", 1, 1000), series("temp", 21.5, 1000), series("jobs", 3, 1000)},
+		Metadata:   metadata,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, map[string]float64{"temp": 21.5}, w.Values())
+
+	// прирост счетчика, который не записало хранилище, уходит со следующим запросом
+	w.Reject("", "jobs")
+	_, err = r.Write(ctx, &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{series("jobs", 5, 2000)}, Metadata: metadata})
+	require.NoError(t, err)
+	assert.Equal(t, 5.0, w.Value("jobs"))
+}
+
+func TestInferType(t *testing.T) {
+	types := map[string]string{"queue_depth": TypeGauge, "processed": TypeCounter}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "queue_depth", want: TypeGauge},
+		{name: "processed", want: TypeCounter},
+		{name: "requests_total", want: TypeCounter},
+		{name: "latency_bucket", want: TypeCounter},
+		{name: "queue_depth_sum", want: TypeGauge},
+		{name: "memory_bytes", want: TypeGauge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, inferType(tt.name, types))
+		})
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	assert.NoError(t, ValidateRules([]Rule{{Match: "node_*", Type: TypeGauge}, {Match: "*"}}))
+	assert.ErrorIs(t, ValidateRules([]Rule{{Match: "[", Type: TypeGauge}}), ErrInvalidRule)
+	assert.ErrorIs(t, ValidateRules([]Rule{{Match: "*", Type: "histogram"}}), ErrInvalidRule)
+}
@@ -0,0 +1,76 @@
+package ingest
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultSeriesTTL сколько хранится последнее значение ряда, который перестали присылать.
+const DefaultSeriesTTL = time.Hour
+
+// Series последние значения накопительных рядов, по ним приемники считают прирост. Ряды, которые
+// не обновлялись дольше ttl, забываются, иначе состояние росло бы с каждой новой комбинацией меток.
+// Забытый ряд считается новым, и его следующее значение пишется целиком.
+//
+// Блокировка не держится во время записи пачки: приемник читает значения через Load, пишет пачку
+// и сохраняет новые значения через Store. Поэтому один ряд должен приходить от одного отправителя
+// по порядку, как это делают Prometheus и OpenTelemetry Collector.
+type Series[V any] struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]seriesEntry[V]
+	swept   time.Time
+	now     func() time.Time
+}
+
+type seriesEntry[V any] struct {
+	value V
+	seen  time.Time
+}
+
+// NewSeries конструктор для Series. При ttl <= 0 ряды не забываются.
+func NewSeries[V any](ttl time.Duration) *Series[V] {
+	return &Series[V]{ttl: ttl, entries: make(map[string]seriesEntry[V]), now: time.Now}
+}
+
+// Load возвращает последнее значение ряда, если оно есть и не устарело.
+func (s *Series[V]) Load(key string) (V, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok || s.expired(e, s.now()) {
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
+
+// Store сохраняет значения рядов после записи пачки и удаляет устаревшие ряды не чаще раза в ttl.
+func (s *Series[V]) Store(values map[string]V) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for key, value := range values {
+		s.entries[key] = seriesEntry[V]{value: value, seen: now}
+	}
+	if s.ttl <= 0 || now.Sub(s.swept) < s.ttl {
+		return
+	}
+	for key, e := range s.entries {
+		if s.expired(e, now) {
+			delete(s.entries, key)
+		}
+	}
+	s.swept = now
+}
+
+// Len количество хранимых рядов.
+func (s *Series[V]) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+func (s *Series[V]) expired(e seriesEntry[V], now time.Time) bool {
+	return s.ttl > 0 && now.Sub(e.seen) > s.ttl
+}
@@ -0,0 +1,51 @@
+package ingest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeries(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewSeries[float64](time.Minute)
+	s.now = func() time.Time { return now }
+
+	_, ok := s.Load("a")
+	assert.False(t, ok)
+
+	s.Store(map[string]float64{"a": 1, "b": 2})
+	v, ok := s.Load("a")
+	assert.True(t, ok)
+	assert.Equal(t, float64(1), v)
+
+	// a обновляется, b перестали присылать
+	now = now.Add(45 * time.Second)
+	s.Store(map[string]float64{"a": 3})
+	now = now.Add(30 * time.Second)
+	_, ok = s.Load("b")
+	assert.False(t, ok, "expired series is treated as new")
+	v, ok = s.Load("a")
+	assert.True(t, ok)
+	assert.Equal(t, float64(3), v)
+	assert.Equal(t, 2, s.Len(), "expired series stays until the next sweep")
+
+	s.Store(map[string]float64{"c": 4})
+	assert.Equal(t, 2, s.Len())
+	_, ok = s.Load("b")
+	assert.False(t, ok)
+}
+
+func TestSeries_NoTTL(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewSeries[float64](0)
+	s.now = func() time.Time { return now }
+
+	s.Store(map[string]float64{"a": 1})
+	now = now.Add(24 * time.Hour)
+	s.Store(map[string]float64{"b": 2})
+	_, ok := s.Load("a")
+	assert.True(t, ok)
+	assert.Equal(t, 2, s.Len())
+}
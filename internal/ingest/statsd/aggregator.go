@@ -0,0 +1,136 @@
+package statsd
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/sebasttiano/Blackbird.git/internal/models"
+)
+
+// Percentiles перцентили, которые считаются для таймеров.
+var Percentiles = []float64{50, 90, 95, 99}
+
+// Aggregator копит значения между сбросами. Counter суммируются с учетом частоты сэмплирования,
+// для gauge сохраняется последнее значение, таймеры сворачиваются в статистику, set считает уникальные значения.
+type Aggregator struct {
+	mu       sync.Mutex
+	counters map[string]float64
+	gauges   map[string]float64
+	changed  map[string]struct{}
+	timers   map[string][]float64
+	counts   map[string]float64
+	sets     map[string]map[string]struct{}
+}
+
+// NewAggregator конструктор для Aggregator.
+func NewAggregator() *Aggregator {
+	return &Aggregator{
+		counters: make(map[string]float64),
+		gauges:   make(map[string]float64),
+		changed:  make(map[string]struct{}),
+		timers:   make(map[string][]float64),
+		counts:   make(map[string]float64),
+		sets:     make(map[string]map[string]struct{}),
+	}
+}
+
+// Add учитывает значение в текущем интервале.
+func (a *Aggregator) Add(s Sample) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch s.Type {
+	case TypeCounter:
+		a.counters[s.Name] += s.Value / s.Rate
+	case TypeGauge:
+		if s.Relative {
+			a.gauges[s.Name] += s.Value
+		} else {
+			a.gauges[s.Name] = s.Value
+		}
+		a.changed[s.Name] = struct{}{}
+	case TypeTimer, TypeHisto:
+		a.timers[s.Name] = append(a.timers[s.Name], s.Value)
+		a.counts[s.Name] += 1 / s.Rate
+	case TypeSet:
+		if a.sets[s.Name] == nil {
+			a.sets[s.Name] = make(map[string]struct{})
+		}
+		a.sets[s.Name][s.Raw] = struct{}{}
+	}
+}
+
+// Flush забирает накопленные за интервал метрики. Дробный остаток счетчиков переносится в следующий интервал,
+// последние значения gauge запоминаются для относительных изменений.
+func (a *Aggregator) Flush() []*models.Metrics {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var metrics []*models.Metrics
+	for name, sum := range a.counters {
+		delta := int64(math.Round(sum))
+		if rest := sum - float64(delta); rest != 0 {
+			a.counters[name] = rest
+		} else {
+			delete(a.counters, name)
+		}
+		if delta != 0 {
+			metrics = append(metrics, counter(name, delta))
+		}
+	}
+	for name := range a.changed {
+		metrics = append(metrics, gauge(name, a.gauges[name]))
+		delete(a.changed, name)
+	}
+	for name, values := range a.timers {
+		metrics = append(metrics, timerStats(name, values, a.counts[name])...)
+		delete(a.timers, name)
+		delete(a.counts, name)
+	}
+	for name, set := range a.sets {
+		metrics = append(metrics, gauge(name, float64(len(set))))
+		delete(a.sets, name)
+	}
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].ID < metrics[j].ID })
+	return metrics
+}
+
+// timerStats сворачивает значения таймера в count, sum, min, max, mean и перцентили.
+func timerStats(name string, values []float64, count float64) []*models.Metrics {
+	sort.Float64s(values)
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	stats := []*models.Metrics{
+		counter(name+".count", int64(math.Round(count))),
+		gauge(name+".sum", sum),
+		gauge(name+".min", values[0]),
+		gauge(name+".max", values[len(values)-1]),
+		gauge(name+".mean", sum/float64(len(values))),
+	}
+	for _, p := range Percentiles {
+		stats = append(stats, gauge(name+".p"+strconv.FormatFloat(p, 'f', -1, 64), percentile(values, p)))
+	}
+	return stats
+}
+
+// percentile возвращает перцентиль по методу ближайшего ранга, values должны быть отсортированы.
+func percentile(values []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(values))))
+	if rank < 1 {
+		rank = 1
+	}
+	return values[rank-1]
+}
+
+func counter(name string, delta int64) *models.Metrics {
+	return &models.Metrics{ID: name, MType: "counter", Delta: &delta}
+}
+
+func gauge(name string, value float64) *models.Metrics {
+	return &models.Metrics{ID: name, MType: "gauge", Value: &value}
+}
@@ -0,0 +1,104 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Типы метрик StatsD.
+const (
+	TypeCounter = "c"
+	TypeGauge   = "g"
+	TypeTimer   = "ms"
+	TypeHisto   = "h"
+	TypeSet     = "s"
+)
+
+// ErrParse ошибка разбора строки протокола StatsD.
+var ErrParse = errors.New("failed to parse statsd line")
+
+// Sample одно значение из строки протокола.
+type Sample struct {
+	Name  string
+	Type  string
+	Value float64
+	// Raw исходное значение, нужно для set.
+	Raw string
+	// Relative для gauge со знаком +/- значение прибавляется к текущему.
+	Relative bool
+	// Rate частота сэмплирования из @rate, по умолчанию 1.
+	Rate float64
+}
+
+// ParseLine разбирает строку вида name:value|type[|@rate][|#tags]. Теги DogStatsD игнорируются.
+func ParseLine(line string) (Sample, error) {
+	line = strings.TrimSpace(line)
+	pipe := strings.IndexByte(line, '|')
+	if pipe < 0 {
+		return Sample{}, fmt.Errorf("%w: %q: missing type", ErrParse, line)
+	}
+	colon := strings.LastIndexByte(line[:pipe], ':')
+	if colon <= 0 {
+		return Sample{}, fmt.Errorf("%w: %q: missing name or value", ErrParse, line)
+	}
+	name := sanitizeName(line[:colon])
+	if name == "" {
+		return Sample{}, fmt.Errorf("%w: %q: empty name", ErrParse, line)
+	}
+
+	parts := strings.Split(line[colon+1:], "|")
+	s := Sample{Name: name, Type: parts[1], Raw: parts[0], Rate: 1}
+
+	for _, opt := range parts[2:] {
+		if strings.HasPrefix(opt, "@") {
+			rate, err := strconv.ParseFloat(opt[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return Sample{}, fmt.Errorf("%w: %q: bad sample rate", ErrParse, line)
+			}
+			s.Rate = rate
+		}
+	}
+
+	switch s.Type {
+	case TypeSet:
+		if s.Raw == "" {
+			return Sample{}, fmt.Errorf("%w: %q: empty set value", ErrParse, line)
+		}
+		return s, nil
+	case TypeCounter, TypeGauge, TypeTimer, TypeHisto:
+	default:
+		return Sample{}, fmt.Errorf("%w: %q: unknown type %q", ErrParse, line, s.Type)
+	}
+
+	value, err := strconv.ParseFloat(s.Raw, 64)
+	if err != nil {
+		return Sample{}, fmt.Errorf("%w: %q: bad value", ErrParse, line)
+	}
+	// ParseFloat принимает NaN и Inf, такие значения испортили бы агрегаты
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return Sample{}, fmt.Errorf("%w: %q: value is not finite", ErrParse, line)
+	}
+	s.Value = value
+	s.Relative = s.Type == TypeGauge && (s.Raw[0] == '+' || s.Raw[0] == '-')
+	return s, nil
+}
+
+// sanitizeName заменяет пробелы и слэши, как это делает etsy statsd, и отбрасывает прочие спецсимволы.
+func sanitizeName(name string) string {
+	var b strings.Builder
+	b.Grow(len(name))
+	for _, r := range strings.TrimSpace(name) {
+		switch {
+		case r == ' ':
+			b.WriteByte('_')
+		case r == '/':
+			b.WriteByte('-')
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-', r == '.':
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,255 @@
+// Package statsd принимает метрики по протоколу StatsD через UDP и TCP,
+// агрегирует их за интервал и пишет в хранилище пачками.
+package statsd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sebasttiano/Blackbird.git/internal/audit"
+	"github.com/sebasttiano/Blackbird.git/internal/ingest"
+	"github.com/sebasttiano/Blackbird.git/internal/logger"
+	"go.uber.org/zap"
+)
+
+// DefaultFlushInterval интервал сброса агрегированных метрик по умолчанию.
+const DefaultFlushInterval = 10 * time.Second
+
+// maxPacketSize максимальный размер UDP датаграммы.
+const maxPacketSize = 65535
+
+// Server слушает StatsD на одном порту по UDP и TCP.
+type Server struct {
+	addr     string
+	interval time.Duration
+	writer   ingest.Writer
+	agg      *Aggregator
+
+	udp   net.PacketConn
+	tcp   net.Listener
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+	done  chan struct{}
+	stop  sync.Once
+
+	bad      atomic.Int64
+	rejected atomic.Int64
+}
+
+// NewServer конструктор для Server.
+func NewServer(addr string, interval time.Duration, writer ingest.Writer) *Server {
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+	return &Server{
+		addr:     addr,
+		interval: interval,
+		writer:   writer,
+		agg:      NewAggregator(),
+		conns:    make(map[net.Conn]struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Listen открывает UDP сокет и TCP листенер на том же порту.
+func (s *Server) Listen() error {
+	udp, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return err
+	}
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		udp.Close()
+		return err
+	}
+	s.udp, s.tcp = udp, tcp
+	return nil
+}
+
+// Addr возвращает адрес, на котором слушает сервер.
+func (s *Server) Addr() string {
+	if s.udp == nil {
+		return s.addr
+	}
+	return s.udp.LocalAddr().String()
+}
+
+// Serve принимает метрики и сбрасывает их каждый интервал, пока сервер не остановлен.
+func (s *Server) Serve() {
+	s.wg.Add(3)
+	go s.serveUDP()
+	go s.serveTCP()
+	go s.flushLoop()
+	s.wg.Wait()
+}
+
+// Start открывает сокеты и обслуживает их до остановки.
+func (s *Server) Start() {
+	if err := s.Listen(); err != nil {
+		logger.Log.Error("failed to start statsd listener", zap.String("address", s.addr), zap.Error(err))
+		return
+	}
+	logger.Log.Info("Running statsd listener", zap.String("address", s.Addr()))
+	s.Serve()
+}
+
+// Shutdown закрывает сокеты, дожидается обработчиков и сбрасывает последние накопленные метрики.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stop.Do(func() {
+		close(s.done)
+		if s.udp != nil {
+			s.udp.Close()
+		}
+		if s.tcp != nil {
+			s.tcp.Close()
+		}
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+	})
+	s.wg.Wait()
+	return s.Flush(ctx)
+}
+
+// HandleShutdown останавливает сервер по сигналу.
+func (s *Server) HandleShutdown(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	<-ctx.Done()
+	logger.Log.Info("shutdown signal caught. shutting down statsd listener")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		logger.Log.Error("couldn`t flush statsd metrics on shutdown", zap.Error(err))
+		return
+	}
+	logger.Log.Info("statsd listener gracefully shutdown")
+}
+
+// Flush пишет накопленные метрики. При ошибке записи метрики интервала теряются, метрика,
+// отклоненная сервисом, не мешает записи остальных.
+func (s *Server) Flush(ctx context.Context) error {
+	metrics := s.agg.Flush()
+	if len(metrics) == 0 {
+		return nil
+	}
+	ctx = audit.WithSource(ctx, audit.Source{Transport: audit.TransportStatsD})
+	result, err := ingest.Store(ctx, s.writer, "statsd", metrics)
+	if err != nil {
+		return err
+	}
+	s.rejected.Add(int64(result.Rejected))
+	logger.Log.Debug("statsd metrics flushed", zap.Int("metrics", result.Accepted))
+	return nil
+}
+
+// BadLines возвращает количество строк, которые не удалось разобрать.
+func (s *Server) BadLines() int64 {
+	return s.bad.Load()
+}
+
+// Rejected возвращает количество метрик, которые отклонил сервис.
+func (s *Server) Rejected() int64 {
+	return s.rejected.Load()
+}
+
+func (s *Server) flushLoop() {
+	defer s.wg.Done()
+	tick := time.NewTicker(s.interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+			if err := s.Flush(ctx); err != nil {
+				logger.Log.Error("couldn`t flush statsd metrics", zap.Error(err))
+			}
+			cancel()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Server) serveUDP() {
+	defer s.wg.Done()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Log.Error("statsd udp read failed", zap.Error(err))
+			}
+			return
+		}
+		for _, line := range bytes.Split(buf[:n], []byte("\n")) {
+			s.handleLine(line)
+		}
+	}
+}
+
+func (s *Server) serveTCP() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Log.Error("statsd tcp accept failed", zap.Error(err))
+			}
+			return
+		}
+		s.mu.Lock()
+		select {
+		case <-s.done:
+			// сервер уже останавливается, соединение не попадет в список на закрытие
+			s.mu.Unlock()
+			conn.Close()
+			return
+		default:
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		s.handleLine(scanner.Bytes())
+	}
+}
+
+func (s *Server) handleLine(line []byte) {
+	if len(bytes.TrimSpace(line)) == 0 {
+		return
+	}
+	sample, err := ParseLine(string(line))
+	if err != nil {
+		s.bad.Add(1)
+		logger.Log.Debug("bad statsd line", zap.Error(err))
+		return
+	}
+	s.agg.Add(sample)
+}
@@ -0,0 +1,115 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sebasttiano/Blackbird.git/internal/ingest/ingesttest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    Sample
+		wantErr bool
+	}{
+		{name: "counter", line: "hits:1|c", want: Sample{Name: "hits", Type: TypeCounter, Value: 1, Raw: "1", Rate: 1}},
+		{name: "sampled counter", line: "hits:2|c|@0.1", want: Sample{Name: "hits", Type: TypeCounter, Value: 2, Raw: "2", Rate: 0.1}},
+		{name: "gauge", line: "temp:12.5|g", want: Sample{Name: "temp", Type: TypeGauge, Value: 12.5, Raw: "12.5", Rate: 1}},
+		{name: "relative gauge", line: "queue:-3|g", want: Sample{Name: "queue", Type: TypeGauge, Value: -3, Raw: "-3", Relative: true, Rate: 1}},
+		{name: "timer with tags", line: "api.latency:320|ms|#env:prod", want: Sample{Name: "api.latency", Type: TypeTimer, Value: 320, Raw: "320", Rate: 1}},
+		{name: "set", line: "users:alice|s", want: Sample{Name: "users", Type: TypeSet, Raw: "alice", Rate: 1}},
+		{name: "sanitized name", line: "my app/requests:1|c", want: Sample{Name: "my_app-requests", Type: TypeCounter, Value: 1, Raw: "1", Rate: 1}},
+		{name: "no type", line: "hits:1", wantErr: true},
+		{name: "no value", line: "hits|c", wantErr: true},
+		{name: "bad value", line: "hits:abc|c", wantErr: true},
+		{name: "nan value", line: "hits:NaN|c", wantErr: true},
+		{name: "inf gauge", line: "temp:+Inf|g", wantErr: true},
+		{name: "negative inf timer", line: "api.latency:-inf|ms", wantErr: true},
+		{name: "unknown type", line: "hits:1|x", wantErr: true},
+		{name: "bad rate", line: "hits:1|c|@2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLine(tt.line)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrParse)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAggregator_Flush(t *testing.T) {
+	a := NewAggregator()
+	for _, line := range []string{
+		"hits:1|c", "hits:1|c|@0.5", "hits:0.4|c",
+		"queue:10|g", "queue:+5|g",
+		"users:alice|s", "users:bob|s", "users:alice|s",
+	} {
+		s, err := ParseLine(line)
+		require.NoError(t, err)
+		a.Add(s)
+	}
+	for i := 1; i <= 10; i++ {
+		a.Add(Sample{Name: "lat", Type: TypeTimer, Value: float64(i * 10), Rate: 1})
+	}
+
+	got := make(map[string]float64)
+	for _, m := range a.Flush() {
+		if m.MType == "counter" {
+			got[m.ID] = float64(*m.Delta)
+		} else {
+			got[m.ID] = *m.Value
+		}
+	}
+	assert.Equal(t, map[string]float64{
+		"hits": 3, "queue": 15, "users": 2,
+		"lat.count": 10, "lat.sum": 550, "lat.min": 10, "lat.max": 100, "lat.mean": 55,
+		"lat.p50": 50, "lat.p90": 90, "lat.p95": 100, "lat.p99": 100,
+	}, got)
+
+	// дробный остаток счетчика переносится, gauge без изменений не пишется
+	a.Add(Sample{Name: "hits", Type: TypeCounter, Value: 0.2, Rate: 1})
+	a.Add(Sample{Name: "queue", Type: TypeGauge, Value: -5, Relative: true, Rate: 1})
+	flushed := a.Flush()
+	require.Len(t, flushed, 2)
+	assert.Equal(t, "hits", flushed[0].ID)
+	assert.Equal(t, int64(1), *flushed[0].Delta)
+	assert.Equal(t, 10.0, *flushed[1].Value)
+}
+
+func TestServer(t *testing.T) {
+	w := ingesttest.NewWriter()
+	s := NewServer("127.0.0.1:0", time.Hour, w)
+	require.NoError(t, s.Listen())
+	go s.Serve()
+
+	udp, err := net.Dial("udp", s.Addr())
+	require.NoError(t, err)
+	defer udp.Close()
+	_, err = udp.Write([]byte("hits:1|c\nhits:2|c\ntemp:21.5|g\nbroken"))
+	require.NoError(t, err)
+
+	tcp, err := net.Dial("tcp", s.Addr())
+	require.NoError(t, err)
+	_, err = tcp.Write([]byte("hits:4|c\n"))
+	require.NoError(t, err)
+	tcp.Close()
+
+	assert.Eventually(t, func() bool {
+		s.agg.mu.Lock()
+		defer s.agg.mu.Unlock()
+		return s.agg.counters["hits"] == 7 && s.BadLines() == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, s.Shutdown(context.Background()))
+	assert.Equal(t, map[string]float64{"hits": 7, "temp": 21.5}, w.Values())
+}
@@ -0,0 +1,180 @@
+// Package ipfilter пропускает к серверу только клиентов из доверенных подсетей.
+// Адрес клиента берется из адреса соединения. Заголовкам прокси фильтр верит, только если соединение
+// пришло от доверенного прокси, иначе любой клиент подставил бы в X-Real-IP адрес из доверенной подсети.
+// Фильтр общий для REST и gRPC: заголовки HTTP и ключи метаданных gRPC называются одинаково.
+package ipfilter
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/sebasttiano/Blackbird.git/internal/common"
+)
+
+// Заголовки прокси с адресом клиента.
+const (
+	// HeaderRealIP адрес клиента, который ставит прокси или агент.
+	HeaderRealIP = common.RealIPHeader
+	// HeaderForwardedFor цепочка адресов, каждый прокси дописывает в конец адрес, с которого пришел запрос.
+	HeaderForwardedFor = "X-Forwarded-For"
+)
+
+// DefaultHeaders заголовки, которым фильтр доверяет по умолчанию, если запрос пришел от доверенного прокси.
+var DefaultHeaders = []string{HeaderRealIP}
+
+// ErrForbidden ошибка, если клиент не из доверенной подсети.
+var ErrForbidden = errors.New("client address is forbidden")
+
+// ErrUnknownHeader ошибка, если заголовок прокси не поддерживается.
+var ErrUnknownHeader = errors.New("unknown proxy header. only X-Real-IP and X-Forwarded-For are available")
+
+// ErrBadAddress ошибка, если адрес клиента в заголовке или соединении не разбирается.
+var ErrBadAddress = errors.New("couldn`t determine client address")
+
+// Getter возвращает значения заголовка или ключа метаданных по имени.
+type Getter func(name string) []string
+
+// Filter проверяет адрес клиента по списку подсетей IPv4 и IPv6.
+type Filter struct {
+	prefixes []netip.Prefix
+	proxies  []netip.Prefix
+	headers  []string
+	depth    int
+}
+
+// ParseSubnets разбирает подсети через запятую, например "10.0.0.0/8, fd00::/8".
+func ParseSubnets(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted subnet %q: %w", part, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// New конструктор для Filter. proxies подсети прокси, от которых принимаются заголовки с адресом клиента,
+// без них адрес всегда берется из соединения. headers заголовки прокси в порядке приоритета, nil означает
+// DefaultHeaders, пустой список означает адрес соединения. forwardedDepth сколько прокси стоит перед сервером:
+// адрес клиента берется из X-Forwarded-For на столько позиций от конца цепочки, 0 считается за 1.
+func New(prefixes, proxies []netip.Prefix, headers []string, forwardedDepth int) (*Filter, error) {
+	if headers == nil {
+		headers = DefaultHeaders
+	}
+	f := &Filter{prefixes: prefixes, proxies: proxies, depth: forwardedDepth}
+	if f.depth < 1 {
+		f.depth = 1
+	}
+	for _, h := range headers {
+		switch canonical := strings.TrimSpace(h); {
+		case strings.EqualFold(canonical, HeaderRealIP):
+			f.headers = append(f.headers, HeaderRealIP)
+		case strings.EqualFold(canonical, HeaderForwardedFor):
+			f.headers = append(f.headers, HeaderForwardedFor)
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrUnknownHeader, h)
+		}
+	}
+	return f, nil
+}
+
+// Subnets возвращает доверенные подсети.
+func (f *Filter) Subnets() []netip.Prefix {
+	return f.prefixes
+}
+
+// Proxies возвращает подсети доверенных прокси.
+func (f *Filter) Proxies() []netip.Prefix {
+	return f.proxies
+}
+
+// Allowed проверяет, что адрес входит в одну из доверенных подсетей. Без подсетей разрешены все адреса.
+func (f *Filter) Allowed(addr netip.Addr) bool {
+	if len(f.prefixes) == 0 {
+		return true
+	}
+	return contains(f.prefixes, addr)
+}
+
+// ClientIP определяет адрес клиента по адресу соединения remoteAddr вида host:port или host.
+// Если соединение пришло от доверенного прокси, адрес берется из первого доверенного заголовка,
+// который есть в запросе.
+func (f *Filter) ClientIP(remoteAddr string, get Getter) (netip.Addr, error) {
+	peer, err := parseAddr(remoteAddr)
+	if err != nil || !contains(f.proxies, peer) {
+		return peer, err
+	}
+	for _, h := range f.headers {
+		values := get(h)
+		if len(values) == 0 {
+			continue
+		}
+		if h == HeaderForwardedFor {
+			return f.forwarded(values)
+		}
+		return parseAddr(values[0])
+	}
+	return peer, nil
+}
+
+// Check определяет адрес клиента и проверяет, что он из доверенной подсети.
+func (f *Filter) Check(remoteAddr string, get Getter) (netip.Addr, error) {
+	addr, err := f.ClientIP(remoteAddr, get)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if !f.Allowed(addr) {
+		return addr, ErrForbidden
+	}
+	return addr, nil
+}
+
+// forwarded достает адрес клиента из цепочки X-Forwarded-For. Адреса левее нужной позиции
+// мог подставить сам клиент, поэтому они не используются.
+func (f *Filter) forwarded(values []string) (netip.Addr, error) {
+	var chain []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				chain = append(chain, part)
+			}
+		}
+	}
+	if len(chain) < f.depth {
+		return netip.Addr{}, fmt.Errorf("%w: %s has %d addresses, expected at least %d", ErrBadAddress, HeaderForwardedFor, len(chain), f.depth)
+	}
+	return parseAddr(chain[len(chain)-f.depth])
+}
+
+// contains проверяет, что адрес входит в одну из подсетей.
+func contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAddr разбирает адрес с портом или без.
+func parseAddr(s string) (netip.Addr, error) {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("%w: %q", ErrBadAddress, s)
+	}
+	return addr.Unmap(), nil
+}
@@ -0,0 +1,135 @@
+package ipfilter
+
+import (
+	"net/http"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSubnets(t *testing.T) {
+	prefixes, err := ParseSubnets("192.168.1.7/24, fd00::/8,,")
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24"), netip.MustParsePrefix("fd00::/8")}, prefixes)
+
+	_, err = ParseSubnets("192.168.1.0")
+	assert.Error(t, err)
+}
+
+func TestNew(t *testing.T) {
+	f, err := New(nil, nil, nil, 0)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultHeaders, f.headers)
+	assert.Equal(t, 1, f.depth)
+
+	f, err = New(nil, nil, []string{" x-forwarded-for", "X-REAL-IP"}, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{HeaderForwardedFor, HeaderRealIP}, f.headers)
+
+	_, err = New(nil, nil, []string{"True-Client-IP"}, 0)
+	assert.ErrorIs(t, err, ErrUnknownHeader)
+}
+
+func TestFilter_Check(t *testing.T) {
+	prefixes, err := ParseSubnets("192.168.1.0/24,2001:db8::/32")
+	require.NoError(t, err)
+	proxies, err := ParseSubnets("10.0.0.0/8")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		headers    []string
+		depth      int
+		remoteAddr string
+		header     http.Header
+		want       string
+		wantErr    error
+	}{
+		{name: "peer address with port", headers: []string{}, remoteAddr: "192.168.1.10:5555", want: "192.168.1.10"},
+		{name: "peer address without port", headers: []string{}, remoteAddr: "192.168.1.10", want: "192.168.1.10"},
+		{name: "ipv6 peer", headers: []string{}, remoteAddr: "[2001:db8::1]:5555", want: "2001:db8::1"},
+		{name: "ipv4 mapped ipv6 peer", headers: []string{}, remoteAddr: "[::ffff:192.168.1.10]:5555", want: "192.168.1.10"},
+		{name: "untrusted peer", headers: []string{}, remoteAddr: "10.0.0.1:5555", want: "10.0.0.1", wantErr: ErrForbidden},
+		{name: "bad peer address", headers: []string{}, remoteAddr: "bufconn", wantErr: ErrBadAddress},
+		{
+			name:       "headers are ignored when not trusted",
+			headers:    []string{},
+			remoteAddr: "10.0.0.1:5555",
+			header:     http.Header{"X-Real-Ip": {"192.168.1.10"}},
+			want:       "10.0.0.1",
+			wantErr:    ErrForbidden,
+		},
+		{name: "x-real-ip", remoteAddr: "10.0.0.1:5555", header: http.Header{"X-Real-Ip": {"192.168.1.10"}}, want: "192.168.1.10"},
+		{name: "untrusted x-real-ip", remoteAddr: "10.0.0.1:5555", header: http.Header{"X-Real-Ip": {"10.0.0.7"}}, want: "10.0.0.7", wantErr: ErrForbidden},
+		{name: "bad x-real-ip", remoteAddr: "10.0.0.1:5555", header: http.Header{"X-Real-Ip": {"localhost"}}, wantErr: ErrBadAddress},
+		{name: "x-real-ip missing", remoteAddr: "10.0.0.1:5555", want: "10.0.0.1", wantErr: ErrForbidden},
+		{name: "spoofed x-real-ip", remoteAddr: "203.0.113.5:5555", header: http.Header{"X-Real-Ip": {"192.168.1.10"}}, want: "203.0.113.5", wantErr: ErrForbidden},
+		{name: "x-real-ip from client", remoteAddr: "192.168.1.1:5555", header: http.Header{"X-Real-Ip": {"10.0.0.7"}}, want: "192.168.1.1"},
+		{name: "x-real-ip with bad peer address", remoteAddr: "bufconn", header: http.Header{"X-Real-Ip": {"192.168.1.10"}}, wantErr: ErrBadAddress},
+		{
+			name:       "forwarded for one proxy",
+			headers:    []string{HeaderForwardedFor},
+			remoteAddr: "10.0.0.1:5555",
+			header:     http.Header{"X-Forwarded-For": {"1.2.3.4, 192.168.1.10"}},
+			want:       "192.168.1.10",
+		},
+		{
+			name:       "spoofed forwarded for",
+			headers:    []string{HeaderForwardedFor},
+			remoteAddr: "10.0.0.1:5555",
+			header:     http.Header{"X-Forwarded-For": {"192.168.1.10, 1.2.3.4"}},
+			want:       "1.2.3.4",
+			wantErr:    ErrForbidden,
+		},
+		{
+			name:       "forwarded for two proxies in several headers",
+			headers:    []string{HeaderForwardedFor},
+			depth:      2,
+			remoteAddr: "10.0.0.1:5555",
+			header:     http.Header{"X-Forwarded-For": {"1.2.3.4", "192.168.1.10, 10.0.0.2"}},
+			want:       "192.168.1.10",
+		},
+		{
+			name:       "forwarded for shorter than proxy chain",
+			headers:    []string{HeaderForwardedFor},
+			depth:      2,
+			remoteAddr: "10.0.0.1:5555",
+			header:     http.Header{"X-Forwarded-For": {"192.168.1.10"}},
+			wantErr:    ErrBadAddress,
+		},
+		{
+			name:       "spoofed forwarded for from client",
+			headers:    []string{HeaderForwardedFor},
+			remoteAddr: "203.0.113.5:5555",
+			header:     http.Header{"X-Forwarded-For": {"192.168.1.10"}},
+			want:       "203.0.113.5",
+			wantErr:    ErrForbidden,
+		},
+		{
+			name:       "header priority",
+			headers:    []string{HeaderForwardedFor, HeaderRealIP},
+			remoteAddr: "10.0.0.1:5555",
+			header:     http.Header{"X-Real-Ip": {"10.0.0.7"}, "X-Forwarded-For": {"2001:db8::5"}},
+			want:       "2001:db8::5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := New(prefixes, proxies, tt.headers, tt.depth)
+			require.NoError(t, err)
+			addr, err := f.Check(tt.remoteAddr, tt.header.Values)
+			assert.ErrorIs(t, err, tt.wantErr)
+			if tt.want != "" {
+				assert.Equal(t, tt.want, addr.String())
+			}
+		})
+	}
+}
+
+func TestFilter_AllowedWithoutSubnets(t *testing.T) {
+	f, err := New(nil, nil, nil, 0)
+	require.NoError(t, err)
+	assert.True(t, f.Allowed(netip.MustParseAddr("8.8.8.8")))
+}
@@ -0,0 +1,85 @@
+// Package jsonfile хранит список записей в JSON файле, который меняют и сервер, и blackbirdctl.
+package jsonfile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// List список записей в JSON файле. Файл перечитывается, только если изменились время модификации
+// или размер, и переписывается атомарно через временный файл в том же каталоге.
+// List не защищен от параллельного использования: чтение, изменение и запись идут под блокировкой владельца.
+type List[T any] struct {
+	// Items записи из последнего прочитанного файла, Save сохраняет их.
+	Items   []T
+	path    string
+	kind    string
+	modTime time.Time
+	size    int64
+}
+
+// New конструктор для List. kind что хранится в файле, например "tokens", нужен для сообщений об ошибках.
+func New[T any](path, kind string) *List[T] {
+	return &List[T]{path: path, kind: kind}
+}
+
+// Reload перечитывает файл, если он изменился с прошлого чтения. Отсутствующий файл означает пустой список.
+func (l *List[T]) Reload() error {
+	info, err := os.Stat(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		l.Items, l.modTime, l.size = nil, time.Time{}, 0
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(l.modTime) && info.Size() == l.size {
+		return nil
+	}
+
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return err
+	}
+	var items []T
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &items); err != nil {
+			return fmt.Errorf("failed to parse %s file %s: %w", l.kind, l.path, err)
+		}
+	}
+	l.Items, l.modTime, l.size = items, info.ModTime(), info.Size()
+	return nil
+}
+
+// Save атомарно переписывает файл записями Items с правами 0600.
+func (l *List[T]) Save() error {
+	data, err := json.MarshalIndent(l.Items, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		return err
+	}
+	// следующее чтение подхватит файл заново
+	l.modTime, l.size = time.Time{}, 0
+	return nil
+}
@@ -0,0 +1,50 @@
+package jsonfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type item struct {
+	ID string `json:"id"`
+}
+
+func TestList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "items.json")
+
+	// отсутствующий файл это пустой список
+	l := New[item](path, "items")
+	require.NoError(t, l.Reload())
+	assert.Empty(t, l.Items)
+
+	l.Items = append(l.Items, item{ID: "a"})
+	require.NoError(t, l.Save())
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	matches, err := filepath.Glob(path + ".*")
+	require.NoError(t, err)
+	assert.Empty(t, matches, "temporary file must be renamed")
+
+	// изменение другим процессом подхватывается при следующем чтении
+	other := New[item](path, "items")
+	require.NoError(t, other.Reload())
+	assert.Equal(t, []item{{ID: "a"}}, other.Items)
+	other.Items = append(other.Items, item{ID: "b"})
+	require.NoError(t, other.Save())
+
+	require.NoError(t, l.Reload())
+	assert.Equal(t, []item{{ID: "a"}, {ID: "b"}}, l.Items)
+
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0600))
+	assert.ErrorContains(t, l.Reload(), "failed to parse items file")
+	assert.Equal(t, []item{{ID: "a"}, {ID: "b"}}, l.Items, "broken file keeps the previous version")
+
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, l.Reload())
+	assert.Empty(t, l.Items)
+}
@@ -0,0 +1,247 @@
+// Package keyring хранит ключи подписи HMAC и приватные ключи RSA сервера под идентификаторами.
+// Агент передает идентификатор ключа в заголовке, поэтому новый ключ можно добавить, не меняя
+// настройки всех агентов разом, а старый вывести из оборота с отсрочкой.
+//
+// Формат файла:
+//
+//	{"keys": [
+//	  {"id": "2024-06", "type": "hmac", "secret": "..."},
+//	  {"id": "2024-01", "type": "hmac", "secret": "...", "retired_at": "2024-06-01T00:00:00Z"},
+//	  {"id": "rsa-1", "type": "rsa", "private_key_file": "/etc/blackbird/rsa_private"}
+//	]}
+//
+// Первый действующий ключ каждого типа основной: им сервер подписывает ответы.
+package keyring
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sebasttiano/Blackbird.git/internal/common"
+)
+
+// Type тип ключа.
+type Type string
+
+const (
+	// TypeHMAC секрет для подписи запросов HMAC-SHA256.
+	TypeHMAC Type = "hmac"
+	// TypeRSA приватный ключ RSA для расшифровки запросов.
+	TypeRSA Type = "rsa"
+)
+
+// DefaultID идентификатор ключей из старых настроек KEY и CRYPTO_KEY. Ими проверяются запросы
+// агентов, которые не передают идентификатор ключа.
+const DefaultID = "default"
+
+// DefaultGracePeriod сколько выведенный из оборота ключ еще принимается по умолчанию.
+const DefaultGracePeriod = 24 * time.Hour
+
+// Статусы ключа.
+const (
+	StatusActive  = "active"
+	StatusRetired = "retired"
+	StatusExpired = "expired"
+)
+
+// ErrUnknownKey ошибка, если ключа с таким идентификатором нет.
+var ErrUnknownKey = errors.New("unknown key")
+
+// ErrKeyExpired ошибка, если ключ выведен из оборота и отсрочка закончилась.
+var ErrKeyExpired = errors.New("key is expired")
+
+// ErrDuplicateKey ошибка, если идентификатор ключа уже занят ключом того же типа.
+var ErrDuplicateKey = errors.New("duplicate key id")
+
+// ErrInvalidKey ошибка, если описание ключа неполное.
+var ErrInvalidKey = errors.New("invalid key")
+
+// Key ключ с идентификатором.
+type Key struct {
+	ID   string `json:"id"`
+	Type Type   `json:"type"`
+	// Secret секрет ключа HMAC.
+	Secret string `json:"secret,omitempty"`
+	// PrivateKeyFile путь к PEM файлу приватного ключа RSA.
+	PrivateKeyFile string `json:"private_key_file,omitempty"`
+	// RetiredAt когда ключ выведен из оборота, nil у действующих ключей.
+	RetiredAt *time.Time `json:"retired_at,omitempty"`
+
+	privateKey *rsa.PrivateKey
+}
+
+// PrivateKey приватный ключ RSA, nil у ключей HMAC.
+func (k *Key) PrivateKey() *rsa.PrivateKey {
+	return k.privateKey
+}
+
+// Status возвращает статус ключа на момент now с учетом отсрочки grace.
+func (k *Key) Status(now time.Time, grace time.Duration) string {
+	switch {
+	case k.RetiredAt == nil || now.Before(*k.RetiredAt):
+		return StatusActive
+	case now.Before(k.RetiredAt.Add(grace)):
+		return StatusRetired
+	default:
+		return StatusExpired
+	}
+}
+
+// Keyring набор ключей. Методы nil набора ведут себя как у пустого.
+type Keyring struct {
+	keys  []*Key
+	byID  map[keyRef]*Key
+	grace time.Duration
+	now   func() time.Time
+}
+
+// keyRef идентификаторы уникальны в пределах типа ключа.
+type keyRef struct {
+	t  Type
+	id string
+}
+
+// New конструктор для Keyring. grace сколько ключ принимается после RetiredAt, отрицательное значение считается за 0.
+func New(grace time.Duration) *Keyring {
+	if grace < 0 {
+		grace = 0
+	}
+	return &Keyring{byID: make(map[keyRef]*Key), grace: grace, now: time.Now}
+}
+
+// file формат файла с ключами.
+type file struct {
+	Keys []Key `json:"keys"`
+}
+
+// Load читает ключи из JSON файла. Пути к ключам RSA отсчитываются от текущей директории.
+func Load(path string, grace time.Duration) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse keyring %s: %w", path, err)
+	}
+	kr := New(grace)
+	for _, key := range f.Keys {
+		if err := kr.Add(key); err != nil {
+			return nil, err
+		}
+	}
+	return kr, nil
+}
+
+// Add добавляет ключ. Приватный ключ RSA читается из PrivateKeyFile, если не задан через WithPrivateKey.
+func (kr *Keyring) Add(key Key) error {
+	if key.ID == "" {
+		return fmt.Errorf("%w: id is required", ErrInvalidKey)
+	}
+	if _, ok := kr.byID[keyRef{key.Type, key.ID}]; ok {
+		return fmt.Errorf("%w: %s %q", ErrDuplicateKey, key.Type, key.ID)
+	}
+	switch key.Type {
+	case TypeHMAC:
+		if key.Secret == "" {
+			return fmt.Errorf("%w: hmac key %q has no secret", ErrInvalidKey, key.ID)
+		}
+	case TypeRSA:
+		if key.privateKey == nil {
+			data, err := os.ReadFile(key.PrivateKeyFile)
+			if err != nil {
+				return fmt.Errorf("%w: rsa key %q: %v", ErrInvalidKey, key.ID, err)
+			}
+			key.privateKey = common.UnmarshalRSAPrivate(data)
+		}
+		if key.privateKey == nil {
+			return fmt.Errorf("%w: rsa key %q is not a PKCS1 private key", ErrInvalidKey, key.ID)
+		}
+	default:
+		return fmt.Errorf("%w: key %q has unknown type %q", ErrInvalidKey, key.ID, key.Type)
+	}
+	kr.keys = append(kr.keys, &key)
+	kr.byID[keyRef{key.Type, key.ID}] = &key
+	return nil
+}
+
+// WithPrivateKey описывает ключ RSA, уже загруженный в память.
+func WithPrivateKey(id string, priv *rsa.PrivateKey) Key {
+	return Key{ID: id, Type: TypeRSA, privateKey: priv}
+}
+
+// Has проверяет, есть ли в наборе ключи типа t.
+func (kr *Keyring) Has(t Type) bool {
+	if kr == nil {
+		return false
+	}
+	for _, key := range kr.keys {
+		if key.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Get возвращает принимаемый ключ типа t. Пустой id означает ключ DefaultID, а без него основной ключ.
+func (kr *Keyring) Get(t Type, id string) (*Key, error) {
+	if kr == nil {
+		return nil, fmt.Errorf("%w: %s %q", ErrUnknownKey, t, id)
+	}
+	if id == "" {
+		if key, ok := kr.byID[keyRef{t, DefaultID}]; ok {
+			return kr.check(key)
+		}
+		if key := kr.Primary(t); key != nil {
+			return key, nil
+		}
+		return nil, fmt.Errorf("%w: no %s keys", ErrUnknownKey, t)
+	}
+	key, ok := kr.byID[keyRef{t, id}]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s %q", ErrUnknownKey, t, id)
+	}
+	return kr.check(key)
+}
+
+// check отклоняет ключ, у которого закончилась отсрочка.
+func (kr *Keyring) check(key *Key) (*Key, error) {
+	if key.Status(kr.now(), kr.grace) == StatusExpired {
+		return nil, fmt.Errorf("%w: %q", ErrKeyExpired, key.ID)
+	}
+	return key, nil
+}
+
+// Primary возвращает первый действующий ключ типа t или nil.
+func (kr *Keyring) Primary(t Type) *Key {
+	if kr == nil {
+		return nil
+	}
+	now := kr.now()
+	for _, key := range kr.keys {
+		if key.Type == t && key.Status(now, kr.grace) == StatusActive {
+			return key
+		}
+	}
+	return nil
+}
+
+// GracePeriod сколько ключ принимается после вывода из оборота.
+func (kr *Keyring) GracePeriod() time.Duration {
+	if kr == nil {
+		return 0
+	}
+	return kr.grace
+}
+
+// Keys возвращает ключи в порядке добавления.
+func (kr *Keyring) Keys() []*Key {
+	if kr == nil {
+		return nil
+	}
+	return kr.keys
+}
@@ -0,0 +1,108 @@
+package keyring
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeKeyring(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	require.NoError(t, os.WriteFile(path, []byte(data), 0600))
+	return path
+}
+
+func TestKeyring_Get(t *testing.T) {
+	now := time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+	path := writeKeyring(t, `{"keys": [
+		{"id": "2024-06", "type": "hmac", "secret": "new"},
+		{"id": "2024-05", "type": "hmac", "secret": "retiring", "retired_at": "2024-06-09T12:00:00Z"},
+		{"id": "2024-01", "type": "hmac", "secret": "old", "retired_at": "2024-06-01T00:00:00Z"},
+		{"id": "rsa-1", "type": "rsa", "private_key_file": "../../files/rsa_private"}
+	]}`)
+	kr, err := Load(path, 24*time.Hour)
+	require.NoError(t, err)
+	kr.now = func() time.Time { return now }
+
+	tests := []struct {
+		name    string
+		t       Type
+		id      string
+		want    string
+		wantErr error
+	}{
+		{name: "active key", t: TypeHMAC, id: "2024-06", want: "2024-06"},
+		{name: "retired key in grace period", t: TypeHMAC, id: "2024-05", want: "2024-05"},
+		{name: "retired key after grace period", t: TypeHMAC, id: "2024-01", wantErr: ErrKeyExpired},
+		{name: "unknown key", t: TypeHMAC, id: "2023-01", wantErr: ErrUnknownKey},
+		{name: "id of another type", t: TypeRSA, id: "2024-06", wantErr: ErrUnknownKey},
+		{name: "no id means primary without default key", t: TypeHMAC, want: "2024-06"},
+		{name: "rsa key", t: TypeRSA, id: "rsa-1", want: "rsa-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := kr.Get(tt.t, tt.id)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, key.ID)
+		})
+	}
+
+	rsaKey, err := kr.Get(TypeRSA, "rsa-1")
+	require.NoError(t, err)
+	assert.NotNil(t, rsaKey.PrivateKey())
+
+	// ключ из старых настроек проверяет запросы без идентификатора
+	require.NoError(t, kr.Add(Key{ID: DefaultID, Type: TypeHMAC, Secret: "legacy"}))
+	key, err := kr.Get(TypeHMAC, "")
+	require.NoError(t, err)
+	assert.Equal(t, "legacy", key.Secret)
+	assert.Equal(t, "2024-06", kr.Primary(TypeHMAC).ID)
+}
+
+func TestKeyring_Add(t *testing.T) {
+	kr := New(-time.Hour)
+	assert.Zero(t, kr.GracePeriod())
+
+	require.NoError(t, kr.Add(Key{ID: "k1", Type: TypeHMAC, Secret: "secret"}))
+	assert.ErrorIs(t, kr.Add(Key{ID: "k1", Type: TypeHMAC, Secret: "other"}), ErrDuplicateKey)
+	assert.ErrorIs(t, kr.Add(Key{Type: TypeHMAC, Secret: "secret"}), ErrInvalidKey)
+	assert.ErrorIs(t, kr.Add(Key{ID: "k2", Type: TypeHMAC}), ErrInvalidKey)
+	assert.ErrorIs(t, kr.Add(Key{ID: "k3", Type: "aes"}), ErrInvalidKey)
+	assert.ErrorIs(t, kr.Add(Key{ID: "k4", Type: TypeRSA, PrivateKeyFile: "missing"}), ErrInvalidKey)
+	assert.ErrorIs(t, kr.Add(Key{ID: "k5", Type: TypeRSA, PrivateKeyFile: "../../files/rsa_public"}), ErrInvalidKey)
+	assert.Len(t, kr.Keys(), 1)
+
+	assert.True(t, kr.Has(TypeHMAC))
+	assert.False(t, kr.Has(TypeRSA))
+
+	_, err := Load(writeKeyring(t, `{"keys": [`), time.Hour)
+	assert.Error(t, err)
+}
+
+func TestKeyring_Nil(t *testing.T) {
+	var kr *Keyring
+	assert.False(t, kr.Has(TypeHMAC))
+	assert.Nil(t, kr.Primary(TypeHMAC))
+	assert.Nil(t, kr.Keys())
+	_, err := kr.Get(TypeHMAC, "")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestKey_Status(t *testing.T) {
+	retired := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	key := Key{ID: "k", Type: TypeHMAC, Secret: "s", RetiredAt: &retired}
+
+	assert.Equal(t, StatusActive, key.Status(retired.Add(-time.Second), time.Hour), "retirement is scheduled")
+	assert.Equal(t, StatusRetired, key.Status(retired, time.Hour))
+	assert.Equal(t, StatusExpired, key.Status(retired.Add(time.Hour), time.Hour))
+	assert.Equal(t, StatusActive, (&Key{}).Status(retired, 0))
+}
@@ -3,6 +3,7 @@ package logger
 
 import (
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 // Log будет доступен всему коду как синглтон.
@@ -10,15 +11,16 @@ import (
 // По умолчанию установлен no-op-логер, который не выводит никаких сообщений.
 var Log *zap.Logger = zap.NewNop()
 
+// Level уровень логирования синглтона, его можно менять без перезапуска через SetLevel.
+var Level = zap.NewAtomicLevel()
+
 // Initialize инициализирует синглтон логера с необходимым уровнем логирования.
 func Initialize(level string) error {
-	// преобразуем текстовый уровень логирования в zap.AtomicLevel
-	lvl, err := zap.ParseAtomicLevel(level)
-	if err != nil {
+	if err := SetLevel(level); err != nil {
 		return err
 	}
 	cfg := zap.NewProductionConfig()
-	cfg.Level = lvl
+	cfg.Level = Level
 	cfg.DisableStacktrace = true
 	zl, err := cfg.Build()
 	if err != nil {
@@ -27,3 +29,13 @@ func Initialize(level string) error {
 	Log = zl
 	return nil
 }
+
+// SetLevel меняет уровень логирования на лету, level в формате zap: debug, info, warn, error.
+func SetLevel(level string) error {
+	lvl, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	Level.SetLevel(lvl)
+	return nil
+}
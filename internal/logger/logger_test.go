@@ -25,3 +25,11 @@ func TestInitialize(t *testing.T) {
 		})
 	}
 }
+
+func TestSetLevel(t *testing.T) {
+	assert.NoError(t, Initialize("info"))
+	assert.NoError(t, SetLevel("warn"))
+	assert.Equal(t, zapcore.WarnLevel, Log.Level())
+	assert.Error(t, SetLevel("loud"))
+	assert.Equal(t, zapcore.WarnLevel, Level.Level())
+}
@@ -1,6 +1,8 @@
 // Package models хранит различные модельки.
 package models
 
+import "time"
+
 // Metrics модель для парсинга запросов связанных с gauge и counter метриками
 type Metrics struct {
 	ID    string   `json:"id"`              // имя метрики
@@ -29,3 +31,72 @@ func (m *MetricSet) CastToMetrics() []*Metrics {
 	}
 	return metrics
 }
+
+// Статусы обработки метрики в пакетном обновлении.
+const (
+	StatusAccepted = "accepted"
+	StatusRejected = "rejected"
+)
+
+// Коды причин отклонения метрики в пакетном обновлении.
+const (
+	ReasonEmptyID      = "empty_id"      // не передано имя метрики
+	ReasonUnknownType  = "unknown_type"  // тип метрики не gauge и не counter
+	ReasonMissingValue = "missing_value" // не передано значение метрики
+	ReasonStorageError = "storage_error" // хранилище не смогло сохранить метрику
+	ReasonBatchAborted = "batch_aborted" // метрика корректна, но пакет отклонен целиком
+	ReasonReservedName = "reserved_name" // имя метрики занято под метрики сервера
+)
+
+// MetricResult результат обработки одной метрики из пакета
+type MetricResult struct {
+	ID      string `json:"id"`                // имя метрики
+	MType   string `json:"type"`              // тип метрики
+	Status  string `json:"status"`            // accepted или rejected
+	Reason  string `json:"reason,omitempty"`  // код причины отклонения
+	Message string `json:"message,omitempty"` // текст ошибки
+}
+
+// BatchResult результат пакетного обновления метрик
+type BatchResult struct {
+	Accepted int            `json:"accepted"`
+	Rejected int            `json:"rejected"`
+	Results  []MetricResult `json:"results"`
+	// Duplicate пакет с таким идентификатором уже был принят ранее и повторно не применялся.
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+// Статусы чтения метрики в пакетном чтении, для некорректного запроса метрики используется StatusRejected.
+const (
+	StatusFound   = "found"
+	StatusMissing = "missing"
+)
+
+// MetricValue результат чтения одной метрики из пакета
+type MetricValue struct {
+	Metrics
+	Status string `json:"status"`           // found, missing или rejected
+	Reason string `json:"reason,omitempty"` // код причины отклонения
+}
+
+// MetricUpdate событие о принятом обновлении метрики. Для counter содержит принятое приращение.
+type MetricUpdate struct {
+	Metrics
+	Time time.Time `json:"time"`
+}
+
+// Хранилища метрик для статистики.
+const (
+	BackendMemory   = "memory"
+	BackendPostgres = "postgres"
+)
+
+// StorageStats статистика хранилища метрик для администратора.
+type StorageStats struct {
+	Backend  string `json:"backend"`  // memory или postgres
+	Series   int    `json:"series"`   // всего метрик
+	Gauges   int    `json:"gauges"`   // из них gauge
+	Counters int    `json:"counters"` // из них counter
+	// LastSnapshot время последнего успешного сохранения метрик в файл, nil если сохранений не было.
+	LastSnapshot *time.Time `json:"last_snapshot,omitempty"`
+}
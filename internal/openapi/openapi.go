@@ -0,0 +1,239 @@
+// Package openapi хранит спецификацию OpenAPI 3 REST API сервера и проверяет по ней входящие запросы.
+// Поддерживается подмножество спецификации, которое использует документ: параметры path, query и header,
+// тела запросов по типу содержимого и JSON схемы с type, enum, required, properties, items, minimum и minLength.
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Document спецификация OpenAPI в JSON, встроенная в бинарник.
+//
+//go:embed openapi.json
+var Document []byte
+
+// ErrInvalidSpec ошибка разбора спецификации.
+var ErrInvalidSpec = errors.New("invalid openapi specification")
+
+const (
+	componentParameters = "#/components/parameters/"
+	componentSchemas    = "#/components/schemas/"
+)
+
+// Spec разобранная спецификация.
+type Spec struct {
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+	routes     []route
+}
+
+// PathItem операции одного пути по методам в нижнем регистре, как в документе.
+type PathItem map[string]*Operation
+
+// Operation операция API.
+type Operation struct {
+	OperationID string       `json:"operationId"`
+	Parameters  []*Parameter `json:"parameters"`
+	RequestBody *RequestBody `json:"requestBody"`
+}
+
+// Parameter параметр операции.
+type Parameter struct {
+	Ref      string  `json:"$ref"`
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+// RequestBody тело запроса по типам содержимого.
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// MediaType описание тела одного типа содержимого.
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema JSON схема значения.
+type Schema struct {
+	Ref        string             `json:"$ref"`
+	Type       string             `json:"type"`
+	Format     string             `json:"format"`
+	Enum       []any              `json:"enum"`
+	Required   []string           `json:"required"`
+	Properties map[string]*Schema `json:"properties"`
+	Items      *Schema            `json:"items"`
+	AllOf      []*Schema          `json:"allOf"`
+	Minimum    *float64           `json:"minimum"`
+	MinLength  *int               `json:"minLength"`
+	Nullable   bool               `json:"nullable"`
+}
+
+// Components переиспользуемые части спецификации.
+type Components struct {
+	Parameters map[string]*Parameter `json:"parameters"`
+	Schemas    map[string]*Schema    `json:"schemas"`
+}
+
+// route шаблон пути, разбитый на сегменты. Сегмент в фигурных скобках - параметр.
+type route struct {
+	path     string
+	segments []string
+}
+
+// Load разбирает встроенную спецификацию.
+func Load() (*Spec, error) {
+	return Parse(Document)
+}
+
+// Parse разбирает спецификацию, подставляет ссылки на параметры и проверяет ссылки на схемы.
+func Parse(data []byte) (*Spec, error) {
+	var spec Spec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSpec, err)
+	}
+	for path, item := range spec.Paths {
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("%w: path %q must start with /", ErrInvalidSpec, path)
+		}
+		for method, op := range item {
+			for i, param := range op.Parameters {
+				if param.Ref == "" {
+					continue
+				}
+				resolved, ok := spec.Components.Parameters[strings.TrimPrefix(param.Ref, componentParameters)]
+				if !ok || !strings.HasPrefix(param.Ref, componentParameters) {
+					return nil, fmt.Errorf("%w: %s %s: unknown parameter %q", ErrInvalidSpec, method, path, param.Ref)
+				}
+				op.Parameters[i] = resolved
+			}
+			for _, param := range op.Parameters {
+				if err := spec.checkRefs(param.Schema); err != nil {
+					return nil, fmt.Errorf("%w: %s %s: %w", ErrInvalidSpec, method, path, err)
+				}
+			}
+			if op.RequestBody == nil {
+				continue
+			}
+			for _, media := range op.RequestBody.Content {
+				if err := spec.checkRefs(media.Schema); err != nil {
+					return nil, fmt.Errorf("%w: %s %s: %w", ErrInvalidSpec, method, path, err)
+				}
+			}
+		}
+		spec.routes = append(spec.routes, route{path: path, segments: split(path)})
+	}
+	for name, schema := range spec.Components.Schemas {
+		if err := spec.checkRefs(schema); err != nil {
+			return nil, fmt.Errorf("%w: schema %s: %w", ErrInvalidSpec, name, err)
+		}
+	}
+	// пути без параметров проверяются раньше шаблонов
+	sort.Slice(spec.routes, func(i, j int) bool {
+		pi, pj := strings.Count(spec.routes[i].path, "{"), strings.Count(spec.routes[j].path, "{")
+		if pi != pj {
+			return pi < pj
+		}
+		return spec.routes[i].path < spec.routes[j].path
+	})
+	return &spec, nil
+}
+
+// checkRefs проверяет, что все ссылки схемы указывают на существующие схемы. По ссылкам не переходит.
+func (s *Spec) checkRefs(schema *Schema) error {
+	if schema == nil {
+		return nil
+	}
+	if schema.Ref != "" {
+		if _, err := s.schema(schema.Ref); err != nil {
+			return err
+		}
+	}
+	children := append([]*Schema{schema.Items}, schema.AllOf...)
+	for _, prop := range schema.Properties {
+		children = append(children, prop)
+	}
+	for _, child := range children {
+		if err := s.checkRefs(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// schema находит схему по ссылке.
+func (s *Spec) schema(ref string) (*Schema, error) {
+	schema, ok := s.Components.Schemas[strings.TrimPrefix(ref, componentSchemas)]
+	if !ok || !strings.HasPrefix(ref, componentSchemas) {
+		return nil, fmt.Errorf("unknown schema %q", ref)
+	}
+	return schema, nil
+}
+
+// Operations возвращает все операции спецификации в виде "METHOD /path", отсортированные.
+func (s *Spec) Operations() []string {
+	var ops []string
+	for path, item := range s.Paths {
+		for method := range item {
+			ops = append(ops, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+// Find находит операцию для метода и пути запроса и значения параметров пути.
+// Завершающий слэш пути не учитывается. Если путь или метод не описаны, возвращает false.
+func (s *Spec) Find(method, path string) (*Operation, map[string]string, bool) {
+	segments := split(path)
+	for _, r := range s.routes {
+		params, ok := r.match(segments)
+		if !ok {
+			continue
+		}
+		op, ok := s.Paths[r.path][strings.ToLower(method)]
+		if !ok || op == nil {
+			continue
+		}
+		return op, params, true
+	}
+	return nil, nil, false
+}
+
+// match сопоставляет сегменты пути запроса с шаблоном.
+func (r route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(r.segments) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, seg := range r.segments {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			params[seg[1:len(seg)-1]] = segments[i]
+			continue
+		}
+		if seg != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// split разбивает путь на сегменты без начального и завершающего слэша.
+func split(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
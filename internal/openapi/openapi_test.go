@@ -0,0 +1,115 @@
+package openapi
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	spec, err := Load()
+	require.NoError(t, err)
+	assert.Contains(t, spec.Operations(), "POST /updates/")
+
+	_, err = Parse([]byte(`{"paths": {"/value/": {"post": {"requestBody": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Unknown"}}}}}}}}`))
+	assert.ErrorIs(t, err, ErrInvalidSpec)
+	_, err = Parse([]byte(`{"paths": {"/value/": {"get": {"parameters": [{"$ref": "#/components/parameters/Unknown"}]}}}}`))
+	assert.ErrorIs(t, err, ErrInvalidSpec)
+}
+
+func TestSpec_Find(t *testing.T) {
+	spec, err := Load()
+	require.NoError(t, err)
+
+	tests := []struct {
+		method string
+		path   string
+		want   string
+		params map[string]string
+	}{
+		{http.MethodGet, "/", "mainPage", map[string]string{}},
+		{http.MethodPost, "/value/", "getMetricJSON", map[string]string{}},
+		{http.MethodGet, "/value/gauge/Alloc", "getMetric", map[string]string{"metricType": "gauge", "metricName": "Alloc"}},
+		{http.MethodGet, "/value/gauge/Alloc/", "getMetric", map[string]string{"metricType": "gauge", "metricName": "Alloc"}},
+		{http.MethodPost, "/update/counter/PollCount/5", "updateMetric", map[string]string{"metricType": "counter", "metricName": "PollCount", "metricValue": "5"}},
+		{http.MethodGet, "/update/", "", nil},
+		{http.MethodGet, "/unknown", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			op, params, ok := spec.Find(tt.method, tt.path)
+			if tt.want == "" {
+				assert.False(t, ok)
+				return
+			}
+			require.True(t, ok)
+			assert.Equal(t, tt.want, op.OperationID)
+			assert.Equal(t, tt.params, params)
+		})
+	}
+}
+
+func TestSpec_ValidateRequest(t *testing.T) {
+	spec, err := Load()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        string
+		wantErr     string
+	}{
+		{name: "valid metric", method: http.MethodPost, target: "/update/", contentType: "application/json", body: `{"id":"Alloc","type":"gauge","value":1.5}`},
+		{name: "valid batch", method: http.MethodPost, target: "/updates/?atomic=true", contentType: "application/json; charset=utf-8", body: `[{"id":"PollCount","type":"counter","delta":5}]`},
+		{name: "missing id", method: http.MethodPost, target: "/update/", contentType: "application/json", body: `{"type":"gauge","value":1.5}`, wantErr: "body: property id is required"},
+		{name: "unknown type", method: http.MethodPost, target: "/updates/", contentType: "application/json", body: `[{"id":"Alloc","type":"histogram"}]`, wantErr: "body[0].type: must be one of [gauge counter]"},
+		{name: "fractional delta", method: http.MethodPost, target: "/updates/", contentType: "application/json", body: `[{"id":"PollCount","type":"counter","delta":1.5}]`, wantErr: "body[0].delta: must be an integer"},
+		{name: "string value", method: http.MethodPost, target: "/value/", contentType: "application/json", body: `{"id":"Alloc","type":"gauge","value":"1"}`, wantErr: "body.value: must be a number"},
+		{name: "not an array", method: http.MethodPost, target: "/updates/", contentType: "application/json", body: `{"id":"Alloc"}`, wantErr: "body: must be an array"},
+		{name: "broken json", method: http.MethodPost, target: "/update/", contentType: "application/json", body: `{"id":`, wantErr: "request body is not a valid json"},
+		{name: "wrong content type", method: http.MethodPost, target: "/update/", contentType: "text/plain", body: `{}`, wantErr: "content type text/plain is not supported, expected one of application/json"},
+		{name: "missing body", method: http.MethodPost, target: "/update/", contentType: "application/json", wantErr: "request body is required"},
+		{name: "path parameter", method: http.MethodGet, target: "/value/histogram/Alloc", wantErr: "path parameter metricType must be one of [gauge counter]"},
+		{name: "boolean query", method: http.MethodPost, target: "/updates/?atomic=yes", contentType: "application/json", body: `[]`, wantErr: "query parameter atomic must be a boolean"},
+		{name: "minimum", method: http.MethodGet, target: "/audit?limit=-1", wantErr: "query parameter limit must be at least 0"},
+		{name: "date-time", method: http.MethodGet, target: "/audit?since=yesterday", wantErr: "query parameter since must be a date-time in RFC 3339"},
+		{name: "any content type", method: http.MethodPost, target: "/influx/write?precision=s", body: "cpu value=1"},
+		{name: "unknown precision", method: http.MethodPost, target: "/influx/write?precision=d", body: "cpu value=1", wantErr: "query parameter precision must be one of"},
+		{name: "unknown route", method: http.MethodGet, target: "/unknown?limit=x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req *http.Request
+			if tt.body == "" {
+				req = httptest.NewRequest(tt.method, tt.target, nil)
+			} else {
+				req = httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+
+			err := spec.ValidateRequest(req)
+			if tt.wantErr != "" {
+				require.ErrorIs(t, err, ErrInvalidRequest)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			// тело остается доступным обработчику
+			body := new(strings.Builder)
+			if req.Body != nil {
+				_, err = io.Copy(body, req.Body)
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.body, body.String())
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidRequest запрос не соответствует спецификации.
+var ErrInvalidRequest = errors.New("request doesn`t match the api specification")
+
+// MaxBodySize максимальный размер тела запроса, которое проверяется по схеме.
+const MaxBodySize = 32 << 20
+
+// ValidateRequest проверяет параметры и тело запроса по описанию операции.
+// Запросы к путям и методам вне спецификации не проверяются, их отклонит роутер.
+// Тело с JSON схемой читается целиком и подменяется копией, чтобы обработчик мог прочитать его снова.
+func (s *Spec) ValidateRequest(req *http.Request) error {
+	op, pathParams, ok := s.Find(req.Method, req.URL.Path)
+	if !ok {
+		return nil
+	}
+	query := req.URL.Query()
+	for _, param := range op.Parameters {
+		var value string
+		var present bool
+		switch param.In {
+		case "path":
+			value, present = pathParams[param.Name]
+		case "query":
+			present = query.Has(param.Name)
+			value = query.Get(param.Name)
+		case "header":
+			value = req.Header.Get(param.Name)
+			present = value != ""
+		default:
+			continue
+		}
+		if !present {
+			if param.Required {
+				return fmt.Errorf("%w: %s parameter %s is required", ErrInvalidRequest, param.In, param.Name)
+			}
+			continue
+		}
+		if err := s.validateParam(param.Schema, value); err != nil {
+			return fmt.Errorf("%w: %s parameter %s %w", ErrInvalidRequest, param.In, param.Name, err)
+		}
+	}
+	if op.RequestBody != nil {
+		return s.validateBody(req, op.RequestBody)
+	}
+	return nil
+}
+
+// validateBody проверяет тип содержимого и JSON тело запроса.
+func (s *Spec) validateBody(req *http.Request, body *RequestBody) error {
+	if body.Required && (req.Body == nil || req.Body == http.NoBody) {
+		return fmt.Errorf("%w: request body is required", ErrInvalidRequest)
+	}
+	contentType := req.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("%w: bad content type %q", ErrInvalidRequest, contentType)
+	}
+	media, ok := body.media(mediaType)
+	if !ok {
+		return fmt.Errorf("%w: content type %s is not supported, expected one of %s", ErrInvalidRequest, mediaType, strings.Join(body.mediaTypes(), ", "))
+	}
+	if media.Schema == nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) || req.Body == nil {
+		return nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(req.Body, MaxBodySize+1))
+	req.Body.Close()
+	if err != nil {
+		return fmt.Errorf("%w: couldn`t read request body: %w", ErrInvalidRequest, err)
+	}
+	if len(data) > MaxBodySize {
+		return fmt.Errorf("%w: request body is larger than %d bytes", ErrInvalidRequest, MaxBodySize)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(data))
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return fmt.Errorf("%w: request body is not a valid json: %w", ErrInvalidRequest, err)
+	}
+	if err := s.validateValue(media.Schema, value, "body"); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	return nil
+}
+
+// media находит описание типа содержимого, учитывая шаблоны вида */* и text/*.
+func (b *RequestBody) media(mediaType string) (MediaType, bool) {
+	if media, ok := b.Content[mediaType]; ok {
+		return media, true
+	}
+	if major, _, ok := strings.Cut(mediaType, "/"); ok {
+		if media, ok := b.Content[major+"/*"]; ok {
+			return media, true
+		}
+	}
+	media, ok := b.Content["*/*"]
+	return media, ok
+}
+
+// mediaTypes возвращает описанные типы содержимого, отсортированные.
+func (b *RequestBody) mediaTypes() []string {
+	types := make([]string, 0, len(b.Content))
+	for mediaType := range b.Content {
+		types = append(types, mediaType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// validateParam проверяет строковое значение параметра по схеме.
+func (s *Spec) validateParam(schema *Schema, value string) error {
+	schema, err := s.resolve(schema)
+	if err != nil || schema == nil {
+		return err
+	}
+	var typed any = value
+	switch schema.Type {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("must be a %s", schema.Type)
+		}
+		typed = json.Number(value)
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("must be a boolean")
+		}
+		typed = b
+	}
+	if err := s.validateValue(schema, typed, ""); err != nil {
+		return errors.New(strings.TrimPrefix(err.Error(), ": "))
+	}
+	return nil
+}
+
+// validateValue проверяет значение, декодированное из JSON с UseNumber, по схеме.
+// location путь к значению для сообщения об ошибке, например body[0].type.
+func (s *Spec) validateValue(schema *Schema, value any, location string) error {
+	schema, err := s.resolve(schema)
+	if err != nil || schema == nil {
+		return err
+	}
+	for _, part := range schema.AllOf {
+		if err := s.validateValue(part, value, location); err != nil {
+			return err
+		}
+	}
+	if value == nil {
+		if schema.Nullable || schema.Type == "" {
+			return nil
+		}
+		return fmt.Errorf("%s: must not be null", location)
+	}
+
+	switch schema.Type {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: must be an object", location)
+		}
+		for _, name := range schema.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s: property %s is required", location, name)
+			}
+		}
+		names := make([]string, 0, len(schema.Properties))
+		for name := range schema.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if v, ok := obj[name]; ok {
+				if err := s.validateValue(schema.Properties[name], v, location+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	case "array":
+		arr, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s: must be an array", location)
+		}
+		for i, item := range arr {
+			if err := s.validateValue(schema.Items, item, location+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: must be a string", location)
+		}
+		if schema.MinLength != nil && len(str) < *schema.MinLength {
+			return fmt.Errorf("%s: must be at least %d characters long", location, *schema.MinLength)
+		}
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				return fmt.Errorf("%s: must be a date-time in RFC 3339", location)
+			}
+		}
+	case "integer", "number":
+		num, ok := value.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s: must be a %s", location, schema.Type)
+		}
+		if schema.Type == "integer" {
+			if _, err := strconv.ParseInt(num.String(), 10, 64); err != nil {
+				return fmt.Errorf("%s: must be an integer", location)
+			}
+		}
+		f, err := num.Float64()
+		if err != nil {
+			return fmt.Errorf("%s: must be a number", location)
+		}
+		if schema.Minimum != nil && f < *schema.Minimum {
+			return fmt.Errorf("%s: must be at least %v", location, *schema.Minimum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: must be a boolean", location)
+		}
+	}
+
+	if len(schema.Enum) > 0 {
+		for _, allowed := range schema.Enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: must be one of %v", location, schema.Enum)
+	}
+	return nil
+}
+
+// resolve возвращает схему, на которую ссылается $ref, или саму схему.
+func (s *Spec) resolve(schema *Schema) (*Schema, error) {
+	if schema == nil || schema.Ref == "" {
+		return schema, nil
+	}
+	return s.schema(schema.Ref)
+}
@@ -74,43 +74,144 @@ func (d *DBStorage) GetCounter(ctx context.Context, metric *CounterMetric) error
 	return nil
 }
 
-// SetGauge метод сохраняет в БД метрику типа Gauge.
-func (d *DBStorage) SetGauge(ctx context.Context, metric *GaugeMetric) error {
-	tx, err := d.conn.Beginx()
+// txKey ключ контекста с транзакцией, в которой пишутся метрики.
+type txKey struct{}
+
+// WithTx возвращает контекст, в котором DBStorage пишет метрики в транзакции tx и не фиксирует ее сам.
+// Так запись пакета и отметка о его приеме попадают в одну транзакцию.
+func WithTx(ctx context.Context, tx *sqlx.Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// inTx выполняет fn в транзакции из контекста или в новой транзакции, которую фиксирует сам.
+func (d *DBStorage) inTx(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	if tx, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		return fn(tx)
+	}
+	tx, err := d.conn.BeginTxx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
 
-	sqlInsert := `INSERT INTO gauge_metrics (name, gauge)
+const (
+	sqlGaugeInsert = `INSERT INTO gauge_metrics (name, gauge)
                       VALUES ($1, $2)
                       ON CONFLICT (name) DO UPDATE
                       SET gauge = excluded.gauge;`
+	sqlCounterInsert = `INSERT INTO counter_metrics (name, counter)
+					  VALUES ($1, $2)
+                      ON CONFLICT (name) DO UPDATE 
+					  SET counter = counter_metrics.counter + excluded.counter;`
+)
 
-	if _, err := tx.ExecContext(ctx, sqlInsert, metric.Name, metric.Value); err != nil {
-		tx.Rollback()
+// SetGauge метод сохраняет в БД метрику типа Gauge.
+func (d *DBStorage) SetGauge(ctx context.Context, metric *GaugeMetric) error {
+	return d.inTx(ctx, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, sqlGaugeInsert, metric.Name, metric.Value)
 		return err
-	}
-	tx.Commit()
-	return nil
+	})
 }
 
 // SetCounter метод сохоаняет в БД метрику типа Counter.
 func (d *DBStorage) SetCounter(ctx context.Context, metric *CounterMetric) error {
-	tx, err := d.conn.Beginx()
+	return d.inTx(ctx, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, sqlCounterInsert, metric.Name, metric.Value)
+		return err
+	})
+}
+
+// SetMetrics метод сохраняет в БД пачку метрик типов Gauge и Counter в одной транзакции.
+func (d *DBStorage) SetMetrics(ctx context.Context, gauges []GaugeMetric, counters []CounterMetric) error {
+	return d.inTx(ctx, func(tx *sqlx.Tx) error {
+		for _, metric := range gauges {
+			if _, err := tx.ExecContext(ctx, sqlGaugeInsert, metric.Name, metric.Value); err != nil {
+				return err
+			}
+		}
+		for _, metric := range counters {
+			if _, err := tx.ExecContext(ctx, sqlCounterInsert, metric.Name, metric.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteGauge метод удаляет из БД метрику типа Gauge.
+func (d *DBStorage) DeleteGauge(ctx context.Context, metric *GaugeMetric) error {
+	return d.deleteMetric(ctx, `DELETE FROM gauge_metrics WHERE name = $1`, metric.Name)
+}
+
+// DeleteCounter метод удаляет из БД метрику типа Counter.
+func (d *DBStorage) DeleteCounter(ctx context.Context, metric *CounterMetric) error {
+	return d.deleteMetric(ctx, `DELETE FROM counter_metrics WHERE name = $1`, metric.Name)
+}
+
+// deleteMetric выполняет запрос удаления и возвращает ErrNoRows, если метрики не было.
+func (d *DBStorage) deleteMetric(ctx context.Context, sqlDelete string, name string) error {
+	result, err := d.conn.ExecContext(ctx, sqlDelete, name)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if affected == 0 {
+		return ErrNoRows
+	}
+	return nil
+}
 
-	sqlInsert := `INSERT INTO counter_metrics (name, counter)
-					  VALUES ($1, $2)
-                      ON CONFLICT (name) DO UPDATE 
-					  SET counter = counter_metrics.counter + excluded.counter;`
+// ResetCounter метод обнуляет в БД метрику типа Counter, значение до сброса записывается в metric.
+func (d *DBStorage) ResetCounter(ctx context.Context, metric *CounterMetric) error {
+	sqlUpdate := `UPDATE counter_metrics AS c SET counter = 0
+                  FROM (SELECT id, counter FROM counter_metrics WHERE name = $1 FOR UPDATE) AS old
+                  WHERE c.id = old.id
+                  RETURNING c.id, c.name, old.counter`
 
-	if _, err := tx.ExecContext(ctx, sqlInsert, metric.Name, metric.Value); err != nil {
-		tx.Rollback()
+	if err := d.conn.GetContext(ctx, metric, sqlUpdate, metric.Name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRows
+		}
+		return err
+	}
+	return nil
+}
+
+// metricRow строка выборки метрик обоих типов одним запросом.
+type metricRow struct {
+	Type    string          `db:"type"`
+	ID      int64           `db:"id"`
+	Name    string          `db:"name"`
+	Gauge   sql.NullFloat64 `db:"gauge"`
+	Counter sql.NullInt64   `db:"counter"`
+}
+
+// GetMetrics метод одним запросом к БД возвращает метрики по именам, ненайденные метрики пропускаются.
+func (d *DBStorage) GetMetrics(ctx context.Context, gauges []string, counters []string, sm *StoreMetrics) error {
+	sqlSelect := `SELECT 'gauge' AS type, id, name, gauge, NULL::bigint AS counter FROM gauge_metrics WHERE name = ANY($1)
+                  UNION ALL
+                  SELECT 'counter' AS type, id, name, NULL::double precision AS gauge, counter FROM counter_metrics WHERE name = ANY($2)`
+
+	var rows []metricRow
+	if err := d.conn.SelectContext(ctx, &rows, sqlSelect, gauges, counters); err != nil {
 		return err
 	}
-	tx.Commit()
+	for _, row := range rows {
+		switch row.Type {
+		case "gauge":
+			sm.Gauge = append(sm.Gauge, GaugeMetric{ID: row.ID, Name: row.Name, Value: row.Gauge.Float64})
+		case "counter":
+			sm.Counter = append(sm.Counter, CounterMetric{ID: row.ID, Name: row.Name, Value: row.Counter.Int64})
+		}
+	}
 	return nil
 }
 
@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"github.com/stretchr/testify/assert"
 	sqlxmock "github.com/zhashkevych/go-sqlxmock"
@@ -258,6 +259,85 @@ func TestDBStorage_SetCounter(t *testing.T) {
 	}
 }
 
+func TestDBStorage_SetMetrics(t *testing.T) {
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	s, err := NewDBStorage(db, false)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when create db storage type", err)
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	testTable := []struct {
+		name     string
+		s        *DBStorage
+		gauges   []GaugeMetric
+		counters []CounterMetric
+		ctx      context.Context
+		mock     func()
+		err      error
+	}{
+		{
+			name:     "OK",
+			s:        s,
+			gauges:   []GaugeMetric{{Name: "test_gauge", Value: 338.1}},
+			counters: []CounterMetric{{Name: "test_counter", Value: 113}},
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectExec("INSERT INTO gauge_metrics").WithArgs("test_gauge", 338.1).WillReturnResult(sqlxmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO counter_metrics").WithArgs("test_counter", 113).WillReturnResult(sqlxmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			err: nil,
+		},
+		{
+			name:     "NOT OK. rollback whole batch",
+			s:        s,
+			gauges:   []GaugeMetric{{Name: "test_gauge", Value: 338.1}},
+			counters: []CounterMetric{{Name: "test_counter", Value: 113}},
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectExec("INSERT INTO gauge_metrics").WithArgs("test_gauge", 338.1).WillReturnResult(sqlxmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO counter_metrics").WithArgs("test_counter", 113).WillReturnError(errors.New("something went wrong"))
+				mock.ExpectRollback()
+			},
+			err: errors.New("something went wrong"),
+		},
+		{
+			name:   "NOT OK. request canceled",
+			s:      s,
+			gauges: []GaugeMetric{{Name: "test_gauge", Value: 338.1}},
+			ctx:    canceled,
+			mock:   func() {},
+			err:    context.Canceled,
+		},
+	}
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock()
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.TODO()
+			}
+			err := tt.s.SetMetrics(ctx, tt.gauges, tt.counters)
+			if tt.err != nil {
+				if assert.Errorf(t, err, tt.err.Error()) {
+					assert.Equal(t, tt.err, err)
+				}
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
 func TestDBStorage_GetAllMetrics(t *testing.T) {
 	db, mock, err := sqlxmock.Newx()
 	if err != nil {
@@ -395,3 +475,141 @@ func TestDBStorage_Bootstrap(t *testing.T) {
 		})
 	}
 }
+
+func TestDBStorage_ResetCounter(t *testing.T) {
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	s, err := NewDBStorage(db, false)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when create db storage type", err)
+	}
+
+	testTable := []struct {
+		name string
+		mock func()
+		want int64
+		err  error
+	}{
+		{
+			name: "OK",
+			mock: func() {
+				rows := sqlxmock.NewRows([]string{"id", "name", "counter"}).AddRow(1, "PollCount", 42)
+				mock.ExpectQuery("UPDATE counter_metrics").WithArgs("PollCount").WillReturnRows(rows)
+			},
+			want: 42,
+		},
+		{
+			name: "NOT OK. ErrNoRows",
+			mock: func() {
+				mock.ExpectQuery("UPDATE counter_metrics").WithArgs("PollCount").WillReturnError(sql.ErrNoRows)
+			},
+			err: ErrNoRows,
+		},
+	}
+
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock()
+			m := &CounterMetric{Name: "PollCount"}
+			err := s.ResetCounter(context.TODO(), m)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, m.Value)
+		})
+	}
+}
+
+func TestDBStorage_DeleteGauge(t *testing.T) {
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	s, err := NewDBStorage(db, false)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when create db storage type", err)
+	}
+
+	mock.ExpectExec("DELETE FROM gauge_metrics").WithArgs("Alloc").WillReturnResult(sqlxmock.NewResult(0, 1))
+	assert.NoError(t, s.DeleteGauge(context.TODO(), &GaugeMetric{Name: "Alloc"}))
+
+	mock.ExpectExec("DELETE FROM gauge_metrics").WithArgs("Alloc").WillReturnResult(sqlxmock.NewResult(0, 0))
+	assert.ErrorIs(t, s.DeleteGauge(context.TODO(), &GaugeMetric{Name: "Alloc"}), ErrNoRows)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// arrayConverter пропускает массивы имен в драйвер как есть, как это делает pgx.
+type arrayConverter struct{}
+
+func (arrayConverter) ConvertValue(v any) (driver.Value, error) {
+	if names, ok := v.([]string); ok {
+		return names, nil
+	}
+	return driver.DefaultParameterConverter.ConvertValue(v)
+}
+
+func TestDBStorage_GetMetrics(t *testing.T) {
+	db, mock, err := sqlxmock.Newx(sqlxmock.ValueConverterOption(arrayConverter{}))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	s, err := NewDBStorage(db, false)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when create db storage type", err)
+	}
+
+	gauges := []string{"Alloc", "unknown"}
+	counters := []string{"PollCount"}
+	testTable := []struct {
+		name string
+		mock func()
+		want *StoreMetrics
+		err  error
+	}{
+		{
+			name: "OK",
+			mock: func() {
+				rows := sqlxmock.NewRows([]string{"type", "id", "name", "gauge", "counter"}).
+					AddRow("gauge", 1, "Alloc", 1.5, nil).
+					AddRow("counter", 2, "PollCount", nil, 42)
+				mock.ExpectQuery("SELECT 'gauge' AS type").WithArgs(gauges, counters).WillReturnRows(rows)
+			},
+			want: &StoreMetrics{
+				Gauge:   []GaugeMetric{{1, "Alloc", 1.5}},
+				Counter: []CounterMetric{{2, "PollCount", 42}},
+			},
+		},
+		{
+			name: "NOT OK. select failed",
+			mock: func() {
+				mock.ExpectQuery("SELECT 'gauge' AS type").WithArgs(gauges, counters).WillReturnError(errors.New("connection reset"))
+			},
+			err: errors.New("connection reset"),
+		},
+	}
+
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock()
+			sm := &StoreMetrics{}
+			err := s.GetMetrics(context.TODO(), gauges, counters, sm)
+			if tt.err != nil {
+				assert.Equal(t, tt.err, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, sm)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
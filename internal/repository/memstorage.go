@@ -2,7 +2,7 @@ package repository
 
 import (
 	"context"
-	"errors"
+	"fmt"
 )
 
 // MemStorage хранит Gauge и Counter метрики в памяти
@@ -24,7 +24,7 @@ func (g *MemStorage) GetGauge(ctx context.Context, metric *GaugeMetric) error {
 	var ok bool
 	metric.Value, ok = g.Gauge[metric.Name]
 	if !ok {
-		return errors.New("error: invalid gauge metric name")
+		return fmt.Errorf("error: invalid gauge metric name: %w", ErrNoRows)
 	}
 	return nil
 }
@@ -34,7 +34,7 @@ func (g *MemStorage) GetCounter(ctx context.Context, metric *CounterMetric) erro
 	var ok bool
 	metric.Value, ok = g.Counter[metric.Name]
 	if !ok {
-		return errors.New("error: invalid counter metric name")
+		return fmt.Errorf("error: invalid counter metric name: %w", ErrNoRows)
 	}
 	return nil
 }
@@ -51,6 +51,61 @@ func (g *MemStorage) SetCounter(ctx context.Context, metric *CounterMetric) erro
 	return nil
 }
 
+// SetMetrics метод сохраняет в памяти пачку метрик типов Gauge и Counter.
+func (g *MemStorage) SetMetrics(ctx context.Context, gauges []GaugeMetric, counters []CounterMetric) error {
+	for _, metric := range gauges {
+		g.Gauge[metric.Name] = metric.Value
+	}
+	for _, metric := range counters {
+		g.Counter[metric.Name] += metric.Value
+	}
+	return nil
+}
+
+// DeleteGauge метод удаляет из памяти метрику типа Gauge.
+func (g *MemStorage) DeleteGauge(ctx context.Context, metric *GaugeMetric) error {
+	if _, ok := g.Gauge[metric.Name]; !ok {
+		return fmt.Errorf("error: invalid gauge metric name: %w", ErrNoRows)
+	}
+	delete(g.Gauge, metric.Name)
+	return nil
+}
+
+// DeleteCounter метод удаляет из памяти метрику типа Counter.
+func (g *MemStorage) DeleteCounter(ctx context.Context, metric *CounterMetric) error {
+	if _, ok := g.Counter[metric.Name]; !ok {
+		return fmt.Errorf("error: invalid counter metric name: %w", ErrNoRows)
+	}
+	delete(g.Counter, metric.Name)
+	return nil
+}
+
+// ResetCounter метод обнуляет в памяти метрику типа Counter, значение до сброса записывается в metric.
+func (g *MemStorage) ResetCounter(ctx context.Context, metric *CounterMetric) error {
+	var ok bool
+	metric.Value, ok = g.Counter[metric.Name]
+	if !ok {
+		return fmt.Errorf("error: invalid counter metric name: %w", ErrNoRows)
+	}
+	g.Counter[metric.Name] = 0
+	return nil
+}
+
+// GetMetrics метод возвращает из памяти метрики по именам, ненайденные метрики пропускаются.
+func (g *MemStorage) GetMetrics(ctx context.Context, gauges []string, counters []string, s *StoreMetrics) error {
+	for _, name := range gauges {
+		if value, ok := g.Gauge[name]; ok {
+			s.Gauge = append(s.Gauge, GaugeMetric{Name: name, Value: value})
+		}
+	}
+	for _, name := range counters {
+		if value, ok := g.Counter[name]; ok {
+			s.Counter = append(s.Counter, CounterMetric{Name: name, Value: value})
+		}
+	}
+	return nil
+}
+
 // GetAllMetrics метод возвращает все метрики из памяти.
 func (g *MemStorage) GetAllMetrics(ctx context.Context, s *StoreMetrics) error {
 	for key, value := range g.Gauge {
@@ -75,3 +75,32 @@ func TestNewMemStorage(t *testing.T) {
 		})
 	}
 }
+
+func TestMemStorage_SetMetrics(t *testing.T) {
+	storage := NewMemStorage()
+	gauges := []GaugeMetric{{Name: "gauge1", Value: 1.5}, {Name: "gauge1", Value: 2.5}}
+	counters := []CounterMetric{{Name: "counter1", Value: 10}, {Name: "counter1", Value: 15}}
+
+	require.NoError(t, storage.SetMetrics(context.TODO(), gauges, counters))
+	assert.Equal(t, 2.5, storage.Gauge["gauge1"])
+	assert.Equal(t, int64(25), storage.Counter["counter1"])
+}
+
+func TestMemStorage_DeleteAndReset(t *testing.T) {
+	ctx := context.TODO()
+	storage := NewMemStorage()
+	require.NoError(t, storage.SetGauge(ctx, &GaugeMetric{Name: "Alloc", Value: 1.5}))
+	require.NoError(t, storage.SetCounter(ctx, &CounterMetric{Name: "PollCount", Value: 7}))
+
+	reset := CounterMetric{Name: "PollCount"}
+	require.NoError(t, storage.ResetCounter(ctx, &reset))
+	assert.Equal(t, int64(7), reset.Value)
+	assert.Equal(t, int64(0), storage.Counter["PollCount"])
+	assert.ErrorIs(t, storage.ResetCounter(ctx, &CounterMetric{Name: "unknown"}), ErrNoRows)
+
+	require.NoError(t, storage.DeleteGauge(ctx, &GaugeMetric{Name: "Alloc"}))
+	assert.ErrorIs(t, storage.GetGauge(ctx, &GaugeMetric{Name: "Alloc"}), ErrNoRows)
+	assert.ErrorIs(t, storage.DeleteGauge(ctx, &GaugeMetric{Name: "Alloc"}), ErrNoRows)
+	require.NoError(t, storage.DeleteCounter(ctx, &CounterMetric{Name: "PollCount"}))
+	assert.ErrorIs(t, storage.DeleteCounter(ctx, &CounterMetric{Name: "PollCount"}), ErrNoRows)
+}
@@ -0,0 +1,63 @@
+package selfmetrics
+
+import "time"
+
+// Metrics набор метрик сервера.
+type Metrics struct {
+	*Registry
+	// HTTPRequests запросы HTTP по шаблону маршрута, методу и коду ответа.
+	HTTPRequests *CounterVec
+	// HTTPDuration длительность обработки запросов HTTP.
+	HTTPDuration *HistogramVec
+	// GRPCRequests вызовы gRPC по методу и коду статуса.
+	GRPCRequests *CounterVec
+	// GRPCDuration длительность обработки вызовов gRPC.
+	GRPCDuration *HistogramVec
+	// StorageOperations обращения к хранилищу по операции и результату.
+	StorageOperations *CounterVec
+	// StorageDuration длительность обращений к хранилищу.
+	StorageDuration *HistogramVec
+	// Retries неудачные попытки в Service.Retry: retry - будет повтор, exhausted - попытки кончились.
+	Retries *CounterVec
+	// Saves сохранения метрик в файл по результату.
+	Saves *CounterVec
+	// SaveDuration длительность сохранения метрик в файл.
+	SaveDuration *HistogramVec
+}
+
+// New конструктор для Metrics, регистрирует все метрики в новом реестре.
+func New() *Metrics {
+	r := NewRegistry()
+	return &Metrics{
+		Registry:          r,
+		HTTPRequests:      r.NewCounter("blackbird_http_requests_total", "HTTP requests handled.", "route", "method", "code"),
+		HTTPDuration:      r.NewHistogram("blackbird_http_request_duration_seconds", "HTTP request latency.", nil, "route", "method"),
+		GRPCRequests:      r.NewCounter("blackbird_grpc_requests_total", "gRPC calls handled.", "method", "code"),
+		GRPCDuration:      r.NewHistogram("blackbird_grpc_request_duration_seconds", "gRPC call latency.", nil, "method"),
+		StorageOperations: r.NewCounter("blackbird_storage_operations_total", "Repository calls.", "operation", "result"),
+		StorageDuration:   r.NewHistogram("blackbird_storage_operation_duration_seconds", "Repository call latency.", nil, "operation"),
+		Retries:           r.NewCounter("blackbird_retries_total", "Failed attempts in storage retries.", "result"),
+		Saves:             r.NewCounter("blackbird_file_saves_total", "Snapshots saved to file.", "result"),
+		SaveDuration:      r.NewHistogram("blackbird_file_save_duration_seconds", "Snapshot save latency.", nil),
+	}
+}
+
+// ObserveStorage учитывает обращение к хранилищу, начатое в start.
+func (m *Metrics) ObserveStorage(operation string, start time.Time, err error) {
+	m.StorageOperations.Inc(operation, result(err))
+	m.StorageDuration.Observe(time.Since(start).Seconds(), operation)
+}
+
+// ObserveSave учитывает сохранение в файл, начатое в start.
+func (m *Metrics) ObserveSave(start time.Time, err error) {
+	m.Saves.Inc(result(err))
+	m.SaveDuration.Observe(time.Since(start).Seconds())
+}
+
+// result возвращает значение метки result по ошибке.
+func result(err error) string {
+	if err != nil {
+		return ResultError
+	}
+	return ResultOK
+}
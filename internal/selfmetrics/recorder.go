@@ -0,0 +1,87 @@
+package selfmetrics
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/sebasttiano/Blackbird.git/internal/logger"
+	"github.com/sebasttiano/Blackbird.git/internal/models"
+	"go.uber.org/zap"
+)
+
+// Writer сохраняет метрики сервера в хранилище в обход запрета на зарезервированный префикс.
+type Writer interface {
+	SetSelfMetrics(ctx context.Context, metrics []*models.Metrics) error
+}
+
+// Recorder периодически записывает метрики сервера в его же хранилище под префиксом.
+// Счетчики пишутся приростом с прошлой записи, поэтому в хранилище они совпадают с /internal/metrics.
+type Recorder struct {
+	metrics *Metrics
+	prefix  string
+	writer  Writer
+	// written сколько каждого счетчика уже записано
+	written map[string]int64
+}
+
+// NewRecorder конструктор для Recorder.
+func NewRecorder(metrics *Metrics, prefix string, writer Writer) *Recorder {
+	return &Recorder{
+		metrics: metrics,
+		prefix:  prefix,
+		writer:  writer,
+		written: make(map[string]int64),
+	}
+}
+
+// Flush записывает текущие значения. Записанные значения запоминаются только после успешной записи.
+func (r *Recorder) Flush(ctx context.Context) error {
+	samples := r.metrics.Samples()
+	batch := make([]*models.Metrics, 0, len(samples))
+	pending := make(map[string]int64)
+	for _, s := range samples {
+		id := r.prefix + s.ID
+		if s.MType == "gauge" {
+			value := s.Value
+			batch = append(batch, &models.Metrics{ID: id, MType: s.MType, Value: &value})
+			continue
+		}
+		total := int64(math.Floor(s.Value))
+		written, seen := r.written[id]
+		if seen && total == written {
+			continue
+		}
+		delta := total - written
+		batch = append(batch, &models.Metrics{ID: id, MType: s.MType, Delta: &delta})
+		pending[id] = total
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	if err := r.writer.SetSelfMetrics(ctx, batch); err != nil {
+		return err
+	}
+	for id, total := range pending {
+		r.written[id] = total
+	}
+	return nil
+}
+
+// Run записывает метрики с интервалом до отмены контекста.
+func (r *Recorder) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			flushCtx, cancel := context.WithTimeout(ctx, interval)
+			if err := r.Flush(flushCtx); err != nil {
+				logger.Log.Error("couldn`t store self metrics", zap.Error(err))
+			}
+			cancel()
+		}
+	}
+}
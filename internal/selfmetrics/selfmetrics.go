@@ -0,0 +1,268 @@
+// Package selfmetrics собирает метрики работы самого сервера: запросы HTTP и gRPC,
+// обращения к хранилищу, повторы и сохранение в файл.
+package selfmetrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets границы гистограмм длительности в секундах.
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Результаты операций для метки result.
+const (
+	ResultOK        = "ok"
+	ResultError     = "error"
+	ResultRetry     = "retry"
+	ResultExhausted = "exhausted"
+)
+
+// series значения одного набора меток.
+type series struct {
+	labels []string
+	value  float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// vec семейство метрик с одним именем и набором меток.
+type vec struct {
+	name    string
+	help    string
+	mType   string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// get возвращает ряд для значений меток, создавая его при первом обращении. Вызывается под mu.
+func (v *vec) get(values []string) *series {
+	if len(values) != len(v.labels) {
+		// ошибка в коде инструментирования, недостающие метки заполняются пустыми
+		fixed := make([]string, len(v.labels))
+		copy(fixed, values)
+		values = fixed
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), values...)}
+		if v.mType == "histogram" {
+			s.counts = make([]uint64, len(v.buckets))
+		}
+		v.series[key] = s
+	}
+	return s
+}
+
+// sorted возвращает копию рядов, отсортированных по значениям меток.
+func (v *vec) sorted() []series {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	out := make([]series, 0, len(v.series))
+	for _, s := range v.series {
+		c := *s
+		c.counts = append([]uint64(nil), s.counts...)
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return strings.Join(out[i].labels, "\xff") < strings.Join(out[j].labels, "\xff")
+	})
+	return out
+}
+
+// CounterVec счетчик с метками.
+type CounterVec struct {
+	v *vec
+}
+
+// Inc увеличивает счетчик на единицу.
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add увеличивает счетчик на delta, отрицательные значения игнорируются.
+func (c *CounterVec) Add(delta float64, values ...string) {
+	if delta < 0 {
+		return
+	}
+	c.v.mu.Lock()
+	c.v.get(values).value += delta
+	c.v.mu.Unlock()
+}
+
+// Value возвращает текущее значение счетчика.
+func (c *CounterVec) Value(values ...string) float64 {
+	c.v.mu.Lock()
+	defer c.v.mu.Unlock()
+	return c.v.get(values).value
+}
+
+// HistogramVec гистограмма с метками.
+type HistogramVec struct {
+	v *vec
+}
+
+// Observe добавляет наблюдение в гистограмму.
+func (h *HistogramVec) Observe(value float64, values ...string) {
+	h.v.mu.Lock()
+	defer h.v.mu.Unlock()
+	s := h.v.get(values)
+	for i, bound := range h.v.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// Count возвращает количество наблюдений.
+func (h *HistogramVec) Count(values ...string) uint64 {
+	h.v.mu.Lock()
+	defer h.v.mu.Unlock()
+	return h.v.get(values).count
+}
+
+// Registry хранит семейства метрик и выводит их.
+type Registry struct {
+	mu   sync.Mutex
+	vecs []*vec
+}
+
+// NewRegistry конструктор для Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewCounter регистрирует счетчик с метками.
+func (r *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{v: r.register(&vec{name: name, help: help, mType: "counter", labels: labels})}
+}
+
+// NewHistogram регистрирует гистограмму с метками, nil buckets означает DefaultBuckets.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	return &HistogramVec{v: r.register(&vec{name: name, help: help, mType: "histogram", labels: labels, buckets: buckets})}
+}
+
+// register добавляет семейство в реестр.
+func (r *Registry) register(v *vec) *vec {
+	v.series = make(map[string]*series)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.vecs = append(r.vecs, v)
+	sort.SliceStable(r.vecs, func(i, j int) bool { return r.vecs[i].name < r.vecs[j].name })
+	return v
+}
+
+// families возвращает копию списка семейств.
+func (r *Registry) families() []*vec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*vec(nil), r.vecs...)
+}
+
+// Write выводит все метрики в текстовом формате Prometheus 0.0.4.
+func (r *Registry) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, v := range r.families() {
+		bw.WriteString("# HELP " + v.name + " " + v.help + "\n")
+		bw.WriteString("# TYPE " + v.name + " " + v.mType + "\n")
+		for _, s := range v.sorted() {
+			if v.mType == "counter" {
+				bw.WriteString(v.name + labelString(v.labels, s.labels, "") + " " + formatFloat(s.value) + "\n")
+				continue
+			}
+			for i, bound := range v.buckets {
+				bw.WriteString(v.name + "_bucket" + labelString(v.labels, s.labels, formatFloat(bound)) + " " + strconv.FormatUint(s.counts[i], 10) + "\n")
+			}
+			bw.WriteString(v.name + "_bucket" + labelString(v.labels, s.labels, "+Inf") + " " + strconv.FormatUint(s.count, 10) + "\n")
+			bw.WriteString(v.name + "_sum" + labelString(v.labels, s.labels, "") + " " + formatFloat(s.sum) + "\n")
+			bw.WriteString(v.name + "_count" + labelString(v.labels, s.labels, "") + " " + strconv.FormatUint(s.count, 10) + "\n")
+		}
+	}
+	return bw.Flush()
+}
+
+// Sample значение метрики в виде, пригодном для записи в хранилище.
+type Sample struct {
+	// ID имя метрики: имя семейства, значения меток и для гистограмм суффикс count или sum через точку.
+	ID string
+	// MType тип метрики: counter или gauge.
+	MType string
+	Value float64
+}
+
+// Samples возвращает текущие значения всех рядов. Гистограмма дает счетчик наблюдений count
+// и gauge с суммой sum.
+func (r *Registry) Samples() []Sample {
+	var out []Sample
+	for _, v := range r.families() {
+		for _, s := range v.sorted() {
+			id := strings.Join(append([]string{v.name}, s.labels...), ".")
+			if v.mType == "counter" {
+				out = append(out, Sample{ID: id, MType: "counter", Value: s.value})
+				continue
+			}
+			out = append(out,
+				Sample{ID: id + ".count", MType: "counter", Value: float64(s.count)},
+				Sample{ID: id + ".sum", MType: "gauge", Value: s.sum},
+			)
+		}
+	}
+	return out
+}
+
+// labelString собирает метки ряда в виде {k="v",...}, le добавляется для корзин гистограммы.
+func labelString(names, values []string, le string) string {
+	if len(names) == 0 && le == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name + `="` + escapeLabel(values[i]) + `"`)
+	}
+	if le != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(`le="` + le + `"`)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// escapeLabel экранирует обратный слэш, кавычку и перевод строки в значении метки.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// formatFloat форматирует значение, включая бесконечности.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,83 @@
+package selfmetrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sebasttiano/Blackbird.git/internal/ingest/ingesttest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounter("requests_total", "Requests.", "route", "code")
+	latency := r.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1})
+
+	requests.Inc("/update/", "200")
+	requests.Add(2, "/update/", "200")
+	requests.Inc(`/a"b`, "500")
+	requests.Add(-5, "/update/", "200")
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	latency.Observe(3)
+
+	var b bytes.Buffer
+	require.NoError(t, r.Write(&b))
+	assert.Equal(t, `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 3.55
+latency_seconds_count 3
+# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{route="/a\"b",code="500"} 1
+requests_total{route="/update/",code="200"} 3
+`, b.String())
+}
+
+func TestRegistry_Samples(t *testing.T) {
+	m := New()
+	m.StorageOperations.Inc("set_gauge", ResultOK)
+	m.ObserveSave(time.Now(), errors.New("disk is full"))
+
+	samples := make(map[string]Sample)
+	for _, s := range m.Samples() {
+		samples[s.ID] = s
+	}
+	assert.Equal(t, Sample{ID: "blackbird_storage_operations_total.set_gauge.ok", MType: "counter", Value: 1}, samples["blackbird_storage_operations_total.set_gauge.ok"])
+	assert.Equal(t, 1.0, samples["blackbird_file_saves_total.error"].Value)
+	assert.Equal(t, 1.0, samples["blackbird_file_save_duration_seconds.count"].Value)
+	assert.Equal(t, "gauge", samples["blackbird_file_save_duration_seconds.sum"].MType)
+}
+
+func TestRecorder_Flush(t *testing.T) {
+	m := New()
+	w := ingesttest.NewWriter()
+	r := NewRecorder(m, "_bb.", w)
+	ctx := context.Background()
+	id := "_bb.blackbird_retries_total.retry"
+
+	m.Retries.Inc(ResultRetry)
+	m.GRPCDuration.Observe(0.25, "/Metrics/GetMetric")
+	require.NoError(t, r.Flush(ctx))
+	assert.Equal(t, 1.0, w.Value(id))
+	assert.Equal(t, 1.0, w.Value("_bb.blackbird_grpc_request_duration_seconds./Metrics/GetMetric.count"))
+	assert.Equal(t, 0.25, w.Value("_bb.blackbird_grpc_request_duration_seconds./Metrics/GetMetric.sum"))
+
+	// после ошибки записи прирост не теряется
+	m.Retries.Add(2, ResultRetry)
+	w.Fail(errors.New("storage is down"))
+	require.Error(t, r.Flush(ctx))
+	w.Fail(nil)
+	require.NoError(t, r.Flush(ctx))
+	assert.Equal(t, 3.0, w.Value(id))
+
+	require.NoError(t, r.Flush(ctx))
+	assert.Equal(t, 3.0, w.Value(id))
+}
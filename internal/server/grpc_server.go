@@ -2,46 +2,105 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
+	"github.com/sebasttiano/Blackbird.git/internal/auth"
 	"github.com/sebasttiano/Blackbird.git/internal/handlers"
+	"github.com/sebasttiano/Blackbird.git/internal/health"
+	"github.com/sebasttiano/Blackbird.git/internal/ingest/otlp"
 	"github.com/sebasttiano/Blackbird.git/internal/logger"
 	pb "github.com/sebasttiano/Blackbird.git/internal/proto"
 	"github.com/sebasttiano/Blackbird.git/internal/service"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
+// ErrNotServing ошибка проверки готовности, пока gRPC сервер не слушает порт.
+var ErrNotServing = errors.New("gRPC server is not serving")
+
 // GRPSServer реалиузет gRPC сервер.
 type GRPSServer struct {
-	srv *grpc.Server
+	srv     *grpc.Server
+	service *service.Service
+	tls     bool
+	serving atomic.Bool
 }
 
-// NewGRPSServer конструктор для gRPC сервера
-func NewGRPSServer(service *service.Service) *GRPSServer {
-	s := grpc.NewServer(grpc.UnaryInterceptor(logging.UnaryServerInterceptor(handlers.InterceptorLogger(logger.Log))))
-	pb.RegisterMetricsServer(s, &handlers.MetricsServer{Service: service})
+// NewGRPSServer конструктор для gRPC сервера. Приемник OTLP общий с HTTP сервером,
+// чтобы накопительные ряды считались одинаково с обоих транспортов; nil создает отдельный.
+// Проверки готовности отдаются сервисом grpc.health.v1.Health, обычно это те же проверки, что у /readyz.
+// Транспорт и доступ настраиваются через cfg, тот же, что у HTTP сервера.
+func NewGRPSServer(service *service.Service, cfg handlers.Config, otlpReceiver *otlp.Receiver, checks *health.Health) *GRPSServer {
+	authenticator := auth.NewAuthenticator(cfg.Tokens, cfg.AdminToken)
+	unary := []grpc.UnaryServerInterceptor{
+		handlers.MetricsInterceptor(service.Settings.SelfMetrics),
+		logging.UnaryServerInterceptor(handlers.InterceptorLogger(logger.Log)),
+	}
+	stream := []grpc.StreamServerInterceptor{
+		handlers.StreamMetricsInterceptor(service.Settings.SelfMetrics),
+		logging.StreamServerInterceptor(handlers.InterceptorLogger(logger.Log)),
+	}
+	if cfg.TrustedSubnet != nil {
+		unary = append(unary, handlers.TrustedSubnetInterceptor(cfg.TrustedSubnet))
+		stream = append(stream, handlers.StreamTrustedSubnetInterceptor(cfg.TrustedSubnet))
+	}
+	unary = append(unary, handlers.IdentityInterceptor, handlers.SignatureInterceptor(cfg.Signatures),
+		handlers.RequireSignInterceptor(cfg.Signatures), handlers.AuditSourceInterceptor(cfg.TrustedSubnet), handlers.AuthInterceptor(authenticator))
+	stream = append(stream, handlers.StreamIdentityInterceptor, handlers.StreamAuthInterceptor(authenticator))
+	opts := []grpc.ServerOption{grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...)}
+	if cfg.TLS != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(cfg.TLS)))
+	}
+	s := grpc.NewServer(opts...)
+	pb.RegisterMetricsServer(s, &handlers.MetricsServer{Service: service, Keys: cfg.Keys})
+	pb.RegisterAdminServer(s, &handlers.AdminServer{Service: service})
+	if otlpReceiver == nil {
+		otlpReceiver = otlp.NewReceiver(service, otlp.Namer{ResourceAttributes: cfg.OTLPResourceAttributes})
+	}
+	colmetricspb.RegisterMetricsServiceServer(s, &handlers.OTLPServer{Receiver: otlpReceiver})
+	if checks == nil {
+		checks = health.New(0)
+	}
+	healthpb.RegisterHealthServer(s, health.NewGRPCServer(checks))
 	return &GRPSServer{
-		srv: s,
+		srv:     s,
+		service: service,
+		tls:     cfg.TLS != nil,
 	}
 }
 
 // Start запускает grpc сервер.
 func (s *GRPSServer) Start(addr string) {
-	logger.Log.Info("Running gRPC server", zap.String("address", addr))
+	logger.Log.Info("Running gRPC server", zap.String("address", addr), zap.Bool("tls", s.tls))
 	listen, err := net.Listen("tcp", addr)
 	if err != nil {
 		fmt.Println(err.Error())
 		logger.Log.Error("failed to allocate tcp socket for gRPC server", zap.Error(err))
+		return
 	}
+	s.serving.Store(true)
+	defer s.serving.Store(false)
 	if err := s.srv.Serve(listen); err != nil {
 		logger.Log.Error("failed to start gRPC server", zap.Error(err))
 	}
 }
 
+// Check проверка готовности: gRPC сервер слушает порт.
+func (s *GRPSServer) Check(ctx context.Context) error {
+	if !s.serving.Load() {
+		return ErrNotServing
+	}
+	return nil
+}
+
 // HandleShutdown закрывает grpc сервер.
 func (s *GRPSServer) HandleShutdown(ctx context.Context, wg *sync.WaitGroup) {
 
@@ -50,6 +109,8 @@ func (s *GRPSServer) HandleShutdown(ctx context.Context, wg *sync.WaitGroup) {
 	<-ctx.Done()
 	logger.Log.Info("shutdown signal caught. shutting down gRPC server")
 
+	// стримы подписчиков бесконечны, без закрытия подписок GracefulStop их не дождется
+	s.service.CloseSubscriptions()
 	s.srv.GracefulStop()
 	logger.Log.Info("gRPC server gracefully shutdown")
 }
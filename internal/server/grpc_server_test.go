@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"github.com/sebasttiano/Blackbird.git/internal/handlers"
 	"github.com/sebasttiano/Blackbird.git/internal/repository"
 	"github.com/sebasttiano/Blackbird.git/internal/service"
 	"github.com/stretchr/testify/assert"
@@ -16,7 +17,7 @@ var GServ *GRPSServer
 func init() {
 	repo := repository.NewMemStorage()
 	srv := service.NewService(&service.Settings{Retries: 1, BackoffFactor: 1}, repo)
-	GServ = NewGRPSServer(srv)
+	GServ = NewGRPSServer(srv, handlers.Config{}, nil, nil)
 }
 
 func TestNewGRPSServer(t *testing.T) {
@@ -33,6 +34,7 @@ func TestGRPSServer_StartAndShutdown(t *testing.T) {
 	wg.Add(1)
 	go GServ.Start(":4095")
 	time.Sleep(1 * time.Second)
+	assert.NoError(t, GServ.Check(context.Background()))
 
 	// Assert socket is used
 	_, err := net.Listen("tcp", ":4095")
@@ -40,6 +42,8 @@ func TestGRPSServer_StartAndShutdown(t *testing.T) {
 
 	GServ.HandleShutdown(ctx, wg)
 	wg.Wait()
+	time.Sleep(100 * time.Millisecond)
+	assert.ErrorIs(t, GServ.Check(context.Background()), ErrNotServing)
 
 	// Assert socket is free
 	_, err = net.Listen("tcp", ":4095")
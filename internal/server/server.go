@@ -26,15 +26,22 @@ type Server struct {
 func NewServer(serverAddr string, views *handlers.ServerViews, router chi.Router) *Server {
 
 	return &Server{
-		srv:   &http.Server{Addr: serverAddr, Handler: router},
+		srv:   &http.Server{Addr: serverAddr, Handler: router, TLSConfig: views.TLS},
 		views: views,
 	}
 }
 
-// Start запускает http сервер.
+// Start запускает http сервер, с TLS если он настроен.
 func (s *Server) Start(cfg *config.Config) {
-	logger.Log.Info("Running server", zap.String("address", cfg.ServerIPAddr))
-	if err := s.srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	logger.Log.Info("Running server", zap.String("address", cfg.ServerIPAddr), zap.Bool("tls", s.srv.TLSConfig != nil))
+	var err error
+	if s.srv.TLSConfig != nil {
+		// сертификат берется из TLSConfig.GetCertificate
+		err = s.srv.ListenAndServeTLS("", "")
+	} else {
+		err = s.srv.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		logger.Log.Error("server error", zap.Error(err))
 		return
 	}
@@ -51,6 +58,9 @@ func (s *Server) HandleShutdown(ctx context.Context, wg *sync.WaitGroup, cfg *co
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
 
+	// SSE соединения бесконечны, без закрытия подписок Shutdown их не дождется
+	s.views.Service.CloseSubscriptions()
+
 	if cfg.FileStoragePath != "" {
 		if err := s.views.Service.Save(); err != nil {
 			logger.Log.Error("couldn`t finally save file after graceful shutdown", zap.Error(err))
@@ -18,7 +18,7 @@ var Serv *Server
 func init() {
 	repo := repository.NewMemStorage()
 	srv := service.NewService(&service.Settings{Retries: 1, BackoffFactor: 1}, repo)
-	views := handlers.NewServerViews(srv)
+	views := handlers.NewServerViews(srv, handlers.Config{})
 	router := views.InitRouter()
 	Serv = NewServer(":3081", &views, router)
 }
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sebasttiano/Blackbird.git/internal/logger"
+	"github.com/sebasttiano/Blackbird.git/internal/models"
+	"github.com/sebasttiano/Blackbird.git/internal/repository"
+	"go.uber.org/zap"
+)
+
+// ResetCounter обнуляет counter метрику и возвращает ее значение до сброса.
+func (s *Service) ResetCounter(ctx context.Context, metricName string) (int64, error) {
+	m := repository.CounterMetric{Name: metricName}
+	err := s.Retry(ctx, s.retries, func(ctx context.Context) error {
+		return s.repo.ResetCounter(ctx, &m)
+	})
+	if errors.Is(err, repository.ErrNoRows) {
+		return 0, Errorf(ErrNotFound, "counter metric %s not found", metricName)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to reset counter metric %w", err)
+	}
+	logger.Log.Info("counter metric reset", zap.String("name", metricName), zap.Int64("previous", m.Value))
+	return m.Value, s.syncSave()
+}
+
+// DeleteMetric удаляет метрику из хранилища.
+func (s *Service) DeleteMetric(ctx context.Context, metricName string, metricType string) error {
+	var del func(ctx context.Context) error
+	switch metricType {
+	case "gauge":
+		del = func(ctx context.Context) error {
+			return s.repo.DeleteGauge(ctx, &repository.GaugeMetric{Name: metricName})
+		}
+	case "counter":
+		del = func(ctx context.Context) error {
+			return s.repo.DeleteCounter(ctx, &repository.CounterMetric{Name: metricName})
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownMetricType, metricType)
+	}
+
+	err := s.Retry(ctx, s.retries, del)
+	if errors.Is(err, repository.ErrNoRows) {
+		return Errorf(ErrNotFound, "%s metric %s not found", metricType, metricName)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete %s metric %w", metricType, err)
+	}
+
+	s.updatedMu.Lock()
+	delete(s.updated, metricType+":"+metricName)
+	s.updatedMu.Unlock()
+	logger.Log.Info("metric deleted", zap.String("name", metricName), zap.String("type", metricType))
+	return s.syncSave()
+}
+
+// Stats возвращает статистику хранилища: тип, количество метрик и время последнего сохранения в файл.
+func (s *Service) Stats(ctx context.Context) (*models.StorageStats, error) {
+	var sm repository.StoreMetrics
+	err := s.Retry(ctx, s.retries, func(ctx context.Context) error {
+		sm = repository.StoreMetrics{}
+		return s.repo.GetAllMetrics(ctx, &sm)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metrics %w", err)
+	}
+
+	stats := &models.StorageStats{
+		Backend:  models.BackendMemory,
+		Gauges:   len(sm.Gauge),
+		Counters: len(sm.Counter),
+		Series:   len(sm.Gauge) + len(sm.Counter),
+	}
+	if _, ok := unwrapRepository(s.repo).(*repository.DBStorage); ok {
+		stats.Backend = models.BackendPostgres
+	}
+	s.snapshotMu.RLock()
+	if !s.lastSnapshot.IsZero() {
+		t := s.lastSnapshot
+		stats.LastSnapshot = &t
+	}
+	s.snapshotMu.RUnlock()
+	return stats, nil
+}
+
+// syncSave сохраняет метрики в файл после изменения, если включено синхронное сохранение.
+func (s *Service) syncSave() error {
+	if !s.Settings.SyncSave {
+		return nil
+	}
+	if err := s.Save(); err != nil {
+		logger.Log.Error("couldn`t save to the file", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// snapshotSaved запоминает время успешного сохранения метрик в файл.
+func (s *Service) snapshotSaved(t time.Time) {
+	s.snapshotMu.Lock()
+	s.lastSnapshot = t
+	s.snapshotMu.Unlock()
+}
@@ -0,0 +1,120 @@
+// Package broker рассылает подписчикам принятые обновления метрик.
+package broker
+
+import (
+	"errors"
+	"path"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sebasttiano/Blackbird.git/internal/logger"
+	"github.com/sebasttiano/Blackbird.git/internal/models"
+	"go.uber.org/zap"
+)
+
+// DefaultBuffer размер буфера подписчика по умолчанию.
+const DefaultBuffer = 100
+
+// ErrClosed ошибка, если подписка оформляется после остановки брокера.
+var ErrClosed = errors.New("broker closed")
+
+// Broker рассылает принятые обновления метрик всем подписчикам.
+// Медленным подписчикам обновления не блокируют запись: если буфер подписчика полон, событие отбрасывается.
+type Broker struct {
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	buffer int
+	closed bool
+}
+
+// NewBroker конструктор для Broker.
+func NewBroker(buffer int) *Broker {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	return &Broker{subs: make(map[*Subscription]struct{}), buffer: buffer}
+}
+
+// Subscription подписка на обновления метрик, имена которых подходят под шаблон.
+type Subscription struct {
+	ch      chan models.MetricUpdate
+	pattern string
+	dropped int64
+	broker  *Broker
+}
+
+// Updates возвращает канал с обновлениями. Канал закрывается при отписке или остановке брокера.
+func (s *Subscription) Updates() <-chan models.MetricUpdate {
+	return s.ch
+}
+
+// Dropped возвращает количество событий, отброшенных из-за переполнения буфера.
+func (s *Subscription) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// Close отписывается от брокера.
+func (s *Subscription) Close() {
+	s.broker.unsubscribe(s)
+}
+
+// Subscribe оформляет подписку. Пустой шаблон означает все метрики, синтаксис шаблона как у path.Match.
+func (b *Broker) Subscribe(pattern string) (*Subscription, error) {
+	if pattern == "" {
+		pattern = "*"
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+
+	sub := &Subscription{ch: make(chan models.MetricUpdate, b.buffer), pattern: pattern, broker: b}
+	b.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+// Publish отправляет обновление всем подходящим подписчикам без блокировки.
+func (b *Broker) Publish(update models.MetricUpdate) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subs {
+		if ok, _ := path.Match(sub.pattern, update.ID); !ok {
+			continue
+		}
+		select {
+		case sub.ch <- update:
+		default:
+			dropped := atomic.AddInt64(&sub.dropped, 1)
+			logger.Log.Debug("slow subscriber, update dropped", zap.String("metric", update.ID), zap.Int64("dropped", dropped))
+		}
+	}
+}
+
+// Close закрывает все подписки и запрещает новые.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subs {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// unsubscribe удаляет подписку и закрывает ее канал.
+func (b *Broker) unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
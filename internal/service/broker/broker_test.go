@@ -0,0 +1,99 @@
+package broker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sebasttiano/Blackbird.git/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newUpdate(id string, value float64) models.MetricUpdate {
+	return models.MetricUpdate{Metrics: models.Metrics{ID: id, MType: "gauge", Value: &value}, Time: time.Now()}
+}
+
+func TestBroker_SubscribeMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		publish []string
+		want    []string
+	}{
+		{name: "all metrics", pattern: "", publish: []string{"HeapAlloc", "PollCount"}, want: []string{"HeapAlloc", "PollCount"}},
+		{name: "prefix match", pattern: "Heap*", publish: []string{"HeapAlloc", "PollCount", "HeapSys"}, want: []string{"HeapAlloc", "HeapSys"}},
+		{name: "exact match", pattern: "PollCount", publish: []string{"HeapAlloc", "PollCount"}, want: []string{"PollCount"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBroker(10)
+			sub, err := b.Subscribe(tt.pattern)
+			require.NoError(t, err)
+			defer sub.Close()
+
+			for _, id := range tt.publish {
+				b.Publish(newUpdate(id, 1))
+			}
+
+			got := make([]string, 0, len(tt.want))
+			for len(got) < len(tt.want) {
+				got = append(got, (<-sub.Updates()).ID)
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Empty(t, sub.Updates())
+		})
+	}
+}
+
+func TestBroker_SubscribeBadPattern(t *testing.T) {
+	b := NewBroker(0)
+	_, err := b.Subscribe("Heap[")
+	assert.Error(t, err)
+}
+
+func TestBroker_SlowConsumer(t *testing.T) {
+	b := NewBroker(2)
+	slow, err := b.Subscribe("*")
+	require.NoError(t, err)
+	fast, err := b.Subscribe("*")
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	received := 0
+	go func() {
+		for range fast.Updates() {
+			received++
+		}
+		close(done)
+	}()
+
+	for i := 0; i < 5; i++ {
+		b.Publish(newUpdate("HeapAlloc", float64(i)))
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Len(t, slow.Updates(), 2)
+	assert.Equal(t, int64(3), slow.Dropped())
+
+	b.Close()
+	<-done
+	assert.Equal(t, 5, received)
+	assert.Equal(t, int64(0), fast.Dropped())
+}
+
+func TestBroker_Close(t *testing.T) {
+	b := NewBroker(1)
+	sub, err := b.Subscribe("*")
+	require.NoError(t, err)
+
+	b.Close()
+	_, ok := <-sub.Updates()
+	assert.False(t, ok)
+
+	// повторная отписка после закрытия брокера безопасна
+	sub.Close()
+
+	_, err = b.Subscribe("*")
+	assert.ErrorIs(t, err, ErrClosed)
+}
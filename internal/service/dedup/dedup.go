@@ -0,0 +1,161 @@
+// Package dedup хранит окно идентификаторов уже принятых пакетов метрик,
+// чтобы повторная доставка того же пакета не применялась дважды.
+package dedup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sebasttiano/Blackbird.git/internal/logger"
+	"github.com/sebasttiano/Blackbird.git/internal/repository"
+	"go.uber.org/zap"
+)
+
+// DefaultSize размер окна по умолчанию.
+const DefaultSize = 10000
+
+// ErrEmptyBatchID ошибка, если идентификатор пакета не передан.
+var ErrEmptyBatchID = errors.New("batch id is empty")
+
+// ErrInFlight ошибка, если пакет с тем же идентификатором сейчас применяется. Повтор надо отправить
+// позже: первая попытка еще может не записаться.
+var ErrInFlight = errors.New("batch with this id is being applied, retry later")
+
+// WriteFunc записывает пакет. Возвращает true, если пакет применен и его идентификатор надо запомнить.
+type WriteFunc func(ctx context.Context) (bool, error)
+
+// MemoryWindow окно фиксированного размера в памяти, при переполнении вытесняются самые старые идентификаторы.
+type MemoryWindow struct {
+	mu sync.Mutex
+	// seen принятые пакеты: true у примененных, false у тех, что сейчас пишутся
+	seen map[string]bool
+	ring []string
+	next int
+}
+
+// NewMemoryWindow конструктор для MemoryWindow.
+func NewMemoryWindow(size int) *MemoryWindow {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	return &MemoryWindow{seen: make(map[string]bool, size), ring: make([]string, size)}
+}
+
+// Apply вызывает write, если пакет batchID еще не принят, и запоминает идентификатор, если write
+// его применил. Возвращает false, если пакет уже применен, и ErrInFlight, если он сейчас пишется.
+func (w *MemoryWindow) Apply(ctx context.Context, batchID string, write WriteFunc) (bool, error) {
+	if batchID == "" {
+		return false, ErrEmptyBatchID
+	}
+	w.mu.Lock()
+	if applied, ok := w.seen[batchID]; ok {
+		w.mu.Unlock()
+		if applied {
+			return false, nil
+		}
+		return false, ErrInFlight
+	}
+	w.seen[batchID] = false
+	w.mu.Unlock()
+
+	keep, err := write(ctx)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !keep {
+		// пакет не применен, повтор должен быть принят
+		delete(w.seen, batchID)
+		return true, err
+	}
+	if old := w.ring[w.next]; old != "" {
+		delete(w.seen, old)
+	}
+	w.ring[w.next] = batchID
+	w.next = (w.next + 1) % len(w.ring)
+	w.seen[batchID] = true
+	return true, err
+}
+
+// DBWindow окно в Postgres, переживает перезапуск сервера. Идентификатор пакета вставляется в той же
+// транзакции, что и метрики, поэтому после сбоя посередине повтор применяется заново. Повтор,
+// пришедший во время первой попытки, ждет ее конца на уникальном ключе.
+type DBWindow struct {
+	conn *sqlx.DB
+	size int
+}
+
+// NewDBWindow конструктор для DBWindow, при bootstrap создает таблицу окна.
+func NewDBWindow(conn *sqlx.DB, size int, bootstrap bool) (*DBWindow, error) {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	w := &DBWindow{conn: conn, size: size}
+	if bootstrap {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		if err := w.Bootstrap(ctx); err != nil {
+			logger.Log.Error("dedup window bootstrap failed", zap.Error(err))
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// Apply вызывает write в транзакции, в которой запомнен идентификатор пакета, и фиксирует ее,
+// если write применил пакет. Возвращает false, если пакет уже применен. Идентификаторы старше
+// размера окна удаляются.
+func (w *DBWindow) Apply(ctx context.Context, batchID string, write WriteFunc) (bool, error) {
+	if batchID == "" {
+		return false, ErrEmptyBatchID
+	}
+	tx, err := w.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+
+	var id int64
+	sqlInsert := `INSERT INTO batch_dedup (batch_id) VALUES ($1)
+                      ON CONFLICT (batch_id) DO NOTHING
+                      RETURNING id`
+	if err := tx.GetContext(ctx, &id, sqlInsert, batchID); err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	keep, err := write(repository.WithTx(ctx, tx))
+	if !keep {
+		tx.Rollback()
+		return true, err
+	}
+	if errCommit := tx.Commit(); errCommit != nil {
+		return true, fmt.Errorf("failed to commit batch %s: %w", batchID, errCommit)
+	}
+
+	sqlDelete := `DELETE FROM batch_dedup WHERE id <= $1`
+	if _, errTrim := w.conn.ExecContext(ctx, sqlDelete, id-int64(w.size)); errTrim != nil {
+		logger.Log.Error("failed to trim dedup window", zap.Error(errTrim))
+	}
+	return true, err
+}
+
+// Bootstrap создает, если надо, таблицу окна.
+func (w *DBWindow) Bootstrap(ctx context.Context) error {
+	_, err := w.conn.ExecContext(ctx, `
+	   CREATE TABLE IF NOT EXISTS batch_dedup (
+	       id bigserial PRIMARY KEY,
+	       batch_id varchar(256),
+	       received_at timestamptz DEFAULT now(),
+	       UNIQUE(batch_id)
+	   )
+	`)
+	return err
+}
@@ -0,0 +1,171 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sebasttiano/Blackbird.git/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sqlxmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+func applied(context.Context) (bool, error) { return true, nil }
+
+func TestMemoryWindow(t *testing.T) {
+	ctx := context.Background()
+	w := NewMemoryWindow(2)
+
+	fresh, err := w.Apply(ctx, "agent:1", applied)
+	require.NoError(t, err)
+	assert.True(t, fresh)
+
+	fresh, err = w.Apply(ctx, "agent:1", applied)
+	require.NoError(t, err)
+	assert.False(t, fresh, "повтор должен быть распознан")
+
+	// непримененный пакет не запоминается
+	errWrite := errors.New("storage is down")
+	fresh, err = w.Apply(ctx, "agent:2", func(context.Context) (bool, error) { return false, errWrite })
+	assert.True(t, fresh)
+	assert.ErrorIs(t, err, errWrite)
+	fresh, _ = w.Apply(ctx, "agent:2", applied)
+	assert.True(t, fresh)
+
+	// окно из двух элементов вытесняет самый старый
+	w.Apply(ctx, "agent:3", applied)
+	fresh, _ = w.Apply(ctx, "agent:1", applied)
+	assert.True(t, fresh)
+	assert.Len(t, w.seen, 2)
+
+	_, err = w.Apply(ctx, "", applied)
+	assert.ErrorIs(t, err, ErrEmptyBatchID)
+}
+
+func TestMemoryWindow_InFlight(t *testing.T) {
+	ctx := context.Background()
+	w := NewMemoryWindow(10)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := w.Apply(ctx, "agent:1", func(context.Context) (bool, error) {
+			close(started)
+			<-release
+			return true, nil
+		})
+		done <- err
+	}()
+	<-started
+
+	// пока первая попытка пишется, повтор не подтверждается
+	fresh, err := w.Apply(ctx, "agent:1", applied)
+	assert.False(t, fresh)
+	assert.ErrorIs(t, err, ErrInFlight)
+
+	close(release)
+	require.NoError(t, <-done)
+	fresh, err = w.Apply(ctx, "agent:1", applied)
+	require.NoError(t, err)
+	assert.False(t, fresh)
+}
+
+func TestDBWindow_Apply(t *testing.T) {
+	db, mock, err := sqlxmock.Newx()
+	require.NoError(t, err)
+	defer db.Close()
+
+	w, err := NewDBWindow(db, 100, false)
+	require.NoError(t, err)
+	repo, err := repository.NewDBStorage(db, false)
+	require.NoError(t, err)
+	value := 1.5
+
+	tests := []struct {
+		name      string
+		mock      func()
+		write     WriteFunc
+		want      bool
+		wantErr   bool
+		wantWrite bool
+	}{
+		{
+			name: "new batch",
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery("INSERT INTO batch_dedup").WithArgs("agent:1").
+					WillReturnRows(sqlxmock.NewRows([]string{"id"}).AddRow(150))
+				mock.ExpectExec("INSERT INTO gauge_metrics").WithArgs("g", value).
+					WillReturnResult(sqlxmock.NewResult(1, 1))
+				mock.ExpectCommit()
+				mock.ExpectExec("DELETE FROM batch_dedup").WithArgs(int64(50)).
+					WillReturnResult(sqlxmock.NewResult(0, 3))
+			},
+			write: func(ctx context.Context) (bool, error) {
+				return true, repo.SetGauge(ctx, &repository.GaugeMetric{Name: "g", Value: value})
+			},
+			want:      true,
+			wantWrite: true,
+		},
+		{
+			name: "replay",
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery("INSERT INTO batch_dedup").WithArgs("agent:1").
+					WillReturnRows(sqlxmock.NewRows([]string{"id"}))
+				mock.ExpectRollback()
+			},
+			write: applied,
+			want:  false,
+		},
+		{
+			name: "write failed",
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery("INSERT INTO batch_dedup").WithArgs("agent:1").
+					WillReturnRows(sqlxmock.NewRows([]string{"id"}).AddRow(151))
+				mock.ExpectExec("INSERT INTO gauge_metrics").WithArgs("g", value).
+					WillReturnError(errors.New("disk full"))
+				mock.ExpectRollback()
+			},
+			write: func(ctx context.Context) (bool, error) {
+				err := repo.SetGauge(ctx, &repository.GaugeMetric{Name: "g", Value: value})
+				return err == nil, err
+			},
+			want:      true,
+			wantErr:   true,
+			wantWrite: true,
+		},
+		{
+			name: "db error",
+			mock: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery("INSERT INTO batch_dedup").WithArgs("agent:1").
+					WillReturnError(errors.New("connection refused"))
+				mock.ExpectRollback()
+			},
+			write:   applied,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock()
+			var called bool
+			got, err := w.Apply(context.Background(), "agent:1", func(ctx context.Context) (bool, error) {
+				called = true
+				return tt.write(ctx)
+			})
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantWrite, called)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sebasttiano/Blackbird.git/internal/repository"
+	"github.com/sebasttiano/Blackbird.git/internal/service/broker"
+)
+
+// Виды ошибок сервиса, по ним транспорт выбирает код ответа. Проверяются через errors.Is.
+var (
+	// ErrNotFound запрошенной метрики нет в хранилище.
+	ErrNotFound = errors.New("not found")
+	// ErrInvalidArgument запрос некорректен, повтор без изменений не поможет.
+	ErrInvalidArgument = errors.New("invalid argument")
+	// ErrUnavailable хранилище или сервер временно недоступны, запрос можно повторить.
+	ErrUnavailable = errors.New("unavailable")
+	// ErrConflict запрос противоречит состоянию сервера.
+	ErrConflict = errors.New("conflict")
+	// ErrPermissionDenied клиенту запрещено выполнять запрос.
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrUnauthenticated клиент не передал или передал неверные учетные данные.
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrFailedPrecondition состояние сервера не позволяет выполнить запрос, например хранилище не поддерживает действие.
+	ErrFailedPrecondition = errors.New("failed precondition")
+)
+
+// Error ошибка сервиса с видом. Сообщение берется из исходной ошибки,
+// errors.Is находит и вид, и исходную ошибку.
+type Error struct {
+	Kind error
+	Err  error
+}
+
+// Error метод интерфейса возвращает сообщение исходной ошибки.
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap метод интерфейса возвращает вид и исходную ошибку.
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// NewError оборачивает ошибку в Error с видом kind.
+func NewError(kind error, err error) *Error {
+	return &Error{Kind: kind, Err: err}
+}
+
+// Errorf создает Error с видом kind и форматированным сообщением, %w поддерживается.
+func Errorf(kind error, format string, args ...any) *Error {
+	return NewError(kind, fmt.Errorf(format, args...))
+}
+
+// KindOf возвращает вид ошибки. Известные ошибки пакетов без вида классифицируются здесь же,
+// все остальные считаются внутренними и дают nil.
+func KindOf(err error) error {
+	for _, kind := range []error{ErrNotFound, ErrInvalidArgument, ErrUnavailable, ErrConflict, ErrPermissionDenied, ErrUnauthenticated, ErrFailedPrecondition} {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+	var retryErr *RetryDBError
+	switch {
+	case errors.Is(err, ErrUnknownMetricType), errors.Is(err, ErrBatchRejected):
+		return ErrInvalidArgument
+	case errors.Is(err, ErrReservedName):
+		return ErrConflict
+	case errors.Is(err, ErrNotSupported):
+		return ErrFailedPrecondition
+	case errors.Is(err, repository.ErrNoRows):
+		return ErrNotFound
+	case errors.Is(err, broker.ErrClosed), errors.Is(err, context.DeadlineExceeded), errors.As(err, &retryErr):
+		return ErrUnavailable
+	}
+	return nil
+}
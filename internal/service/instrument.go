@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/sebasttiano/Blackbird.git/internal/repository"
+	"github.com/sebasttiano/Blackbird.git/internal/selfmetrics"
+)
+
+// instrumentedRepository считает обращения к хранилищу и их длительность.
+type instrumentedRepository struct {
+	Repository
+	metrics *selfmetrics.Metrics
+}
+
+// GetGauge учитывает чтение gauge метрики.
+func (r *instrumentedRepository) GetGauge(ctx context.Context, metric *repository.GaugeMetric) error {
+	start := time.Now()
+	err := r.Repository.GetGauge(ctx, metric)
+	r.metrics.ObserveStorage("get_gauge", start, err)
+	return err
+}
+
+// GetCounter учитывает чтение counter метрики.
+func (r *instrumentedRepository) GetCounter(ctx context.Context, metric *repository.CounterMetric) error {
+	start := time.Now()
+	err := r.Repository.GetCounter(ctx, metric)
+	r.metrics.ObserveStorage("get_counter", start, err)
+	return err
+}
+
+// SetGauge учитывает запись gauge метрики.
+func (r *instrumentedRepository) SetGauge(ctx context.Context, metric *repository.GaugeMetric) error {
+	start := time.Now()
+	err := r.Repository.SetGauge(ctx, metric)
+	r.metrics.ObserveStorage("set_gauge", start, err)
+	return err
+}
+
+// SetCounter учитывает запись counter метрики.
+func (r *instrumentedRepository) SetCounter(ctx context.Context, metric *repository.CounterMetric) error {
+	start := time.Now()
+	err := r.Repository.SetCounter(ctx, metric)
+	r.metrics.ObserveStorage("set_counter", start, err)
+	return err
+}
+
+// SetMetrics учитывает запись пачки метрик.
+func (r *instrumentedRepository) SetMetrics(ctx context.Context, gauges []repository.GaugeMetric, counters []repository.CounterMetric) error {
+	start := time.Now()
+	err := r.Repository.SetMetrics(ctx, gauges, counters)
+	r.metrics.ObserveStorage("set_metrics", start, err)
+	return err
+}
+
+// GetAllMetrics учитывает чтение всех метрик.
+func (r *instrumentedRepository) GetAllMetrics(ctx context.Context, s *repository.StoreMetrics) error {
+	start := time.Now()
+	err := r.Repository.GetAllMetrics(ctx, s)
+	r.metrics.ObserveStorage("get_all", start, err)
+	return err
+}
+
+// DeleteGauge учитывает удаление gauge метрики.
+func (r *instrumentedRepository) DeleteGauge(ctx context.Context, metric *repository.GaugeMetric) error {
+	start := time.Now()
+	err := r.Repository.DeleteGauge(ctx, metric)
+	r.metrics.ObserveStorage("delete_gauge", start, err)
+	return err
+}
+
+// DeleteCounter учитывает удаление counter метрики.
+func (r *instrumentedRepository) DeleteCounter(ctx context.Context, metric *repository.CounterMetric) error {
+	start := time.Now()
+	err := r.Repository.DeleteCounter(ctx, metric)
+	r.metrics.ObserveStorage("delete_counter", start, err)
+	return err
+}
+
+// GetMetrics учитывает чтение нескольких метрик.
+func (r *instrumentedRepository) GetMetrics(ctx context.Context, gauges []string, counters []string, sm *repository.StoreMetrics) error {
+	start := time.Now()
+	err := r.Repository.GetMetrics(ctx, gauges, counters, sm)
+	r.metrics.ObserveStorage("get_metrics", start, err)
+	return err
+}
+
+// ResetCounter учитывает сброс counter метрики.
+func (r *instrumentedRepository) ResetCounter(ctx context.Context, metric *repository.CounterMetric) error {
+	start := time.Now()
+	err := r.Repository.ResetCounter(ctx, metric)
+	r.metrics.ObserveStorage("reset_counter", start, err)
+	return err
+}
+
+// unwrapRepository возвращает хранилище без обертки, чтобы проверять его тип.
+func unwrapRepository(repo Repository) Repository {
+	if r, ok := repo.(*instrumentedRepository); ok {
+		return r.Repository
+	}
+	return repo
+}
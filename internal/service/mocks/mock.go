@@ -11,6 +11,8 @@ import (
 	gomock "github.com/golang/mock/gomock"
 	models "github.com/sebasttiano/Blackbird.git/internal/models"
 	repository "github.com/sebasttiano/Blackbird.git/internal/repository"
+	broker "github.com/sebasttiano/Blackbird.git/internal/service/broker"
+	dedup "github.com/sebasttiano/Blackbird.git/internal/service/dedup"
 )
 
 // MockMetricService is a mock of MetricService interface.
@@ -64,6 +66,21 @@ func (mr *MockMetricServiceMockRecorder) GetModelValue(ctx, metric interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetModelValue", reflect.TypeOf((*MockMetricService)(nil).GetModelValue), ctx, metric)
 }
 
+// GetModelValues mocks base method.
+func (m *MockMetricService) GetModelValues(ctx context.Context, metrics []*models.Metrics) ([]models.MetricValue, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetModelValues", ctx, metrics)
+	ret0, _ := ret[0].([]models.MetricValue)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetModelValues indicates an expected call of GetModelValues.
+func (mr *MockMetricServiceMockRecorder) GetModelValues(ctx, metrics interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetModelValues", reflect.TypeOf((*MockMetricService)(nil).GetModelValues), ctx, metrics)
+}
+
 // GetValue mocks base method.
 func (m *MockMetricService) GetValue(ctx context.Context, string, metricType string) (interface{}, error) {
 	m.ctrl.T.Helper()
@@ -121,6 +138,21 @@ func (mr *MockMetricServiceMockRecorder) SetModelValue(ctx, metrics interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetModelValue", reflect.TypeOf((*MockMetricService)(nil).SetModelValue), ctx, metrics)
 }
 
+// SetModelValueBatch mocks base method.
+func (m *MockMetricService) SetModelValueBatch(ctx context.Context, batchID string, metrics []*models.Metrics, atomic bool) (*models.BatchResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetModelValueBatch", ctx, batchID, metrics, atomic)
+	ret0, _ := ret[0].(*models.BatchResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetModelValueBatch indicates an expected call of SetModelValueBatch.
+func (mr *MockMetricServiceMockRecorder) SetModelValueBatch(ctx, batchID, metrics, atomic interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetModelValueBatch", reflect.TypeOf((*MockMetricService)(nil).SetModelValueBatch), ctx, batchID, metrics, atomic)
+}
+
 // SetValue mocks base method.
 func (m *MockMetricService) SetValue(ctx context.Context, metricName, metricType, metricValue string) error {
 	m.ctrl.T.Helper()
@@ -135,6 +167,116 @@ func (mr *MockMetricServiceMockRecorder) SetValue(ctx, metricName, metricType, m
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetValue", reflect.TypeOf((*MockMetricService)(nil).SetValue), ctx, metricName, metricType, metricValue)
 }
 
+// Subscribe mocks base method.
+func (m *MockMetricService) Subscribe(pattern string) (*broker.Subscription, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Subscribe", pattern)
+	ret0, _ := ret[0].(*broker.Subscription)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Subscribe indicates an expected call of Subscribe.
+func (mr *MockMetricServiceMockRecorder) Subscribe(pattern interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Subscribe", reflect.TypeOf((*MockMetricService)(nil).Subscribe), pattern)
+}
+
+// MockAdminService is a mock of AdminService interface.
+type MockAdminService struct {
+	ctrl     *gomock.Controller
+	recorder *MockAdminServiceMockRecorder
+}
+
+// MockAdminServiceMockRecorder is the mock recorder for MockAdminService.
+type MockAdminServiceMockRecorder struct {
+	mock *MockAdminService
+}
+
+// NewMockAdminService creates a new mock instance.
+func NewMockAdminService(ctrl *gomock.Controller) *MockAdminService {
+	mock := &MockAdminService{ctrl: ctrl}
+	mock.recorder = &MockAdminServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAdminService) EXPECT() *MockAdminServiceMockRecorder {
+	return m.recorder
+}
+
+// DeleteMetric mocks base method.
+func (m *MockAdminService) DeleteMetric(ctx context.Context, metricName, metricType string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteMetric", ctx, metricName, metricType)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteMetric indicates an expected call of DeleteMetric.
+func (mr *MockAdminServiceMockRecorder) DeleteMetric(ctx, metricName, metricType interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMetric", reflect.TypeOf((*MockAdminService)(nil).DeleteMetric), ctx, metricName, metricType)
+}
+
+// ResetCounter mocks base method.
+func (m *MockAdminService) ResetCounter(ctx context.Context, metricName string) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetCounter", ctx, metricName)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ResetCounter indicates an expected call of ResetCounter.
+func (mr *MockAdminServiceMockRecorder) ResetCounter(ctx, metricName interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetCounter", reflect.TypeOf((*MockAdminService)(nil).ResetCounter), ctx, metricName)
+}
+
+// Restore mocks base method.
+func (m *MockAdminService) Restore() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Restore")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Restore indicates an expected call of Restore.
+func (mr *MockAdminServiceMockRecorder) Restore() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Restore", reflect.TypeOf((*MockAdminService)(nil).Restore))
+}
+
+// Save mocks base method.
+func (m *MockAdminService) Save() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Save")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Save indicates an expected call of Save.
+func (mr *MockAdminServiceMockRecorder) Save() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Save", reflect.TypeOf((*MockAdminService)(nil).Save))
+}
+
+// Stats mocks base method.
+func (m *MockAdminService) Stats(ctx context.Context) (*models.StorageStats, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stats", ctx)
+	ret0, _ := ret[0].(*models.StorageStats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Stats indicates an expected call of Stats.
+func (mr *MockAdminServiceMockRecorder) Stats(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stats", reflect.TypeOf((*MockAdminService)(nil).Stats), ctx)
+}
+
 // MockRepository is a mock of Repository interface.
 type MockRepository struct {
 	ctrl     *gomock.Controller
@@ -158,6 +300,34 @@ func (m *MockRepository) EXPECT() *MockRepositoryMockRecorder {
 	return m.recorder
 }
 
+// DeleteCounter mocks base method.
+func (m *MockRepository) DeleteCounter(ctx context.Context, metric *repository.CounterMetric) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteCounter", ctx, metric)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteCounter indicates an expected call of DeleteCounter.
+func (mr *MockRepositoryMockRecorder) DeleteCounter(ctx, metric interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteCounter", reflect.TypeOf((*MockRepository)(nil).DeleteCounter), ctx, metric)
+}
+
+// DeleteGauge mocks base method.
+func (m *MockRepository) DeleteGauge(ctx context.Context, metric *repository.GaugeMetric) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteGauge", ctx, metric)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteGauge indicates an expected call of DeleteGauge.
+func (mr *MockRepositoryMockRecorder) DeleteGauge(ctx, metric interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteGauge", reflect.TypeOf((*MockRepository)(nil).DeleteGauge), ctx, metric)
+}
+
 // GetAllMetrics mocks base method.
 func (m *MockRepository) GetAllMetrics(ctx context.Context, s *repository.StoreMetrics) error {
 	m.ctrl.T.Helper()
@@ -200,6 +370,34 @@ func (mr *MockRepositoryMockRecorder) GetGauge(ctx, metric interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetGauge", reflect.TypeOf((*MockRepository)(nil).GetGauge), ctx, metric)
 }
 
+// GetMetrics mocks base method.
+func (m *MockRepository) GetMetrics(ctx context.Context, gauges, counters []string, s *repository.StoreMetrics) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMetrics", ctx, gauges, counters, s)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// GetMetrics indicates an expected call of GetMetrics.
+func (mr *MockRepositoryMockRecorder) GetMetrics(ctx, gauges, counters, s interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetrics", reflect.TypeOf((*MockRepository)(nil).GetMetrics), ctx, gauges, counters, s)
+}
+
+// ResetCounter mocks base method.
+func (m *MockRepository) ResetCounter(ctx context.Context, metric *repository.CounterMetric) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetCounter", ctx, metric)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResetCounter indicates an expected call of ResetCounter.
+func (mr *MockRepositoryMockRecorder) ResetCounter(ctx, metric interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetCounter", reflect.TypeOf((*MockRepository)(nil).ResetCounter), ctx, metric)
+}
+
 // RestoreAllMetrics mocks base method.
 func (m *MockRepository) RestoreAllMetrics(gauges map[string]float64, counters map[string]int64) {
 	m.ctrl.T.Helper()
@@ -239,3 +437,55 @@ func (mr *MockRepositoryMockRecorder) SetGauge(ctx, metric interface{}) *gomock.
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetGauge", reflect.TypeOf((*MockRepository)(nil).SetGauge), ctx, metric)
 }
+
+// SetMetrics mocks base method.
+func (m *MockRepository) SetMetrics(ctx context.Context, gauges []repository.GaugeMetric, counters []repository.CounterMetric) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetMetrics", ctx, gauges, counters)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetMetrics indicates an expected call of SetMetrics.
+func (mr *MockRepositoryMockRecorder) SetMetrics(ctx, gauges, counters interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetMetrics", reflect.TypeOf((*MockRepository)(nil).SetMetrics), ctx, gauges, counters)
+}
+
+// MockDeduplicator is a mock of Deduplicator interface.
+type MockDeduplicator struct {
+	ctrl     *gomock.Controller
+	recorder *MockDeduplicatorMockRecorder
+}
+
+// MockDeduplicatorMockRecorder is the mock recorder for MockDeduplicator.
+type MockDeduplicatorMockRecorder struct {
+	mock *MockDeduplicator
+}
+
+// NewMockDeduplicator creates a new mock instance.
+func NewMockDeduplicator(ctrl *gomock.Controller) *MockDeduplicator {
+	mock := &MockDeduplicator{ctrl: ctrl}
+	mock.recorder = &MockDeduplicatorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockDeduplicator) EXPECT() *MockDeduplicatorMockRecorder {
+	return m.recorder
+}
+
+// Apply mocks base method.
+func (m *MockDeduplicator) Apply(ctx context.Context, batchID string, write dedup.WriteFunc) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Apply", ctx, batchID, write)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Apply indicates an expected call of Apply.
+func (mr *MockDeduplicatorMockRecorder) Apply(ctx, batchID, write interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Apply", reflect.TypeOf((*MockDeduplicator)(nil).Apply), ctx, batchID, write)
+}
@@ -5,14 +5,19 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"net"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/sebasttiano/Blackbird.git/internal/audit"
 	"github.com/sebasttiano/Blackbird.git/internal/logger"
 	"github.com/sebasttiano/Blackbird.git/internal/models"
 	"github.com/sebasttiano/Blackbird.git/internal/repository"
+	"github.com/sebasttiano/Blackbird.git/internal/selfmetrics"
+	"github.com/sebasttiano/Blackbird.git/internal/service/broker"
+	"github.com/sebasttiano/Blackbird.git/internal/service/dedup"
 	"go.uber.org/zap"
 )
 
@@ -22,6 +27,15 @@ import (
 var ErrNotSupported = errors.New("service not supported")
 var ErrUnknownMetricType = errors.New("unknown metric type. only gauge and counter are available")
 
+// ErrBatchRejected ошибка, если в режиме "все или ничего" пакет метрик отклонен целиком.
+var ErrBatchRejected = errors.New("batch rejected")
+
+// MaxBulkRead сколько метрик можно прочитать одним запросом.
+const MaxBulkRead = 1000
+
+// ErrReservedName ошибка, если имя метрики начинается с префикса, зарезервированного под метрики сервера.
+var ErrReservedName = errors.New("metric name uses reserved prefix")
+
 // RetryDBError тип реализующий интерфейс Error, записывает количество ретраев и заворачивает ошибку ф-ция.
 type RetryDBError struct {
 	Retries int
@@ -52,7 +66,19 @@ type Settings struct {
 	SaveFilePath  string
 	Retries       uint
 	BackoffFactor uint
-	TrustedSubnet *net.IPNet
+	// SubscriptionBuffer размер буфера каждого подписчика на обновления метрик.
+	SubscriptionBuffer int
+	// Auditor журнал изменений метрик, nil отключает аудит.
+	Auditor *audit.Auditor
+	// DedupWindow сколько последних идентификаторов пакетов помнить, отрицательное значение отключает проверку.
+	DedupWindow int
+	// Dedup окно принятых пакетов для защиты от повторной доставки, nil отключает проверку.
+	Dedup Deduplicator
+	// SelfMetrics метрики работы сервера, nil создает новый набор.
+	SelfMetrics *selfmetrics.Metrics
+	// SelfMetricsPrefix префикс, под которым метрики сервера пишутся в хранилище. Метрики клиентов
+	// с этим префиксом отклоняются, пустое значение отключает резервирование.
+	SelfMetricsPrefix string
 }
 
 // Service реализует интерфейс MetricService.
@@ -61,6 +87,11 @@ type Service struct {
 	fileRestorer FileService
 	repo         Repository
 	retries      []uint
+	broker       *broker.Broker
+	updatedMu    sync.RWMutex
+	updated      map[string]time.Time
+	snapshotMu   sync.RWMutex
+	lastSnapshot time.Time
 }
 
 // NewService конструктор для Service.
@@ -69,16 +100,38 @@ func NewService(serviceSettings *Settings, repo Repository) *Service {
 	for i := 1; i <= int(serviceSettings.Retries); i++ {
 		ri = append(ri, serviceSettings.BackoffFactor*uint(i)-1)
 	}
-	return &Service{serviceSettings, NewFileHanlder(serviceSettings.SaveFilePath), repo, ri}
+	if serviceSettings.SelfMetrics == nil {
+		serviceSettings.SelfMetrics = selfmetrics.New()
+	}
+	return &Service{
+		Settings:     serviceSettings,
+		fileRestorer: NewFileHanlder(serviceSettings.SaveFilePath),
+		repo:         &instrumentedRepository{Repository: repo, metrics: serviceSettings.SelfMetrics},
+		retries:      ri,
+		broker:       broker.NewBroker(serviceSettings.SubscriptionBuffer),
+		updated:      make(map[string]time.Time),
+	}
 }
 
 // MetricService интерфейс описывающий работу с метриками
 type MetricService interface {
 	GetValue(ctx context.Context, string, metricType string) (interface{}, error)
 	GetModelValue(ctx context.Context, metric *models.Metrics) error
+	GetModelValues(ctx context.Context, metrics []*models.Metrics) ([]models.MetricValue, error)
 	SetValue(ctx context.Context, metricName string, metricType string, metricValue string) error
 	SetModelValue(ctx context.Context, metrics []*models.Metrics) error
+	SetModelValueBatch(ctx context.Context, batchID string, metrics []*models.Metrics, atomic bool) (*models.BatchResult, error)
 	GetAllValues(ctx context.Context) *repository.StoreMetrics
+	Subscribe(pattern string) (*broker.Subscription, error)
+	Save() error
+	Restore() error
+}
+
+// AdminService интерфейс описывающий операции администратора над хранилищем.
+type AdminService interface {
+	ResetCounter(ctx context.Context, metricName string) (int64, error)
+	DeleteMetric(ctx context.Context, metricName string, metricType string) error
+	Stats(ctx context.Context) (*models.StorageStats, error)
 	Save() error
 	Restore() error
 }
@@ -89,10 +142,22 @@ type Repository interface {
 	GetCounter(ctx context.Context, metric *repository.CounterMetric) error
 	SetGauge(ctx context.Context, metric *repository.GaugeMetric) error
 	SetCounter(ctx context.Context, metric *repository.CounterMetric) error
+	SetMetrics(ctx context.Context, gauges []repository.GaugeMetric, counters []repository.CounterMetric) error
 	GetAllMetrics(ctx context.Context, s *repository.StoreMetrics) error
+	GetMetrics(ctx context.Context, gauges []string, counters []string, s *repository.StoreMetrics) error
+	DeleteGauge(ctx context.Context, metric *repository.GaugeMetric) error
+	DeleteCounter(ctx context.Context, metric *repository.CounterMetric) error
+	ResetCounter(ctx context.Context, metric *repository.CounterMetric) error
 	RestoreAllMetrics(gauges map[string]float64, counters map[string]int64)
 }
 
+// Deduplicator интерфейс описывающий окно идентификаторов уже принятых пакетов метрик.
+// Apply вызывает write, только если пакет еще не принят, и запоминает идентификатор, если write вернул true.
+// Возвращает false для уже принятого пакета.
+type Deduplicator interface {
+	Apply(ctx context.Context, batchID string, write dedup.WriteFunc) (bool, error)
+}
+
 // GetValue возвращает или Gauge, или Counter метрики.
 func (s *Service) GetValue(ctx context.Context, metricName string, metricType string) (interface{}, error) {
 	switch metricType {
@@ -103,7 +168,10 @@ func (s *Service) GetValue(ctx context.Context, metricName string, metricType st
 			return s.repo.GetGauge(ctx, &m)
 		},
 		)
-		if err != nil && !errors.Is(repository.ErrNoRows, err) {
+		if errors.Is(err, repository.ErrNoRows) {
+			return nil, Errorf(ErrNotFound, "gauge metric %s not found", metricName)
+		}
+		if err != nil {
 			return nil, fmt.Errorf("failed to load gauge metric %w", err)
 		}
 		return m.Value, nil
@@ -114,8 +182,11 @@ func (s *Service) GetValue(ctx context.Context, metricName string, metricType st
 			return s.repo.GetCounter(ctx, &m)
 		},
 		)
-		if err != nil && !errors.Is(repository.ErrNoRows, err) {
-			return nil, fmt.Errorf("failed to load gauge metric %w", err)
+		if errors.Is(err, repository.ErrNoRows) {
+			return nil, Errorf(ErrNotFound, "counter metric %s not found", metricName)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load counter metric %w", err)
 		}
 		return m.Value, nil
 	default:
@@ -126,7 +197,7 @@ func (s *Service) GetValue(ctx context.Context, metricName string, metricType st
 // GetModelValue маппит данные из хранилища в структуру.
 func (s *Service) GetModelValue(ctx context.Context, metric *models.Metrics) error {
 	if metric.ID == "" {
-		return errors.New("name of the metric is required")
+		return Errorf(ErrInvalidArgument, "name of the metric is required")
 	}
 
 	value, err := s.GetValue(ctx, metric.ID, metric.MType)
@@ -145,13 +216,78 @@ func (s *Service) GetModelValue(ctx context.Context, metric *models.Metrics) err
 	return nil
 }
 
+// GetModelValues читает несколько метрик одним обращением к хранилищу. Результаты идут в порядке запроса,
+// ненайденные метрики отмечаются статусом missing, некорректные запросы метрик статусом rejected.
+func (s *Service) GetModelValues(ctx context.Context, metrics []*models.Metrics) ([]models.MetricValue, error) {
+	if len(metrics) > MaxBulkRead {
+		return nil, Errorf(ErrInvalidArgument, "too many metrics requested: %d, max %d", len(metrics), MaxBulkRead)
+	}
+
+	results := make([]models.MetricValue, len(metrics))
+	var gauges, counters []string
+	for i, metric := range metrics {
+		results[i] = models.MetricValue{Metrics: models.Metrics{ID: metric.ID, MType: metric.MType}, Status: models.StatusMissing}
+		switch {
+		case metric.ID == "":
+			results[i].Status, results[i].Reason = models.StatusRejected, models.ReasonEmptyID
+		case metric.MType == "gauge":
+			gauges = append(gauges, metric.ID)
+		case metric.MType == "counter":
+			counters = append(counters, metric.ID)
+		default:
+			results[i].Status, results[i].Reason = models.StatusRejected, models.ReasonUnknownType
+		}
+	}
+	if len(gauges) == 0 && len(counters) == 0 {
+		return results, nil
+	}
+
+	var sm repository.StoreMetrics
+	err := s.Retry(ctx, s.retries, func(ctx context.Context) error {
+		sm = repository.StoreMetrics{}
+		return s.repo.GetMetrics(ctx, gauges, counters, &sm)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metrics %w", err)
+	}
+
+	gaugeValues := make(map[string]float64, len(sm.Gauge))
+	for _, g := range sm.Gauge {
+		gaugeValues[g.Name] = g.Value
+	}
+	counterValues := make(map[string]int64, len(sm.Counter))
+	for _, c := range sm.Counter {
+		counterValues[c.Name] = c.Value
+	}
+	for i := range results {
+		r := &results[i]
+		if r.Status != models.StatusMissing {
+			continue
+		}
+		switch r.MType {
+		case "gauge":
+			if v, ok := gaugeValues[r.ID]; ok {
+				r.Value, r.Status = &v, models.StatusFound
+			}
+		case "counter":
+			if v, ok := counterValues[r.ID]; ok {
+				r.Delta, r.Status = &v, models.StatusFound
+			}
+		}
+	}
+	return results, nil
+}
+
 // SetValue сохраняет или Gauge, или Counter метрики.
 func (s *Service) SetValue(ctx context.Context, metricName string, metricType string, metricValue string) error {
+	if s.reserved(ctx, metricName) {
+		return fmt.Errorf("%w: %s", ErrReservedName, metricName)
+	}
 	switch metricType {
 	case "gauge":
 		valueFloat, err := strconv.ParseFloat(metricValue, 64)
 		if err != nil {
-			return err
+			return NewError(ErrInvalidArgument, err)
 		}
 		m := repository.GaugeMetric{Name: metricName, Value: valueFloat}
 		err = s.Retry(ctx, s.retries, func(ctx context.Context) error {
@@ -160,10 +296,12 @@ func (s *Service) SetValue(ctx context.Context, metricName string, metricType st
 		if err != nil {
 			return err
 		}
+		s.publish(&models.Metrics{ID: metricName, MType: metricType, Value: &valueFloat})
+		s.Settings.Auditor.Record(ctx, []models.Metrics{{ID: metricName, MType: metricType, Value: &valueFloat}})
 	case "counter":
 		intValue, err := strconv.ParseInt(metricValue, 10, 64)
 		if err != nil {
-			return err
+			return NewError(ErrInvalidArgument, err)
 		}
 		m := repository.CounterMetric{Name: metricName, Value: intValue}
 		err = s.Retry(ctx, s.retries, func(ctx context.Context) error {
@@ -172,6 +310,8 @@ func (s *Service) SetValue(ctx context.Context, metricName string, metricType st
 		if err != nil {
 			return err
 		}
+		s.publish(&models.Metrics{ID: metricName, MType: metricType, Delta: &intValue})
+		s.Settings.Auditor.Record(ctx, []models.Metrics{{ID: metricName, MType: metricType, Delta: &intValue}})
 	default:
 		return fmt.Errorf("%w: %s", ErrUnknownMetricType, metricType)
 	}
@@ -185,36 +325,214 @@ func (s *Service) SetValue(ctx context.Context, metricName string, metricType st
 	return nil
 }
 
-// SetModelValue сохраняет или Gauge, или Counter метрики из моделек.
+// SetModelValue сохраняет или Gauge, или Counter метрики из моделек по одной.
+// На первой некорректной метрике запись прерывается, уже записанные метрики остаются.
 func (s *Service) SetModelValue(ctx context.Context, metrics []*models.Metrics) error {
 	for _, metric := range metrics {
-		if metric.ID == "" {
-			return errors.New("name of the metric is required")
+		_, err := validateMetric(metric)
+		if err == nil && s.reserved(ctx, metric.ID) {
+			err = fmt.Errorf("%w: %s", ErrReservedName, metric.ID)
+		}
+		if err != nil {
+			return err
 		}
+		if _, err := s.setModelValueBatch(ctx, []*models.Metrics{metric}, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		switch metric.MType {
-		case "gauge":
-			if metric.Value == nil {
-				return fmt.Errorf("value of the gauge is required. %s", metric.ID)
-			}
-			if err := s.SetValue(ctx, metric.ID, metric.MType, fmt.Sprintf("%.12f", *metric.Value)); err != nil {
-				return err
+// SetModelValueBatch сохраняет пакет метрик и возвращает результат обработки по каждой из них.
+// В режиме atomic пакет либо сохраняется целиком, либо не сохраняется совсем.
+// Повторно доставленный пакет с уже принятым batchID не применяется, а возвращается с признаком Duplicate.
+// Повтор пакета, который еще пишется, получает ErrUnavailable и должен быть отправлен позже.
+func (s *Service) SetModelValueBatch(ctx context.Context, batchID string, metrics []*models.Metrics, atomic bool) (*models.BatchResult, error) {
+	if batchID == "" || s.Settings.Dedup == nil {
+		return s.setModelValueBatch(ctx, metrics, atomic)
+	}
+
+	var result *models.BatchResult
+	var errWrite error
+	fresh, err := s.Settings.Dedup.Apply(ctx, batchID, func(ctx context.Context) (bool, error) {
+		result, errWrite = s.storeBatch(ctx, metrics, atomic)
+		// пакет без принятых метрик не запоминается, повтор должен быть принят
+		return errWrite == nil && result.Accepted > 0, errWrite
+	})
+	if errors.Is(err, dedup.ErrInFlight) {
+		return nil, Errorf(ErrUnavailable, "batch %s: %w", batchID, err)
+	}
+	if err != nil && !errors.Is(err, errWrite) {
+		return nil, Errorf(ErrUnavailable, "failed to apply batch %s: %w", batchID, err)
+	}
+	if !fresh {
+		logger.Log.Info("duplicate batch ignored", zap.String("batch_id", batchID))
+		return &models.BatchResult{Duplicate: true, Results: []models.MetricResult{}}, nil
+	}
+	if errWrite != nil {
+		return result, errWrite
+	}
+	return result, s.notify(ctx, metrics, result)
+}
+
+// setModelValueBatch сохраняет пакет метрик без проверки на повторную доставку.
+func (s *Service) setModelValueBatch(ctx context.Context, metrics []*models.Metrics, atomic bool) (*models.BatchResult, error) {
+	result, err := s.storeBatch(ctx, metrics, atomic)
+	if err != nil {
+		return result, err
+	}
+	return result, s.notify(ctx, metrics, result)
+}
+
+// storeBatch проверяет и записывает пакет метрик в хранилище, результат каждой метрики пишется в BatchResult.
+func (s *Service) storeBatch(ctx context.Context, metrics []*models.Metrics, atomic bool) (*models.BatchResult, error) {
+	result := &models.BatchResult{Results: make([]models.MetricResult, len(metrics))}
+	gauges := make([]repository.GaugeMetric, 0, len(metrics))
+	counters := make([]repository.CounterMetric, 0, len(metrics))
+	var firstErr error
+
+	for i, metric := range metrics {
+		result.Results[i] = models.MetricResult{ID: metric.ID, MType: metric.MType, Status: models.StatusAccepted}
+		reason, err := validateMetric(metric)
+		if err == nil && s.reserved(ctx, metric.ID) {
+			reason, err = models.ReasonReservedName, fmt.Errorf("%w: %s", ErrReservedName, metric.ID)
+		}
+		if err != nil {
+			result.Results[i].Status = models.StatusRejected
+			result.Results[i].Reason = reason
+			result.Results[i].Message = err.Error()
+			if firstErr == nil {
+				firstErr = err
 			}
+			continue
+		}
+		if metric.MType == "gauge" {
+			gauges = append(gauges, repository.GaugeMetric{Name: metric.ID, Value: *metric.Value})
+		} else {
+			counters = append(counters, repository.CounterMetric{Name: metric.ID, Value: *metric.Delta})
+		}
+	}
 
-		case "counter":
-			if metric.Delta == nil {
-				return fmt.Errorf("value of the counter is required. %s", metric.ID)
+	if atomic {
+		if firstErr == nil {
+			firstErr = s.Retry(ctx, s.retries, func(ctx context.Context) error {
+				return s.repo.SetMetrics(ctx, gauges, counters)
+			})
+			if firstErr != nil {
+				rejectAll(result, models.ReasonStorageError, firstErr.Error())
+				return result, firstErr
+			}
+		} else {
+			rejectAll(result, models.ReasonBatchAborted, ErrBatchRejected.Error())
+			return result, fmt.Errorf("%w: %w", ErrBatchRejected, firstErr)
+		}
+	} else {
+		for i, metric := range metrics {
+			if result.Results[i].Status != models.StatusAccepted {
+				continue
 			}
-			if err := s.SetValue(ctx, metric.ID, metric.MType, fmt.Sprintf("%d", *metric.Delta)); err != nil {
-				return err
+			err := s.Retry(ctx, s.retries, func(ctx context.Context) error {
+				if metric.MType == "gauge" {
+					return s.repo.SetGauge(ctx, &repository.GaugeMetric{Name: metric.ID, Value: *metric.Value})
+				}
+				return s.repo.SetCounter(ctx, &repository.CounterMetric{Name: metric.ID, Value: *metric.Delta})
+			})
+			if err != nil {
+				logger.Log.Error("couldn`t save metric", zap.String("metric", metric.ID), zap.Error(err))
+				result.Results[i].Status = models.StatusRejected
+				result.Results[i].Reason = models.ReasonStorageError
+				result.Results[i].Message = err.Error()
 			}
-		default:
-			return fmt.Errorf("%w: %s", ErrUnknownMetricType, metric.MType)
+		}
+	}
+
+	for _, r := range result.Results {
+		if r.Status == models.StatusAccepted {
+			result.Accepted++
+		} else {
+			result.Rejected++
+		}
+	}
+	return result, nil
+}
+
+// notify рассылает подписчикам принятые метрики пакета, пишет их в аудит и, если надо, сохраняет хранилище в файл.
+func (s *Service) notify(ctx context.Context, metrics []*models.Metrics, result *models.BatchResult) error {
+	accepted := make([]models.Metrics, 0, result.Accepted)
+	for i, r := range result.Results {
+		if r.Status == models.StatusAccepted {
+			s.publish(metrics[i])
+			accepted = append(accepted, *metrics[i])
+		}
+	}
+	if !isSelfWrite(ctx) {
+		s.Settings.Auditor.Record(ctx, accepted)
+	}
+
+	if s.Settings.SyncSave && result.Accepted > 0 {
+		if err := s.Save(); err != nil {
+			logger.Log.Error("couldn`t save to the file", zap.Error(err))
+			return err
 		}
 	}
 	return nil
 }
 
+// selfWriteKey ключ контекста, отмечает запись метрик самого сервера.
+type selfWriteKey struct{}
+
+// isSelfWrite возвращает true, если метрики пишет сам сервер.
+func isSelfWrite(ctx context.Context) bool {
+	self, _ := ctx.Value(selfWriteKey{}).(bool)
+	return self
+}
+
+// reserved проверяет, что клиент пишет метрику под префиксом метрик сервера.
+func (s *Service) reserved(ctx context.Context, metricName string) bool {
+	prefix := s.Settings.SelfMetricsPrefix
+	return prefix != "" && strings.HasPrefix(metricName, prefix) && !isSelfWrite(ctx)
+}
+
+// SetSelfMetrics сохраняет метрики самого сервера под зарезервированным префиксом, запись не попадает в аудит.
+func (s *Service) SetSelfMetrics(ctx context.Context, metrics []*models.Metrics) error {
+	_, err := s.setModelValueBatch(context.WithValue(ctx, selfWriteKey{}, true), metrics, true)
+	return err
+}
+
+// validateMetric проверяет метрику и возвращает код причины отклонения вместе с ошибкой.
+func validateMetric(metric *models.Metrics) (string, error) {
+	if metric.ID == "" {
+		return models.ReasonEmptyID, Errorf(ErrInvalidArgument, "name of the metric is required")
+	}
+
+	switch metric.MType {
+	case "gauge":
+		if metric.Value == nil {
+			return models.ReasonMissingValue, Errorf(ErrInvalidArgument, "value of the gauge is required. %s", metric.ID)
+		}
+	case "counter":
+		if metric.Delta == nil {
+			return models.ReasonMissingValue, Errorf(ErrInvalidArgument, "value of the counter is required. %s", metric.ID)
+		}
+	default:
+		return models.ReasonUnknownType, fmt.Errorf("%w: %s", ErrUnknownMetricType, metric.MType)
+	}
+	return "", nil
+}
+
+// rejectAll помечает отклоненными все метрики пакета. Метрики, отклоненные по своей причине, ее сохраняют.
+func rejectAll(result *models.BatchResult, reason string, message string) {
+	for i := range result.Results {
+		if result.Results[i].Status == models.StatusAccepted {
+			result.Results[i].Status = models.StatusRejected
+			result.Results[i].Reason = reason
+			result.Results[i].Message = message
+		}
+	}
+	result.Accepted = 0
+	result.Rejected = len(result.Results)
+}
+
 // GetAllValues забирает все метрики из хранилища.
 func (s *Service) GetAllValues(ctx context.Context) (sm *repository.StoreMetrics) {
 	sm = &repository.StoreMetrics{Gauge: make([]repository.GaugeMetric, 0), Counter: make([]repository.CounterMetric, 0)}
@@ -225,10 +543,46 @@ func (s *Service) GetAllValues(ctx context.Context) (sm *repository.StoreMetrics
 	return sm
 }
 
+// Subscribe подписывает на принятые обновления метрик, имена которых подходят под шаблон.
+func (s *Service) Subscribe(pattern string) (*broker.Subscription, error) {
+	sub, err := s.broker.Subscribe(pattern)
+	if err != nil && !errors.Is(err, broker.ErrClosed) {
+		return nil, NewError(ErrInvalidArgument, err)
+	}
+	return sub, err
+}
+
+// CloseSubscriptions закрывает все подписки на обновления метрик, используется при остановке сервера.
+func (s *Service) CloseSubscriptions() {
+	s.broker.Close()
+}
+
+// publish запоминает время и рассылает подписчикам принятое обновление метрики.
+func (s *Service) publish(metric *models.Metrics) {
+	now := time.Now()
+	s.updatedMu.Lock()
+	s.updated[metric.MType+":"+metric.ID] = now
+	s.updatedMu.Unlock()
+	s.broker.Publish(models.MetricUpdate{Metrics: *metric, Time: now})
+}
+
+// UpdatedAt возвращает время последнего принятого обновления метрики.
+// Время хранится только в памяти, после перезапуска сервера оно неизвестно.
+func (s *Service) UpdatedAt(metricType, metricName string) (time.Time, bool) {
+	s.updatedMu.RLock()
+	defer s.updatedMu.RUnlock()
+	t, ok := s.updated[metricType+":"+metricName]
+	return t, ok
+}
+
 // Save сохраняет в хранилище, если оно типа repository.MemStorage.
-func (s *Service) Save() error {
-	switch s.repo.(type) {
+func (s *Service) Save() (err error) {
+	switch unwrapRepository(s.repo).(type) {
 	case *repository.MemStorage:
+		defer func(start time.Time) {
+			s.Settings.SelfMetrics.ObserveSave(start, err)
+		}(time.Now())
+
 		var sm repository.StoreMetrics
 
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -249,7 +603,11 @@ func (s *Service) Save() error {
 			counters[metric.Name] = metric.Value
 		}
 
-		return s.fileRestorer.Save(gauges, counters)
+		if err := s.fileRestorer.Save(gauges, counters); err != nil {
+			return err
+		}
+		s.snapshotSaved(time.Now())
+		return nil
 	default:
 		return ErrNotSupported
 	}
@@ -257,7 +615,7 @@ func (s *Service) Save() error {
 
 // Restore восстанавливает их хранилища, если оно типа repository.MemStorage.
 func (s *Service) Restore() error {
-	switch s.repo.(type) {
+	switch unwrapRepository(s.repo).(type) {
 	case *repository.MemStorage:
 		gauges, counters, err := s.fileRestorer.Restore()
 		if err != nil {
@@ -270,7 +628,8 @@ func (s *Service) Restore() error {
 	}
 }
 
-// Retry метод повтора функций с задержками при повторных попытках, игнорирует sql.ErrNoRows
+// Retry метод повтора функций с задержками при повторных попытках. Отсутствие строки и ошибки
+// некорректного запроса не повторяются.
 func (s *Service) Retry(ctx context.Context, retryDelays []uint, f func(ctx context.Context) error) error {
 	var retries = len(retryDelays)
 	for _, delay := range retryDelays {
@@ -281,7 +640,12 @@ func (s *Service) Retry(ctx context.Context, retryDelays []uint, f func(ctx cont
 			err := f(ctx)
 			retries -= 1
 			if err != nil {
-				if !errors.Is(err, sql.ErrNoRows) {
+				if !errors.Is(err, sql.ErrNoRows) && !errors.Is(err, repository.ErrNoRows) && !errors.Is(err, ErrInvalidArgument) {
+					if retries == 0 {
+						s.Settings.SelfMetrics.Retries.Inc(selfmetrics.ResultExhausted)
+					} else {
+						s.Settings.SelfMetrics.Retries.Inc(selfmetrics.ResultRetry)
+					}
 					logger.Log.Error(fmt.Sprintf("Request to server failed. retrying in %d seconds... Retries left %d\n", delay, retries), zap.Error(err))
 					time.Sleep(time.Duration(delay) * time.Second)
 					if retries == 0 {
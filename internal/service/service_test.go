@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"github.com/golang/mock/gomock"
 	"github.com/jmoiron/sqlx"
+	"github.com/sebasttiano/Blackbird.git/internal/service/dedup"
 	mockservice "github.com/sebasttiano/Blackbird.git/internal/service/mocks"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"testing"
+	"time"
 
 	"github.com/sebasttiano/Blackbird.git/internal/models"
 	"github.com/sebasttiano/Blackbird.git/internal/repository"
@@ -175,6 +178,68 @@ func TestService_Save(t *testing.T) {
 	}
 }
 
+func TestService_Admin(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemStorage()
+	service := NewService(&Settings{Retries: 1, BackoffFactor: 1, SaveFilePath: t.TempDir() + "/metrics.json"}, repo)
+	require.NoError(t, service.SetValue(ctx, "PollCount", "counter", "5"))
+	require.NoError(t, service.SetValue(ctx, "Alloc", "gauge", "1.5"))
+
+	stats, err := service.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &models.StorageStats{Backend: models.BackendMemory, Series: 2, Gauges: 1, Counters: 1}, stats)
+
+	previous, err := service.ResetCounter(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), previous)
+	value, err := service.GetValue(ctx, "PollCount", "counter")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), value)
+	_, err = service.ResetCounter(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, service.DeleteMetric(ctx, "Alloc", "gauge"))
+	_, ok := service.UpdatedAt("gauge", "Alloc")
+	assert.False(t, ok)
+	assert.ErrorIs(t, service.DeleteMetric(ctx, "Alloc", "gauge"), ErrNotFound)
+	assert.ErrorIs(t, service.DeleteMetric(ctx, "Alloc", "histogram"), ErrUnknownMetricType)
+
+	require.NoError(t, service.Save())
+	stats, err = service.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Series)
+	require.NotNil(t, stats.LastSnapshot)
+	assert.WithinDuration(t, time.Now(), *stats.LastSnapshot, time.Minute)
+}
+
+func TestService_GetModelValues(t *testing.T) {
+	ctx := context.Background()
+	service := NewService(&Settings{Retries: 1, BackoffFactor: 1}, repository.NewMemStorage())
+	require.NoError(t, service.SetValue(ctx, "PollCount", "counter", "5"))
+	require.NoError(t, service.SetValue(ctx, "Alloc", "gauge", "1.5"))
+
+	values, err := service.GetModelValues(ctx, []*models.Metrics{
+		{ID: "Alloc", MType: "gauge"},
+		{ID: "PollCount", MType: "counter"},
+		{ID: "Alloc", MType: "counter"},
+		{ID: "", MType: "gauge"},
+		{ID: "Alloc", MType: "histogram"},
+	})
+	require.NoError(t, err)
+	require.Len(t, values, 5)
+
+	alloc, poll := 1.5, int64(5)
+	assert.Equal(t, models.MetricValue{Metrics: models.Metrics{ID: "Alloc", MType: "gauge", Value: &alloc}, Status: models.StatusFound}, values[0])
+	assert.Equal(t, models.MetricValue{Metrics: models.Metrics{ID: "PollCount", MType: "counter", Delta: &poll}, Status: models.StatusFound}, values[1])
+	assert.Equal(t, models.MetricValue{Metrics: models.Metrics{ID: "Alloc", MType: "counter"}, Status: models.StatusMissing}, values[2])
+	assert.Equal(t, models.ReasonEmptyID, values[3].Reason)
+	assert.Equal(t, models.StatusRejected, values[4].Status)
+	assert.Equal(t, models.ReasonUnknownType, values[4].Reason)
+
+	_, err = service.GetModelValues(ctx, make([]*models.Metrics, MaxBulkRead+1))
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+}
+
 func TestService_Restore(t *testing.T) {
 
 	testTable := []struct {
@@ -244,3 +309,165 @@ func TestService_RetryDBError(t *testing.T) {
 	assert.Equal(t, testError.Error(), "function failed after 3 retries. last error was failed to connect to database")
 	assert.Equal(t, testError.Unwrap().Error(), "failed to connect to database")
 }
+
+func TestKindOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"typed", Errorf(ErrNotFound, "gauge metric %s not found", "Alloc"), ErrNotFound},
+		{"wrapped typed", fmt.Errorf("request failed: %w", NewError(ErrConflict, errors.New("busy"))), ErrConflict},
+		{"unknown type", ErrUnknownMetricType, ErrInvalidArgument},
+		{"reserved name", ErrReservedName, ErrConflict},
+		{"no rows", fmt.Errorf("query: %w", repository.ErrNoRows), ErrNotFound},
+		{"retries exhausted", NewRetryDBError(3, errors.New("connection refused")), ErrUnavailable},
+		{"deadline", context.DeadlineExceeded, ErrUnavailable},
+		{"internal", errors.New("disk is full"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, KindOf(tt.err))
+		})
+	}
+
+	err := Errorf(ErrNotFound, "counter metric %s not found: %w", "PollCount", repository.ErrNoRows)
+	assert.Equal(t, "counter metric PollCount not found: sql: no rows in result set", err.Error())
+	assert.ErrorIs(t, err, repository.ErrNoRows)
+}
+
+func TestService_SetModelValueBatch(t *testing.T) {
+	gauge := 12.5
+	delta := int64(7)
+
+	testTable := []struct {
+		name         string
+		metrics      []*models.Metrics
+		atomic       bool
+		wantAccepted int
+		wantRejected int
+		wantReasons  []string
+		wantStored   int
+		wantErr      error
+	}{
+		{
+			name: "OK all accepted",
+			metrics: []*models.Metrics{
+				{ID: "g", MType: "gauge", Value: &gauge},
+				{ID: "c", MType: "counter", Delta: &delta},
+			},
+			wantAccepted: 2,
+			wantReasons:  []string{"", ""},
+			wantStored:   2,
+		},
+		{
+			name: "OK partial success",
+			metrics: []*models.Metrics{
+				{ID: "g", MType: "gauge", Value: &gauge},
+				{ID: "", MType: "gauge", Value: &gauge},
+				{ID: "c", MType: "counter"},
+				{ID: "h", MType: "histogram", Value: &gauge},
+			},
+			wantAccepted: 1,
+			wantRejected: 3,
+			wantReasons:  []string{"", models.ReasonEmptyID, models.ReasonMissingValue, models.ReasonUnknownType},
+			wantStored:   1,
+		},
+		{
+			name: "NOT OK atomic batch rejected",
+			metrics: []*models.Metrics{
+				{ID: "g", MType: "gauge", Value: &gauge},
+				{ID: "h", MType: "histogram", Value: &gauge},
+			},
+			atomic:       true,
+			wantRejected: 2,
+			wantReasons:  []string{models.ReasonBatchAborted, models.ReasonUnknownType},
+			wantStored:   0,
+			wantErr:      ErrBatchRejected,
+		},
+	}
+
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := repository.NewMemStorage()
+			service := NewService(&Settings{Retries: 1, BackoffFactor: 1}, repo)
+
+			result, err := service.SetModelValueBatch(context.TODO(), "", tt.metrics, tt.atomic)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantAccepted, result.Accepted)
+			assert.Equal(t, tt.wantRejected, result.Rejected)
+			for i, reason := range tt.wantReasons {
+				assert.Equal(t, reason, result.Results[i].Reason)
+			}
+			assert.Equal(t, tt.wantStored, len(repo.Gauge)+len(repo.Counter))
+		})
+	}
+}
+
+func TestService_SetModelValue(t *testing.T) {
+	gauge := 12.5
+	delta := int64(7)
+	repo := repository.NewMemStorage()
+	service := NewService(&Settings{Retries: 1, BackoffFactor: 1, SelfMetricsPrefix: "_bb."}, repo)
+
+	// метрики до некорректной записываются, как и раньше, пакет не откатывается
+	err := service.SetModelValue(context.TODO(), []*models.Metrics{
+		{ID: "g", MType: "gauge", Value: &gauge},
+		{ID: "_bb.g", MType: "gauge", Value: &gauge},
+		{ID: "c", MType: "counter", Delta: &delta},
+	})
+	assert.ErrorIs(t, err, ErrReservedName)
+	assert.Equal(t, gauge, repo.Gauge["g"])
+	assert.NotContains(t, repo.Gauge, "_bb.g")
+	assert.NotContains(t, repo.Counter, "c")
+
+	err = service.SetModelValue(context.TODO(), []*models.Metrics{{ID: "c", MType: "counter"}})
+	assert.Equal(t, ErrInvalidArgument, KindOf(err))
+}
+
+func TestService_SetModelValueBatchDedup(t *testing.T) {
+	gauge := 12.5
+	delta := int64(7)
+	valid := []*models.Metrics{{ID: "c", MType: "counter", Delta: &delta}}
+	invalid := []*models.Metrics{{ID: "g", MType: "gauge", Value: &gauge}, {ID: "h", MType: "histogram"}}
+
+	repo := repository.NewMemStorage()
+	service := NewService(&Settings{Retries: 1, BackoffFactor: 1, Dedup: dedup.NewMemoryWindow(10)}, repo)
+	ctx := context.TODO()
+
+	result, err := service.SetModelValueBatch(ctx, "agent:1", valid, false)
+	require.NoError(t, err)
+	assert.False(t, result.Duplicate)
+
+	// повтор того же пакета не увеличивает счетчик
+	result, err = service.SetModelValueBatch(ctx, "agent:1", valid, false)
+	require.NoError(t, err)
+	assert.True(t, result.Duplicate)
+	assert.Equal(t, int64(7), repo.Counter["c"])
+
+	// отклоненный пакет не запоминается и может быть отправлен повторно
+	_, err = service.SetModelValueBatch(ctx, "agent:2", invalid, true)
+	assert.ErrorIs(t, err, ErrBatchRejected)
+	result, err = service.SetModelValueBatch(ctx, "agent:2", invalid[:1], true)
+	require.NoError(t, err)
+	assert.False(t, result.Duplicate)
+	assert.Equal(t, 1, result.Accepted)
+
+	// пакеты без идентификатора не проверяются
+	service.SetModelValueBatch(ctx, "", valid, false)
+	service.SetModelValueBatch(ctx, "", valid, false)
+	assert.Equal(t, int64(21), repo.Counter["c"])
+
+	// повтор пакета, который еще пишется, не подтверждается
+	d := mockservice.NewMockDeduplicator(gomock.NewController(t))
+	d.EXPECT().Apply(gomock.Any(), "agent:3", gomock.Any()).Return(false, dedup.ErrInFlight)
+	service.Settings.Dedup = d
+	result, err = service.SetModelValueBatch(ctx, "agent:3", valid, false)
+	assert.Nil(t, result)
+	assert.Equal(t, ErrUnavailable, KindOf(err))
+	assert.Equal(t, int64(21), repo.Counter["c"])
+}
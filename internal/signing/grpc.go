@@ -0,0 +1,40 @@
+package signing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// Body сериализует сообщение gRPC для подписи. Сериализация детерминированная, чтобы клиент и сервер
+// получили одинаковые байты.
+func Body(msg any) ([]byte, error) {
+	m, ok := msg.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T is not a protobuf message", ErrBadSignature, msg)
+	}
+	return proto.MarshalOptions{Deterministic: true}.Marshal(m)
+}
+
+// UnaryClientInterceptor подписывает каждый вызов ключом secret и передает подпись в метаданных.
+func UnaryClientInterceptor(secret, keyID string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		body, err := Body(req)
+		if err != nil {
+			return err
+		}
+		// идентификатор пакета в теле запроса, отдельно его подписывать не нужно
+		headers, err := Sign(secret, keyID, http.MethodPost, method, "", body)
+		if err != nil {
+			return err
+		}
+		for k, v := range headers {
+			ctx = metadata.AppendToOutgoingContext(ctx, k, v)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
@@ -0,0 +1,288 @@
+// Package signing подписывает запросы агентов HMAC-SHA256. Подпись покрывает метод, путь, время,
+// одноразовый nonce и идентификатор пакета вместе с телом, поэтому перехваченный запрос нельзя повторить,
+// отправить на другой адрес или выдать за другой пакет. Сервер отклоняет подписи со временем дальше допустимого расхождения часов
+// и запоминает nonce, пока подпись с ним считается свежей.
+//
+// Подписывается строка:
+//
+//	BLACKBIRD-HMAC-SHA256\n<method>\n<path>\n<unix timestamp>\n<nonce>\n[<X-Batch-ID>\n]<hex sha256 тела>
+//
+// Строка с X-Batch-ID есть, только если заголовок передан: по нему сервер отбрасывает повторы пакетов.
+// Для gRPC метод POST, путь полное имя метода, тело детерминированно сериализованный запрос,
+// идентификатор пакета входит в тело.
+package signing
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sebasttiano/Blackbird.git/internal/agentkeys"
+	"github.com/sebasttiano/Blackbird.git/internal/common"
+	"github.com/sebasttiano/Blackbird.git/internal/keyring"
+)
+
+// Заголовки и ключи gRPC метаданных подписи.
+const (
+	// HeaderSignature hex HMAC-SHA256 канонической строки запроса.
+	HeaderSignature = "X-Signature"
+	// HeaderTimestamp время подписи в секундах Unix.
+	HeaderTimestamp = "X-Signature-Timestamp"
+	// HeaderNonce случайная строка, уникальная для каждого запроса.
+	HeaderNonce = "X-Signature-Nonce"
+	// HeaderLegacy подпись старой схемы, покрывает только тело.
+	HeaderLegacy = "HashSHA256"
+)
+
+// DefaultMaxSkew допустимое расхождение часов агента и сервера по умолчанию.
+const DefaultMaxSkew = 5 * time.Minute
+
+// scheme префикс канонической строки, отделяет подписи этой схемы от других HMAC с тем же ключом.
+const scheme = "BLACKBIRD-HMAC-SHA256"
+
+// nonceSize сколько случайных байт в nonce.
+const nonceSize = 16
+
+// ErrNoSignature ошибка, если запрос не подписан.
+var ErrNoSignature = errors.New("request is not signed")
+
+// ErrBadSignature ошибка, если подпись не сходится или заголовки подписи неполные.
+var ErrBadSignature = errors.New("signature validation failed")
+
+// ErrClockSkew ошибка, если время подписи слишком далеко от времени сервера.
+var ErrClockSkew = errors.New("signature timestamp is outside of the allowed clock skew")
+
+// ErrReplay ошибка, если запрос с таким nonce уже принят.
+var ErrReplay = errors.New("request with this nonce was already accepted")
+
+// ErrNoAgentID ошибка, если при включенном реестре агентов в подписанном запросе нет X-Agent-ID.
+var ErrNoAgentID = errors.New("agent id is required to verify signature")
+
+// ErrRegistryUnavailable ошибка, если реестр агентов не ответил.
+var ErrRegistryUnavailable = errors.New("agent registry is unavailable")
+
+// ErrLegacyDisabled ошибка, если запрос подписан старой схемой, а сервер ее не принимает.
+var ErrLegacyDisabled = errors.New("body-only HashSHA256 signature is disabled, sign method, path, timestamp and nonce")
+
+// Getter возвращает значения заголовка или ключа метаданных по имени.
+type Getter func(name string) []string
+
+// Sum вычисляет подпись запроса. Пустой batchID не попадает в каноническую строку.
+func Sum(secret, method, path string, timestamp int64, nonce, batchID string, body []byte) string {
+	bodySum := sha256.Sum256(body)
+	h := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(h, "%s\n%s\n%s\n%d\n%s\n", scheme, method, path, timestamp, nonce)
+	if batchID != "" {
+		fmt.Fprintf(h, "%s\n", batchID)
+	}
+	h.Write([]byte(hex.EncodeToString(bodySum[:])))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// LegacySum вычисляет подпись старой схемы, только по телу.
+func LegacySum(secret string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Sign подписывает запрос текущим временем и новым nonce. Возвращает заголовки подписи,
+// keyID попадает в заголовок X-Sign-Key-ID, batchID в X-Batch-ID, если не пустые.
+func Sign(secret, keyID, method, path, batchID string, body []byte) (map[string]string, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	timestamp := time.Now().Unix()
+	headers := map[string]string{
+		HeaderTimestamp: strconv.FormatInt(timestamp, 10),
+		HeaderNonce:     hex.EncodeToString(nonce),
+	}
+	headers[HeaderSignature] = Sum(secret, method, path, timestamp, headers[HeaderNonce], batchID, body)
+	if keyID != "" {
+		headers[common.SignKeyIDHeader] = keyID
+	}
+	if batchID != "" {
+		headers[common.BatchIDHeader] = batchID
+	}
+	return headers, nil
+}
+
+// Verifier проверяет подписи запросов ключами HMAC из набора ключей.
+type Verifier struct {
+	keys    *keyring.Keyring
+	agents  agentkeys.Store
+	maxSkew time.Duration
+	legacy  bool
+	// unsigned пропускает неподписанные записи, даже если ключи заданы
+	unsigned bool
+	nonces   *NonceCache
+	now      func() time.Time
+}
+
+// NewVerifier конструктор для Verifier. maxSkew допустимое расхождение часов, 0 означает DefaultMaxSkew.
+// legacy разрешает старую подпись только по телу.
+func NewVerifier(keys *keyring.Keyring, maxSkew time.Duration, legacy bool) *Verifier {
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxSkew
+	}
+	return &Verifier{keys: keys, maxSkew: maxSkew, legacy: legacy, nonces: NewNonceCache(), now: time.Now}
+}
+
+// WithAgents включает персональные секреты агентов: запрос с X-Agent-ID зарегистрированного агента
+// проверяется только его секретом, отозванный агент отклоняется. Запросы незарегистрированных агентов
+// проверяются ключами из набора, пока общий ключ не убран из настроек. Подписанный запрос без X-Agent-ID
+// отклоняется, иначе отозванный агент мог бы подписать его общим ключом, а неподписанные записи
+// отклоняет RequireSign.
+func (v *Verifier) WithAgents(store agentkeys.Store) *Verifier {
+	v.agents = store
+	return v
+}
+
+// AllowUnsigned пропускает неподписанные записи при заданных ключах. Нужно только на время перевода
+// агентов на подпись: без подписи перехваченный запрос можно повторить, убрав заголовки подписи.
+func (v *Verifier) AllowUnsigned(allow bool) *Verifier {
+	v.unsigned = allow
+	return v
+}
+
+// Required возвращает true, если записи без подписи надо отклонять. Подпись обязательна, когда задан
+// хотя бы один ключ HMAC или реестр агентов.
+func (v *Verifier) Required() bool {
+	if v == nil || v.unsigned {
+		return false
+	}
+	return v.keys.Has(keyring.TypeHMAC) || v.agents != nil
+}
+
+// Signed проверяет, есть ли в запросе подпись какой-либо схемы.
+func Signed(get Getter) bool {
+	return first(get, HeaderSignature) != "" || first(get, HeaderLegacy) != ""
+}
+
+// Verify проверяет подпись запроса и возвращает ключ, которым он подписан. Подпись новой схемы
+// имеет приоритет, старая проверяется, только если разрешена.
+func (v *Verifier) Verify(ctx context.Context, get Getter, method, path string, body []byte) (*keyring.Key, error) {
+	signature := first(get, HeaderSignature)
+	switch {
+	case signature == "" && first(get, HeaderLegacy) == "":
+		return nil, ErrNoSignature
+	case signature == "" && !v.legacy:
+		return nil, ErrLegacyDisabled
+	}
+	key, err := v.key(ctx, get)
+	if err != nil {
+		return nil, err
+	}
+	if signature == "" {
+		if !equal(LegacySum(key.Secret, body), first(get, HeaderLegacy)) {
+			return nil, ErrBadSignature
+		}
+		return key, nil
+	}
+
+	nonce := first(get, HeaderNonce)
+	if len(nonce) < nonceSize || len(nonce) > 128 {
+		return nil, fmt.Errorf("%w: nonce must be 16 to 128 characters", ErrBadSignature)
+	}
+	timestamp, err := strconv.ParseInt(first(get, HeaderTimestamp), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad timestamp", ErrBadSignature)
+	}
+	signedAt, now := time.Unix(timestamp, 0), v.now()
+	if signedAt.Before(now.Add(-v.maxSkew)) || signedAt.After(now.Add(v.maxSkew)) {
+		return nil, fmt.Errorf("%w: signed at %s, server time %s", ErrClockSkew, signedAt.UTC().Format(time.RFC3339), now.UTC().Format(time.RFC3339))
+	}
+	if !equal(Sum(key.Secret, method, path, timestamp, nonce, first(get, common.BatchIDHeader), body), signature) {
+		return nil, ErrBadSignature
+	}
+	// nonce запоминается только после проверки подписи, иначе кэш забьют чужие запросы
+	if !v.nonces.Add(key.ID+":"+nonce, signedAt.Add(v.maxSkew), now) {
+		return nil, ErrReplay
+	}
+	return key, nil
+}
+
+// key выбирает ключ проверки: секрет зарегистрированного агента или ключ из набора по X-Sign-Key-ID.
+func (v *Verifier) key(ctx context.Context, get Getter) (*keyring.Key, error) {
+	if v.agents != nil {
+		agentID := first(get, common.AgentIDHeader)
+		if agentID == "" {
+			return nil, ErrNoAgentID
+		}
+		agent, err := v.agents.Lookup(ctx, agentID)
+		switch {
+		case err == nil:
+			if err := agent.Active(); err != nil {
+				return nil, err
+			}
+			return &keyring.Key{ID: "agent/" + agent.ID, Type: keyring.TypeHMAC, Secret: agent.Secret}, nil
+		case !errors.Is(err, agentkeys.ErrAgentNotFound):
+			return nil, fmt.Errorf("%w: %v", ErrRegistryUnavailable, err)
+		}
+	}
+	return v.keys.Get(keyring.TypeHMAC, first(get, common.SignKeyIDHeader))
+}
+
+// equal сравнивает подписи в hex за постоянное время.
+func equal(want, got string) bool {
+	gotSum, err := hex.DecodeString(got)
+	if err != nil {
+		return false
+	}
+	wantSum, _ := hex.DecodeString(want)
+	return hmac.Equal(wantSum, gotSum)
+}
+
+func first(get Getter, name string) string {
+	if values := get(name); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// NonceCache помнит принятые nonce до истечения срока свежести подписи. Кэш в памяти процесса:
+// за несколькими серверами повтор на другой экземпляр он не поймает.
+type NonceCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	nextPurge time.Time
+}
+
+// NewNonceCache конструктор для NonceCache.
+func NewNonceCache() *NonceCache {
+	return &NonceCache{seen: make(map[string]time.Time)}
+}
+
+// Add запоминает nonce до expires. Возвращает false, если nonce уже есть и еще не истек.
+func (c *NonceCache) Add(nonce string, expires, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !now.Before(c.nextPurge) {
+		for n, exp := range c.seen {
+			if !now.Before(exp) {
+				delete(c.seen, n)
+			}
+		}
+		c.nextPurge = now.Add(time.Minute)
+	}
+	if exp, ok := c.seen[nonce]; ok && now.Before(exp) {
+		return false
+	}
+	c.seen[nonce] = expires
+	return true
+}
+
+// Len сколько nonce в кэше.
+func (c *NonceCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.seen)
+}
@@ -0,0 +1,226 @@
+package signing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sebasttiano/Blackbird.git/internal/agentkeys"
+	"github.com/sebasttiano/Blackbird.git/internal/common"
+	"github.com/sebasttiano/Blackbird.git/internal/keyring"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getter(headers map[string]string) Getter {
+	return func(name string) []string {
+		if v, ok := headers[name]; ok {
+			return []string{v}
+		}
+		return nil
+	}
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"id":"Alloc"}`)
+	headers, err := Sign("secret", "k1", http.MethodPost, "/updates/", "agent-1:7", body)
+	require.NoError(t, err)
+
+	assert.Equal(t, "k1", headers[common.SignKeyIDHeader])
+	assert.Len(t, headers[HeaderNonce], 2*nonceSize)
+	ts, err := strconv.ParseInt(headers[HeaderTimestamp], 10, 64)
+	require.NoError(t, err)
+	assert.Equal(t, "agent-1:7", headers[common.BatchIDHeader])
+	assert.Equal(t, Sum("secret", http.MethodPost, "/updates/", ts, headers[HeaderNonce], "agent-1:7", body), headers[HeaderSignature])
+
+	other, err := Sign("secret", "", http.MethodPost, "/updates/", "", body)
+	require.NoError(t, err)
+	assert.NotEqual(t, headers[HeaderNonce], other[HeaderNonce])
+	assert.NotContains(t, other, common.SignKeyIDHeader)
+	assert.NotContains(t, other, common.BatchIDHeader)
+
+	// каждая часть канонической строки меняет подпись
+	base := Sum("secret", "POST", "/updates/", 1, "nonce", "", body)
+	assert.NotEqual(t, base, Sum("secret", "PUT", "/updates/", 1, "nonce", "", body))
+	assert.NotEqual(t, base, Sum("secret", "POST", "/update/", 1, "nonce", "", body))
+	assert.NotEqual(t, base, Sum("secret", "POST", "/updates/", 2, "nonce", "", body))
+	assert.NotEqual(t, base, Sum("secret", "POST", "/updates/", 1, "other", "", body))
+	assert.NotEqual(t, base, Sum("secret", "POST", "/updates/", 1, "nonce", "", []byte("{}")))
+	assert.NotEqual(t, base, Sum("secret", "POST", "/updates/", 1, "nonce", "agent-1:7", body))
+	assert.NotEqual(t, Sum("secret", "POST", "/updates/", 1, "nonce", "agent-1:7", body), Sum("secret", "POST", "/updates/", 1, "nonce", "agent-1:8", body))
+	assert.NotEqual(t, base, LegacySum("secret", body))
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	keys := keyring.New(0)
+	require.NoError(t, keys.Add(keyring.Key{ID: keyring.DefaultID, Type: keyring.TypeHMAC, Secret: "secret"}))
+	now := time.Unix(1700000000, 0)
+	body := []byte("body")
+	signed := func(at time.Time, nonce string) map[string]string {
+		return map[string]string{
+			HeaderTimestamp: strconv.FormatInt(at.Unix(), 10),
+			HeaderNonce:     nonce,
+			HeaderSignature: Sum("secret", http.MethodPost, "/updates/", at.Unix(), nonce, "", body),
+		}
+	}
+	// batch подписывает пакет signedID, а в заголовок X-Batch-ID кладет sentID
+	batch := func(at time.Time, nonce, signedID, sentID string) map[string]string {
+		headers := signed(at, nonce)
+		headers[HeaderSignature] = Sum("secret", http.MethodPost, "/updates/", at.Unix(), nonce, signedID, body)
+		if sentID != "" {
+			headers[common.BatchIDHeader] = sentID
+		}
+		return headers
+	}
+	nonce := "0123456789abcdef"
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		wantErr error
+	}{
+		{name: "not signed", headers: map[string]string{}, wantErr: ErrNoSignature},
+		{name: "fresh", headers: signed(now, nonce)},
+		{name: "replay", headers: signed(now, nonce), wantErr: ErrReplay},
+		{name: "same nonce with other timestamp", headers: signed(now.Add(time.Second), nonce), wantErr: ErrReplay},
+		{name: "edge of skew", headers: signed(now.Add(-time.Minute), "edge-of-skew-nonce")},
+		{name: "behind skew", headers: signed(now.Add(-time.Minute-time.Second), "behind-skew-nonce"), wantErr: ErrClockSkew},
+		{name: "ahead of skew", headers: signed(now.Add(time.Minute+time.Second), "ahead-skew-nonce"), wantErr: ErrClockSkew},
+		{name: "short nonce", headers: signed(now, "short"), wantErr: ErrBadSignature},
+		{name: "bad timestamp", headers: map[string]string{HeaderTimestamp: "yesterday", HeaderNonce: nonce, HeaderSignature: "00"}, wantErr: ErrBadSignature},
+		{name: "bad hex", headers: map[string]string{HeaderTimestamp: strconv.FormatInt(now.Unix(), 10), HeaderNonce: "other-nonce-value", HeaderSignature: "zz"}, wantErr: ErrBadSignature},
+		{name: "legacy disabled", headers: map[string]string{HeaderLegacy: LegacySum("secret", body)}, wantErr: ErrLegacyDisabled},
+		{name: "signed batch", headers: batch(now, "signed-batch-nonce", "agent-1:7", "agent-1:7")},
+		{name: "batch id replaced", headers: batch(now, "replaced-batch-nonce", "agent-1:7", "agent-1:8"), wantErr: ErrBadSignature},
+		{name: "batch id removed", headers: batch(now, "removed-batch-nonce", "agent-1:7", ""), wantErr: ErrBadSignature},
+		{name: "batch id added", headers: batch(now, "added-batch-nonce", "", "agent-1:7"), wantErr: ErrBadSignature},
+	}
+	v := NewVerifier(keys, time.Minute, false)
+	v.now = func() time.Time { return now }
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := v.Verify(context.Background(), getter(tt.headers), http.MethodPost, "/updates/", body)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, keyring.DefaultID, key.ID)
+		})
+	}
+
+	// после окна свежести подпись отклоняется по времени, и nonce можно забыть
+	v.now = func() time.Time { return now.Add(2 * time.Minute) }
+	_, err := v.Verify(context.Background(), getter(signed(now, nonce)), http.MethodPost, "/updates/", body)
+	assert.ErrorIs(t, err, ErrClockSkew)
+
+	legacy := NewVerifier(keys, 0, true)
+	_, err = legacy.Verify(context.Background(), getter(map[string]string{HeaderLegacy: LegacySum("secret", body)}), http.MethodPost, "/updates/", body)
+	assert.NoError(t, err)
+	_, err = legacy.Verify(context.Background(), getter(map[string]string{HeaderLegacy: LegacySum("other", body)}), http.MethodPost, "/updates/", body)
+	assert.ErrorIs(t, err, ErrBadSignature)
+
+	_, err = NewVerifier(nil, 0, false).Verify(context.Background(), getter(signed(time.Now(), nonce)), http.MethodPost, "/updates/", body)
+	assert.ErrorIs(t, err, keyring.ErrUnknownKey)
+}
+
+// brokenAgents реестр, который не отвечает.
+type brokenAgents struct{ agentkeys.Store }
+
+func (brokenAgents) Lookup(context.Context, string) (*agentkeys.Agent, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestVerifier_VerifyAgents(t *testing.T) {
+	ctx := context.Background()
+	keys := keyring.New(0)
+	require.NoError(t, keys.Add(keyring.Key{ID: keyring.DefaultID, Type: keyring.TypeHMAC, Secret: "shared"}))
+	agents, err := agentkeys.NewFileStore(filepath.Join(t.TempDir(), "agents.json"))
+	require.NoError(t, err)
+	web, err := agentkeys.NewAgent("web-01")
+	require.NoError(t, err)
+	require.NoError(t, agents.Add(ctx, web))
+	v := NewVerifier(keys, 0, false).WithAgents(agents)
+
+	body := []byte("body")
+	signed := func(secret, agentID string) Getter {
+		headers, err := Sign(secret, "", http.MethodPost, "/updates/", "", body)
+		require.NoError(t, err)
+		if agentID != "" {
+			headers[common.AgentIDHeader] = agentID
+		}
+		return getter(headers)
+	}
+
+	key, err := v.Verify(ctx, signed(web.Secret, "web-01"), http.MethodPost, "/updates/", body)
+	require.NoError(t, err)
+	assert.Equal(t, "agent/web-01", key.ID)
+
+	// зарегистрированный агент не может подписать запрос общим ключом
+	_, err = v.Verify(ctx, signed("shared", "web-01"), http.MethodPost, "/updates/", body)
+	assert.ErrorIs(t, err, ErrBadSignature)
+
+	// незарегистрированные агенты проверяются общим ключом
+	key, err = v.Verify(ctx, signed("shared", "web-02"), http.MethodPost, "/updates/", body)
+	require.NoError(t, err)
+	assert.Equal(t, keyring.DefaultID, key.ID)
+
+	require.NoError(t, agents.Revoke(ctx, "web-01"))
+	_, err = v.Verify(ctx, signed(web.Secret, "web-01"), http.MethodPost, "/updates/", body)
+	assert.ErrorIs(t, err, agentkeys.ErrAgentRevoked)
+
+	// без X-Agent-ID отозванный агент не может подписать запрос общим ключом
+	_, err = v.Verify(ctx, signed("shared", ""), http.MethodPost, "/updates/", body)
+	assert.ErrorIs(t, err, ErrNoAgentID)
+
+	_, err = NewVerifier(keys, 0, false).WithAgents(brokenAgents{}).Verify(ctx, signed("shared", "web-02"), http.MethodPost, "/updates/", body)
+	assert.ErrorIs(t, err, ErrRegistryUnavailable)
+}
+
+func TestVerifier_Required(t *testing.T) {
+	hmacKeys := keyring.New(0)
+	require.NoError(t, hmacKeys.Add(keyring.Key{ID: "k1", Type: keyring.TypeHMAC, Secret: "secret"}))
+	agents, err := agentkeys.NewFileStore(filepath.Join(t.TempDir(), "agents.json"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		verifier *Verifier
+		want     bool
+	}{
+		{name: "nil verifier", want: false},
+		{name: "no keys", verifier: NewVerifier(keyring.New(0), 0, false), want: false},
+		{name: "hmac key", verifier: NewVerifier(hmacKeys, 0, false), want: true},
+		{name: "agent registry", verifier: NewVerifier(nil, 0, false).WithAgents(agents), want: true},
+		{name: "unsigned allowed", verifier: NewVerifier(hmacKeys, 0, false).AllowUnsigned(true), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.verifier.Required())
+		})
+	}
+}
+
+func TestNonceCache(t *testing.T) {
+	c := NewNonceCache()
+	now := time.Unix(1700000000, 0)
+
+	assert.True(t, c.Add("a", now.Add(time.Minute), now))
+	assert.False(t, c.Add("a", now.Add(time.Minute), now.Add(30*time.Second)))
+	assert.True(t, c.Add("b", now.Add(2*time.Minute), now))
+	assert.Equal(t, 2, c.Len())
+
+	// истекшие nonce вычищаются не чаще раза в минуту
+	later := now.Add(90 * time.Second)
+	assert.True(t, c.Add("a", later.Add(time.Minute), later))
+	assert.True(t, c.Add("c", later.Add(time.Minute), later))
+	assert.Equal(t, 3, c.Len())
+
+	muchLater := now.Add(10 * time.Minute)
+	assert.True(t, c.Add("d", muchLater.Add(time.Minute), muchLater))
+	assert.Equal(t, 1, c.Len())
+}
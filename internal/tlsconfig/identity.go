@@ -0,0 +1,47 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+)
+
+// Identity клиент, предъявивший проверенный сертификат при mTLS.
+type Identity struct {
+	CommonName   string   `json:"common_name"`
+	Organization []string `json:"organization,omitempty"`
+	DNSNames     []string `json:"dns_names,omitempty"`
+	SerialNumber string   `json:"serial_number"`
+	// Fingerprint sha256 сертификата в hex.
+	Fingerprint string `json:"fingerprint"`
+}
+
+// PeerIdentity возвращает клиента по состоянию TLS соединения, nil если сертификат не предъявлен или не проверен.
+func PeerIdentity(state *tls.ConnectionState) *Identity {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	cert := state.PeerCertificates[0]
+	sum := sha256.Sum256(cert.Raw)
+	return &Identity{
+		CommonName:   cert.Subject.CommonName,
+		Organization: cert.Subject.Organization,
+		DNSNames:     cert.DNSNames,
+		SerialNumber: cert.SerialNumber.String(),
+		Fingerprint:  hex.EncodeToString(sum[:]),
+	}
+}
+
+type identityKey struct{}
+
+// WithIdentity кладет клиента в контекст запроса.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext возвращает клиента запроса, nil если запрос пришел без клиентского сертификата.
+func IdentityFromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
@@ -0,0 +1,214 @@
+// Package tlsconfig собирает TLS настройки HTTP и gRPC серверов и клиентов агента.
+// Сертификат и ключ перечитываются с диска при изменении файлов, поэтому выпущенный заново сертификат
+// подхватывается без перезапуска. Проверка клиентских сертификатов (mTLS) включается корневым сертификатом клиентов.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sebasttiano/Blackbird.git/internal/logger"
+	"go.uber.org/zap"
+)
+
+// CheckInterval как часто проверять, не изменились ли файлы сертификата и ключа.
+const CheckInterval = 10 * time.Second
+
+// Режимы проверки клиентских сертификатов.
+const (
+	// ClientAuthRequire клиент без сертификата, подписанного корневым сертификатом клиентов, не подключится.
+	ClientAuthRequire = "require"
+	// ClientAuthOptional сертификат проверяется, только если клиент его предъявил.
+	ClientAuthOptional = "optional"
+)
+
+// ErrNoCertificate ошибка, если задан только сертификат или только ключ.
+var ErrNoCertificate = errors.New("both tls certificate and key are required")
+
+// ErrNoCA ошибка, если в файле корневых сертификатов нет ни одного сертификата.
+var ErrNoCA = errors.New("no certificates found in CA file")
+
+// ErrUnknownClientAuth ошибка, если режим проверки клиентских сертификатов неизвестен.
+var ErrUnknownClientAuth = errors.New("unknown client auth mode. only require and optional are available")
+
+// ServerOptions настройки TLS сервера.
+type ServerOptions struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile корневые сертификаты клиентов в PEM, пустой отключает mTLS.
+	ClientCAFile string
+	// ClientAuth режим проверки клиентских сертификатов, по умолчанию ClientAuthRequire.
+	ClientAuth string
+}
+
+// Enabled возвращает true, если TLS настроен.
+func (o ServerOptions) Enabled() bool {
+	return o.CertFile != "" || o.KeyFile != ""
+}
+
+// ClientOptions настройки TLS клиента агента.
+type ClientOptions struct {
+	// CAFile корневые сертификаты сервера в PEM, пустой означает системные.
+	CAFile string
+	// CertFile и KeyFile сертификат клиента для mTLS, необязательны.
+	CertFile string
+	KeyFile  string
+	// ServerName имя сервера в сертификате, если оно отличается от адреса подключения.
+	ServerName string
+}
+
+// Enabled возвращает true, если TLS настроен.
+func (o ClientOptions) Enabled() bool {
+	return o.CAFile != "" || o.CertFile != "" || o.KeyFile != ""
+}
+
+// Server создает TLS настройки сервера. Сертификат перечитывается при изменении файлов.
+func Server(opts ServerOptions) (*tls.Config, error) {
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, ErrNoCertificate
+	}
+	kp, err := NewKeyPair(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return kp.Certificate(), nil },
+	}
+	if opts.ClientCAFile == "" {
+		return cfg, nil
+	}
+
+	switch opts.ClientAuth {
+	case "", ClientAuthRequire:
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	case ClientAuthOptional:
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownClientAuth, opts.ClientAuth)
+	}
+	cfg.ClientCAs, err = loadCA(opts.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Client создает TLS настройки клиента. Клиентский сертификат перечитывается при изменении файлов.
+func Client(opts ClientOptions) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: opts.ServerName}
+	if opts.CAFile != "" {
+		pool, err := loadCA(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	if opts.CertFile == "" && opts.KeyFile == "" {
+		return cfg, nil
+	}
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, ErrNoCertificate
+	}
+	kp, err := NewKeyPair(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) { return kp.Certificate(), nil }
+	return cfg, nil
+}
+
+// loadCA читает корневые сертификаты в PEM.
+func loadCA(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%w: %s", ErrNoCA, path)
+	}
+	return pool, nil
+}
+
+// KeyPair сертификат с ключом, которые перечитываются с диска, если файлы изменились.
+// Файлы проверяются не чаще раза в CheckInterval. Если новый сертификат не читается,
+// остается прежний, а ошибка пишется в лог.
+type KeyPair struct {
+	mu       sync.Mutex
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	modTimes [2]time.Time
+	checked  time.Time
+	interval time.Duration
+	now      func() time.Time
+}
+
+// NewKeyPair конструктор для KeyPair, сразу читает сертификат и ключ.
+func NewKeyPair(certFile, keyFile string) (*KeyPair, error) {
+	kp := &KeyPair{certFile: certFile, keyFile: keyFile, interval: CheckInterval, now: time.Now}
+	modTimes, err := kp.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := kp.load(modTimes); err != nil {
+		return nil, err
+	}
+	return kp, nil
+}
+
+// Certificate возвращает текущий сертификат, при необходимости перечитав файлы.
+func (kp *KeyPair) Certificate() *tls.Certificate {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	now := kp.now()
+	if now.Sub(kp.checked) < kp.interval {
+		return kp.cert
+	}
+	kp.checked = now
+
+	modTimes, err := kp.stat()
+	if err != nil {
+		logger.Log.Error("failed to check tls certificate, using the previous one", zap.String("cert", kp.certFile), zap.Error(err))
+		return kp.cert
+	}
+	if modTimes == kp.modTimes {
+		return kp.cert
+	}
+	if err := kp.load(modTimes); err != nil {
+		logger.Log.Error("failed to reload tls certificate, using the previous one", zap.String("cert", kp.certFile), zap.Error(err))
+		return kp.cert
+	}
+	logger.Log.Info("tls certificate reloaded", zap.String("cert", kp.certFile))
+	return kp.cert
+}
+
+// stat возвращает время изменения файлов сертификата и ключа.
+func (kp *KeyPair) stat() ([2]time.Time, error) {
+	var modTimes [2]time.Time
+	for i, path := range []string{kp.certFile, kp.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// load читает сертификат и ключ.
+func (kp *KeyPair) load(modTimes [2]time.Time) error {
+	cert, err := tls.LoadX509KeyPair(kp.certFile, kp.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load tls certificate %s: %w", kp.certFile, err)
+	}
+	kp.cert, kp.modTimes = &cert, modTimes
+	return nil
+}
@@ -0,0 +1,227 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// issuer выпускает тестовые сертификаты.
+type issuer struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+func newIssuer(t *testing.T) *issuer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "blackbird test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	is := &issuer{cert: cert, key: key, dir: t.TempDir()}
+	is.write(t, "ca.pem", "CERTIFICATE", der)
+	return is
+}
+
+func (is *issuer) write(t *testing.T, name, blockType string, der []byte) string {
+	path := filepath.Join(is.dir, name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600))
+	return path
+}
+
+// issue выпускает сертификат и возвращает пути к сертификату и ключу.
+func (is *issuer) issue(t *testing.T, name string, serial int64, usage x509.ExtKeyUsage) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name, Organization: []string{"blackbird"}},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, is.cert, &key.PublicKey, is.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return is.write(t, name+".pem", "CERTIFICATE", der), is.write(t, name+"-key.pem", "EC PRIVATE KEY", keyDER)
+}
+
+// serve запускает HTTPS сервер, который отвечает CommonName клиентского сертификата.
+func serve(t *testing.T, cfg *tls.Config) string {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if id := PeerIdentity(req.TLS); id != nil {
+			io.WriteString(res, id.CommonName)
+		}
+	}))
+	// StartTLS подставляет свой сертификат, поэтому TLS включается на листенере
+	srv.Listener = tls.NewListener(srv.Listener, cfg)
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return strings.Replace(srv.URL, "http://", "https://", 1)
+}
+
+func get(url string, cfg *tls.Config) (string, error) {
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	res, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	return string(body), err
+}
+
+func TestServerAndClient(t *testing.T) {
+	is := newIssuer(t)
+	ca := filepath.Join(is.dir, "ca.pem")
+	serverCert, serverKey := is.issue(t, "server", 2, x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := is.issue(t, "agent-1", 3, x509.ExtKeyUsageClientAuth)
+
+	withClientCert, err := Client(ClientOptions{CAFile: ca, CertFile: clientCert, KeyFile: clientKey})
+	require.NoError(t, err)
+	withoutClientCert, err := Client(ClientOptions{CAFile: ca})
+	require.NoError(t, err)
+
+	t.Run("tls", func(t *testing.T) {
+		cfg, err := Server(ServerOptions{CertFile: serverCert, KeyFile: serverKey})
+		require.NoError(t, err)
+		url := serve(t, cfg)
+
+		body, err := get(url, withoutClientCert)
+		require.NoError(t, err)
+		assert.Empty(t, body)
+
+		_, err = get(url, &tls.Config{})
+		assert.Error(t, err, "server certificate must not be trusted without CA")
+	})
+	t.Run("mtls required", func(t *testing.T) {
+		cfg, err := Server(ServerOptions{CertFile: serverCert, KeyFile: serverKey, ClientCAFile: ca})
+		require.NoError(t, err)
+		url := serve(t, cfg)
+
+		body, err := get(url, withClientCert)
+		require.NoError(t, err)
+		assert.Equal(t, "agent-1", body)
+
+		_, err = get(url, withoutClientCert)
+		assert.Error(t, err)
+	})
+	t.Run("mtls optional", func(t *testing.T) {
+		cfg, err := Server(ServerOptions{CertFile: serverCert, KeyFile: serverKey, ClientCAFile: ca, ClientAuth: ClientAuthOptional})
+		require.NoError(t, err)
+		url := serve(t, cfg)
+
+		body, err := get(url, withClientCert)
+		require.NoError(t, err)
+		assert.Equal(t, "agent-1", body)
+
+		body, err = get(url, withoutClientCert)
+		require.NoError(t, err)
+		assert.Empty(t, body)
+	})
+}
+
+func TestOptionsErrors(t *testing.T) {
+	is := newIssuer(t)
+	ca := filepath.Join(is.dir, "ca.pem")
+	cert, key := is.issue(t, "server", 2, x509.ExtKeyUsageServerAuth)
+
+	_, err := Server(ServerOptions{CertFile: cert})
+	assert.ErrorIs(t, err, ErrNoCertificate)
+	_, err = Server(ServerOptions{CertFile: cert, KeyFile: key, ClientCAFile: ca, ClientAuth: "sometimes"})
+	assert.ErrorIs(t, err, ErrUnknownClientAuth)
+	_, err = Server(ServerOptions{CertFile: cert, KeyFile: key, ClientCAFile: key})
+	assert.ErrorIs(t, err, ErrNoCA)
+	_, err = Server(ServerOptions{CertFile: key, KeyFile: cert})
+	assert.Error(t, err)
+	_, err = Client(ClientOptions{KeyFile: key})
+	assert.ErrorIs(t, err, ErrNoCertificate)
+
+	assert.False(t, ServerOptions{}.Enabled())
+	assert.True(t, ClientOptions{CAFile: ca}.Enabled())
+}
+
+func TestKeyPair_Reload(t *testing.T) {
+	is := newIssuer(t)
+	cert, key := is.issue(t, "server", 2, x509.ExtKeyUsageServerAuth)
+
+	kp, err := NewKeyPair(cert, key)
+	require.NoError(t, err)
+	now := time.Now()
+	kp.now = func() time.Time { return now }
+	first := kp.Certificate()
+
+	// новый сертификат под теми же именами файлов
+	is.issue(t, "server", 5, x509.ExtKeyUsageServerAuth)
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(cert, future, future))
+	require.NoError(t, os.Chtimes(key, future, future))
+
+	assert.Same(t, first, kp.Certificate(), "files are checked at most once per interval")
+
+	now = now.Add(CheckInterval)
+	reloaded := kp.Certificate()
+	require.NotSame(t, first, reloaded)
+	leaf, err := x509.ParseCertificate(reloaded.Certificate[0])
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), leaf.SerialNumber.Int64())
+
+	// битый файл не заменяет рабочий сертификат
+	require.NoError(t, os.WriteFile(cert, []byte("broken"), 0600))
+	now = now.Add(CheckInterval)
+	assert.Same(t, reloaded, kp.Certificate())
+}
+
+func TestPeerIdentity(t *testing.T) {
+	is := newIssuer(t)
+	certFile, keyFile := is.issue(t, "agent-1", 7, x509.ExtKeyUsageClientAuth)
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	require.NoError(t, err)
+
+	assert.Nil(t, PeerIdentity(nil))
+	assert.Nil(t, PeerIdentity(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}), "unverified certificate")
+
+	id := PeerIdentity(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}, VerifiedChains: [][]*x509.Certificate{{leaf, is.cert}}})
+	require.NotNil(t, id)
+	assert.Equal(t, "agent-1", id.CommonName)
+	assert.Equal(t, []string{"blackbird"}, id.Organization)
+	assert.Equal(t, "7", id.SerialNumber)
+	assert.Len(t, id.Fingerprint, 64)
+}
@@ -0,0 +1,252 @@
+// Дашборд Blackbird: сортировка и фильтр таблиц, автообновление и история значения на странице метрики.
+(function () {
+    "use strict";
+
+    var body = document.body;
+    var refresh = parseInt(body.dataset.refresh, 10) || 5;
+    var source = body.dataset.source;
+    var autoRefresh = document.getElementById("auto-refresh");
+    var search = document.getElementById("search");
+    var timer = null;
+
+    function formatTime(value) {
+        if (!value) {
+            return "—";
+        }
+        var d = new Date(value);
+        var pad = function (n) { return String(n).padStart(2, "0"); };
+        return d.getFullYear() + "-" + pad(d.getMonth() + 1) + "-" + pad(d.getDate()) + " " +
+            pad(d.getHours()) + ":" + pad(d.getMinutes()) + ":" + pad(d.getSeconds());
+    }
+
+    function relative(value) {
+        if (!value) {
+            return "";
+        }
+        var seconds = Math.round((Date.now() - new Date(value).getTime()) / 1000);
+        if (seconds < 60) { return Math.max(seconds, 0) + "s ago"; }
+        if (seconds < 3600) { return Math.round(seconds / 60) + "m ago"; }
+        if (seconds < 86400) { return Math.round(seconds / 3600) + "h ago"; }
+        return Math.round(seconds / 86400) + "d ago";
+    }
+
+    // --- таблицы ---
+
+    var sortState = {};
+
+    function compareRows(key, dir) {
+        return function (a, b) {
+            var x, y;
+            if (key === "name") {
+                x = a.dataset.name.toLowerCase();
+                y = b.dataset.name.toLowerCase();
+                return x < y ? -dir : x > y ? dir : 0;
+            }
+            x = parseFloat(a.dataset[key]);
+            y = parseFloat(b.dataset[key]);
+            return (x - y) * dir;
+        };
+    }
+
+    function sortTable(table) {
+        var state = sortState[table.id] || { key: "name", dir: 1 };
+        var tbody = table.tBodies[0];
+        var rows = Array.prototype.filter.call(tbody.rows, function (r) { return !r.classList.contains("empty"); });
+        rows.sort(compareRows(state.key, state.dir)).forEach(function (r) { tbody.appendChild(r); });
+        Array.prototype.forEach.call(table.tHead.rows[0].cells, function (th) {
+            th.classList.remove("sorted-asc", "sorted-desc");
+            if (th.dataset.sort === state.key) {
+                th.classList.add(state.dir > 0 ? "sorted-asc" : "sorted-desc");
+            }
+        });
+    }
+
+    function filterTable(table) {
+        var query = search ? search.value.trim().toLowerCase() : "";
+        var visible = 0;
+        Array.prototype.forEach.call(table.tBodies[0].rows, function (r) {
+            if (r.classList.contains("empty")) {
+                return;
+            }
+            var match = r.dataset.name.toLowerCase().indexOf(query) !== -1;
+            r.classList.toggle("hidden", !match);
+            if (match) { visible++; }
+        });
+        var count = document.getElementById(table.id + "-count");
+        if (count) {
+            count.textContent = query ? visible + " / " + (table.tBodies[0].rows.length) : String(visible);
+        }
+    }
+
+    function renderRows(table, rows) {
+        var tbody = table.tBodies[0];
+        var previous = {};
+        Array.prototype.forEach.call(tbody.rows, function (r) {
+            if (r.dataset.name) { previous[r.dataset.name] = r.dataset.updated; }
+        });
+        tbody.textContent = "";
+        if (!rows.length) {
+            var empty = tbody.insertRow();
+            empty.className = "empty";
+            var cell = empty.insertCell();
+            cell.colSpan = 3;
+            cell.textContent = "No metrics yet";
+            return;
+        }
+        rows.forEach(function (m) {
+            var tr = tbody.insertRow();
+            var updated = m.updated ? new Date(m.updated).getTime() : 0;
+            tr.dataset.name = m.name;
+            tr.dataset.number = m.number;
+            tr.dataset.updated = updated;
+            if (m.name in previous && String(updated) !== previous[m.name]) {
+                tr.className = "changed";
+            }
+
+            var link = document.createElement("a");
+            link.href = "/ui/metric/" + encodeURIComponent(m.type) + "/" + encodeURIComponent(m.name);
+            link.textContent = m.name;
+            tr.insertCell().appendChild(link);
+
+            var value = tr.insertCell();
+            value.className = "num";
+            value.title = m.raw;
+            value.textContent = m.value;
+
+            var time = document.createElement("time");
+            if (m.updated) {
+                time.dateTime = m.updated;
+                time.title = relative(m.updated);
+            }
+            time.textContent = formatTime(m.updated);
+            tr.insertCell().appendChild(time);
+        });
+    }
+
+    var tables = Array.prototype.slice.call(document.querySelectorAll("table.metrics"));
+    tables.forEach(function (table) {
+        Array.prototype.forEach.call(table.tHead.rows[0].cells, function (th) {
+            th.addEventListener("click", function () {
+                var state = sortState[table.id] || { key: "name", dir: 1 };
+                sortState[table.id] = { key: th.dataset.sort, dir: state.key === th.dataset.sort ? -state.dir : 1 };
+                sortTable(table);
+            });
+        });
+        sortTable(table);
+        filterTable(table);
+    });
+
+    if (search) {
+        var query = new URLSearchParams(window.location.search).get("q");
+        if (query) {
+            search.value = query;
+        }
+        search.addEventListener("input", function () {
+            tables.forEach(filterTable);
+            var url = new URL(window.location.href);
+            if (search.value) {
+                url.searchParams.set("q", search.value);
+            } else {
+                url.searchParams.delete("q");
+            }
+            window.history.replaceState(null, "", url);
+        });
+        tables.forEach(filterTable);
+    }
+
+    // --- страница метрики ---
+
+    var history = [];
+    var historyLimit = 120;
+
+    function renderHistory() {
+        var svg = document.getElementById("history");
+        if (!svg || history.length < 2) {
+            return;
+        }
+        var min = Math.min.apply(null, history);
+        var max = Math.max.apply(null, history);
+        var span = max - min || 1;
+        var step = 600 / (historyLimit - 1);
+        var points = history.map(function (v, i) {
+            return (i * step).toFixed(1) + "," + (115 - (v - min) / span * 110).toFixed(1);
+        }).join(" ");
+        svg.innerHTML = '<polyline points="' + points + '"></polyline>';
+    }
+
+    function renderDetail(metric) {
+        document.getElementById("value").textContent = metric.value;
+        document.getElementById("value").title = metric.raw;
+        document.getElementById("raw").firstElementChild.textContent = metric.raw;
+        var updated = document.getElementById("updated");
+        updated.textContent = formatTime(metric.updated);
+        updated.title = relative(metric.updated);
+        history.push(metric.number);
+        if (history.length > historyLimit) {
+            history.shift();
+        }
+        renderHistory();
+    }
+
+    // --- автообновление ---
+
+    function load() {
+        if (!source) {
+            return;
+        }
+        fetch(source, { headers: { "Accept": "application/json" } })
+            .then(function (res) {
+                if (!res.ok) {
+                    throw new Error("HTTP " + res.status);
+                }
+                return res.json();
+            })
+            .then(function (data) {
+                if (document.getElementById("value")) {
+                    var rows = (data.gauges || []).concat(data.counters || []);
+                    if (rows.length) {
+                        renderDetail(rows[0]);
+                    }
+                    return;
+                }
+                tables.forEach(function (table) {
+                    renderRows(table, data[table.id] || []);
+                    sortTable(table);
+                    filterTable(table);
+                });
+                var generated = document.getElementById("generated");
+                if (generated) {
+                    generated.dateTime = data.generated;
+                    generated.textContent = formatTime(data.generated).slice(11);
+                }
+            })
+            .catch(function (err) {
+                console.warn("blackbird: refresh failed", err);
+            });
+    }
+
+    function schedule() {
+        clearInterval(timer);
+        timer = null;
+        if (autoRefresh && autoRefresh.checked) {
+            timer = setInterval(load, refresh * 1000);
+        }
+    }
+
+    if (autoRefresh) {
+        autoRefresh.addEventListener("change", schedule);
+    }
+    document.addEventListener("visibilitychange", function () {
+        if (document.hidden) {
+            clearInterval(timer);
+            timer = null;
+        } else {
+            load();
+            schedule();
+        }
+    });
+    if (document.getElementById("value")) {
+        load();
+    }
+    schedule();
+})();
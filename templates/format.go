@@ -0,0 +1,127 @@
+package templates
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MetricRow строка таблицы дашборда.
+type MetricRow struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Value значение для человека, например 12.35M или 1,024.
+	Value string `json:"value"`
+	// Raw точное значение.
+	Raw string `json:"raw"`
+	// Number значение для сортировки.
+	Number float64 `json:"number"`
+	// Updated время последнего обновления, nil если неизвестно.
+	Updated *time.Time `json:"updated,omitempty"`
+}
+
+// Dashboard данные главной страницы.
+type Dashboard struct {
+	Gauges   []MetricRow `json:"gauges"`
+	Counters []MetricRow `json:"counters"`
+	// Refresh интервал автообновления в секундах.
+	Refresh   int       `json:"refresh"`
+	Generated time.Time `json:"generated"`
+}
+
+// MetricPage данные страницы одной метрики.
+type MetricPage struct {
+	MetricRow
+	// ExpositionName имя метрики в /metrics.
+	ExpositionName string
+	Refresh        int
+}
+
+// siPrefixes приставки для больших значений gauge.
+var siPrefixes = []struct {
+	limit  float64
+	suffix string
+}{
+	{1e12, "T"},
+	{1e9, "G"},
+	{1e6, "M"},
+}
+
+// FormatGauge форматирует значение gauge: большие числа с приставкой (12.35M),
+// тысячи с разделителями разрядов, малые числа в экспоненциальной записи.
+func FormatGauge(v float64) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	abs := math.Abs(v)
+	switch {
+	case abs >= 1e15:
+		return strconv.FormatFloat(v, 'e', 2, 64)
+	case abs >= 1e6:
+		for _, p := range siPrefixes {
+			if abs >= p.limit {
+				return trimZeros(strconv.FormatFloat(v/p.limit, 'f', 2, 64)) + p.suffix
+			}
+		}
+	case abs >= 1000:
+		s := trimZeros(strconv.FormatFloat(v, 'f', 2, 64))
+		intPart, frac, _ := strings.Cut(s, ".")
+		if frac != "" {
+			frac = "." + frac
+		}
+		return groupDigits(intPart) + frac
+	case abs >= 0.001 || abs == 0:
+		return trimZeros(strconv.FormatFloat(v, 'f', 3, 64))
+	}
+	return strconv.FormatFloat(v, 'e', 2, 64)
+}
+
+// FormatCounter форматирует значение counter с разделителями разрядов.
+func FormatCounter(v int64) string {
+	return groupDigits(strconv.FormatInt(v, 10))
+}
+
+// FormatTime форматирует время обновления, пустое время отображается прочерком.
+func FormatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "—"
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// groupDigits разделяет разряды целого числа запятыми.
+func groupDigits(s string) string {
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	if len(s) <= 3 {
+		return sign + s
+	}
+	var b strings.Builder
+	head := len(s) % 3
+	if head > 0 {
+		b.WriteString(s[:head])
+	}
+	for i := head; i < len(s); i += 3 {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(s[i : i+3])
+	}
+	return sign + b.String()
+}
+
+// trimZeros убирает незначащие нули дробной части.
+func trimZeros(s string) string {
+	if !strings.Contains(s, ".") {
+		return s
+	}
+	s = strings.TrimRight(s, "0")
+	s = strings.TrimSuffix(s, ".")
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
@@ -1,30 +1,71 @@
-// Package templates для работы с html шаблонами.
+// Package templates для работы с html шаблонами и статикой дашборда.
 package templates
 
 import (
+	"embed"
+	"errors"
 	"html/template"
+	"io/fs"
 	"log"
-	"path/filepath"
-	"runtime"
+	"net/url"
 )
 
+//go:embed index.html metric.html
+var pages embed.FS
+
+//go:embed assets
+var assets embed.FS
+
 // HTMLTemplates хранит *template.Template.
 type HTMLTemplates struct {
-	IndexTemplate *template.Template
+	IndexTemplate  *template.Template
+	MetricTemplate *template.Template
+}
+
+// funcs функции, доступные в шаблонах.
+var funcs = template.FuncMap{
+	"formatTime": FormatTime,
+	"pathEscape": url.PathEscape,
+	"dict":       dict,
+}
+
+// dict собирает пары ключ-значение в map, чтобы передать несколько значений во вложенный шаблон.
+func dict(pairs ...any) (map[string]any, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("dict expects key-value pairs")
+	}
+	m := make(map[string]any, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, errors.New("dict keys must be strings")
+		}
+		m[key] = pairs[i+1]
+	}
+	return m, nil
 }
 
-// ParseTemplates парсит шаблон html и возвращает HTMLTemplates.
+// ParseTemplates парсит встроенные в бинарник шаблоны html и возвращает HTMLTemplates.
 func ParseTemplates() HTMLTemplates {
 	ServerTemplates := HTMLTemplates{}
 	var err error
-	var (
-		_, b, _, _ = runtime.Caller(0)
-		basepath   = filepath.Dir(b)
-	)
 
-	ServerTemplates.IndexTemplate, err = template.ParseFiles(basepath + "/index.html")
+	ServerTemplates.IndexTemplate, err = template.New("index.html").Funcs(funcs).ParseFS(pages, "index.html")
+	if err != nil {
+		log.Fatalf("Couldn`t parse templates %v", err)
+	}
+	ServerTemplates.MetricTemplate, err = template.New("metric.html").Funcs(funcs).ParseFS(pages, "metric.html")
 	if err != nil {
 		log.Fatalf("Couldn`t parse templates %v", err)
 	}
 	return ServerTemplates
 }
+
+// Assets возвращает встроенную статику дашборда: стили и скрипты.
+func Assets() fs.FS {
+	sub, err := fs.Sub(assets, "assets")
+	if err != nil {
+		log.Fatalf("Couldn`t open embedded assets %v", err)
+	}
+	return sub
+}
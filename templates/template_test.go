@@ -1,9 +1,13 @@
 package templates
 
 import (
+	"bytes"
+	"math"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestParseTemplates(t *testing.T) {
@@ -21,6 +25,57 @@ func TestParseTemplates(t *testing.T) {
 	}
 }
 
+func TestFormatGauge(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  string
+	}{
+		{value: 0, want: "0"},
+		{value: 0.125, want: "0.125"},
+		{value: 21.5, want: "21.5"},
+		{value: -3, want: "-3"},
+		{value: 1234.5678, want: "1,234.57"},
+		{value: 999999, want: "999,999"},
+		{value: 12345678, want: "12.35M"},
+		{value: -2.5e9, want: "-2.5G"},
+		{value: 7e12, want: "7T"},
+		{value: 3.2e16, want: "3.20e+16"},
+		{value: 0.00004, want: "4.00e-05"},
+		{value: math.Inf(1), want: "+Inf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			assert.Equal(t, tt.want, FormatGauge(tt.value))
+		})
+	}
+}
+
+func TestFormatCounter(t *testing.T) {
+	assert.Equal(t, "0", FormatCounter(0))
+	assert.Equal(t, "999", FormatCounter(999))
+	assert.Equal(t, "1,000", FormatCounter(1000))
+	assert.Equal(t, "123,456,789", FormatCounter(123456789))
+	assert.Equal(t, "-12,345", FormatCounter(-12345))
+}
+
+func TestIndexTemplate(t *testing.T) {
+	updated := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+	d := Dashboard{
+		Gauges:   []MetricRow{{Name: "cpu/load", Type: "gauge", Value: "0.5", Raw: "0.5", Number: 0.5, Updated: &updated}},
+		Counters: []MetricRow{{Name: "<script>", Type: "counter", Value: "1,024", Raw: "1024", Number: 1024}},
+		Refresh:  5,
+	}
+	var b bytes.Buffer
+	require.NoError(t, ParseTemplates().IndexTemplate.Execute(&b, d))
+	page := b.String()
+
+	assert.Contains(t, page, `href="/ui/metric/gauge/cpu%2Fload"`)
+	assert.Contains(t, page, "2024-05-01 10:30:00")
+	assert.Contains(t, page, "&lt;script&gt;")
+	assert.NotContains(t, page, "<script>\n")
+	assert.Contains(t, page, "—")
+}
+
 func BenchmarkParseTemplates(b *testing.B) {
 	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {